@@ -0,0 +1,187 @@
+package ytdlp
+
+import (
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ytget/ytdlp/v2/internal/mimeext"
+	"github.com/ytget/ytdlp/v2/internal/ogg"
+	"github.com/ytget/ytdlp/v2/internal/webm"
+	"github.com/ytget/ytdlp/v2/postprocess"
+	"github.com/ytget/ytdlp/v2/types"
+)
+
+// Audio formats accepted by WithExtractAudio.
+const (
+	AudioFormatBest = "best"
+	AudioFormatM4A  = "m4a"
+	AudioFormatOpus = "opus"
+)
+
+const (
+	codecIDOpus = "A_OPUS"
+	oggVendor   = "ytdlp"
+)
+
+// audioSelector returns the format selector for an audio extraction format.
+// Formats that only ffmpeg can produce are accepted when ffmpeg is set.
+func audioSelector(format string, ffmpeg bool) (string, error) {
+	switch format {
+	case "", AudioFormatBest:
+		return "ba", nil
+	case AudioFormatM4A:
+		return "ba[ext=m4a]", nil
+	case AudioFormatOpus:
+		return "ba[acodec=opus]", nil
+	}
+	if postprocess.AudioExt(format) == "" {
+		return "", fmt.Errorf("unsupported audio format %q (want %s, %s or %s)", format, AudioFormatBest, AudioFormatM4A, AudioFormatOpus)
+	}
+	if !ffmpeg {
+		return "", fmt.Errorf("audio format %q needs ffmpeg post-processing (see WithPostProcessor)", format)
+	}
+	return "ba", nil
+}
+
+// nativeAudioFormat reports whether audio can be extracted in format
+// without ffmpeg.
+func nativeAudioFormat(format string) bool {
+	return format == "" || format == AudioFormatBest || format == AudioFormatM4A || format == AudioFormatOpus
+}
+
+// ffmpegAudioFormat returns the format in which ffmpeg must extract the
+// audio of f, or "" when f is extracted natively: formats such as mp3 are
+// always encoded by ffmpeg, and formats with video need ffmpeg to drop it.
+func (d *Downloader) ffmpegAudioFormat(f types.Format) (string, error) {
+	format := d.options.AudioFormat
+	switch {
+	case nativeAudioFormat(format) && !f.HasVideo():
+		return "", nil
+	case d.options.PostProcessor == nil && f.HasVideo():
+		return "", fmt.Errorf("extract audio: format %d has a video track", f.Itag)
+	case d.options.PostProcessor == nil:
+		return "", fmt.Errorf("extract audio: audio format %q needs ffmpeg post-processing (see WithPostProcessor)", format)
+	case format == AudioFormatOpus:
+		return AudioFormatOpus, nil
+	case nativeAudioFormat(format):
+		return AudioFormatM4A, nil
+	}
+	return format, nil
+}
+
+// isWebMOpus reports whether f is an audio-only WebM stream carrying Opus,
+// which is remuxed into Ogg Opus when extracting audio.
+func isWebMOpus(f types.Format) bool {
+	return mimeext.ExtFromMime(f.MimeType) == mimeext.ExtWebA && strings.HasPrefix(strings.ToLower(f.ACodec), "opus")
+}
+
+// audioExt returns the file extension of extracted audio for f.
+func audioExt(f types.Format) string {
+	if isWebMOpus(f) {
+		return mimeext.ExtOpus
+	}
+	return mimeext.ExtFromMime(f.MimeType)
+}
+
+// remuxWebMOpusFile converts the WebM file at src into an Ogg Opus file at dst.
+func remuxWebMOpusFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if err := remuxWebMOpus(out, in); err != nil {
+		_ = out.Close()
+		_ = os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// remuxWebMOpusStream runs download into a pipe and remuxes the WebM Opus
+// stream it produces into Ogg Opus on dst as the data arrives.
+func remuxWebMOpusStream(dst io.Writer, download func(io.Writer) error) error {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := download(pw)
+		_ = pw.CloseWithError(err)
+		done <- err
+	}()
+	err := remuxWebMOpus(dst, pr)
+	if err == nil {
+		// Let the download finish past trailing elements such as Cues.
+		_, err = io.Copy(io.Discard, pr)
+	}
+	_ = pr.CloseWithError(err)
+	derr := <-done
+	if err != nil {
+		return err
+	}
+	return derr
+}
+
+// remuxWebMOpus copies the Opus track of a WebM stream into an Ogg Opus stream
+// without re-encoding.
+func remuxWebMOpus(dst io.Writer, src io.Reader) error {
+	r, err := webm.NewReader(src)
+	if err != nil {
+		return err
+	}
+	var track *webm.Track
+	for i, t := range r.Tracks() {
+		if t.CodecID == codecIDOpus {
+			track = &r.Tracks()[i]
+			break
+		}
+	}
+	if track == nil {
+		return fmt.Errorf("no Opus track in WebM stream")
+	}
+	head := track.CodecPrivate
+	if len(head) == 0 {
+		channels := track.Channels
+		if channels == 0 {
+			channels = 2
+		}
+		head = ogg.OpusHead(channels, uint16(durationToSamples(track.CodecDelay)), uint32(track.SamplingFrequency))
+	}
+	w, err := ogg.NewOpusWriter(dst, rand.Uint32(), head, ogg.OpusTags(oggVendor))
+	if err != nil {
+		return err
+	}
+	var trim int64
+	for {
+		f, err := r.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if f.Track != track.Number {
+			continue
+		}
+		if err := w.WritePacket(f.Data); err != nil {
+			return err
+		}
+		trim = durationToSamples(f.DiscardPadding)
+	}
+	return w.Close(trim)
+}
+
+// durationToSamples converts d to a number of 48 kHz Opus samples.
+func durationToSamples(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64(d) * ogg.OpusSampleRate / int64(time.Second)
+}
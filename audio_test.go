@@ -0,0 +1,252 @@
+package ytdlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ytget/ytdlp/v2/postprocess"
+	"github.com/ytget/ytdlp/v2/types"
+)
+
+// fakeFFmpeg returns a post-processor running a shell script in place of
+// ffmpeg. The script reports the end of the job and concatenates its inputs
+// into the output.
+func fakeFFmpeg(t *testing.T) *postprocess.PostProcessor {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+	dir := t.TempDir()
+	script := `#!/bin/sh
+ins=; prev=
+for a; do [ "$prev" = -i ] && ins="$ins $a"; prev=$a; done
+echo out_time_us=1000000; echo progress=end
+cat $ins > "$a"
+`
+	if err := os.WriteFile(filepath.Join(dir, "ffmpeg"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return postprocess.New().WithPath(dir)
+}
+
+// ebml encodes an EBML element whose ID is given as raw bytes.
+func ebml(id []byte, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	size := make([]byte, 8)
+	binary.BigEndian.PutUint64(size, uint64(len(body)))
+	size[0] = 0x01 // 8-byte length marker
+	return append(append(append([]byte(nil), id...), size...), body...)
+}
+
+var testOpusHead = []byte("OpusHead\x01\x02\x38\x01\x80\xbb\x00\x00\x00\x00\x00")
+
+// testWebMOpus returns a WebM stream with three 20 ms Opus packets, the last
+// one with 4 ms of discard padding.
+func testWebMOpus() []byte {
+	head := testOpusHead
+	simpleBlock := func(rel byte) []byte {
+		return ebml([]byte{0xA3}, []byte{0x81, 0, rel, 0x80, 0xF8, 0xFF, 0xFE}) // one 20 ms CELT frame
+	}
+	return bytes.Join([][]byte{
+		ebml([]byte{0x1A, 0x45, 0xDF, 0xA3}, ebml([]byte{0x42, 0x82}, []byte("webm"))),
+		ebml([]byte{0x18, 0x53, 0x80, 0x67},
+			ebml([]byte{0x16, 0x54, 0xAE, 0x6B},
+				ebml([]byte{0xAE},
+					ebml([]byte{0xD7}, []byte{1}),
+					ebml([]byte{0x86}, []byte("A_OPUS")),
+					ebml([]byte{0x63, 0xA2}, head),
+				),
+			),
+			ebml([]byte{0x1F, 0x43, 0xB6, 0x75},
+				ebml([]byte{0xE7}, []byte{0}),
+				simpleBlock(0), simpleBlock(20),
+				ebml([]byte{0xA0},
+					ebml([]byte{0xA1}, []byte{0x81, 0, 40, 0, 0xF8, 0xFF, 0xFE}),
+					ebml([]byte{0x75, 0xA2}, []byte{0x00, 0x3D, 0x09, 0x00}), // 4 ms
+				),
+			),
+		),
+	}, nil)
+}
+
+// checkOggOpus verifies that b is the Ogg Opus remux of testWebMOpus.
+func checkOggOpus(t *testing.T, b []byte) {
+	t.Helper()
+	if !bytes.HasPrefix(b, []byte("OggS")) || !bytes.Contains(b, testOpusHead) || !bytes.Contains(b, []byte("OpusTags")) {
+		t.Fatalf("output is not an Ogg Opus stream")
+	}
+	last := bytes.LastIndex(b, []byte("OggS"))
+	if granule := binary.LittleEndian.Uint64(b[last+6:]); granule != 3*960-192 {
+		t.Errorf("final granule = %d, want %d", granule, 3*960-192)
+	}
+	if b[last+5]&0x04 == 0 {
+		t.Errorf("last page is missing the end-of-stream flag")
+	}
+}
+
+func TestRemuxWebMOpus(t *testing.T) {
+	var out bytes.Buffer
+	if err := remuxWebMOpus(&out, bytes.NewReader(testWebMOpus())); err != nil {
+		t.Fatalf("remux failed: %v", err)
+	}
+	checkOggOpus(t, out.Bytes())
+
+	if err := remuxWebMOpus(&out, bytes.NewReader([]byte("garbage"))); err == nil {
+		t.Error("expected error for invalid input")
+	}
+}
+
+func TestRemuxWebMOpusStream(t *testing.T) {
+	src := append(testWebMOpus(), ebml([]byte{0xEC}, make([]byte, 64<<10))...) // trailing Void element
+	var out bytes.Buffer
+	err := remuxWebMOpusStream(&out, func(w io.Writer) error {
+		for b := src; len(b) > 0; b = b[min(len(b), 7):] {
+			if _, err := w.Write(b[:min(len(b), 7)]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("stream remux failed: %v", err)
+	}
+	checkOggOpus(t, out.Bytes())
+
+	failed := errors.New("connection reset")
+	err = remuxWebMOpusStream(io.Discard, func(w io.Writer) error {
+		_, _ = w.Write(src[:40])
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Errorf("expected the download error, got %v", err)
+	}
+}
+
+func TestAudioSelector(t *testing.T) {
+	tests := map[string]string{"": "ba", "best": "ba", "m4a": "ba[ext=m4a]", "opus": "ba[acodec=opus]"}
+	for in, want := range tests {
+		if got, err := audioSelector(in, false); err != nil || got != want {
+			t.Errorf("audioSelector(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := audioSelector("flac", false); err == nil {
+		t.Error("expected error for an audio format that needs ffmpeg")
+	}
+	if got, err := audioSelector("flac", true); err != nil || got != "ba" {
+		t.Errorf("audioSelector(flac) with ffmpeg = %q, %v; want ba", got, err)
+	}
+	if _, err := audioSelector("ape", true); err == nil {
+		t.Error("expected error for unsupported audio format")
+	}
+}
+
+func TestAudioExt(t *testing.T) {
+	tests := []struct {
+		f    types.Format
+		want string
+	}{
+		{types.Format{MimeType: `audio/webm; codecs="opus"`, ACodec: "opus"}, "opus"},
+		{types.Format{MimeType: `audio/webm; codecs="vorbis"`, ACodec: "vorbis"}, "weba"},
+		{types.Format{MimeType: `audio/mp4; codecs="mp4a.40.2"`, ACodec: "mp4a.40.2"}, "m4a"},
+	}
+	for _, tt := range tests {
+		if got := audioExt(tt.f); got != tt.want {
+			t.Errorf("audioExt(%q) = %q, want %q", tt.f.MimeType, got, tt.want)
+		}
+	}
+}
+
+func TestWithExtractAudio(t *testing.T) {
+	d := New().WithExtractAudio(" Opus ")
+	if !d.options.ExtractAudio || d.options.AudioFormat != "opus" {
+		t.Errorf("unexpected options: %+v", d.options)
+	}
+	if got, _, err := d.selection(); err != nil || got != "ba[acodec=opus]" {
+		t.Errorf("selection() = %q, %v", got, err)
+	}
+	d.WithFormat("140", "")
+	if got, _, _ := d.selection(); got != "140" {
+		t.Errorf("explicit selector should win, got %q", got)
+	}
+}
+
+func TestFFmpegAudioFormat(t *testing.T) {
+	audio := types.Format{Itag: 140, MimeType: "audio/mp4", ACodec: "mp4a.40.2"}
+	video := types.Format{Itag: 18, MimeType: "video/mp4", VCodec: "avc1", ACodec: "mp4a.40.2"}
+	pp := postprocess.New()
+	tests := []struct {
+		format string
+		f      types.Format
+		pp     *postprocess.PostProcessor
+		want   string
+		err    bool
+	}{
+		{"best", audio, nil, "", false},
+		{"m4a", audio, pp, "", false},
+		{"mp3", audio, pp, "mp3", false},
+		{"mp3", audio, nil, "", true},
+		{"best", video, pp, "m4a", false},
+		{"opus", video, pp, "opus", false},
+		{"flac", video, pp, "flac", false},
+		{"best", video, nil, "", true},
+	}
+	for _, tt := range tests {
+		d := New().WithExtractAudio(tt.format).WithPostProcessor(tt.pp)
+		got, err := d.ffmpegAudioFormat(tt.f)
+		if got != tt.want || (err != nil) != tt.err {
+			t.Errorf("ffmpegAudioFormat(%s, itag %d, ffmpeg %v) = %q, %v", tt.format, tt.f.Itag, tt.pp != nil, got, err)
+		}
+	}
+}
+
+func TestExtractAudioFFmpeg(t *testing.T) {
+	data := bytes.Repeat([]byte("m4a"), 1000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "media", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	var events []Progress
+	dir := t.TempDir()
+	d := New().WithHTTPClient(srv.Client()).WithOutputPath(dir).WithChecksum(true).
+		WithExtractAudio("mp3").WithPostProcessor(fakeFFmpeg(t)).
+		WithProgress(func(p Progress) {
+			if !p.Phase.IsDownload() {
+				events = append(events, p)
+			}
+		})
+	f := types.Format{Itag: 140, MimeType: `audio/mp4; codecs="mp4a.40.2"`, ACodec: "mp4a.40.2"}
+	out, err := d.downloadFormat(context.Background(), d.newFileDownloader(), srv.URL, f, &VideoInfo{ID: "dQw4w9WgXcQ", Title: "song", Duration: 2}, "")
+	if err != nil {
+		t.Fatalf("downloadFormat failed: %v", err)
+	}
+	if want := filepath.Join(dir, "song.mp3"); out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+	if b, err := os.ReadFile(out); err != nil || !bytes.Equal(b, data) {
+		t.Errorf("ffmpeg did not get the downloaded audio: %d bytes, %v", len(b), err)
+	}
+	entries, _ := os.ReadDir(dir)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	if len(names) != 2 || names[0] != "song.mp3" || names[1] != "song.mp3.sha256" {
+		t.Errorf("directory holds %v, want the mp3 and its checksum", names)
+	}
+	if len(events) != 2 || events[0].Phase != types.PhasePostProcessing || events[1].Percent != 100 {
+		t.Errorf("post-processing events = %+v", events)
+	}
+}
@@ -0,0 +1,185 @@
+package ytdlp
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ytget/ytdlp/v2/downloader"
+	"github.com/ytget/ytdlp/v2/internal/logging"
+	"github.com/ytget/ytdlp/v2/internal/mimeext"
+	"github.com/ytget/ytdlp/v2/internal/mp4"
+	internalSanitize "github.com/ytget/ytdlp/v2/internal/sanitize"
+	"github.com/ytget/ytdlp/v2/types"
+	"github.com/ytget/ytdlp/v2/youtube/innertube"
+)
+
+// maxChapterTitleLength bounds the chapter part of split file names, leaving
+// the rest of the file name length to the name of the split file.
+const maxChapterTitleLength = internalSanitize.MaxFilenameLength / 2
+
+// descriptionTimestampRe matches a description line that starts with a
+// timestamp such as "1:02:03", optionally in brackets, followed by a title.
+var descriptionTimestampRe = regexp.MustCompile(`^[\[(]?((?:\d{1,2}:)?\d{1,2}:\d{2})[\])]?\s*(?:[-–—:|]\s*)?(\S.*)$`)
+
+// wantChapters reports whether fetched video info should carry chapters.
+func (d *Downloader) wantChapters() bool {
+	return d.options.Chapters || d.options.EmbedMetadata || d.options.SplitChapters
+}
+
+// chapters returns the chapters of the video of pr: those of the player
+// response, else those of the watch page data, fetched with ctx, else the
+// timestamps listed in its description. Failing to fetch the watch page data
+// is only logged.
+func (d *Downloader) chapters(ctx context.Context, it *innertube.Client, pr *innertube.PlayerResponse, info *VideoInfo, log *slog.Logger) []types.Chapter {
+	duration := time.Duration(info.Duration) * time.Second
+	if chapters := innertube.ParseChapters(pr.Raw, duration); len(chapters) > 0 {
+		return chapters
+	}
+	if next, err := it.GetNextResponse(ctx, info.ID); err != nil {
+		log.Debug("Chapters not fetched", slog.String(logging.KeyStage, logging.StageInnertube), logging.Err(err))
+	} else if chapters := innertube.ParseChapters(next, duration); len(chapters) > 0 {
+		return chapters
+	}
+	return descriptionChapters(info.Description, duration)
+}
+
+// descriptionChapters returns the chapters listed in a description, one per
+// line starting with a timestamp, as YouTube itself reads them: the first
+// must start at 0:00, the starts must increase and there must be at least
+// two. The last chapter ends at duration.
+func descriptionChapters(description string, duration time.Duration) []types.Chapter {
+	var chapters []types.Chapter
+	for _, line := range strings.Split(description, "\n") {
+		m := descriptionTimestampRe.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		start := parseTimestamp(m[1])
+		if len(chapters) == 0 && start != 0 {
+			return nil
+		}
+		if len(chapters) > 0 && start <= chapters[len(chapters)-1].Start || duration > 0 && start >= duration {
+			continue
+		}
+		chapters = append(chapters, types.Chapter{Start: start, Title: strings.TrimSpace(m[2])})
+	}
+	if len(chapters) < 2 {
+		return nil
+	}
+	for i := range chapters {
+		if i+1 < len(chapters) {
+			chapters[i].End = chapters[i+1].Start
+		} else {
+			chapters[i].End = max(duration, chapters[i].Start)
+		}
+	}
+	return chapters
+}
+
+// parseTimestamp parses "[h:]m:ss".
+func parseTimestamp(s string) time.Duration {
+	var d time.Duration
+	for _, part := range strings.Split(s, ":") {
+		n, _ := strconv.Atoi(part)
+		d = d*60 + time.Duration(n)
+	}
+	return d * time.Second
+}
+
+// splitChapters splits the file at outputPath by the chapters of info and
+// writes the checksums of the parts when enabled. The file itself is kept.
+func (d *Downloader) splitChapters(outputPath string, info *VideoInfo) error {
+	log := d.log().With(slog.String(logging.KeyVideoID, info.ID), slog.String(logging.KeyStage, logging.StagePostProcess))
+	if len(info.Chapters) == 0 {
+		log.Info("No chapters to split by", slog.String("path", outputPath))
+		return nil
+	}
+	d.reportPhase(types.PhasePostProcessing)
+	paths, err := SplitChapters(outputPath, info.Chapters)
+	if err != nil {
+		return err
+	}
+	log.Info("Split by chapters", slog.String("path", outputPath), slog.Int("files", len(paths)))
+	if d.options.Checksum {
+		for _, p := range paths {
+			if _, err := downloader.WriteChecksum(p, p); err != nil {
+				return fmt.Errorf("write checksum failed: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// SplitChapters writes one file per chapter of the MP4 or M4A file at path
+// next to it, named "<name> - 001 <chapter title>.<ext>", and returns their
+// paths; long names and titles are shortened on character boundaries. The
+// file is left unchanged. Cuts are made at the last keyframe at
+// or before each chapter boundary, so that every file starts with one and
+// plays without re-encoding; a chapter without a keyframe of its own is
+// left out, its frames going to the next file. Chapter tracks and metadata
+// are not copied.
+func SplitChapters(path string, chapters []types.Chapter) ([]string, error) {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	if ext != mimeext.DefaultExt && ext != mimeext.ExtM4A {
+		return nil, fmt.Errorf("split chapters: unsupported container %q: only MP4 and M4A can be split", ext)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	all, err := mp4.ReadTracks(f, fi.Size())
+	if err != nil {
+		return nil, fmt.Errorf("split chapters: %w", err)
+	}
+	var tracks []*mp4.Track
+	for _, t := range all {
+		if t.Handler == "vide" || t.Handler == "soun" {
+			tracks = append(tracks, t)
+		}
+	}
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("split chapters: %s has no video or audio track", path)
+	}
+
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	var paths []string
+	for i, c := range chapters {
+		end := c.End
+		if i+1 == len(chapters) {
+			// The last file runs to the end, whatever the stated duration.
+			end = math.MaxInt64
+		}
+		if end <= c.Start {
+			continue
+		}
+		parts, err := mp4.Cut(tracks, c.Start, end)
+		if err != nil {
+			return paths, fmt.Errorf("split chapters: %w", err)
+		}
+		if parts == nil {
+			continue
+		}
+		tag := fmt.Sprintf(" - %03d ", i+1)
+		tag += internalSanitize.Truncate(c.Title, maxChapterTitleLength-len(tag))
+		name := internalSanitize.Truncate(base, internalSanitize.MaxFilenameLength-len(tag))
+		out := filepath.Join(filepath.Dir(path), internalSanitize.ToSafeFilename(name+tag, ext))
+		if err := muxMP4File(out, parts); err != nil {
+			return paths, fmt.Errorf("split chapters: %w", err)
+		}
+		paths = append(paths, out)
+	}
+	return paths, nil
+}
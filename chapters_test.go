@@ -0,0 +1,169 @@
+package ytdlp
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ytget/ytdlp/v2/internal/mp4"
+	internalSanitize "github.com/ytget/ytdlp/v2/internal/sanitize"
+	"github.com/ytget/ytdlp/v2/types"
+)
+
+func TestDescriptionChapters(t *testing.T) {
+	description := "Links below.\n" +
+		"0:00 Intro\n" +
+		"(1:05) - Setup\n" +
+		"  12:30 | Deep dive: part 1\n" +
+		"Recap at 12:00 below\n" +
+		"12:00 Out of order\n" +
+		"[1:02:03] Outro\n" +
+		"9:59:59 Past the end\n"
+	got := descriptionChapters(description, 2*time.Hour)
+	want := []types.Chapter{
+		{Start: 0, End: 65 * time.Second, Title: "Intro"},
+		{Start: 65 * time.Second, End: 750 * time.Second, Title: "Setup"},
+		{Start: 750 * time.Second, End: 3723 * time.Second, Title: "Deep dive: part 1"},
+		{Start: 3723 * time.Second, End: 2 * time.Hour, Title: "Outro"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("descriptionChapters() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("chapter %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	for name, d := range map[string]string{
+		"no zero start": "0:30 Intro\n1:00 Main",
+		"one chapter":   "0:00 Intro",
+		"no timestamps": "Just a video.",
+	} {
+		if got := descriptionChapters(d, time.Hour); got != nil {
+			t.Errorf("%s: descriptionChapters() = %+v", name, got)
+		}
+	}
+}
+
+func TestWantChapters(t *testing.T) {
+	for name, tc := range map[string]struct {
+		d    *Downloader
+		want bool
+	}{
+		"default":        {New(), false},
+		"chapters":       {New().WithChapters(true), true},
+		"embed metadata": {New().WithEmbedMetadata(true), true},
+		"split chapters": {New().WithSplitChapters(true), true},
+	} {
+		if got := tc.d.wantChapters(); got != tc.want {
+			t.Errorf("%s: wantChapters() = %v, want %v", name, got, tc.want)
+		}
+	}
+}
+
+func TestSplitChapters(t *testing.T) {
+	dir := t.TempDir()
+	video := filepath.Join(dir, "v.mp4")
+	audio := filepath.Join(dir, "a.mp4")
+	for path, data := range map[string][]byte{
+		video: fragmentedMP4("vide", "avc1", 12800, 512, 50),
+		audio: fragmentedMP4("soun", "mp4a", 44100, 1024, 86),
+	} {
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out := filepath.Join(dir, "out.mp4")
+	if err := mergeMP4Files(out, video, audio); err != nil {
+		t.Fatal(err)
+	}
+
+	info := &VideoInfo{ID: "dQw4w9WgXcQ", Chapters: []types.Chapter{
+		{Start: 0, End: 500 * time.Millisecond, Title: "Intro"},
+		{Start: 500 * time.Millisecond, End: 1500 * time.Millisecond, Title: "Main/Part"},
+		{Start: 1500 * time.Millisecond, End: time.Second, Title: "End"},
+	}}
+	d := New().WithChecksum(true)
+	if err := d.splitChapters(out, info); err != nil {
+		t.Fatalf("splitChapters() error = %v", err)
+	}
+	// Every video sample is a keyframe, so the cuts fall on the last frame
+	// at or before each boundary; the last file runs to the end although
+	// the chapter claims to end earlier.
+	for i, c := range []struct {
+		name  string
+		video int
+	}{{"out - 001 Intro.mp4", 12}, {"out - 002 Main_Part.mp4", 25}, {"out - 003 End.mp4", 13}} {
+		path := filepath.Join(dir, c.name)
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("part %d: %v", i+1, err)
+		}
+		tracks, err := mp4.ReadTracks(bytes.NewReader(b), int64(len(b)))
+		if err != nil || len(tracks) != 2 {
+			t.Errorf("part %d: %d tracks, %v", i+1, len(tracks), err)
+		} else if len(tracks[0].Samples) != c.video {
+			t.Errorf("part %d has %d video samples, want %d", i+1, len(tracks[0].Samples), c.video)
+		}
+		if _, err := os.Stat(path + ".sha256"); err != nil {
+			t.Errorf("part %d has no checksum: %v", i+1, err)
+		}
+	}
+	if _, err := os.Stat(out); err != nil {
+		t.Errorf("the split file was removed: %v", err)
+	}
+
+	if _, err := SplitChapters(filepath.Join(dir, "out.webm"), info.Chapters); err == nil {
+		t.Error("expected an error for WebM")
+	}
+	info.Chapters = nil
+	if err := d.splitChapters(out, info); err != nil {
+		t.Errorf("splitChapters() without chapters error = %v", err)
+	}
+}
+
+func TestSplitChaptersLongNames(t *testing.T) {
+	dir := t.TempDir()
+	video := filepath.Join(dir, "v.mp4")
+	audio := filepath.Join(dir, "a.mp4")
+	for path, data := range map[string][]byte{
+		video: fragmentedMP4("vide", "avc1", 12800, 512, 50),
+		audio: fragmentedMP4("soun", "mp4a", 44100, 1024, 86),
+	} {
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A 200-byte name of two-byte runes and chapter titles longer than a
+	// whole file name.
+	out := filepath.Join(dir, strings.Repeat("é", 100)+".mp4")
+	if err := mergeMP4Files(out, video, audio); err != nil {
+		t.Fatal(err)
+	}
+	long := strings.Repeat("日", 60)
+	paths, err := SplitChapters(out, []types.Chapter{
+		{Start: 0, End: 500 * time.Millisecond, Title: long},
+		{Start: 500 * time.Millisecond, End: time.Second, Title: long},
+	})
+	if err != nil {
+		t.Fatalf("SplitChapters() error = %v", err)
+	}
+	if len(paths) != 2 {
+		t.Fatalf("SplitChapters() = %v, want 2 files", paths)
+	}
+	for i, p := range paths {
+		name := filepath.Base(p)
+		if !utf8.ValidString(name) || len(name) > internalSanitize.MaxFilenameLength+len(".mp4") {
+			t.Errorf("part %d has an invalid name %q (%d bytes)", i+1, name, len(name))
+		}
+		if !strings.HasPrefix(name, "é") || !strings.Contains(name, fmt.Sprintf(" - %03d 日", i+1)) {
+			t.Errorf("part %d name %q lacks the file name or chapter", i+1, name)
+		}
+	}
+}
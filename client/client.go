@@ -1,10 +1,13 @@
 package client
 
 import (
+	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
 	"time"
+
+	"github.com/ytget/ytdlp/v2/internal/logging"
 )
 
 const (
@@ -46,6 +49,7 @@ type Config struct {
 	Retries   int
 	UserAgent string
 	ProxyURL  string
+	Logger    *slog.Logger
 }
 
 // Client wraps http.Client with retry/backoff and default headers.
@@ -53,6 +57,8 @@ type Client struct {
 	HTTPClient *http.Client
 	Retries    int
 	UserAgent  string
+	// Logger receives retry diagnostics; nil uses slog.Default().
+	Logger *slog.Logger
 }
 
 // New creates a new Client with a tuned Transport, default timeout, and retries.
@@ -96,9 +102,17 @@ func NewWith(cfg Config) *Client {
 		},
 		Retries:   retries,
 		UserAgent: ua,
+		Logger:    cfg.Logger,
 	}
 }
 
+// WithLogger sets the logger used for retry diagnostics. URLs are logged
+// with signature and key parameters redacted.
+func (c *Client) WithLogger(l *slog.Logger) *Client {
+	c.Logger = l
+	return c
+}
+
 // Get performs a GET request with a simple retry policy for transient errors
 // (HTTP 5xx or network failures). It sets a desktop-like User-Agent header.
 func (c *Client) Get(url string) (*http.Response, error) {
@@ -124,9 +138,17 @@ func (c *Client) Get(url string) (*http.Response, error) {
 		if err == nil && resp != nil && resp.StatusCode >= successMinCode && resp.StatusCode < retryableMinCode {
 			return resp, err
 		}
-		if resp != nil && resp.Body != nil {
-			_ = resp.Body.Close()
+		attrs := []any{slog.String(logging.KeyStage, logging.StageHTTP), logging.URL(url), slog.Int("attempt", attempt+1)}
+		if err != nil {
+			attrs = append(attrs, logging.Err(err))
+		}
+		if resp != nil {
+			attrs = append(attrs, slog.Int(logging.KeyStatus, resp.StatusCode))
+			if resp.Body != nil {
+				_ = resp.Body.Close()
+			}
 		}
+		logging.Or(c.Logger).Warn("Request failed", attrs...)
 		time.Sleep(backoff)
 		backoff *= 2
 		if backoff > maxBackoff {
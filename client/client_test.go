@@ -1,8 +1,11 @@
 package client
 
 import (
+	"bytes"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -238,6 +241,35 @@ func TestGetWithNegativeRetries(t *testing.T) {
 	_ = resp.Body.Close()
 }
 
+func TestGetRetryLogging(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	client := NewWith(Config{Retries: 2}).WithLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+	resp, err := client.Get(server.URL + "/watch?key=SECRET")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	_ = resp.Body.Close()
+
+	logs := buf.String()
+	if strings.Contains(logs, "SECRET") {
+		t.Errorf("key leaked into logs: %s", logs)
+	}
+	if !strings.Contains(logs, "status=503") || !strings.Contains(logs, "key=REDACTED") {
+		t.Errorf("Expected retry to be logged, got %q", logs)
+	}
+}
+
 func TestProxyFromURLString(t *testing.T) {
 	proxyURL := "http://proxy.example.com:8080"
 	proxyFunc, err := proxyFromURLString(proxyURL)
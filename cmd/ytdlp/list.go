@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ytget/ytdlp/v2/internal/mimeext"
+	"github.com/ytget/ytdlp/v2/types"
+	"github.com/ytget/ytdlp/v2/youtube/formats"
+)
+
+// listedFormat is the machine-readable view of a format printed by
+// --list-formats-json.
+type listedFormat struct {
+	Itag          int     `json:"itag"`
+	Ext           string  `json:"ext"`
+	Width         int     `json:"width,omitempty"`
+	Height        int     `json:"height,omitempty"`
+	FPS           int     `json:"fps,omitempty"`
+	DynamicRange  string  `json:"dynamic_range,omitempty"`
+	Projection    string  `json:"projection,omitempty"`
+	StereoLayout  string  `json:"stereo_layout,omitempty"`
+	VCodec        string  `json:"vcodec"`
+	ACodec        string  `json:"acodec"`
+	Bitrate       int     `json:"bitrate"`
+	Language      string  `json:"language,omitempty"`
+	AudioTrack    string  `json:"audio_track,omitempty"`
+	Filesize      int64   `json:"filesize,omitempty"`
+	FilesizeExact bool    `json:"filesize_exact"`
+	Adaptive      bool    `json:"adaptive"`
+	Quality       string  `json:"quality,omitempty"`
+	MimeType      string  `json:"mime_type"`
+	NeedsDecipher bool    `json:"needs_decipher"`
+	DurationSec   float64 `json:"duration_sec,omitempty"`
+}
+
+// toListedFormat converts a format into its listing representation.
+func toListedFormat(f types.Format) listedFormat {
+	size, exact := formatFilesize(f)
+	return listedFormat{
+		Itag:          f.Itag,
+		Ext:           mimeext.ExtFromMime(f.MimeType),
+		Width:         f.Width,
+		Height:        f.Height,
+		FPS:           f.FPS,
+		DynamicRange:  f.DynamicRange,
+		Projection:    f.Projection,
+		StereoLayout:  f.StereoLayout,
+		VCodec:        codecOrNone(f.VCodec),
+		ACodec:        codecOrNone(f.ACodec),
+		Bitrate:       f.Bitrate,
+		Language:      f.Language,
+		AudioTrack:    audioTrackName(f),
+		Filesize:      size,
+		FilesizeExact: exact,
+		Adaptive:      f.Adaptive,
+		Quality:       f.Quality,
+		MimeType:      f.MimeType,
+		NeedsDecipher: needsDecipher(f),
+		DurationSec:   float64(f.ApproxDurationMs) / 1000,
+	}
+}
+
+// formatFilesize returns the size of f and whether it is exact. Formats
+// built by hand without a size get an estimate from bitrate and duration.
+func formatFilesize(f types.Format) (int64, bool) {
+	if f.Size > 0 {
+		return f.Size, !f.SizeEstimated
+	}
+	return formats.EstimateSize(f), false
+}
+
+// needsDecipher reports whether the format URL must be built from a signatureCipher.
+func needsDecipher(f types.Format) bool {
+	return strings.TrimSpace(f.URL) == "" && f.SignatureCipher != ""
+}
+
+// audioTrackName returns the display name of the audio track of f, marking
+// the default track.
+func audioTrackName(f types.Format) string {
+	if f.AudioTrack == nil {
+		return ""
+	}
+	name := f.AudioTrack.DisplayName
+	if name == "" {
+		name = f.AudioTrack.ID
+	}
+	if f.AudioTrack.IsDefault {
+		name += " (default)"
+	}
+	return name
+}
+
+func codecOrNone(c string) string {
+	if c == "" {
+		return "none"
+	}
+	return c
+}
+
+// printFormatsTable writes a human-readable table of formats to w.
+func printFormatsTable(w io.Writer, list []types.Format) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "ITAG\tEXT\tRESOLUTION\tFPS\tVCODEC\tACODEC\tLANG\tBITRATE\tFILESIZE\tCIPHER")
+	for _, f := range list {
+		lf := toListedFormat(f)
+		res := "audio only"
+		if lf.VCodec != "none" || lf.Height > 0 {
+			res = fmt.Sprintf("%dx%d", lf.Width, lf.Height)
+			if f.IsHDR() {
+				res += " " + lf.DynamicRange
+			}
+			if f.IsSpherical() {
+				res += " " + lf.Projection
+			}
+			if f.Is3D() {
+				res += " 3D"
+			}
+		}
+		fps := ""
+		if lf.FPS > 0 {
+			fps = strconv.Itoa(lf.FPS)
+		}
+		size := "-"
+		if lf.Filesize > 0 {
+			size = humanBytes(lf.Filesize)
+			if !lf.FilesizeExact {
+				size = "~" + size
+			}
+		}
+		cipherCol := "no"
+		if lf.NeedsDecipher {
+			cipherCol = "yes"
+		}
+		lang := lf.Language
+		if f.AudioTrack != nil && f.AudioTrack.IsDefault {
+			lang += "*"
+		}
+		_, _ = fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%dk\t%s\t%s\n",
+			lf.Itag, lf.Ext, res, fps, lf.VCodec, lf.ACodec, lang, lf.Bitrate/1000, size, cipherCol)
+	}
+	return tw.Flush()
+}
+
+// printFormatsJSON writes formats as a JSON array to w.
+func printFormatsJSON(w io.Writer, list []types.Format) error {
+	out := make([]listedFormat, 0, len(list))
+	for _, f := range list {
+		out = append(out, toListedFormat(f))
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// printPresets writes the available format presets to w.
+func printPresets(w io.Writer, presets []formats.Preset) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "PRESET\tSELECTOR\tSORT\tDESCRIPTION")
+	for _, p := range presets {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", p.Name, p.Selector, p.Sort, p.Description)
+	}
+	return tw.Flush()
+}
+
+// humanBytes formats a byte count using binary units (e.g., "12.3MiB").
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	_ "net/http/pprof"
 	"net/url"
@@ -17,7 +18,10 @@ import (
 
 	"github.com/ytget/ytdlp/v2"
 	"github.com/ytget/ytdlp/v2/client"
+	"github.com/ytget/ytdlp/v2/downloader"
 	"github.com/ytget/ytdlp/v2/internal/botguard"
+	"github.com/ytget/ytdlp/v2/postprocess"
+	"github.com/ytget/ytdlp/v2/youtube/formats"
 )
 
 func main() {
@@ -39,6 +43,7 @@ func main() {
 
 	var (
 		flagFormat       string
+		flagFormatSort   string
 		flagExt          string
 		flagOutput       string
 		flagNoProgress   bool
@@ -47,6 +52,8 @@ func main() {
 		flagUA           string
 		flagProxy        string
 		flagRateLimit    string
+		flagRateBurst    string
+		flagConnections  int
 		flagPlaylist     bool
 		flagLimit        int
 		flagConcurrency  int
@@ -59,17 +66,44 @@ func main() {
 		flagClientName   string
 		flagClientVer    string
 		flagPrintURL     bool
+		flagListFormats  bool
+		flagListJSON     bool
+		flagAudioLang    string
+		flagAllAudio     bool
+		flagExtractAudio bool
+		flagAudioFormat  string
+		flagProbeSizes   bool
+		flagListPresets  bool
+		flagVerbose      bool
+		flagQuiet        bool
+		flagCheck        bool
+		flagChecksum     bool
+		flagEmbedMeta    bool
+		flagSplitChaps   bool
+		flagFFmpeg       bool
+		flagFFmpegPath   string
 	)
 
-	flag.StringVar(&flagFormat, "format", "", "Format selector (e.g., 'itag=22', 'best', 'height<=480')")
+	flag.StringVar(&flagFormat, "format", "", "Format selector or preset (e.g., 'itag=22', 'best[height<=480]', 'bv+ba/b', 'apple')")
+	flag.StringVar(&flagFormatSort, "format-sort", "", "Format sort order (e.g., 'res:1080,fps,vcodec:av01,+size')")
+	flag.StringVar(&flagFormatSort, "S", "", "Format sort order (shorthand for --format-sort)")
+	flag.BoolVar(&flagExtractAudio, "x", false, "Download audio only (shorthand for --extract-audio)")
+	flag.BoolVar(&flagExtractAudio, "extract-audio", false, "Download the best audio-only stream instead of a video")
+	flag.StringVar(&flagAudioFormat, "audio-format", "best", "Audio format for --extract-audio: best|m4a|opus, or mp3|aac|flac|wav|vorbis with --ffmpeg")
+	flag.StringVar(&flagAudioLang, "audio-lang", "", "Preferred audio track language (e.g., 'de', 'pt-BR')")
+	flag.BoolVar(&flagAllAudio, "all-audio-tracks", false, "Download the best audio of every audio track (one file per language)")
 	flag.StringVar(&flagExt, "ext", "", "Desired extension (e.g., 'mp4', 'webm')")
-	flag.StringVar(&flagOutput, "output", "", "Output path (file or directory). Empty derives from title + MIME")
+	flag.StringVar(&flagOutput, "output", "", "Output path (file or directory), or '-' for stdout. Empty derives from title + MIME")
+	flag.StringVar(&flagOutput, "o", "", "Output path (shorthand for --output)")
 	flag.BoolVar(&flagNoProgress, "no-progress", false, "Disable progress output")
 	flag.DurationVar(&flagTimeout, "http-timeout", 30*time.Second, "HTTP timeout (e.g., 30s, 1m)")
 	flag.IntVar(&flagRetries, "retries", 3, "HTTP retries for transient errors")
 	flag.StringVar(&flagUA, "ua", "", "Override User-Agent header")
 	flag.StringVar(&flagProxy, "proxy", "", "Proxy URL (http/https/socks)")
-	flag.StringVar(&flagRateLimit, "rate-limit", "", "Download rate limit (e.g., 2MiB/s, 500KiB/s)")
+	flag.StringVar(&flagRateLimit, "rate-limit", "", "Download rate limit for all downloads together (e.g., 2MiB/s, 500KiB/s)")
+	flag.StringVar(&flagRateBurst, "rate-burst", "", "Burst size for --rate-limit (e.g., 256KiB). Empty uses a small default")
+	flag.IntVar(&flagConnections, "N", 1, "Parallel connections per file (shorthand for --connections)")
+	flag.IntVar(&flagConnections, "connections", 1, "Parallel connections per file (byte ranges fetched concurrently)")
 	flag.BoolVar(&flagPlaylist, "playlist", false, "Treat input as playlist URL or ID")
 	flag.IntVar(&flagLimit, "limit", 0, "Max items to process for playlist (0 means all)")
 	flag.IntVar(&flagConcurrency, "concurrency", 1, "Parallelism for playlist downloads")
@@ -83,6 +117,21 @@ func main() {
 	flag.StringVar(&flagClientVer, "client-version", "", "Innertube client version (default 20.10.38)")
 	flag.BoolVar(&flagPrintURL, "g", false, "Print final media URL and exit (no download)")
 	flag.BoolVar(&flagPrintURL, "print-url", false, "Print final media URL and exit (no download)")
+	flag.BoolVar(&flagListFormats, "F", false, "List available formats as a table and exit (no download)")
+	flag.BoolVar(&flagListFormats, "list-formats", false, "List available formats as a table and exit (no download)")
+	flag.BoolVar(&flagListPresets, "list-presets", false, "List format presets accepted by --format and exit")
+	flag.BoolVar(&flagProbeSizes, "probe-sizes", false, "Probe exact sizes of formats without a content length (one request per format)")
+	flag.BoolVar(&flagListJSON, "list-formats-json", false, "List available formats as JSON and exit (no download)")
+	flag.BoolVar(&flagCheck, "check-container", false, "Check the MP4/WebM structure of downloaded files before keeping them")
+	flag.BoolVar(&flagChecksum, "sha256", false, "Write the SHA-256 of each downloaded file to '<file>.sha256'")
+	flag.BoolVar(&flagEmbedMeta, "embed-metadata", false, "Write title, channel, date, description, URL, chapters and the thumbnail as cover art into MP4/M4A/WebM/MKV files")
+	flag.BoolVar(&flagSplitChaps, "split-chapters", false, "Also write one MP4/M4A file per chapter, cut at keyframes ('<name> - 001 <chapter>.mp4')")
+	flag.BoolVar(&flagFFmpeg, "ffmpeg", false, "Use ffmpeg from PATH for merges and audio formats the built-in muxers cannot produce")
+	flag.StringVar(&flagFFmpegPath, "ffmpeg-location", "", "Path of the ffmpeg binary or its directory (implies --ffmpeg)")
+	flag.BoolVar(&flagVerbose, "v", false, "Verbose logging (shorthand for --verbose)")
+	flag.BoolVar(&flagVerbose, "verbose", false, "Log debug details (requests, deciphering, retries) to stderr")
+	flag.BoolVar(&flagQuiet, "q", false, "Only log errors (shorthand for --quiet)")
+	flag.BoolVar(&flagQuiet, "quiet", false, "Only log errors")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: %s [flags] <video_or_playlist_url>\n", os.Args[0])
@@ -91,6 +140,13 @@ func main() {
 	}
 
 	flag.Parse()
+	if flagListPresets {
+		if err := printPresets(os.Stdout, formats.Presets()); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 	args := flag.Args()
 	if len(args) < 1 {
 		flag.Usage()
@@ -98,9 +154,29 @@ func main() {
 	}
 
 	input := strings.TrimSpace(args[0])
+	toStdout := flagOutput == "-"
+	// One limiter for every download, so --rate-limit is a global limit even
+	// with --concurrency and --connections.
+	limiter := downloader.NewLimiter(parseRate(flagRateLimit), parseRate(flagRateBurst))
+	if toStdout && (flagPlaylist || flagAllAudio) {
+		fmt.Fprintln(os.Stderr, "Output '-' (stdout) is not supported with --playlist or --all-audio-tracks")
+		os.Exit(2)
+	}
+
+	logger := newLogger(flagVerbose, flagQuiet)
+	slog.SetDefault(logger)
+
+	var pp *postprocess.PostProcessor
+	if flagFFmpeg || flagFFmpegPath != "" {
+		pp = postprocess.New().WithPath(flagFFmpegPath).WithLogger(logger)
+		if _, err := pp.Locate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+	}
 
 	// Build client config
-	cfg := client.Config{Timeout: flagTimeout, Retries: flagRetries, UserAgent: flagUA, ProxyURL: flagProxy}
+	cfg := client.Config{Timeout: flagTimeout, Retries: flagRetries, UserAgent: flagUA, ProxyURL: flagProxy, Logger: logger}
 	c := client.NewWith(cfg)
 
 	// Determine Botguard mode and initialize solver/cache
@@ -155,7 +231,7 @@ func main() {
 			}
 		}
 
-		d := ytdlp.New().WithHTTPClient(c.HTTPClient).
+		d := ytdlp.New().WithHTTPClient(c.HTTPClient).WithLogger(logger).
 			WithBotguard(bgMode, solver, cache).
 			WithBotguardDebug(flagBGDebug).
 			WithBotguardTTL(flagBGCacheTTL)
@@ -181,7 +257,7 @@ func main() {
 		for w := 0; w < flagConcurrency; w++ {
 			go func() {
 				defer wg.Done()
-				localD := ytdlp.New().WithHTTPClient(c.HTTPClient).
+				localD := ytdlp.New().WithHTTPClient(c.HTTPClient).WithLogger(logger).
 					WithBotguard(bgMode, solver, cache).
 					WithBotguardDebug(flagBGDebug).
 					WithBotguardTTL(flagBGCacheTTL)
@@ -191,15 +267,30 @@ func main() {
 				if flagFormat != "" || flagExt != "" {
 					localD = localD.WithFormat(flagFormat, flagExt)
 				}
-				if bps := parseRate(flagRateLimit); bps > 0 {
-					localD = localD.WithRateLimit(bps)
+				if flagFormatSort != "" {
+					localD = localD.WithFormatSort(flagFormatSort)
+				}
+				if flagAudioLang != "" {
+					localD = localD.WithAudioLanguage(flagAudioLang)
+				}
+				if flagExtractAudio {
+					localD = localD.WithExtractAudio(flagAudioFormat)
+				}
+				if flagProbeSizes {
+					localD = localD.WithSizeProbe(true)
+				}
+				if limiter != nil {
+					localD = localD.WithLimiter(limiter)
+				}
+				if flagConnections > 1 {
+					localD = localD.WithConnections(flagConnections)
+				}
+				localD = localD.WithContainerCheck(flagCheck).WithChecksum(flagChecksum).WithEmbedMetadata(flagEmbedMeta).WithSplitChapters(flagSplitChaps)
+				if pp != nil {
+					localD = localD.WithPostProcessor(pp)
 				}
 				if !flagNoProgress && flagConcurrency == 1 {
-					localD = localD.WithProgress(func(p ytdlp.Progress) {
-						if p.TotalSize > 0 {
-							_, _ = fmt.Fprintf(os.Stdout, "Downloaded %.1f%%\r", p.Percent)
-						}
-					})
+					localD = localD.WithProgress(printProgress(os.Stdout)).WithProgressInterval(progressInterval)
 				}
 				for idx := range jobs {
 					item := items[idx]
@@ -228,7 +319,7 @@ func main() {
 		return
 	}
 
-	d := ytdlp.New().WithHTTPClient(c.HTTPClient).
+	d := ytdlp.New().WithHTTPClient(c.HTTPClient).WithLogger(logger).
 		WithBotguard(bgMode, solver, cache).
 		WithBotguardDebug(flagBGDebug).
 		WithBotguardTTL(flagBGCacheTTL)
@@ -238,18 +329,66 @@ func main() {
 	if flagFormat != "" || flagExt != "" {
 		d = d.WithFormat(flagFormat, flagExt)
 	}
-	if flagOutput != "" {
+	if flagFormatSort != "" {
+		d = d.WithFormatSort(flagFormatSort)
+	}
+	if flagAudioLang != "" {
+		d = d.WithAudioLanguage(flagAudioLang)
+	}
+	if flagExtractAudio {
+		d = d.WithExtractAudio(flagAudioFormat)
+	}
+	if flagProbeSizes {
+		d = d.WithSizeProbe(true)
+	}
+	if flagOutput != "" && !toStdout {
 		d = d.WithOutputPath(flagOutput)
 	}
-	if !flagNoProgress && !flagPrintURL {
-		d = d.WithProgress(func(p ytdlp.Progress) {
-			if p.TotalSize > 0 {
-				_, _ = fmt.Fprintf(os.Stdout, "Downloaded %.1f%%\r", p.Percent)
-			}
-		})
+	// When the media goes to stdout, everything else goes to stderr.
+	msgOut := os.Stdout
+	if toStdout {
+		msgOut = os.Stderr
 	}
-	if bps := parseRate(flagRateLimit); bps > 0 {
-		d = d.WithRateLimit(bps)
+	listing := flagListFormats || flagListJSON
+	if !flagNoProgress && !flagPrintURL && !listing {
+		d = d.WithProgress(printProgress(msgOut)).WithProgressInterval(progressInterval)
+	}
+	if limiter != nil {
+		d = d.WithLimiter(limiter)
+	}
+	if flagConnections > 1 {
+		d = d.WithConnections(flagConnections)
+	}
+	d = d.WithContainerCheck(flagCheck).WithChecksum(flagChecksum).WithEmbedMetadata(flagEmbedMeta).WithSplitChapters(flagSplitChaps)
+	if pp != nil {
+		d = d.WithPostProcessor(pp)
+	}
+
+	if listing {
+		info, err := d.GetInfo(context.Background(), input)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		sorted, err := formats.Sort(info.Formats, flagFormatSort)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+		// Like yt-dlp, list from worst to best so the best formats end up last.
+		for i, j := 0, len(sorted)-1; i < j; i, j = i+1, j-1 {
+			sorted[i], sorted[j] = sorted[j], sorted[i]
+		}
+		if flagListJSON {
+			err = printFormatsJSON(os.Stdout, sorted)
+		} else {
+			err = printFormatsTable(os.Stdout, sorted)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
 	}
 
 	if flagPrintURL {
@@ -263,6 +402,28 @@ func main() {
 		return
 	}
 
+	if flagAllAudio {
+		_, files, err := d.DownloadAllAudioTracks(context.Background(), input)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		for _, f := range files {
+			_, _ = fmt.Fprintf(os.Stdout, "\nSaved: %s\n", f.Path)
+		}
+		return
+	}
+
+	if toStdout {
+		info, err := d.DownloadTo(context.Background(), input, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		_, _ = fmt.Fprintf(msgOut, "\nWritten to stdout: %s\n", info.Title)
+		return
+	}
+
 	info, err := d.Download(context.Background(), input)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
@@ -272,6 +433,20 @@ func main() {
 	_, _ = fmt.Fprintf(os.Stdout, "\nSaved: %s\n", info.Title)
 }
 
+// newLogger returns the logger for all library messages, written to stderr
+// so that they never mix with media written to stdout. Warnings and errors
+// are shown by default.
+func newLogger(verbose, quiet bool) *slog.Logger {
+	level := slog.LevelWarn
+	switch {
+	case quiet:
+		level = slog.LevelError
+	case verbose:
+		level = slog.LevelDebug
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+}
+
 // parseRate parses strings like "2MiB/s", "500KiB/s" into bytes per second.
 func parseRate(s string) int64 {
 	s = strings.TrimSpace(strings.ToUpper(s))
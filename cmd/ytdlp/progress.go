@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ytget/ytdlp/v2"
+	"github.com/ytget/ytdlp/v2/types"
+)
+
+// progressInterval limits how often the progress line is redrawn.
+const progressInterval = 200 * time.Millisecond
+
+// phaseLabels are the progress line prefixes of each phase.
+var phaseLabels = map[types.Phase]string{
+	types.PhaseResolving:        "Resolving",
+	types.PhaseDeciphering:      "Deciphering",
+	types.PhaseDownloading:      "Downloading",
+	types.PhaseDownloadingVideo: "Downloading video",
+	types.PhaseDownloadingAudio: "Downloading audio",
+	types.PhaseMerging:          "Merging",
+	types.PhasePostProcessing:   "Post-processing",
+}
+
+// printProgress returns a progress callback that redraws a single status
+// line on w, e.g. "Downloading video  42.1% of 12.3MiB at 2.1MiB/s ETA 0:05".
+func printProgress(w io.Writer) func(ytdlp.Progress) {
+	return func(p ytdlp.Progress) {
+		label := phaseLabels[p.Phase]
+		if label == "" {
+			label = string(p.Phase)
+		}
+		if !p.Phase.IsDownload() {
+			if p.Percent > 0 {
+				label += fmt.Sprintf(" %5.1f%%", p.Percent)
+			}
+			_, _ = fmt.Fprintf(w, "\r%-72s\r", label+"...")
+			return
+		}
+		line := label
+		if p.TotalSize > 0 {
+			line += fmt.Sprintf(" %5.1f%% of %s", p.Percent, humanBytes(p.TotalSize))
+		} else {
+			line += " " + humanBytes(p.DownloadedSize)
+		}
+		if p.Speed > 0 {
+			line += " at " + humanBytes(int64(p.Speed)) + "/s"
+		}
+		if p.ETA > 0 {
+			line += " ETA " + formatETA(p.ETA)
+		}
+		_, _ = fmt.Fprintf(w, "\r%-72s", line)
+	}
+}
+
+// formatETA formats d as "m:ss" or "h:mm:ss".
+func formatETA(d time.Duration) string {
+	s := int64(d.Round(time.Second) / time.Second)
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
@@ -0,0 +1,162 @@
+package downloader
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultMinChunkSizeBytes is the lower bound used when
+	// WithAdaptiveChunkSize is given none.
+	defaultMinChunkSizeBytes = 256 << 10
+	// adaptiveChunkTarget is how long an adaptive chunk should take to
+	// download: long enough to amortize the request, short enough that a
+	// failure loses little.
+	adaptiveChunkTarget = 2 * time.Second
+)
+
+// WithAdaptiveChunkSize lets the size of ranged requests follow the measured
+// throughput, between minSize and maxSize bytes. Chunks that arrive quickly
+// grow the size (at most doubling it per chunk) and slow chunks shrink it,
+// aiming at about two seconds per request; every failed or interrupted
+// request halves it. minSize <= 0 selects 256 KiB; maxSize below minSize is
+// raised to minSize. Progress fragments keep the default 1 MiB grid, which
+// the resume sidecar pins for the whole download.
+func (d *Downloader) WithAdaptiveChunkSize(minSize, maxSize int64) *Downloader {
+	if minSize <= 0 {
+		minSize = defaultMinChunkSizeBytes
+	}
+	if maxSize < minSize {
+		maxSize = minSize
+	}
+	d.minChunkSize, d.maxChunkSize = minSize, maxSize
+	return d
+}
+
+// WithRangeParam makes requests to googlevideo hosts ask for a byte range
+// with the "range=start-end" query parameter instead of the Range header.
+// These servers throttle the two differently and answer the parameter with
+// 200 and just the requested bytes. Other hosts still get the header.
+func (d *Downloader) WithRangeParam(enabled bool) *Downloader {
+	d.rangeParam = enabled
+	return d
+}
+
+// chunkSizer picks the size of the next ranged request. It is shared by all
+// connections of a download and safe for concurrent use; a nil chunkSizer
+// ignores observations.
+type chunkSizer struct {
+	mu       sync.Mutex
+	size     int64
+	min, max int64
+}
+
+// newChunkSizer returns a sizer for one download with the given chunk size.
+// Without adaptive sizing its size is fixed at chunkSize; with it, sizing
+// starts there.
+func (d *Downloader) newChunkSizer(chunkSize int64) *chunkSizer {
+	lo, hi := d.minChunkSize, d.maxChunkSize
+	if hi == 0 {
+		lo, hi = chunkSize, chunkSize
+	}
+	return &chunkSizer{size: clampSize(chunkSize, lo, hi), min: lo, max: hi}
+}
+
+// next returns the size of the next request.
+func (s *chunkSizer) next() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// observe adapts the size to n bytes received in elapsed.
+func (s *chunkSizer) observe(n int64, elapsed time.Duration) {
+	if s == nil || n <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elapsed <= 0 {
+		s.size = clampSize(s.size*2, s.min, s.max)
+		return
+	}
+	ideal := int64(float64(n) / elapsed.Seconds() * adaptiveChunkTarget.Seconds())
+	ideal = clampSize(ideal, s.size/2, s.size*2)
+	s.size = clampSize(ideal, s.min, s.max)
+}
+
+// failed halves the size after a failed or interrupted request.
+func (s *chunkSizer) failed() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.size = clampSize(s.size/2, s.min, s.max)
+}
+
+func clampSize(v, lo, hi int64) int64 {
+	return max(lo, min(v, hi))
+}
+
+// rangeQueue hands out the missing parts of a file in pieces of the size
+// each caller asks for. It is safe for concurrent use.
+type rangeQueue struct {
+	mu     sync.Mutex
+	ranges []byteRange
+}
+
+// next removes and returns up to size bytes from the start of the first
+// missing range. It returns false when nothing is left.
+func (q *rangeQueue) next(size int64) (byteRange, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.ranges) == 0 {
+		return byteRange{}, false
+	}
+	r := q.ranges[0]
+	if r.Len() > size {
+		r.End = r.Start + size
+		q.ranges[0].Start = r.End
+	} else {
+		q.ranges = q.ranges[1:]
+	}
+	return r, true
+}
+
+// setRange asks req for bytes start..end (inclusive), with the "range" query
+// parameter for googlevideo hosts in range-parameter mode and with the Range
+// header otherwise. It reports whether the parameter was used.
+func (d *Downloader) setRange(req *http.Request, start, end int64) bool {
+	if !d.rangeParam || !isGoogleVideoHost(req.URL.String()) {
+		req.Header.Set(headerRange, fmt.Sprintf("bytes=%d-%d", start, end))
+		return false
+	}
+	var kept []string
+	for _, p := range strings.Split(req.URL.RawQuery, "&") {
+		if p != "" && !strings.HasPrefix(p, "range=") {
+			kept = append(kept, p)
+		}
+	}
+	// Keep the other parameters as they are: re-encoding them could alter
+	// the signed values.
+	req.URL.RawQuery = strings.Join(append(kept, fmt.Sprintf("range=%d-%d", start, end)), "&")
+	return true
+}
+
+// asPartialContent makes the 200 answer to a range-parameter request look
+// like the 206 answer to a Range header, so that callers handle both alike.
+// The total size is not known from such a response.
+func asPartialContent(resp *http.Response, start, end int64) {
+	if resp.StatusCode != http.StatusOK {
+		return
+	}
+	if resp.ContentLength >= 0 && start+resp.ContentLength-1 < end {
+		end = start + resp.ContentLength - 1
+	}
+	resp.StatusCode = http.StatusPartialContent
+	resp.Header.Set(headerContentRange, fmt.Sprintf("bytes %d-%d/*", start, end))
+}
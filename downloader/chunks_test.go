@@ -0,0 +1,179 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestChunkSizer(t *testing.T) {
+	fixed := New(nil, nil, 0).newChunkSizer(defaultChunkSizeBytes)
+	fixed.observe(defaultChunkSizeBytes, time.Millisecond)
+	fixed.failed()
+	if got := fixed.next(); got != defaultChunkSizeBytes {
+		t.Errorf("fixed size changed to %d", got)
+	}
+
+	s := New(nil, nil, 0).WithAdaptiveChunkSize(256<<10, 4<<20).newChunkSizer(defaultChunkSizeBytes)
+	if got := s.next(); got != 1<<20 {
+		t.Fatalf("initial size = %d, want 1 MiB", got)
+	}
+	s.observe(1<<20, 10*time.Millisecond) // fast: grows, at most doubling
+	if got := s.next(); got != 2<<20 {
+		t.Errorf("after fast chunk size = %d, want 2 MiB", got)
+	}
+	s.observe(2<<20, time.Millisecond)
+	s.observe(4<<20, time.Millisecond)
+	if got := s.next(); got != 4<<20 {
+		t.Errorf("size = %d, want max 4 MiB", got)
+	}
+	s.observe(4<<20, 4*time.Second) // 1 MiB/s: two seconds is 2 MiB
+	if got := s.next(); got != 2<<20 {
+		t.Errorf("after slow chunk size = %d, want 2 MiB", got)
+	}
+	s.failed()
+	s.failed()
+	s.failed()
+	if got := s.next(); got != 256<<10 {
+		t.Errorf("after failures size = %d, want min 256 KiB", got)
+	}
+
+	if d := New(nil, nil, 0).WithAdaptiveChunkSize(0, 1); d.minChunkSize != defaultMinChunkSizeBytes || d.maxChunkSize != defaultMinChunkSizeBytes {
+		t.Errorf("defaults: min=%d max=%d", d.minChunkSize, d.maxChunkSize)
+	}
+}
+
+func TestRangeQueue(t *testing.T) {
+	q := &rangeQueue{ranges: []byteRange{{0, 10}, {20, 25}}}
+	var got []byteRange
+	for _, size := range []int64{4, 100, 3, 3} {
+		r, ok := q.next(size)
+		if !ok {
+			t.Fatalf("queue empty too early, got %v", got)
+		}
+		got = append(got, r)
+	}
+	want := []byteRange{{0, 4}, {4, 10}, {20, 23}, {23, 25}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("ranges = %v, want %v", got, want)
+	}
+	if _, ok := q.next(1); ok {
+		t.Error("expected an empty queue")
+	}
+}
+
+func TestDownloadAdaptiveChunkSize(t *testing.T) {
+	data := testData(7<<20 + 11)
+	var (
+		mu      sync.Mutex
+		largest int
+	)
+	inner := makeServer(data)
+	defer inner.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a, b int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &a, &b); err == nil {
+			mu.Lock()
+			largest = max(largest, b-a+1)
+			mu.Unlock()
+		}
+		inner.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	for _, connections := range []int{1, 3} {
+		largest = 0
+		out := filepath.Join(t.TempDir(), "file.bin")
+		dl := New(server.Client(), nil, 0).WithConnections(connections).WithAdaptiveChunkSize(64<<10, 4<<20)
+		if err := dl.Download(context.Background(), server.URL, out); err != nil {
+			t.Fatalf("connections=%d: download failed: %v", connections, err)
+		}
+		got, err := os.ReadFile(out)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("connections=%d: content mismatch: err=%v got=%d want=%d", connections, err, len(got), len(data))
+		}
+		if largest <= defaultChunkSizeBytes {
+			t.Errorf("connections=%d: largest request %d bytes, expected chunks to grow on a fast link", connections, largest)
+		}
+	}
+}
+
+// googleVideoTransport sends requests for googlevideo hosts to a test
+// server.
+type googleVideoTransport struct {
+	target *url.URL
+}
+
+func (t googleVideoTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	r.URL.Scheme, r.URL.Host = t.target.Scheme, t.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestDownloadRangeParam(t *testing.T) {
+	data := testData(3<<20 + 5)
+	var (
+		mu       sync.Mutex
+		paramReq int
+		failures []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rng := r.URL.Query().Get("range")
+		if rng == "" {
+			// Size probe: a plain Range request.
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-1/%d", len(data)))
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write(data[:2])
+			return
+		}
+		mu.Lock()
+		paramReq++
+		if r.Header.Get("Range") != "" {
+			failures = append(failures, "Range header sent with range parameter")
+		}
+		if got := r.URL.RawQuery; !strings.HasPrefix(got, "sparams=expire%2Cid&sig=A%2FB&range=") {
+			failures = append(failures, "query rewritten: "+got)
+		}
+		mu.Unlock()
+		var a, b int
+		_, _ = fmt.Sscanf(rng, "%d-%d", &a, &b)
+		b = min(b, len(data)-1)
+		w.Header().Set("Content-Length", fmt.Sprint(b-a+1))
+		_, _ = w.Write(data[a : b+1])
+	}))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+	client := &http.Client{Transport: googleVideoTransport{target: target}}
+	mediaURL := "https://rr1---sn-abc.googlevideo.com/videoplayback?sparams=expire%2Cid&sig=A%2FB"
+
+	for _, connections := range []int{1, 3} {
+		out := filepath.Join(t.TempDir(), "file.bin")
+		dl := New(client, nil, 0).WithConnections(connections).WithRangeParam(true)
+		if err := dl.Download(context.Background(), mediaURL, out); err != nil {
+			t.Fatalf("connections=%d: download failed: %v", connections, err)
+		}
+		got, err := os.ReadFile(out)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("connections=%d: content mismatch: err=%v got=%d want=%d", connections, err, len(got), len(data))
+		}
+	}
+	var buf bytes.Buffer
+	if err := New(client, nil, 0).WithRangeParam(true).DownloadTo(context.Background(), mediaURL, &buf); err != nil || !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("DownloadTo: err=%v got=%d want=%d", err, buf.Len(), len(data))
+	}
+	if paramReq == 0 {
+		t.Fatal("no request used the range parameter")
+	}
+	for _, f := range failures {
+		t.Error(f)
+	}
+}
@@ -0,0 +1,206 @@
+package downloader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ytget/ytdlp/v2/internal/webm"
+)
+
+// Container is a media container whose structure can be checked after a
+// download (see WithContainerCheck).
+type Container string
+
+const (
+	// ContainerMP4 covers MP4 and M4A files (ISO base media format).
+	ContainerMP4 Container = "mp4"
+	// ContainerWebM covers WebM and Matroska files.
+	ContainerWebM Container = "webm"
+)
+
+// ContainerFromMime returns the container of a video/* or audio/* MIME type,
+// or "" when it cannot be checked.
+func ContainerFromMime(mime string) Container {
+	base, _, _ := strings.Cut(mime, ";")
+	_, sub, _ := strings.Cut(strings.TrimSpace(base), "/")
+	switch strings.ToLower(sub) {
+	case "mp4":
+		return ContainerMP4
+	case "webm":
+		return ContainerWebM
+	}
+	return ""
+}
+
+// CheckContainer checks that the file at path is a complete c container.
+// Failures wrap ErrIntegrity.
+func CheckContainer(path string, c Container) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	switch c {
+	case ContainerMP4:
+		err = checkMP4(f, fi.Size())
+	case ContainerWebM:
+		err = checkWebM(f, fi.Size())
+	default:
+		return fmt.Errorf("unknown container %q", c)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrIntegrity, c, err)
+	}
+	return nil
+}
+
+// checkMP4 walks the top-level boxes of an MP4 file. The file must start
+// with ftyp, contain moov and mdat, and end exactly where its last box ends.
+func checkMP4(r io.ReaderAt, size int64) error {
+	seen := make(map[string]bool)
+	var hdr [16]byte
+	for off := int64(0); off < size; {
+		if size-off < 8 {
+			return fmt.Errorf("%d trailing bytes at offset %d", size-off, off)
+		}
+		if _, err := r.ReadAt(hdr[:8], off); err != nil {
+			return err
+		}
+		boxSize := int64(binary.BigEndian.Uint32(hdr[:4]))
+		typ := string(hdr[4:8])
+		headerLen := int64(8)
+		switch boxSize {
+		case 0: // box extends to the end of the file
+			boxSize = size - off
+		case 1: // 64-bit size follows the type
+			if _, err := r.ReadAt(hdr[8:16], off+8); err != nil {
+				return fmt.Errorf("box %q at offset %d: %v", typ, off, err)
+			}
+			boxSize = int64(binary.BigEndian.Uint64(hdr[8:16]))
+			headerLen = 16
+		}
+		if off == 0 && typ != "ftyp" {
+			return fmt.Errorf("first box is %q, not ftyp", typ)
+		}
+		if boxSize < headerLen || boxSize > size-off {
+			return fmt.Errorf("box %q at offset %d has size %d, %d bytes left", typ, off, boxSize, size-off)
+		}
+		seen[typ] = true
+		off += boxSize
+	}
+	for _, typ := range []string{"moov", "mdat"} {
+		if !seen[typ] {
+			return fmt.Errorf("no %s box", typ)
+		}
+	}
+	return nil
+}
+
+// EBML element IDs checked by checkWebM.
+const (
+	ebmlID        = 0x1A45DFA3
+	ebmlDocTypeID = 0x4282
+	ebmlSegmentID = 0x18538067
+)
+
+// checkWebM checks the EBML header and its DocType, that a Segment follows
+// and, when its size is known, ends within the file, and that the segment
+// holds tracks and at least one cluster.
+func checkWebM(r io.ReaderAt, size int64) error {
+	sr := io.NewSectionReader(r, 0, size)
+	id, hdrSize, err := readEBMLHeader(sr)
+	if err != nil || id != ebmlID {
+		return fmt.Errorf("missing EBML header")
+	}
+	if hdrSize < 0 || hdrSize > 1<<10 {
+		return fmt.Errorf("bad EBML header size %d", hdrSize)
+	}
+	body := make([]byte, hdrSize)
+	if _, err := io.ReadFull(sr, body); err != nil {
+		return fmt.Errorf("truncated EBML header")
+	}
+	if dt := ebmlDocType(body); dt != "webm" && dt != "matroska" {
+		return fmt.Errorf("unexpected DocType %q", dt)
+	}
+	id, segSize, err := readEBMLHeader(sr)
+	if err != nil || id != ebmlSegmentID {
+		return fmt.Errorf("no Segment after the EBML header")
+	}
+	pos, _ := sr.Seek(0, io.SeekCurrent)
+	if segSize >= 0 && pos+segSize > size {
+		return fmt.Errorf("segment ends at %d, file has %d bytes", pos+segSize, size)
+	}
+	if _, err := webm.NewReader(io.NewSectionReader(r, 0, size)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readEBMLHeader reads an element ID and data size; an unknown size is
+// returned as -1.
+func readEBMLHeader(r io.Reader) (uint32, int64, error) {
+	id, _, err := readEBMLVint(r, true)
+	if err != nil {
+		return 0, 0, err
+	}
+	size, n, err := readEBMLVint(r, false)
+	if err != nil {
+		return 0, 0, err
+	}
+	if size == 1<<(7*n)-1 {
+		return uint32(id), -1, nil
+	}
+	return uint32(id), int64(size), nil
+}
+
+// readEBMLVint reads a variable-length integer and its length. With
+// keepMarker the length marker is kept, as in element IDs.
+func readEBMLVint(r io.Reader, keepMarker bool) (uint64, int, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[:1]); err != nil {
+		return 0, 0, err
+	}
+	n := 1
+	for mask := byte(0x80); n <= 8 && b[0]&mask == 0; mask >>= 1 {
+		n++
+	}
+	if n > 8 {
+		return 0, 0, fmt.Errorf("bad variable-length integer")
+	}
+	if _, err := io.ReadFull(r, b[1:n]); err != nil {
+		return 0, 0, err
+	}
+	v := uint64(b[0])
+	if !keepMarker {
+		v &= uint64(0xFF >> n)
+	}
+	for _, c := range b[1:n] {
+		v = v<<8 | uint64(c)
+	}
+	return v, n, nil
+}
+
+// ebmlDocType returns the DocType of an EBML header body.
+func ebmlDocType(body []byte) string {
+	r := bytes.NewReader(body)
+	for r.Len() > 0 {
+		id, size, err := readEBMLHeader(r)
+		if err != nil || size < 0 || size > int64(r.Len()) {
+			return ""
+		}
+		v := make([]byte, size)
+		_, _ = io.ReadFull(r, v)
+		if id == ebmlDocTypeID {
+			return string(bytes.TrimRight(v, "\x00"))
+		}
+	}
+	return ""
+}
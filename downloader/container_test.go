@@ -0,0 +1,104 @@
+package downloader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// box encodes an MP4 box.
+func box(typ string, payload []byte) []byte {
+	b := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(b, uint32(8+len(payload)))
+	copy(b[4:], typ)
+	return append(b, payload...)
+}
+
+// ebmlEl encodes an EBML element with a one-byte size.
+func ebmlEl(id uint32, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	var out []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if b := byte(id >> shift); b != 0 || len(out) > 0 {
+			out = append(out, b)
+		}
+	}
+	return append(append(out, 0x80|byte(len(body))), body...)
+}
+
+func testMP4() []byte {
+	return bytes.Join([][]byte{
+		box("ftyp", []byte("dash\x00\x00\x00\x00iso6mp41")),
+		box("moov", box("mvhd", make([]byte, 20))),
+		box("sidx", make([]byte, 12)),
+		box("moof", make([]byte, 16)),
+		box("mdat", testData(100)),
+	}, nil)
+}
+
+func testWebM(docType string) []byte {
+	tracks := ebmlEl(0x1654AE6B, ebmlEl(0xAE, ebmlEl(0xD7, []byte{1}), ebmlEl(0x86, []byte("A_OPUS"))))
+	cluster := ebmlEl(0x1F43B675, ebmlEl(0xE7, []byte{0}), ebmlEl(0xA3, []byte{0x81, 0, 0, 0x80, 1, 2, 3}))
+	return append(ebmlEl(ebmlID, ebmlEl(ebmlDocTypeID, []byte(docType))), ebmlEl(ebmlSegmentID, tracks, cluster)...)
+}
+
+func TestCheckContainer(t *testing.T) {
+	mp4 := testMP4()
+	webm := testWebM("webm")
+	large := append(box("ftyp", []byte("isom")), 0, 0, 0, 1, 'm', 'o', 'o', 'v', 0, 0, 0, 0, 0, 0, 0, 16)
+	large = append(large, box("mdat", nil)...)
+	tests := []struct {
+		name      string
+		data      []byte
+		container Container
+		wantErr   bool
+	}{
+		{"mp4", mp4, ContainerMP4, false},
+		{"mp4 with 64-bit box size", large, ContainerMP4, false},
+		{"mp4 truncated", mp4[:len(mp4)-10], ContainerMP4, true},
+		{"mp4 trailing bytes", append(append([]byte{}, mp4...), 1, 2), ContainerMP4, true},
+		{"mp4 without moov", bytes.Join([][]byte{box("ftyp", nil), box("mdat", nil)}, nil), ContainerMP4, true},
+		{"mp4 not starting with ftyp", box("mdat", nil), ContainerMP4, true},
+		{"html as mp4", []byte("<html><body>Error</body></html>"), ContainerMP4, true},
+		{"webm", webm, ContainerWebM, false},
+		{"matroska", testWebM("matroska"), ContainerWebM, false},
+		{"webm truncated", webm[:len(webm)-12], ContainerWebM, true},
+		{"webm wrong doctype", testWebM("other"), ContainerWebM, true},
+		{"mp4 as webm", mp4, ContainerWebM, true},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "file")
+			if err := os.WriteFile(path, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			err := CheckContainer(path, tt.container)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckContainer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrIntegrity) {
+				t.Errorf("error %v does not wrap ErrIntegrity", err)
+			}
+		})
+	}
+}
+
+func TestContainerFromMime(t *testing.T) {
+	tests := map[string]Container{
+		`video/mp4; codecs="avc1.64001F"`: ContainerMP4,
+		`audio/mp4; codecs="mp4a.40.2"`:   ContainerMP4,
+		`video/webm; codecs="vp9"`:        ContainerWebM,
+		"audio/webm":                      ContainerWebM,
+		"video/3gpp":                      "",
+		"":                                "",
+	}
+	for mime, want := range tests {
+		if got := ContainerFromMime(mime); got != want {
+			t.Errorf("ContainerFromMime(%q) = %q, want %q", mime, got, want)
+		}
+	}
+}
@@ -5,13 +5,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/ytget/ytdlp/v2/internal/logging"
+	"github.com/ytget/ytdlp/v2/types"
 )
 
 const (
@@ -21,9 +24,12 @@ const (
 	initialBackoffDuration        = 200 * time.Millisecond
 	maxBackoffDuration            = 3 * time.Second
 	copyBufferSizeBytes           = 32 * 1024 // 32KB
+	maxErrorBodyBytes             = 4 << 10   // error response bytes drained for connection reuse
 	headerRange                   = "Range"
 	headerContentRange            = "Content-Range"
 	headerContentLength           = "Content-Length"
+	headerETag                    = "ETag"
+	headerLastModified            = "Last-Modified"
 	headerUserAgent               = "User-Agent"
 	headerAccept                  = "Accept"
 	headerAcceptLanguage          = "Accept-Language"
@@ -47,26 +53,36 @@ const (
 	userAgentValue = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/135.0.0.0 Safari/537.36"
 )
 
-// Progress holds information about download progress.
-type Progress struct {
-	TotalSize      int64
-	DownloadedSize int64
-	Percent        float64
-}
-
 // Downloader is responsible for downloading media files with chunked HTTP
 // requests, simple retry/backoff, and optional rate limiting.
 type Downloader struct {
 	Client       *http.Client
 	ProgressFunc func(Progress)
 
-	chunkSize    int64
-	maxRetries   int
-	rateLimitBps int64
+	chunkSize   int64
+	maxRetries  int
+	limiter     *Limiter
+	connections int
+	videoID     string
+	itag        int
+	refreshURL  URLRefreshFunc
+
+	phase            types.Phase
+	progressInterval time.Duration
+	logger           *slog.Logger
+
+	expectedSize int64
+	container    Container
+	checksum     bool
+
+	minChunkSize int64
+	maxChunkSize int64
+	rangeParam   bool
 }
 
 // New creates a new downloader instance with sane defaults.
-// If client is nil, a default http.Client is used. rateLimitBps=0 disables limiting.
+// If client is nil, a default http.Client is used. rateLimitBps=0 disables limiting;
+// otherwise the downloader gets its own Limiter (see WithLimiter to share one).
 func New(client *http.Client, progressFunc func(Progress), rateLimitBps int64) *Downloader {
 	if client == nil {
 		client = &http.Client{}
@@ -76,8 +92,51 @@ func New(client *http.Client, progressFunc func(Progress), rateLimitBps int64) *
 		ProgressFunc: progressFunc,
 		chunkSize:    defaultChunkSizeBytes,
 		maxRetries:   defaultMaxRetries,
-		rateLimitBps: rateLimitBps,
+		limiter:      NewLimiter(rateLimitBps, 0),
+		connections:  1,
+	}
+}
+
+// WithLogger sets the logger for diagnostics. Messages carry the video ID
+// and itag given to WithResumeKey; URLs and credentials are redacted.
+// Request and response details are logged at debug level. A nil logger
+// (the default) uses slog.Default().
+func (d *Downloader) WithLogger(l *slog.Logger) *Downloader {
+	d.logger = l
+	return d
+}
+
+// log returns the logger with the download's identifying attributes.
+func (d *Downloader) log() *slog.Logger {
+	l := logging.Or(d.logger).With(slog.String(logging.KeyStage, logging.StageDownload))
+	if d.videoID != "" {
+		l = l.With(slog.String(logging.KeyVideoID, d.videoID), slog.Int(logging.KeyItag, d.itag))
 	}
+	return l
+}
+
+// logExchange logs a request and its response, if any, at debug level.
+func (d *Downloader) logExchange(msg string, req *http.Request, resp *http.Response) {
+	l := logging.Or(d.logger)
+	if !l.Enabled(req.Context(), slog.LevelDebug) {
+		return
+	}
+	attrs := []any{
+		slog.String("method", req.Method),
+		logging.URL(req.URL.String()),
+		logging.Headers("request_headers", req.Header),
+	}
+	if resp != nil {
+		attrs = append(attrs, slog.Int(logging.KeyStatus, resp.StatusCode), logging.Headers("response_headers", resp.Header))
+	}
+	d.log().DebugContext(req.Context(), msg, attrs...)
+}
+
+// source returns the shared URL holder of one download.
+func (d *Downloader) source(urlStr string) *mediaURL {
+	m := newMediaURL(urlStr, d.refreshURL)
+	m.log = d.log()
+	return m
 }
 
 func isGoogleVideoHost(rawURL string) bool {
@@ -89,8 +148,36 @@ func isGoogleVideoHost(rawURL string) bool {
 	return strings.HasSuffix(h, ".googlevideo.com") || h == "googlevideo.com"
 }
 
+// ProbeSize returns the total size of the resource at urlStr using a single
+// ranged request, without downloading its content.
+func (d *Downloader) ProbeSize(ctx context.Context, urlStr string) (int64, error) {
+	return d.detectTotalSize(ctx, urlStr)
+}
+
 // detectTotalSize tries HEAD first, then GET range 0-0 to infer total size.
 func (d *Downloader) detectTotalSize(ctx context.Context, urlStr string) (int64, error) {
+	rf, err := d.probeRemote(ctx, urlStr)
+	return rf.Size, err
+}
+
+// remoteFile describes the resource behind a URL as reported by the server.
+type remoteFile struct {
+	Size         int64
+	ETag         string
+	LastModified string
+}
+
+func newRemoteFile(resp *http.Response, size int64) remoteFile {
+	return remoteFile{
+		Size:         size,
+		ETag:         resp.Header.Get(headerETag),
+		LastModified: resp.Header.Get(headerLastModified),
+	}
+}
+
+// probeRemote is detectTotalSize that also returns the validators (ETag,
+// Last-Modified) used to check that a partial download can be resumed.
+func (d *Downloader) probeRemote(ctx context.Context, urlStr string) (remoteFile, error) {
 	if isGoogleVideoHost(urlStr) {
 		// Skip HEAD for googlevideo; perform GET bytes=0-1 directly
 		getReq, _ := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
@@ -101,35 +188,27 @@ func (d *Downloader) detectTotalSize(ctx context.Context, urlStr string) (int64,
 		getReq.Header.Set(headerCacheControl, "no-cache")
 		getReq.Header.Set(headerRange, "bytes=0-1")
 
-		log.Printf("Downloader: GET range request headers:")
-		for k, v := range getReq.Header {
-			log.Printf("  %s: %s", k, v)
-		}
 		getResp, err := d.Client.Do(getReq)
 		if err != nil {
-			return 0, err
+			return remoteFile{}, err
 		}
 		defer func() { _ = getResp.Body.Close() }()
-		log.Printf("Downloader: GET range response status: %d", getResp.StatusCode)
-		log.Printf("Downloader: GET range response headers:")
-		for k, v := range getResp.Header {
-			log.Printf("  %s: %s", k, v)
-		}
+		d.logExchange("Probe response", getReq, getResp)
 		cr := getResp.Header.Get(headerContentRange)
 		if cr != "" {
 			parts := strings.Split(cr, "/")
 			if len(parts) == 2 {
 				if v, err := strconv.ParseInt(parts[1], 10, 64); err == nil {
-					return v, nil
+					return newRemoteFile(getResp, v), nil
 				}
 			}
 		}
 		if cl := getResp.Header.Get(headerContentLength); cl != "" {
 			if v, err := strconv.ParseInt(cl, 10, 64); err == nil {
-				return v, nil
+				return newRemoteFile(getResp, v), nil
 			}
 		}
-		return 0, errors.New("cannot determine total size")
+		return remoteFile{}, errors.New("cannot determine total size")
 	}
 
 	// Non-googlevideo: attempt HEAD first
@@ -142,29 +221,21 @@ func (d *Downloader) detectTotalSize(ctx context.Context, urlStr string) (int64,
 	headReq.Header.Set(headerCacheControl, "no-cache")
 	headReq.Header.Set(headerRange, "bytes=0-1")
 
-	log.Printf("Downloader: HEAD request headers:")
-	for k, v := range headReq.Header {
-		log.Printf("  %s: %s", k, v)
-	}
 	headResp, err := d.Client.Do(headReq)
 	if err == nil && headResp != nil {
 		defer func() { _ = headResp.Body.Close() }()
-		log.Printf("Downloader: HEAD response status: %d", headResp.StatusCode)
-		log.Printf("Downloader: HEAD response headers:")
-		for k, v := range headResp.Header {
-			log.Printf("  %s: %s", k, v)
-		}
+		d.logExchange("Probe response", headReq, headResp)
 		if cr := headResp.Header.Get(headerContentRange); cr != "" {
 			parts := strings.Split(cr, "/")
 			if len(parts) == 2 {
 				if v, err := strconv.ParseInt(parts[1], 10, 64); err == nil {
-					return v, nil
+					return newRemoteFile(headResp, v), nil
 				}
 			}
 		}
 		if cl := headResp.Header.Get(headerContentLength); cl != "" {
 			if v, err := strconv.ParseInt(cl, 10, 64); err == nil {
-				return v, nil
+				return newRemoteFile(headResp, v), nil
 			}
 		}
 	}
@@ -179,162 +250,136 @@ func (d *Downloader) detectTotalSize(ctx context.Context, urlStr string) (int64,
 	getReq.Header.Set(headerCacheControl, "no-cache")
 	getReq.Header.Set(headerRange, "bytes=0-1")
 
-	log.Printf("Downloader: GET range request headers:")
-	for k, v := range getReq.Header {
-		log.Printf("  %s: %s", k, v)
-	}
 	getResp, err := d.Client.Do(getReq)
 	if err != nil {
-		return 0, err
+		return remoteFile{}, err
 	}
 	defer func() { _ = getResp.Body.Close() }()
-	log.Printf("Downloader: GET range response status: %d", getResp.StatusCode)
-	log.Printf("Downloader: GET range response headers:")
-	for k, v := range getResp.Header {
-		log.Printf("  %s: %s", k, v)
-	}
+	d.logExchange("Probe response", getReq, getResp)
 	cr := getResp.Header.Get(headerContentRange)
 	if cr != "" {
 		parts := strings.Split(cr, "/")
 		if len(parts) == 2 {
 			if v, err := strconv.ParseInt(parts[1], 10, 64); err == nil {
-				return v, nil
+				return newRemoteFile(getResp, v), nil
 			}
 		}
 	}
 	if cl := getResp.Header.Get(headerContentLength); cl != "" {
 		if v, err := strconv.ParseInt(cl, 10, 64); err == nil {
-			return v, nil
+			return newRemoteFile(getResp, v), nil
 		}
 	}
-	return 0, errors.New("cannot determine total size")
+	return remoteFile{}, errors.New("cannot determine total size")
 }
 
-// sleepForRate enforces simple rate limit based on bytes written in this step.
-func (d *Downloader) sleepForRate(written int64) {
-	if d.rateLimitBps <= 0 || written <= 0 {
-		return
-	}
-	dur := time.Duration(int64(time.Second) * written / d.rateLimitBps)
-	if dur > 0 {
-		time.Sleep(dur)
-	}
-}
-
-// Download downloads a file by URL and saves it to outputPath. It supports
-// resuming from an existing temporary file and reports progress periodically.
+// Download downloads a file by URL and saves it to outputPath. A partial
+// download is resumed when its ".part.json" sidecar matches the remote file
+// (see WithResumeKey) and restarted otherwise. Progress is reported
+// periodically. With more than one connection (see WithConnections) and a
+// known size, the file is fetched as disjoint byte ranges in parallel.
+// Expired or rejected URLs are replaced via WithURLRefresh when configured.
 func (d *Downloader) Download(ctx context.Context, urlStr string, outputPath string) error {
-	log.Printf("Downloader: Starting download to %s", outputPath)
+	log := d.log()
+	log.InfoContext(ctx, "Starting download", slog.String("path", outputPath))
+	src := d.source(urlStr)
 
-	tmpPath := outputPath + temporaryFileSuffix
-	var outFile *os.File
-	var err error
-	if _, statErr := os.Stat(tmpPath); statErr == nil {
-		outFile, err = os.OpenFile(tmpPath, os.O_WRONLY|os.O_APPEND, 0644)
-		if err != nil {
-			return fmt.Errorf("failed to open tmp for append: %v", err)
-		}
-		log.Printf("Downloader: Resuming from existing temp file")
+	probeURL, _ := src.get(ctx)
+	rf, err := d.probeRemote(ctx, probeURL)
+	if err != nil {
+		log.WarnContext(ctx, "Could not determine total size, downloading without it", logging.Err(err))
+		rf = remoteFile{}
 	} else {
-		outFile, err = os.Create(tmpPath)
-		if err != nil {
-			return fmt.Errorf("failed to create output file: %v", err)
+		log.DebugContext(ctx, "Total size detected", slog.Int64("size", rf.Size))
+	}
+	if err := d.checkRemoteSize(rf.Size); err != nil {
+		return err
+	}
+	if rf.Size == 0 {
+		rf.Size = d.expectedSize
+	}
+	state := d.newResumeState(rf)
+	prepareResume(outputPath, state, log)
+
+	if d.connections > 1 && rf.Size > state.ChunkSize {
+		err := d.downloadSegmented(ctx, src, outputPath, state)
+		if !errors.Is(err, errRangeNotSupported) {
+			return err
 		}
-		log.Printf("Downloader: Created new temp file")
+		log.WarnContext(ctx, "Server ignored Range, falling back to a single connection")
 	}
-	defer func() { _ = outFile.Close() }()
+	return d.downloadSequential(ctx, src, outputPath, state)
+}
 
-	currentInfo, _ := outFile.Stat()
-	downloaded := currentInfo.Size()
-	log.Printf("Downloader: Already downloaded: %d bytes", downloaded)
+// downloadSequential fetches the file chunk by chunk over one connection,
+// continuing after the leading completed range of state.
+func (d *Downloader) downloadSequential(ctx context.Context, src *mediaURL, outputPath string, state *resumeState) error {
+	tmpPath := outputPath + temporaryFileSuffix
+	statePath := outputPath + resumeStateSuffix
+	totalSize := state.TotalSize
+	downloaded := int64(0)
+	if len(state.Ranges) > 0 && state.Ranges[0].Start == 0 {
+		downloaded = state.Ranges[0].End
+	}
 
-	log.Printf("Downloader: Detecting total file size...")
-	totalSize, err := d.detectTotalSize(ctx, urlStr)
+	outFile, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
-		log.Printf("Downloader: Warning: Could not determine total size: %v", err)
-		log.Printf("Downloader: Will download without size information")
-		totalSize = 0
-	} else {
-		log.Printf("Downloader: Total size: %d bytes", totalSize)
+		return fmt.Errorf("failed to create output file: %v", err)
+	}
+	defer func() { _ = outFile.Close() }()
+	// Drop anything after the last byte known to be valid.
+	if err := outFile.Truncate(downloaded); err != nil {
+		return fmt.Errorf("failed to truncate tmp: %v", err)
+	}
+	if _, err := outFile.Seek(downloaded, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek tmp: %v", err)
 	}
+	log := d.log()
+	log.DebugContext(ctx, "Downloading sequentially", slog.Int64("present", downloaded), slog.Int64("size", totalSize))
+
+	saveState := func() {
+		state.Ranges = []byteRange{{Start: 0, End: downloaded}}
+		if err := state.save(statePath); err != nil {
+			log.WarnContext(ctx, "Failed to save resume state", logging.Err(err))
+		}
+	}
+	saveState()
+	meter := d.newProgressMeter(totalSize, downloaded, state.ChunkSize)
+	chunks := d.newChunkSizer(state.ChunkSize)
+	completed := false
+	defer func() {
+		if !completed {
+			saveState()
+		}
+	}()
 
 	for downloaded < totalSize || totalSize == 0 {
 		start := downloaded
-		end := int64(0)
-		if totalSize > 0 {
-			end = start + d.chunkSize - 1
-			if end >= totalSize {
-				end = totalSize - 1
-			}
-		} else {
-			// When size unknown, prefer bounded first-chunk to probe and avoid 403
-			end = start + d.chunkSize - 1
+		end := start + chunks.next() - 1
+		if totalSize > 0 && end >= totalSize {
+			end = totalSize - 1
 		}
 
-		var resp *http.Response
-		var lastErr error
-		backoff := initialBackoffDuration
-		for attempt := 0; attempt < d.maxRetries; attempt++ {
-			req, _ := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
-			req.Header.Set(headerUserAgent, userAgentValue)
-			req.Header.Set(headerAccept, "*/*")
-			req.Header.Set(headerAcceptEncoding, "identity")
-			req.Header.Set(headerConnection, "keep-alive")
-			req.Header.Set(headerCacheControl, "no-cache")
-			if !isGoogleVideoHost(urlStr) {
-				req.Header.Set(headerAcceptLanguage, "en-US,en;q=0.9")
-			}
-
-			rangeVal := fmt.Sprintf("bytes=%d-%d", start, end)
-			req.Header.Set(headerRange, rangeVal)
-			log.Printf("Downloader: Requesting range: %s", rangeVal)
-
-			log.Printf("Downloader: Request headers:")
-			for k, v := range req.Header {
-				log.Printf("  %s: %s", k, v)
-			}
-
-			resp, lastErr = d.Client.Do(req)
-			if lastErr == nil && resp != nil && resp.StatusCode >= successMinHTTPStatusCode && resp.StatusCode < successMaxHTTPStatusExclusive {
-				log.Printf("Downloader: Request successful, status: %d", resp.StatusCode)
-				log.Printf("Downloader: Response headers:")
-				for k, v := range resp.Header {
-					log.Printf("  %s: %s", k, v)
-				}
-				break
-			}
-			if resp != nil {
-				log.Printf("Downloader: Request failed with status: %d", resp.StatusCode)
-				log.Printf("Downloader: Response headers:")
-				for k, v := range resp.Header {
-					log.Printf("  %s: %s", k, v)
-				}
-				if resp.Body != nil {
-					body, _ := io.ReadAll(resp.Body)
-					log.Printf("Downloader: Response body: %s", string(body))
-					_ = resp.Body.Close()
-				}
-				lastErr = fmt.Errorf("HTTP status %d", resp.StatusCode)
-			}
-			log.Printf("Downloader: Request failed, attempt %d: %v", attempt+1, lastErr)
-			time.Sleep(backoff)
-			backoff *= 2
-			if backoff > maxBackoffDuration {
-				backoff = maxBackoffDuration
-			}
+		began := time.Now()
+		resp, err := d.fetchRange(ctx, src, chunks, start, end)
+		if err != nil {
+			return err
 		}
-		if lastErr != nil {
-			return fmt.Errorf("download chunk failed: %v", lastErr)
+		body, err := d.rangeBody(ctx, resp, start, end, totalSize)
+		if err != nil {
+			_ = resp.Body.Close()
+			return err
 		}
-		if resp == nil {
-			return fmt.Errorf("empty response")
+		if totalSize == 0 {
+			// The first response of a file of unknown size may tell it.
+			totalSize = responseTotal(resp)
+			meter.setTotal(totalSize, state.ChunkSize)
 		}
 
-		log.Printf("Downloader: Starting to copy response body...")
 		buf := make([]byte, copyBufferSizeBytes)
 		totalRead := int64(0)
 		for {
-			n, rerr := resp.Body.Read(buf)
+			n, rerr := body.Read(buf)
 			if n > 0 {
 				if _, werr := outFile.Write(buf[:n]); werr != nil {
 					_ = resp.Body.Close()
@@ -342,17 +387,14 @@ func (d *Downloader) Download(ctx context.Context, urlStr string, outputPath str
 				}
 				downloaded += int64(n)
 				totalRead += int64(n)
-				if d.ProgressFunc != nil {
-					p := Progress{TotalSize: totalSize, DownloadedSize: downloaded}
-					if totalSize > 0 {
-						p.Percent = float64(downloaded) / float64(totalSize) * 100
-					}
-					d.ProgressFunc(p)
+				meter.update(downloaded, int((downloaded-1)/state.ChunkSize)+1)
+				if err := d.throttle(ctx, n); err != nil {
+					_ = resp.Body.Close()
+					return err
 				}
-				d.sleepForRate(int64(n))
 			}
 			if rerr == io.EOF {
-				log.Printf("Downloader: Response body completed, read %d bytes", totalRead)
+				log.DebugContext(ctx, "Chunk completed", slog.Int64("start", start), slog.Int64("bytes", totalRead))
 				break
 			}
 			if rerr != nil {
@@ -361,22 +403,132 @@ func (d *Downloader) Download(ctx context.Context, urlStr string, outputPath str
 			}
 		}
 		_ = resp.Body.Close()
+		chunks.observe(totalRead, time.Since(began))
+		saveState()
 
-		if totalSize == 0 {
-			// We do not know size; continue bounded chunks until server closes or 206 signals end
-			continue
+		if totalSize == 0 && (resp.StatusCode != http.StatusPartialContent || totalRead < end-start+1) {
+			break // size unknown and the server had no more data
+		}
+		if totalRead == 0 {
+			return fmt.Errorf("no data received at offset %d of %d", start, totalSize)
+		}
+	}
+
+	completed = true
+	_ = os.Remove(statePath)
+	if err := d.verify(tmpPath, outputPath, totalSize); err != nil {
+		// The data cannot be trusted, so it is not kept for resuming.
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, outputPath)
+}
+
+// rangeBody returns the part of resp's body holding bytes start..end of a
+// file of totalSize bytes (0 when unknown). A 206 response must cover the
+// requested range. Any other status means the server ignored Range and sent
+// the whole file: its first start bytes are discarded and the rest of the
+// file is returned.
+func (d *Downloader) rangeBody(ctx context.Context, resp *http.Response, start, end, totalSize int64) (io.Reader, error) {
+	if resp.StatusCode == http.StatusPartialContent {
+		if err := checkContentRange(resp, start, totalSize); err != nil {
+			return nil, err
 		}
-		if downloaded >= totalSize {
-			break
+		return io.LimitReader(resp.Body, end-start+1), nil
+	}
+	if totalSize > 0 && resp.ContentLength > 0 && resp.ContentLength != totalSize {
+		return nil, fmt.Errorf("%w: file size changed from %d to %d", ErrIntegrity, totalSize, resp.ContentLength)
+	}
+	if start > 0 {
+		d.log().WarnContext(ctx, "Server ignored Range, skipping bytes already written", slog.Int(logging.KeyStatus, resp.StatusCode), slog.Int64("skip", start))
+		if _, err := io.CopyN(io.Discard, resp.Body, start); err != nil {
+			return nil, fmt.Errorf("failed to skip to offset %d: %v", start, err)
 		}
 	}
+	return resp.Body, nil
+}
 
-	if fi, err := os.Stat(tmpPath); err == nil {
-		if fi.Size() == 0 {
-			_ = os.Remove(tmpPath)
-			return fmt.Errorf("empty download: 0 bytes written")
+// responseTotal returns the file size told by a range response: the total
+// of its Content-Range, or the length of a whole-file response. It returns
+// 0 when unknown.
+func responseTotal(resp *http.Response) int64 {
+	if resp.StatusCode == http.StatusPartialContent {
+		return contentRangeTotal(resp.Header.Get(headerContentRange))
+	}
+	if resp.ContentLength > 0 {
+		return resp.ContentLength
+	}
+	return 0
+}
+
+// fetchRange requests bytes start..end (inclusive) and retries failed
+// requests with backoff; each failure is reported to chunks, which may be
+// nil. A URL rejected with 403/410 is refreshed and retried without counting
+// as an attempt. The caller must close the response body.
+func (d *Downloader) fetchRange(ctx context.Context, src *mediaURL, chunks *chunkSizer, start, end int64) (*http.Response, error) {
+	var resp *http.Response
+	var lastErr error
+	backoff := initialBackoffDuration
+	for attempt := 0; attempt < d.maxRetries; attempt++ {
+		urlStr, gen := src.get(ctx)
+		req, _ := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
+		req.Header.Set(headerUserAgent, userAgentValue)
+		req.Header.Set(headerAccept, "*/*")
+		req.Header.Set(headerAcceptEncoding, "identity")
+		req.Header.Set(headerConnection, "keep-alive")
+		req.Header.Set(headerCacheControl, "no-cache")
+		if !isGoogleVideoHost(urlStr) {
+			req.Header.Set(headerAcceptLanguage, "en-US,en;q=0.9")
 		}
+		rangeParam := d.setRange(req, start, end)
+
+		resp, lastErr = d.Client.Do(req)
+		d.logExchange("Range response", req, resp)
+		if lastErr == nil && resp != nil && resp.StatusCode >= successMinHTTPStatusCode && resp.StatusCode < successMaxHTTPStatusExclusive {
+			if rangeParam {
+				asPartialContent(resp, start, end)
+			}
+			return resp, nil
+		}
+		chunks.failed()
+		if resp != nil {
+			if resp.Body != nil {
+				_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxErrorBodyBytes))
+				_ = resp.Body.Close()
+			}
+			lastErr = fmt.Errorf("HTTP status %d", resp.StatusCode)
+			if isURLRejected(resp.StatusCode) && src.refresh != nil {
+				rerr := src.renew(ctx, gen)
+				if rerr == nil {
+					attempt--
+					continue
+				}
+				d.log().WarnContext(ctx, "URL refresh failed", logging.Err(rerr))
+			}
+		}
+		d.log().WarnContext(ctx, "Range request failed", slog.Int("attempt", attempt+1), slog.Int64("start", start), logging.Err(lastErr))
+		if err := sleepContext(ctx, backoff); err != nil {
+			return nil, err
+		}
+		backoff *= 2
+		if backoff > maxBackoffDuration {
+			backoff = maxBackoffDuration
+		}
+	}
+	if lastErr == nil {
+		lastErr = errors.New("empty response")
 	}
+	return nil, fmt.Errorf("download chunk failed: %v", lastErr)
+}
 
-	return os.Rename(tmpPath, outputPath)
+// sleepContext waits for dur or until ctx is done.
+func sleepContext(ctx context.Context, dur time.Duration) error {
+	t := time.NewTimer(dur)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
@@ -1,11 +1,15 @@
 package downloader
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -173,10 +177,14 @@ func TestDownloadResume(t *testing.T) {
 	out := t.TempDir() + "/file.bin"
 	tmp := out + ".tmp"
 
-	// Pre-create partial tmp (first 1MB)
+	// Pre-create partial tmp (first 1MB) and its resume state
 	if err := os.WriteFile(tmp, data[:1<<20], 0644); err != nil {
 		t.Fatalf("precreate tmp failed: %v", err)
 	}
+	state := &resumeState{TotalSize: int64(len(data)), Ranges: []byteRange{{0, 1 << 20}}}
+	if err := state.save(out + resumeStateSuffix); err != nil {
+		t.Fatalf("precreate resume state failed: %v", err)
+	}
 
 	// Resume and complete
 	if err := dl.Download(ctx, server.URL, out); err != nil {
@@ -190,76 +198,76 @@ func TestDownloadResume(t *testing.T) {
 	if string(bs[:1024]) != string(data[:1024]) || string(bs[len(bs)-1024:]) != string(data[len(data)-1024:]) {
 		t.Fatalf("content mismatch")
 	}
+	if _, err := os.Stat(out + resumeStateSuffix); !os.IsNotExist(err) {
+		t.Errorf("resume state left behind: %v", err)
+	}
 }
 
-func TestSleepForRate(t *testing.T) {
-	tests := []struct {
-		name         string
-		rateLimitBps int64
-		written      int64
-		expectSleep  bool
-	}{
-		{
-			name:         "No rate limit",
-			rateLimitBps: 0,
-			written:      1000,
-			expectSleep:  false,
-		},
-		{
-			name:         "Negative rate limit",
-			rateLimitBps: -100,
-			written:      1000,
-			expectSleep:  false,
-		},
-		{
-			name:         "No bytes written",
-			rateLimitBps: 1000,
-			written:      0,
-			expectSleep:  false,
-		},
-		{
-			name:         "Negative bytes written",
-			rateLimitBps: 1000,
-			written:      -100,
-			expectSleep:  false,
-		},
-		{
-			name:         "Normal rate limiting",
-			rateLimitBps: 1000,
-			written:      1000,
-			expectSleep:  true,
-		},
-		{
-			name:         "High rate limit",
-			rateLimitBps: 1000000,
-			written:      1000,
-			expectSleep:  true,
-		},
+func TestLimiter(t *testing.T) {
+	if l := NewLimiter(0, 0); l != nil {
+		t.Errorf("expected nil limiter for zero rate")
+	}
+	var nilLimiter *Limiter
+	if err := nilLimiter.WaitN(context.Background(), 1<<20); err != nil {
+		t.Errorf("nil limiter should not wait: %v", err)
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			downloader := &Downloader{
-				rateLimitBps: tt.rateLimitBps,
-			}
+	// Within the burst there is no wait.
+	l := NewLimiter(100000, 50000)
+	start := time.Now()
+	if err := l.WaitN(context.Background(), 50000); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 10*time.Millisecond {
+		t.Errorf("burst should not wait, waited %v", d)
+	}
+	// Past the burst, callers wait for the bucket to refill: 20 KB at
+	// 100 KB/s is 200 ms, no matter how many goroutines share it.
+	start = time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = l.WaitN(context.Background(), 5000)
+		}()
+	}
+	wg.Wait()
+	if d := time.Since(start); d < 150*time.Millisecond || d > time.Second {
+		t.Errorf("shared limiter waited %v, want about 200ms", d)
+	}
 
-			// Measure execution time
-			start := time.Now()
-			downloader.sleepForRate(tt.written)
-			duration := time.Since(start)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.WaitN(ctx, 1<<20); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
 
-			if tt.expectSleep {
-				// Should sleep for at least some time
-				if duration < time.Millisecond {
-					t.Errorf("Expected sleep time > 0, got %v", duration)
-				}
-			} else {
-				// Should not sleep
-				if duration > time.Millisecond {
-					t.Errorf("Expected no sleep, got sleep time %v", duration)
-				}
+func TestDownloadSharedLimiter(t *testing.T) {
+	data := make([]byte, 256<<10)
+	server := makeServer(data)
+	defer server.Close()
+
+	// Two downloads sharing 1 MiB/s move 512 KiB in about half a second
+	// (minus the burst); with separate limiters it would take half as long.
+	l := NewLimiter(1<<20, 32<<10)
+	dir := t.TempDir()
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			dl := New(server.Client(), nil, 0).WithLimiter(l)
+			if err := dl.Download(context.Background(), server.URL, fmt.Sprintf("%s/%d.bin", dir, i)); err != nil {
+				t.Errorf("download %d: %v", i, err)
 			}
-		})
+		}(i)
+	}
+	wg.Wait()
+	if d := time.Since(start); d < 400*time.Millisecond {
+		t.Errorf("shared limit not enforced: took %v", d)
 	}
 }
 
@@ -340,3 +348,26 @@ func TestIsGoogleVideoHost(t *testing.T) {
 		})
 	}
 }
+
+func TestDownloadLogger(t *testing.T) {
+	data := testData(3000)
+	server := makeServer(data)
+	defer server.Close()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	dl := New(server.Client(), nil, 0).WithLogger(logger).WithResumeKey("abc123", 18)
+	out := t.TempDir() + "/file.bin"
+	if err := dl.Download(context.Background(), server.URL+"/media?itag=18&sig=SECRET", out); err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	logs := buf.String()
+	if strings.Contains(logs, "SECRET") {
+		t.Errorf("signature leaked into logs:\n%s", logs)
+	}
+	for _, want := range []string{"video_id=abc123", "itag=18", "stage=download", "sig=REDACTED", "level=DEBUG"} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("logs do not contain %q:\n%s", want, logs)
+		}
+	}
+}
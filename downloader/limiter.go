@@ -0,0 +1,80 @@
+package downloader
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// defaultLimiterBurst is the burst used when NewLimiter is given none: large
+// enough for a couple of reads, small enough to keep the rate smooth.
+const defaultLimiterBurst = 2 * copyBufferSizeBytes
+
+// Limiter is a token-bucket bandwidth limiter. One Limiter can be shared by
+// any number of Downloaders and connections; together they stay under its
+// rate, and each may transfer up to burst bytes without waiting after an
+// idle period. It is safe for concurrent use.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a limiter allowing bytesPerSecond on average with bursts
+// of up to burst bytes. burst <= 0 selects a small default. A
+// non-positive rate returns nil, which never waits.
+func NewLimiter(bytesPerSecond, burst int64) *Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = defaultLimiterBurst
+	}
+	return &Limiter{
+		rate:   float64(bytesPerSecond),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// WithLimiter makes the downloader wait on l for every transferred byte,
+// replacing the limiter created from the rate given to New. Sharing one
+// Limiter between Downloaders makes its rate a global limit.
+func (d *Downloader) WithLimiter(l *Limiter) *Downloader {
+	d.limiter = l
+	return d
+}
+
+// WaitN blocks until n bytes may be transferred or ctx is done. Calls are
+// served in order: each one reserves its tokens immediately and sleeps until
+// the bucket has refilled, so waiting callers cannot starve each other.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	return sleepContext(ctx, wait)
+}
+
+// throttle waits on the downloader's limiter, if any, for n bytes.
+func (d *Downloader) throttle(ctx context.Context, n int) error {
+	return d.limiter.WaitN(ctx, n)
+}
@@ -0,0 +1,153 @@
+package downloader
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ytget/ytdlp/v2/types"
+)
+
+// speedWindow is the period over which the instantaneous speed is measured.
+const speedWindow = time.Second
+
+// Progress holds information about download progress.
+type Progress struct {
+	TotalSize      int64
+	DownloadedSize int64
+	Percent        float64
+
+	// Phase is PhaseDownloading unless set with WithPhase.
+	Phase types.Phase
+	// Speed is measured over the last second, AverageSpeed over the whole
+	// download (bytes already present when resuming excluded), both in
+	// bytes per second.
+	Speed        float64
+	AverageSpeed float64
+	// ETA is the estimated time left; zero when unknown.
+	ETA     time.Duration
+	Elapsed time.Duration
+	// FragmentIndex (1-based) is the chunk the latest bytes belong to, out
+	// of FragmentCount; both are zero when the size is unknown.
+	FragmentIndex int
+	FragmentCount int
+}
+
+// WithPhase sets the phase reported in progress events, e.g. to tell a video
+// download from an audio download.
+func (d *Downloader) WithPhase(p types.Phase) *Downloader {
+	d.phase = p
+	return d
+}
+
+// WithProgressInterval limits progress callbacks to one per interval. The
+// event that completes the download is always delivered. Zero (the default)
+// reports every read.
+func (d *Downloader) WithProgressInterval(interval time.Duration) *Downloader {
+	if interval < 0 {
+		interval = 0
+	}
+	d.progressInterval = interval
+	return d
+}
+
+type progressSample struct {
+	at    time.Time
+	bytes int64
+}
+
+// progressMeter turns byte counts into throttled Progress events with speed
+// and ETA. It is safe for concurrent use; callbacks are serialized.
+type progressMeter struct {
+	mu        sync.Mutex
+	fn        func(Progress)
+	phase     types.Phase
+	interval  time.Duration
+	total     int64
+	fragments int
+	start     time.Time
+	base      int64
+	samples   []progressSample
+	lastEmit  time.Time
+}
+
+// newProgressMeter returns a meter for a download of total bytes (0 when
+// unknown) of which already bytes are present. Fragments are chunks of
+// chunkSize bytes. It returns nil when d has no progress callback.
+func (d *Downloader) newProgressMeter(total, already, chunkSize int64) *progressMeter {
+	if d.ProgressFunc == nil {
+		return nil
+	}
+	phase := d.phase
+	if phase == "" {
+		phase = types.PhaseDownloading
+	}
+	m := &progressMeter{
+		fn:       d.ProgressFunc,
+		phase:    phase,
+		interval: d.progressInterval,
+		start:    time.Now(),
+		base:     already,
+	}
+	m.samples = []progressSample{{at: m.start, bytes: already}}
+	m.setTotal(total, chunkSize)
+	return m
+}
+
+// setTotal updates the total size once it becomes known.
+func (m *progressMeter) setTotal(total, chunkSize int64) {
+	if m == nil || total <= 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.total = total
+	m.fragments = int((total + chunkSize - 1) / chunkSize)
+}
+
+// update reports that downloaded bytes are present, the latest of them
+// belonging to the 1-based fragment.
+func (m *progressMeter) update(downloaded int64, fragment int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.samples = append(m.samples, progressSample{at: now, bytes: downloaded})
+	// Keep one sample older than the window as the reference point.
+	for len(m.samples) > 2 && now.Sub(m.samples[1].at) >= speedWindow {
+		m.samples = m.samples[1:]
+	}
+	done := m.total > 0 && downloaded >= m.total
+	if !done && m.interval > 0 && now.Sub(m.lastEmit) < m.interval {
+		return
+	}
+	m.lastEmit = now
+
+	p := Progress{
+		TotalSize:      m.total,
+		DownloadedSize: downloaded,
+		Phase:          m.phase,
+		Elapsed:        now.Sub(m.start),
+	}
+	if m.total > 0 {
+		p.Percent = float64(downloaded) / float64(m.total) * 100
+		p.FragmentIndex = fragment
+		p.FragmentCount = m.fragments
+	}
+	ref := m.samples[0]
+	if dt := now.Sub(ref.at).Seconds(); dt > 0 {
+		p.Speed = float64(downloaded-ref.bytes) / dt
+	}
+	if dt := p.Elapsed.Seconds(); dt > 0 {
+		p.AverageSpeed = float64(downloaded-m.base) / dt
+	}
+	speed := p.Speed
+	if speed <= 0 {
+		speed = p.AverageSpeed
+	}
+	if m.total > 0 && speed > 0 && downloaded < m.total {
+		p.ETA = time.Duration(float64(m.total-downloaded) / speed * float64(time.Second))
+	}
+	m.fn(p)
+}
@@ -0,0 +1,124 @@
+package downloader
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ytget/ytdlp/v2/types"
+)
+
+func TestProgressMeter(t *testing.T) {
+	var events []Progress
+	d := New(nil, func(p Progress) { events = append(events, p) }, 0).WithProgressInterval(time.Hour)
+	m := d.newProgressMeter(3*defaultChunkSizeBytes, defaultChunkSizeBytes, defaultChunkSizeBytes)
+	time.Sleep(10 * time.Millisecond)
+	m.update(defaultChunkSizeBytes+1000, 2)
+	m.update(2*defaultChunkSizeBytes, 2) // throttled
+	m.update(3*defaultChunkSizeBytes, 3) // final, always delivered
+
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2: %+v", len(events), events)
+	}
+	first, last := events[0], events[1]
+	if first.Phase != types.PhaseDownloading {
+		t.Errorf("Phase = %q, want %q", first.Phase, types.PhaseDownloading)
+	}
+	if first.FragmentIndex != 2 || first.FragmentCount != 3 {
+		t.Errorf("fragment = %d/%d, want 2/3", first.FragmentIndex, first.FragmentCount)
+	}
+	if first.Speed <= 0 || first.AverageSpeed <= 0 || first.ETA <= 0 {
+		t.Errorf("expected speed and ETA, got %+v", first)
+	}
+	// Resumed bytes do not count towards the average speed.
+	if max := 1000 / first.Elapsed.Seconds(); first.AverageSpeed > max+1 {
+		t.Errorf("AverageSpeed = %.0f includes resumed bytes (max %.0f)", first.AverageSpeed, max)
+	}
+	if last.Percent != 100 || last.ETA != 0 || last.FragmentIndex != 3 {
+		t.Errorf("unexpected final event %+v", last)
+	}
+}
+
+func TestProgressMeterUnknownSize(t *testing.T) {
+	var got Progress
+	d := New(nil, func(p Progress) { got = p }, 0)
+	m := d.newProgressMeter(0, 0, defaultChunkSizeBytes)
+	m.update(500, 1)
+	if got.DownloadedSize != 500 || got.Percent != 0 || got.ETA != 0 || got.FragmentCount != 0 {
+		t.Errorf("unexpected event for unknown size: %+v", got)
+	}
+	m.setTotal(1000, defaultChunkSizeBytes)
+	m.update(600, 1)
+	if got.TotalSize != 1000 || got.Percent != 60 || got.FragmentCount != 1 {
+		t.Errorf("unexpected event after size became known: %+v", got)
+	}
+	if New(nil, nil, 0).newProgressMeter(10, 0, defaultChunkSizeBytes) != nil {
+		t.Error("expected no meter without a progress callback")
+	}
+}
+
+func TestDownloadProgressEvents(t *testing.T) {
+	data := testData(5*defaultChunkSizeBytes/2 + 123)
+	server := makeServer(data)
+	defer server.Close()
+
+	for _, connections := range []int{1, 3} {
+		var (
+			mu     sync.Mutex
+			events []Progress
+		)
+		dl := New(server.Client(), func(p Progress) {
+			mu.Lock()
+			events = append(events, p)
+			mu.Unlock()
+		}, 0).WithConnections(connections).WithPhase(types.PhaseDownloadingAudio)
+		out := t.TempDir() + "/file.bin"
+		if err := dl.Download(context.Background(), server.URL, out); err != nil {
+			t.Fatalf("connections=%d: download failed: %v", connections, err)
+		}
+		if len(events) == 0 {
+			t.Fatalf("connections=%d: no progress events", connections)
+		}
+		var prev int64
+		for _, p := range events {
+			if p.Phase != types.PhaseDownloadingAudio {
+				t.Fatalf("connections=%d: Phase = %q", connections, p.Phase)
+			}
+			if p.FragmentCount != 3 || p.FragmentIndex < 1 || p.FragmentIndex > 3 {
+				t.Fatalf("connections=%d: fragment %d/%d", connections, p.FragmentIndex, p.FragmentCount)
+			}
+			if p.DownloadedSize < prev {
+				t.Fatalf("connections=%d: progress went back from %d to %d", connections, prev, p.DownloadedSize)
+			}
+			prev = p.DownloadedSize
+		}
+		if last := events[len(events)-1]; last.DownloadedSize != int64(len(data)) || last.Percent != 100 {
+			t.Errorf("connections=%d: final event %+v", connections, last)
+		}
+	}
+}
+
+func TestDownloadToProgressEvents(t *testing.T) {
+	data := testData(3*defaultChunkSizeBytes/2 + 7)
+	server := makeServer(data)
+	defer server.Close()
+
+	var events []Progress
+	dl := New(server.Client(), func(p Progress) { events = append(events, p) }, 0).WithPhase(types.PhaseDownloadingVideo)
+	if err := dl.DownloadTo(context.Background(), server.URL, io.Discard); err != nil {
+		t.Fatalf("DownloadTo failed: %v", err)
+	}
+	if len(events) == 0 {
+		t.Fatal("no progress events")
+	}
+	for _, p := range events {
+		if p.Phase != types.PhaseDownloadingVideo || p.FragmentCount != 2 || p.FragmentIndex < 1 || p.FragmentIndex > 2 {
+			t.Fatalf("unexpected event %+v", p)
+		}
+	}
+	if last := events[len(events)-1]; last.DownloadedSize != int64(len(data)) || last.Percent != 100 || last.FragmentIndex != 2 {
+		t.Errorf("final event %+v", last)
+	}
+}
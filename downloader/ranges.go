@@ -0,0 +1,91 @@
+package downloader
+
+import "sort"
+
+// byteRange is a half-open span [Start, End) of a file.
+type byteRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// Len returns the number of bytes covered by r.
+func (r byteRange) Len() int64 {
+	return r.End - r.Start
+}
+
+// rangeSet keeps sorted, non-overlapping, merged byte ranges. It records
+// which parts of a file are already on disk, so a byte written twice (for
+// example after a retry) is only counted once.
+type rangeSet struct {
+	ranges []byteRange
+}
+
+// Add marks [start, end) as present, merging with adjacent ranges.
+func (s *rangeSet) Add(start, end int64) {
+	if end <= start {
+		return
+	}
+	i := sort.Search(len(s.ranges), func(i int) bool { return s.ranges[i].End >= start })
+	j := i
+	for j < len(s.ranges) && s.ranges[j].Start <= end {
+		if s.ranges[j].Start < start {
+			start = s.ranges[j].Start
+		}
+		if s.ranges[j].End > end {
+			end = s.ranges[j].End
+		}
+		j++
+	}
+	merged := append([]byteRange{{Start: start, End: end}}, s.ranges[j:]...)
+	s.ranges = append(s.ranges[:i], merged...)
+}
+
+// Ranges returns a copy of the ranges in the set.
+func (s *rangeSet) Ranges() []byteRange {
+	return append([]byteRange(nil), s.ranges...)
+}
+
+// Size returns the total number of bytes covered by the set.
+func (s *rangeSet) Size() int64 {
+	var n int64
+	for _, r := range s.ranges {
+		n += r.Len()
+	}
+	return n
+}
+
+// Missing returns the gaps in [0, total) not covered by the set.
+func (s *rangeSet) Missing(total int64) []byteRange {
+	var out []byteRange
+	pos := int64(0)
+	for _, r := range s.ranges {
+		if r.Start >= total {
+			break
+		}
+		if r.Start > pos {
+			out = append(out, byteRange{Start: pos, End: r.Start})
+		}
+		if r.End > pos {
+			pos = r.End
+		}
+	}
+	if pos < total {
+		out = append(out, byteRange{Start: pos, End: total})
+	}
+	return out
+}
+
+// splitRanges cuts ranges into pieces of at most size bytes.
+func splitRanges(ranges []byteRange, size int64) []byteRange {
+	var out []byteRange
+	for _, r := range ranges {
+		for start := r.Start; start < r.End; start += size {
+			end := start + size
+			if end > r.End {
+				end = r.End
+			}
+			out = append(out, byteRange{Start: start, End: end})
+		}
+	}
+	return out
+}
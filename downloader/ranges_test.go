@@ -0,0 +1,41 @@
+package downloader
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRangeSet(t *testing.T) {
+	var s rangeSet
+	s.Add(10, 20)
+	s.Add(30, 40)
+	s.Add(15, 25) // overlaps the first range
+	s.Add(25, 30) // bridges the gap
+	s.Add(50, 60)
+	s.Add(5, 5) // empty
+	want := []byteRange{{10, 40}, {50, 60}}
+	if !reflect.DeepEqual(s.ranges, want) {
+		t.Fatalf("ranges = %v, want %v", s.ranges, want)
+	}
+	if got := s.Size(); got != 40 {
+		t.Errorf("Size() = %d, want 40", got)
+	}
+	if got, want := s.Missing(70), []byteRange{{0, 10}, {40, 50}, {60, 70}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Missing(70) = %v, want %v", got, want)
+	}
+	if got, want := s.Missing(45), []byteRange{{0, 10}, {40, 45}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Missing(45) = %v, want %v", got, want)
+	}
+	s.Add(0, 100)
+	if len(s.ranges) != 1 || s.Missing(100) != nil {
+		t.Errorf("expected a single full range, got %v", s.ranges)
+	}
+}
+
+func TestSplitRanges(t *testing.T) {
+	got := splitRanges([]byteRange{{0, 10}, {20, 23}}, 4)
+	want := []byteRange{{0, 4}, {4, 8}, {8, 10}, {20, 23}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitRanges = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,223 @@
+package downloader
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	readerBlockSize       = 256 << 10 // bytes per cached block
+	readerCacheBlocks     = 16        // blocks kept in the LRU cache
+	readerReadAheadBlocks = 4         // blocks fetched at once on sequential reads
+)
+
+// ErrReaderClosed is returned by Reader methods after Close.
+var ErrReaderClosed = errors.New("downloader: reader closed")
+
+// Reader gives random access to a remote file with ranged GETs. Data is
+// fetched in blocks kept in a small LRU cache; sequential reads fetch several
+// blocks per request. Requests use the Downloader's headers, retries, rate
+// limit and URL refresh. Reader implements io.ReadSeekCloser and io.ReaderAt
+// and is safe for concurrent use; fetches are serialized.
+type Reader struct {
+	d      *Downloader
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	src    *mediaURL
+	size   int64
+
+	mu     sync.Mutex
+	pos    int64
+	blocks map[int64]*list.Element // block index -> element holding *readerBlock
+	lru    *list.List
+	next   int64 // block following the last fetch, to detect sequential reads
+	closed bool
+}
+
+type readerBlock struct {
+	index int64
+	data  []byte
+}
+
+// Open returns a Reader for the file at urlStr. The size must be known and
+// the server must support Range requests. Requests made by the Reader use
+// ctx; Close cancels them.
+func (d *Downloader) Open(ctx context.Context, urlStr string) (*Reader, error) {
+	src := d.source(urlStr)
+	probeURL, _ := src.get(ctx)
+	size, err := d.detectTotalSize(ctx, probeURL)
+	if err != nil {
+		return nil, fmt.Errorf("open: cannot determine size: %v", err)
+	}
+	if size <= 0 {
+		return nil, fmt.Errorf("open: invalid size %d", size)
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	return &Reader{
+		d:      d,
+		ctx:    ctx,
+		cancel: cancel,
+		src:    src,
+		size:   size,
+		blocks: make(map[int64]*list.Element),
+		lru:    list.New(),
+	}, nil
+}
+
+// Size returns the size of the remote file.
+func (r *Reader) Size() int64 {
+	return r.size
+}
+
+// Read implements io.Reader.
+func (r *Reader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n, err := r.readAt(p, r.pos)
+	r.pos += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// ReadAt implements io.ReaderAt. It does not move the Read offset.
+func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.readAt(p, off)
+}
+
+// Seek implements io.Seeker. Seeking does not fetch anything.
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, ErrReaderClosed
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, fmt.Errorf("seek: invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("seek: negative position %d", offset)
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+// Close releases the cache and cancels pending requests. Reads waiting on a
+// request return ErrReaderClosed without waiting for it to time out.
+func (r *Reader) Close() error {
+	// Cancel before locking: a read holds the lock while it fetches.
+	r.cancel(ErrReaderClosed)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.closed {
+		r.closed = true
+		r.blocks = nil
+		r.lru.Init()
+	}
+	return nil
+}
+
+func (r *Reader) readAt(p []byte, off int64) (int, error) {
+	if r.closed {
+		return 0, ErrReaderClosed
+	}
+	if off < 0 {
+		return 0, fmt.Errorf("read: negative offset %d", off)
+	}
+	n := 0
+	for n < len(p) && off+int64(n) < r.size {
+		pos := off + int64(n)
+		b, err := r.block(pos / readerBlockSize)
+		if err != nil {
+			if errors.Is(context.Cause(r.ctx), ErrReaderClosed) {
+				err = ErrReaderClosed
+			}
+			return n, err
+		}
+		n += copy(p[n:], b.data[pos-b.index*readerBlockSize:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// block returns block i from the cache or fetches it, together with the
+// following blocks when the access looks sequential.
+func (r *Reader) block(i int64) (*readerBlock, error) {
+	if el, ok := r.blocks[i]; ok {
+		r.lru.MoveToFront(el)
+		return el.Value.(*readerBlock), nil
+	}
+	count := int64(1)
+	if i == r.next {
+		count = readerReadAheadBlocks
+	}
+	start := i * readerBlockSize
+	end := start + count*readerBlockSize
+	if end > r.size {
+		end = r.size
+	}
+	buf := make([]byte, end-start)
+	w := &bufferAt{buf: buf, off: start}
+	progress := &rangeProgress{total: r.size}
+	if err := r.d.fetchSegment(r.ctx, r.src, nil, w, byteRange{Start: start, End: end}, progress); err != nil {
+		return nil, err
+	}
+	r.next = i + count
+	// Insert the requested block last so it is the most recently used.
+	for j := count - 1; j >= 0; j-- {
+		lo := j * readerBlockSize
+		if lo >= int64(len(buf)) {
+			continue
+		}
+		hi := lo + readerBlockSize
+		if hi > int64(len(buf)) {
+			hi = int64(len(buf))
+		}
+		r.put(&readerBlock{index: i + j, data: buf[lo:hi]})
+	}
+	return r.blocks[i].Value.(*readerBlock), nil
+}
+
+func (r *Reader) put(b *readerBlock) {
+	if el, ok := r.blocks[b.index]; ok {
+		el.Value = b
+		r.lru.MoveToFront(el)
+		return
+	}
+	r.blocks[b.index] = r.lru.PushFront(b)
+	for r.lru.Len() > readerCacheBlocks {
+		old := r.lru.Back()
+		r.lru.Remove(old)
+		delete(r.blocks, old.Value.(*readerBlock).index)
+	}
+}
+
+// bufferAt is an io.WriterAt over a byte slice that holds the file bytes
+// starting at off.
+type bufferAt struct {
+	buf []byte
+	off int64
+}
+
+func (w *bufferAt) WriteAt(p []byte, off int64) (int, error) {
+	i := off - w.off
+	if i < 0 || i+int64(len(p)) > int64(len(w.buf)) {
+		return 0, fmt.Errorf("write at %d outside buffer", off)
+	}
+	return copy(w.buf[i:], p), nil
+}
@@ -0,0 +1,170 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReader(t *testing.T) {
+	data := testData(3<<20 + 99)
+	server := newRangeServer(data, `"r"`)
+	defer server.Close()
+
+	r, err := New(server.Client(), nil, 0).Open(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer r.Close()
+	if r.Size() != int64(len(data)) {
+		t.Fatalf("Size() = %d, want %d", r.Size(), len(data))
+	}
+
+	// Sequential read with read-ahead: one request per 4 blocks.
+	got, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("ReadAll: err=%v got=%d want=%d", err, len(got), len(data))
+	}
+	want := (len(data) + readerReadAheadBlocks*readerBlockSize - 1) / (readerReadAheadBlocks * readerBlockSize)
+	if n := len(server.requested()); n != want {
+		t.Errorf("sequential read made %d requests, want %d", n, want)
+	}
+
+	// Random access near the end is served from the cache.
+	before := len(server.requested())
+	if _, err := r.Seek(-1000, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	tail := make([]byte, 2000)
+	n, err := io.ReadFull(r, tail)
+	if n != 1000 || err != io.ErrUnexpectedEOF || !bytes.Equal(tail[:n], data[len(data)-1000:]) {
+		t.Errorf("tail read: n=%d err=%v", n, err)
+	}
+	if len(server.requested()) != before {
+		t.Errorf("cached blocks were fetched again")
+	}
+
+	// ReadAt at the start, which has been evicted by now.
+	p := make([]byte, 100)
+	if n, err := r.ReadAt(p, 10); n != 100 || err != nil || !bytes.Equal(p, data[10:110]) {
+		t.Errorf("ReadAt: n=%d err=%v", n, err)
+	}
+	if n, err := r.ReadAt(p, int64(len(data))); n != 0 || err != io.EOF {
+		t.Errorf("ReadAt past end: n=%d err=%v", n, err)
+	}
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Error("expected error for negative seek")
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(p); !errors.Is(err, ErrReaderClosed) {
+		t.Errorf("expected ErrReaderClosed, got %v", err)
+	}
+}
+
+func TestReaderRefresh(t *testing.T) {
+	data := testData(2 << 20)
+	server, current := tokenServer(data, 2)
+	defer server.Close()
+
+	var refreshes int32
+	dl := New(server.Client(), nil, 0).WithURLRefresh(func(context.Context) (string, error) {
+		atomic.AddInt32(&refreshes, 1)
+		return current(), nil
+	})
+	r, err := dl.Open(context.Background(), current())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("ReadAll: err=%v got=%d want=%d", err, len(got), len(data))
+	}
+	if atomic.LoadInt32(&refreshes) != 1 {
+		t.Errorf("expected 1 refresh, got %d", refreshes)
+	}
+}
+
+func TestReaderRangeIgnored(t *testing.T) {
+	data := testData(1 << 20)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") == "bytes=0-1" {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-1/%d", len(data)))
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write(data[:2])
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+
+	r, err := New(server.Client(), nil, 0).Open(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer r.Close()
+	if _, err := r.ReadAt(make([]byte, 10), 5); !errors.Is(err, errRangeNotSupported) {
+		t.Errorf("expected errRangeNotSupported, got %v", err)
+	}
+}
+
+func TestReaderCloseDuringRead(t *testing.T) {
+	data := testData(1 << 20)
+	release := make(chan struct{})
+	fetching := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") == "bytes=0-1" {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-1/%d", len(data)))
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write(data[:2])
+			return
+		}
+		fetching <- struct{}{}
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	r, err := New(server.Client(), nil, 0).Open(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := r.ReadAt(make([]byte, 10), 5)
+		done <- err
+	}()
+	<-fetching
+
+	closed := make(chan struct{})
+	go func() {
+		_ = r.Close()
+		close(closed)
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrReaderClosed) {
+			t.Errorf("stalled ReadAt returned %v, want ErrReaderClosed", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("stalled ReadAt did not return after Close")
+	}
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close did not return")
+	}
+}
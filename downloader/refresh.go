@@ -0,0 +1,118 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ytget/ytdlp/v2/internal/logging"
+)
+
+const (
+	// urlExpiryMargin is how long before its "expire" time a URL is refreshed.
+	urlExpiryMargin = time.Minute
+	// maxURLRefreshes bounds the refreshes of a single download.
+	maxURLRefreshes = 5
+)
+
+// URLRefreshFunc returns a fresh URL for the media being downloaded, for
+// example by resolving the same format again. It is called when the server
+// rejects the current URL (403/410) or when the URL is about to expire.
+type URLRefreshFunc func(ctx context.Context) (string, error)
+
+// WithURLRefresh registers a callback used to replace an expired or rejected
+// media URL. The download then continues from the current offset with the
+// new URL instead of failing.
+func (d *Downloader) WithURLRefresh(fn URLRefreshFunc) *Downloader {
+	d.refreshURL = fn
+	return d
+}
+
+// mediaURL holds the current URL of one download. It is shared by all
+// connections, so a URL rejected by several of them is refreshed only once.
+type mediaURL struct {
+	mu        sync.Mutex
+	url       string
+	gen       int
+	expire    time.Time
+	refresh   URLRefreshFunc
+	refreshes int
+	log       *slog.Logger
+}
+
+func newMediaURL(u string, refresh URLRefreshFunc) *mediaURL {
+	return &mediaURL{url: u, expire: urlExpiry(u), refresh: refresh}
+}
+
+// urlExpiry returns the time given by the "expire" query parameter (Unix
+// seconds, as used by googlevideo), or the zero time.
+func urlExpiry(rawURL string) time.Time {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return time.Time{}
+	}
+	sec, err := strconv.ParseInt(u.Query().Get("expire"), 10, 64)
+	if err != nil || sec <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
+
+// get returns the URL to use and its generation. A URL that is about to
+// expire is refreshed first; if that fails, the old URL is still returned.
+func (m *mediaURL) get(ctx context.Context) (string, int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.refresh != nil && !m.expire.IsZero() && time.Until(m.expire) < urlExpiryMargin {
+		logging.Or(m.log).InfoContext(ctx, "Media URL about to expire, refreshing", slog.Time("expire", m.expire))
+		if err := m.renewLocked(ctx); err != nil {
+			logging.Or(m.log).WarnContext(ctx, "URL refresh failed", logging.Err(err))
+			m.expire = time.Time{} // do not try again before the URL is rejected
+		}
+	}
+	return m.url, m.gen
+}
+
+// renew replaces the URL of generation gen after the server rejected it. When
+// another connection already replaced it, the newer URL is kept.
+func (m *mediaURL) renew(ctx context.Context, gen int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if gen != m.gen {
+		return nil
+	}
+	return m.renewLocked(ctx)
+}
+
+func (m *mediaURL) renewLocked(ctx context.Context) error {
+	if m.refresh == nil {
+		return errors.New("no URL refresh configured")
+	}
+	if m.refreshes >= maxURLRefreshes {
+		return errors.New("too many URL refreshes")
+	}
+	m.refreshes++
+	u, err := m.refresh(ctx)
+	if err != nil {
+		return err
+	}
+	m.url = u
+	m.gen++
+	m.expire = urlExpiry(u)
+	if !m.expire.IsZero() && time.Until(m.expire) < urlExpiryMargin {
+		m.expire = time.Time{} // fresh but short-lived; use it until rejected
+	}
+	logging.Or(m.log).InfoContext(ctx, "Media URL refreshed", slog.Int("refresh", m.refreshes), slog.Int("max", maxURLRefreshes))
+	return nil
+}
+
+// isURLRejected reports whether status means the URL itself is no longer
+// valid, as opposed to a transient server error.
+func isURLRejected(status int) bool {
+	return status == http.StatusForbidden || status == http.StatusGone
+}
@@ -0,0 +1,131 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tokenServer serves data only for requests carrying the current token; the
+// token changes after a number of successful ranged requests, like a media
+// URL that expires mid-download.
+func tokenServer(data []byte, rotateAfter int) (*httptest.Server, func() string) {
+	var mu sync.Mutex
+	token, served := 1, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ok := r.URL.Query().Get("token") == fmt.Sprint(token)
+		if ok {
+			served++
+			if served == rotateAfter {
+				token++
+			}
+		}
+		mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var a, b int
+		_, _ = fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &a, &b)
+		if b >= len(data) {
+			b = len(data) - 1
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", a, b, len(data)))
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", b-a+1))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(data[a : b+1])
+	}))
+	current := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return fmt.Sprintf("%s/?token=%d", srv.URL, token)
+	}
+	return srv, current
+}
+
+func TestDownloadRefreshOn403(t *testing.T) {
+	for _, conns := range []int{1, 3} {
+		t.Run(fmt.Sprintf("connections=%d", conns), func(t *testing.T) {
+			data := testData(4<<20 + 11)
+			server, current := tokenServer(data, 3)
+			defer server.Close()
+
+			var refreshes int32
+			dl := New(server.Client(), nil, 0).WithConnections(conns).WithURLRefresh(func(ctx context.Context) (string, error) {
+				atomic.AddInt32(&refreshes, 1)
+				return current(), nil
+			})
+			dl.maxRetries = 1 // a 403 must not use up the retries
+			out := t.TempDir() + "/file.bin"
+			if err := dl.Download(context.Background(), current(), out); err != nil {
+				t.Fatalf("download failed: %v", err)
+			}
+			bs, err := os.ReadFile(out)
+			if err != nil || !bytes.Equal(bs, data) {
+				t.Fatalf("content mismatch: err=%v got=%d want=%d", err, len(bs), len(data))
+			}
+			if n := atomic.LoadInt32(&refreshes); n != 1 {
+				t.Errorf("expected 1 refresh, got %d", n)
+			}
+		})
+	}
+}
+
+func TestDownloadWithoutRefreshFailsOn403(t *testing.T) {
+	data := testData(3 << 20)
+	server, current := tokenServer(data, 2)
+	defer server.Close()
+
+	dl := New(server.Client(), nil, 0)
+	dl.maxRetries = 1
+	if err := dl.Download(context.Background(), current(), t.TempDir()+"/file.bin"); err == nil {
+		t.Fatal("expected download to fail with an expired URL")
+	}
+}
+
+func TestMediaURLExpiry(t *testing.T) {
+	soon := fmt.Sprintf("https://r1.googlevideo.com/videoplayback?expire=%d&itag=18", time.Now().Add(10*time.Second).Unix())
+	later := fmt.Sprintf("https://r1.googlevideo.com/videoplayback?expire=%d&itag=18", time.Now().Add(6*time.Hour).Unix())
+	if got := urlExpiry("https://example.com/x"); !got.IsZero() {
+		t.Errorf("urlExpiry without parameter = %v", got)
+	}
+
+	calls := 0
+	m := newMediaURL(soon, func(context.Context) (string, error) {
+		calls++
+		return later, nil
+	})
+	if u, gen := m.get(context.Background()); u != later || gen != 1 || calls != 1 {
+		t.Fatalf("expected proactive refresh, got %q gen=%d calls=%d", u, gen, calls)
+	}
+	if u, _ := m.get(context.Background()); u != later || calls != 1 {
+		t.Errorf("fresh URL refreshed again (calls=%d)", calls)
+	}
+	// A stale generation (another connection already refreshed) is a no-op.
+	if err := m.renew(context.Background(), 0); err != nil || calls != 1 {
+		t.Errorf("stale renew: err=%v calls=%d", err, calls)
+	}
+
+	failing := newMediaURL(soon, func(context.Context) (string, error) {
+		calls++
+		return "", errors.New("offline")
+	})
+	calls = 0
+	for i := 0; i < 3; i++ {
+		if u, _ := failing.get(context.Background()); u != soon {
+			t.Fatalf("expected the old URL after a failed refresh, got %q", u)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("failed proactive refresh retried %d times", calls)
+	}
+}
@@ -0,0 +1,150 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// resumeStateSuffix is appended to the output path for the sidecar file that
+// describes a partial download.
+const resumeStateSuffix = ".part.json"
+
+// resumeState is the content of the ".part.json" sidecar stored next to the
+// temporary file. It identifies what is being downloaded and which byte
+// ranges of the temporary file are already valid. ChunkSize pins the chunk
+// grid of the download (the fragments of progress events and, without
+// adaptive sizing, the request size), so that a resumed download keeps it.
+type resumeState struct {
+	VideoID      string      `json:"video_id,omitempty"`
+	Itag         int         `json:"itag,omitempty"`
+	TotalSize    int64       `json:"total_size"`
+	ETag         string      `json:"etag,omitempty"`
+	LastModified string      `json:"last_modified,omitempty"`
+	ChunkSize    int64       `json:"chunk_size,omitempty"`
+	Ranges       []byteRange `json:"ranges"`
+}
+
+// WithResumeKey identifies the media behind the next downloads (video ID and
+// itag). The key is stored in the resume sidecar, and a partial download made
+// for a different key is discarded instead of being resumed.
+func (d *Downloader) WithResumeKey(videoID string, itag int) *Downloader {
+	d.videoID = strings.TrimSpace(videoID)
+	d.itag = itag
+	return d
+}
+
+// newResumeState returns the state for a fresh download of rf.
+func (d *Downloader) newResumeState(rf remoteFile) *resumeState {
+	return &resumeState{
+		VideoID:      d.videoID,
+		Itag:         d.itag,
+		TotalSize:    rf.Size,
+		ETag:         rf.ETag,
+		LastModified: rf.LastModified,
+		ChunkSize:    d.chunkSize,
+	}
+}
+
+// mismatch returns why a partial download described by s cannot be resumed
+// as the download described by want, or "" when it can.
+func (s *resumeState) mismatch(want *resumeState) string {
+	switch {
+	case s.VideoID != want.VideoID:
+		return fmt.Sprintf("video ID %q != %q", s.VideoID, want.VideoID)
+	case s.Itag != want.Itag:
+		return fmt.Sprintf("itag %d != %d", s.Itag, want.Itag)
+	case s.TotalSize != want.TotalSize:
+		return fmt.Sprintf("total size %d != %d", s.TotalSize, want.TotalSize)
+	case s.ETag != "" && want.ETag != "" && s.ETag != want.ETag:
+		return fmt.Sprintf("ETag %s != %s", s.ETag, want.ETag)
+	case s.LastModified != "" && want.LastModified != "" && s.LastModified != want.LastModified:
+		return fmt.Sprintf("Last-Modified %q != %q", s.LastModified, want.LastModified)
+	case s.ChunkSize < 0:
+		return fmt.Sprintf("invalid chunk size %d", s.ChunkSize)
+	}
+	for _, r := range s.Ranges {
+		if r.Start < 0 || r.End <= r.Start || (s.TotalSize > 0 && r.End > s.TotalSize) {
+			return fmt.Sprintf("invalid range %d-%d", r.Start, r.End)
+		}
+	}
+	return ""
+}
+
+// loadResumeState reads the sidecar at path. It returns nil when the file
+// does not exist.
+func loadResumeState(path string) (*resumeState, error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s resumeState
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("invalid resume state %s: %v", path, err)
+	}
+	return &s, nil
+}
+
+// save writes the sidecar atomically (write to a temporary file, then rename).
+func (s *resumeState) save(path string) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := path + temporaryFileSuffix
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// prepareResume validates the partial download of outputPath against want.
+// When it can be resumed, want takes the byte ranges of the temporary file
+// that can be kept and the chunk size of the sidecar, if any. On any mismatch
+// the temporary file and sidecar are removed and want is left unchanged, so
+// the download starts over. A temporary file without a sidecar is never
+// trusted.
+func prepareResume(outputPath string, want *resumeState, log *slog.Logger) {
+	tmpPath := outputPath + temporaryFileSuffix
+	statePath := outputPath + resumeStateSuffix
+	fi, statErr := os.Stat(tmpPath)
+	state, err := loadResumeState(statePath)
+
+	reason := ""
+	switch {
+	case statErr != nil && state == nil && err == nil:
+		return // nothing to resume
+	case statErr != nil:
+		reason = "temporary file is missing"
+	case err != nil:
+		reason = err.Error()
+	case state == nil:
+		reason = "no resume state"
+	default:
+		reason = state.mismatch(want)
+		if reason == "" {
+			for _, r := range state.Ranges {
+				if r.End > fi.Size() {
+					reason = fmt.Sprintf("temporary file is shorter than range %d-%d", r.Start, r.End)
+					break
+				}
+			}
+		}
+	}
+	if reason != "" {
+		log.Info("Discarding partial download", slog.String("reason", reason))
+		_ = os.Remove(tmpPath)
+		_ = os.Remove(statePath)
+		return
+	}
+	log.Info("Resuming partial download", slog.Int("ranges", len(state.Ranges)))
+	want.Ranges = state.Ranges
+	if state.ChunkSize > 0 {
+		want.ChunkSize = state.ChunkSize
+	}
+}
@@ -0,0 +1,222 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+)
+
+// rangeServer serves data with Range support and an ETag, and records the
+// start offset of every ranged GET except the size probe.
+type rangeServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	starts []int
+	failAt int // offset at which requests fail with 500; -1 disables
+}
+
+func newRangeServer(data []byte, etag string) *rangeServer {
+	s := &rangeServer{failAt: -1}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a, b int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &a, &b); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if b >= len(data) {
+			b = len(data) - 1
+		}
+		s.mu.Lock()
+		fail := s.failAt >= 0 && a >= s.failAt
+		if b > 1 {
+			s.starts = append(s.starts, a)
+		}
+		s.mu.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", a, b, len(data)))
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", b-a+1))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(data[a : b+1])
+	}))
+	return s
+}
+
+func (s *rangeServer) requested() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int(nil), s.starts...)
+}
+
+func TestResumeStateMismatch(t *testing.T) {
+	base := resumeState{VideoID: "abc", Itag: 140, TotalSize: 100, ETag: `"x"`, Ranges: []byteRange{{0, 50}}}
+	tests := []struct {
+		name  string
+		edit  func(*resumeState)
+		match bool
+	}{
+		{"same", func(*resumeState) {}, true},
+		{"validator unknown", func(s *resumeState) { s.ETag = "" }, true},
+		{"video", func(s *resumeState) { s.VideoID = "def" }, false},
+		{"itag", func(s *resumeState) { s.Itag = 251 }, false},
+		{"size", func(s *resumeState) { s.TotalSize = 99 }, false},
+		{"etag", func(s *resumeState) { s.ETag = `"y"` }, false},
+		{"chunk size", func(s *resumeState) { s.ChunkSize = 256 << 10 }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := base
+			want.Ranges = nil
+			tt.edit(&want)
+			if got := base.mismatch(&want); (got == "") != tt.match {
+				t.Errorf("mismatch() = %q, want match=%v", got, tt.match)
+			}
+		})
+	}
+	bad := base
+	bad.Ranges = []byteRange{{90, 120}}
+	if bad.mismatch(&base) == "" {
+		t.Error("expected range past the end to be rejected")
+	}
+	bad = base
+	bad.ChunkSize = -1
+	if bad.mismatch(&base) == "" {
+		t.Error("expected a negative chunk size to be rejected")
+	}
+}
+
+func TestDownloadResumeDiscardsMismatch(t *testing.T) {
+	data := testData(2<<20 + 5)
+	server := newRangeServer(data, `"v2"`)
+	defer server.Close()
+	garbage := bytes.Repeat([]byte{0xEE}, 1<<20)
+
+	tests := []struct {
+		name  string
+		state *resumeState
+	}{
+		{"no sidecar", nil},
+		{"other itag", &resumeState{VideoID: "vid", Itag: 137, TotalSize: int64(len(data)), Ranges: []byteRange{{0, 1 << 20}}}},
+		{"changed file", &resumeState{VideoID: "vid", Itag: 140, TotalSize: int64(len(data)), ETag: `"v1"`, Ranges: []byteRange{{0, 1 << 20}}}},
+		{"other size", &resumeState{VideoID: "vid", Itag: 140, TotalSize: 1 << 21, Ranges: []byteRange{{0, 1 << 20}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := t.TempDir() + "/file.bin"
+			if err := os.WriteFile(out+temporaryFileSuffix, garbage, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.state != nil {
+				if err := tt.state.save(out + resumeStateSuffix); err != nil {
+					t.Fatal(err)
+				}
+			}
+			dl := New(server.Client(), nil, 0).WithResumeKey("vid", 140)
+			if err := dl.Download(context.Background(), server.URL, out); err != nil {
+				t.Fatalf("download failed: %v", err)
+			}
+			bs, err := os.ReadFile(out)
+			if err != nil || !bytes.Equal(bs, data) {
+				t.Fatalf("content mismatch: err=%v got=%d want=%d", err, len(bs), len(data))
+			}
+		})
+	}
+}
+
+func TestDownloadSegmentedResume(t *testing.T) {
+	data := testData(4 << 20)
+	server := newRangeServer(data, `"v1"`)
+	defer server.Close()
+	out := t.TempDir() + "/file.bin"
+
+	// The first run fails for everything from 2 MiB on.
+	server.failAt = 2 << 20
+	dl := New(server.Client(), nil, 0).WithConnections(2).WithResumeKey("vid", 137)
+	dl.maxRetries = 1
+	if err := dl.Download(context.Background(), server.URL, out); err == nil {
+		t.Fatal("expected the first run to fail")
+	}
+	state, err := loadResumeState(out + resumeStateSuffix)
+	if err != nil || state == nil {
+		t.Fatalf("resume state not saved: %v", err)
+	}
+	if state.VideoID != "vid" || state.Itag != 137 || state.ETag != `"v1"` || state.TotalSize != int64(len(data)) {
+		t.Errorf("unexpected resume state: %+v", state)
+	}
+	// A range running in parallel with the failing one may have been cut
+	// short by the cancellation, but whatever was written must be recorded.
+	done := rangeSet{ranges: state.Ranges}
+	if done.Size() < 1<<20 || state.Ranges[len(state.Ranges)-1].End > 2<<20 {
+		t.Fatalf("unexpected completed ranges: %v", state.Ranges)
+	}
+
+	server.mu.Lock()
+	server.failAt = -1
+	server.starts = nil
+	server.mu.Unlock()
+	if err := dl.Download(context.Background(), server.URL, out); err != nil {
+		t.Fatalf("resume failed: %v", err)
+	}
+	for _, start := range server.requested() {
+		for _, r := range done.ranges {
+			if int64(start) >= r.Start && int64(start) < r.End {
+				t.Errorf("completed range %v refetched from %d", r, start)
+			}
+		}
+	}
+	bs, err := os.ReadFile(out)
+	if err != nil || !bytes.Equal(bs, data) {
+		t.Fatalf("content mismatch: err=%v got=%d want=%d", err, len(bs), len(data))
+	}
+	if _, err := os.Stat(out + resumeStateSuffix); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("resume state left behind: %v", err)
+	}
+}
+
+func TestDownloadResumeKeepsChunkSize(t *testing.T) {
+	data := testData(2 << 20)
+	server := newRangeServer(data, `"v1"`)
+	defer server.Close()
+	out := t.TempDir() + "/file.bin"
+
+	// A partial download made with 512 KiB chunks resumes on that grid,
+	// although the downloader's own chunk size is 1 MiB.
+	const chunk = 512 << 10
+	if err := os.WriteFile(out+temporaryFileSuffix, data[:chunk], 0644); err != nil {
+		t.Fatal(err)
+	}
+	state := &resumeState{VideoID: "vid", Itag: 140, TotalSize: int64(len(data)), ETag: `"v1"`, ChunkSize: chunk, Ranges: []byteRange{{0, chunk}}}
+	if err := state.save(out + resumeStateSuffix); err != nil {
+		t.Fatal(err)
+	}
+	var last Progress
+	dl := New(server.Client(), func(p Progress) { last = p }, 0).WithResumeKey("vid", 140)
+	if err := dl.Download(context.Background(), server.URL, out); err != nil {
+		t.Fatalf("resume failed: %v", err)
+	}
+	var starts []int
+	for _, s := range server.requested() {
+		if s > 0 { // skip the size probe
+			starts = append(starts, s)
+		}
+	}
+	if fmt.Sprint(starts) != fmt.Sprint([]int{chunk, 2 * chunk, 3 * chunk}) {
+		t.Errorf("requested ranges from %v, want 512 KiB steps", starts)
+	}
+	if last.FragmentCount != 4 || last.FragmentIndex != 4 {
+		t.Errorf("final fragment %d/%d, want 4/4", last.FragmentIndex, last.FragmentCount)
+	}
+	bs, err := os.ReadFile(out)
+	if err != nil || !bytes.Equal(bs, data) {
+		t.Fatalf("content mismatch: err=%v got=%d want=%d", err, len(bs), len(data))
+	}
+}
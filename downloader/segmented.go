@@ -0,0 +1,236 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ytget/ytdlp/v2/internal/logging"
+)
+
+// errRangeNotSupported is returned by segmented downloads when the server
+// answers a ranged request with the whole resource.
+var errRangeNotSupported = errors.New("server does not support range requests")
+
+// WithConnections sets how many byte ranges are fetched in parallel. Values
+// below 2 keep the default single-connection mode. Parallel mode is only used
+// when the total size is known; the limiter is shared by all connections.
+func (d *Downloader) WithConnections(n int) *Downloader {
+	if n < 1 {
+		n = 1
+	}
+	d.connections = n
+	return d
+}
+
+// rangeProgress tracks completed ranges of a segmented download and reports
+// aggregate progress through meter, which may be nil.
+type rangeProgress struct {
+	mu        sync.Mutex
+	done      rangeSet
+	total     int64
+	chunkSize int64
+	meter     *progressMeter
+	log       *slog.Logger
+}
+
+// add records [start, end) as written and reports progress. The fragment is
+// the chunk that start falls in.
+func (p *rangeProgress) add(start, end int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done.Add(start, end)
+	if p.meter != nil {
+		p.meter.update(p.done.Size(), int(start/p.chunkSize)+1)
+	}
+}
+
+// size returns the number of bytes written so far.
+func (p *rangeProgress) size() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.done.Size()
+}
+
+// save records the completed ranges in state and writes it to path.
+func (p *rangeProgress) save(state *resumeState, path string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	state.Ranges = p.done.Ranges()
+	if err := state.save(path); err != nil {
+		logging.Or(p.log).Warn("Failed to save resume state", logging.Err(err))
+	}
+}
+
+// downloadSegmented lets up to d.connections workers fetch the missing parts
+// of the file, each taking the next chunk-sized range as it finishes one and
+// writing it at its offset in the preallocated temporary file. Completed ranges are saved to
+// the resume sidecar as they finish.
+func (d *Downloader) downloadSegmented(ctx context.Context, src *mediaURL, outputPath string, state *resumeState) error {
+	tmpPath := outputPath + temporaryFileSuffix
+	statePath := outputPath + resumeStateSuffix
+	totalSize := state.TotalSize
+	outFile, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %v", err)
+	}
+	if err := outFile.Truncate(totalSize); err != nil {
+		_ = outFile.Close()
+		return fmt.Errorf("failed to preallocate output file: %v", err)
+	}
+
+	log := d.log()
+	progress := &rangeProgress{total: totalSize, chunkSize: state.ChunkSize, log: log}
+	for _, r := range state.Ranges {
+		progress.done.Add(r.Start, r.End)
+	}
+	progress.meter = d.newProgressMeter(totalSize, progress.done.Size(), state.ChunkSize)
+	progress.save(state, statePath)
+	chunks := d.newChunkSizer(state.ChunkSize)
+	missing := progress.done.Missing(totalSize)
+	queue := &rangeQueue{ranges: missing}
+	workers := d.connections
+	if n := len(splitRanges(missing, chunks.next())); workers > n {
+		workers = n
+	}
+	log.DebugContext(ctx, "Downloading ranges in parallel", slog.Int("ranges", len(missing)), slog.Int("connections", workers), slog.Int64("present", progress.size()))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				r, ok := queue.next(chunks.next())
+				if !ok {
+					return
+				}
+				if err := d.fetchSegment(ctx, src, chunks, outFile, r, progress); err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					return
+				}
+				progress.save(state, statePath)
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+
+	if firstErr == nil && progress.size() != totalSize {
+		firstErr = fmt.Errorf("incomplete download: %d of %d bytes", progress.size(), totalSize)
+	}
+	if cerr := outFile.Close(); firstErr == nil && cerr != nil {
+		firstErr = fmt.Errorf("failed to close output file: %v", cerr)
+	}
+	if firstErr != nil {
+		// Keep the temporary file: the sidecar says which parts are valid.
+		progress.save(state, statePath)
+		return firstErr
+	}
+	_ = os.Remove(statePath)
+	if err := d.verify(tmpPath, outputPath, totalSize); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, outputPath)
+}
+
+// fetchSegment downloads r into w at its offset. When the body breaks off,
+// the request is retried from the first byte not yet written. Throughput and
+// failures are reported to chunks, which may be nil.
+func (d *Downloader) fetchSegment(ctx context.Context, src *mediaURL, chunks *chunkSizer, w io.WriterAt, r byteRange, progress *rangeProgress) error {
+	pos := r.Start
+	var lastErr error
+	began := time.Now()
+	backoff := initialBackoffDuration
+	for attempt := 0; attempt < d.maxRetries && pos < r.End; attempt++ {
+		if attempt > 0 {
+			chunks.failed()
+			d.log().WarnContext(ctx, "Range interrupted, retrying", slog.Int64("start", r.Start), slog.Int64("end", r.End-1), slog.Int64("offset", pos), slog.Int("attempt", attempt), logging.Err(lastErr))
+			if err := sleepContext(ctx, backoff); err != nil {
+				return err
+			}
+			backoff *= 2
+			if backoff > maxBackoffDuration {
+				backoff = maxBackoffDuration
+			}
+		}
+		resp, err := d.fetchRange(ctx, src, chunks, pos, r.End-1)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusPartialContent {
+			_ = resp.Body.Close()
+			return errRangeNotSupported
+		}
+		if err := checkContentRange(resp, pos, progress.total); err != nil {
+			_ = resp.Body.Close()
+			return err
+		}
+		var n int64
+		n, lastErr = d.copyAt(ctx, w, io.LimitReader(resp.Body, r.End-pos), pos, progress)
+		_ = resp.Body.Close()
+		pos += n
+		if lastErr == nil && pos < r.End {
+			lastErr = io.ErrUnexpectedEOF
+		}
+		if errors.Is(lastErr, errWrite) {
+			return lastErr
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	if pos < r.End {
+		return fmt.Errorf("download range %d-%d failed: %v", r.Start, r.End-1, lastErr)
+	}
+	chunks.observe(r.Len(), time.Since(began))
+	return nil
+}
+
+// errWrite marks local write failures, which are not worth retrying.
+var errWrite = errors.New("failed to write chunk")
+
+// copyAt copies src into w starting at off, recording each written span in
+// progress. All connections wait on the same limiter, so together they
+// honour the rate limit.
+func (d *Downloader) copyAt(ctx context.Context, w io.WriterAt, src io.Reader, off int64, progress *rangeProgress) (int64, error) {
+	buf := make([]byte, copyBufferSizeBytes)
+	var written int64
+	for {
+		n, rerr := src.Read(buf)
+		if n > 0 {
+			if _, werr := w.WriteAt(buf[:n], off+written); werr != nil {
+				return written, fmt.Errorf("%w: %v", errWrite, werr)
+			}
+			progress.add(off+written, off+written+int64(n))
+			written += int64(n)
+			if err := d.throttle(ctx, n); err != nil {
+				return written, err
+			}
+		}
+		if rerr == io.EOF {
+			return written, nil
+		}
+		if rerr != nil {
+			return written, rerr
+		}
+	}
+}
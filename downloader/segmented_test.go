@@ -0,0 +1,123 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+)
+
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	return data
+}
+
+func TestDownloadSegmented(t *testing.T) {
+	data := testData(5<<20 + 123)
+	server := makeServer(data)
+	defer server.Close()
+
+	var mu sync.Mutex
+	var last Progress
+	calls := 0
+	dl := New(server.Client(), func(p Progress) {
+		mu.Lock()
+		defer mu.Unlock()
+		if p.DownloadedSize < last.DownloadedSize {
+			t.Errorf("progress went backwards: %d after %d", p.DownloadedSize, last.DownloadedSize)
+		}
+		last = p
+		calls++
+	}, 0).WithConnections(4)
+	out := t.TempDir() + "/file.bin"
+
+	if err := dl.Download(context.Background(), server.URL, out); err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	bs, err := os.ReadFile(out)
+	if err != nil || !bytes.Equal(bs, data) {
+		t.Fatalf("content mismatch: err=%v got=%d want=%d", err, len(bs), len(data))
+	}
+	if calls == 0 || last.DownloadedSize != int64(len(data)) || last.TotalSize != int64(len(data)) || last.Percent != 100 {
+		t.Errorf("unexpected final progress %+v after %d calls", last, calls)
+	}
+	if _, err := os.Stat(out + temporaryFileSuffix); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+}
+
+func TestDownloadSegmentedRetry(t *testing.T) {
+	data := testData(3 << 20)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a, b int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &a, &b); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if b >= len(data) {
+			b = len(data) - 1
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", a, b, len(data)))
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", b-a+1))
+		w.WriteHeader(http.StatusPartialContent)
+		// Break off every response that starts at a chunk boundary halfway;
+		// the retry starts mid-chunk and succeeds.
+		if b-a > 1 && a%defaultChunkSizeBytes == 0 {
+			_, _ = w.Write(data[a : a+(b-a+1)/2])
+			return
+		}
+		_, _ = w.Write(data[a : b+1])
+	}))
+	defer server.Close()
+
+	var mu sync.Mutex
+	var downloaded int64
+	dl := New(server.Client(), func(p Progress) {
+		mu.Lock()
+		downloaded = p.DownloadedSize
+		mu.Unlock()
+	}, 0).WithConnections(3)
+	out := t.TempDir() + "/file.bin"
+	if err := dl.Download(context.Background(), server.URL, out); err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	bs, err := os.ReadFile(out)
+	if err != nil || !bytes.Equal(bs, data) {
+		t.Fatalf("content mismatch: err=%v got=%d want=%d", err, len(bs), len(data))
+	}
+	if downloaded != int64(len(data)) {
+		t.Errorf("progress reported %d bytes, want %d", downloaded, len(data))
+	}
+}
+
+func TestDownloadSegmentedRangeIgnored(t *testing.T) {
+	data := testData(2<<20 + 7)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") == "bytes=0-1" {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-1/%d", len(data)))
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write(data[:2])
+			return
+		}
+		// Ignores Range and always sends the whole file.
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+
+	dl := New(server.Client(), nil, 0).WithConnections(4)
+	out := t.TempDir() + "/file.bin"
+	if err := dl.Download(context.Background(), server.URL, out); err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	bs, err := os.ReadFile(out)
+	if err != nil || !bytes.Equal(bs, data) {
+		t.Fatalf("content mismatch: err=%v got=%d want=%d", err, len(bs), len(data))
+	}
+}
@@ -0,0 +1,158 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ytget/ytdlp/v2/internal/logging"
+)
+
+// errStreamWrite marks failures of the destination writer, which are not
+// retried.
+var errStreamWrite = errors.New("failed to write to destination")
+
+// DownloadTo downloads a file by URL and writes it to w in order, without a
+// temporary file or resume support. w does not need to seek: when a request
+// breaks off, the next one starts at the first byte not yet written, and a
+// server that ignores Range has the bytes already written skipped, so no
+// byte is written twice. Parallel connections are not used.
+func (d *Downloader) DownloadTo(ctx context.Context, urlStr string, w io.Writer) error {
+	log := d.log()
+	log.InfoContext(ctx, "Starting download to stream")
+	src := d.source(urlStr)
+
+	probeURL, _ := src.get(ctx)
+	totalSize, err := d.detectTotalSize(ctx, probeURL)
+	if err != nil {
+		log.WarnContext(ctx, "Could not determine total size", logging.Err(err))
+		totalSize = 0
+	} else {
+		log.DebugContext(ctx, "Total size detected", slog.Int64("size", totalSize))
+	}
+	if err := d.checkRemoteSize(totalSize); err != nil {
+		return err
+	}
+	if totalSize == 0 {
+		totalSize = d.expectedSize
+	}
+
+	meter := d.newProgressMeter(totalSize, 0, d.chunkSize)
+	chunks := d.newChunkSizer(d.chunkSize)
+	var written int64
+	for totalSize == 0 || written < totalSize {
+		end := written + chunks.next() - 1
+		if totalSize > 0 && end >= totalSize {
+			end = totalSize - 1
+		}
+		want := end - written + 1
+
+		var n int64
+		var lastErr error
+		began := time.Now()
+		backoff := initialBackoffDuration
+		for attempt := 0; attempt < d.maxRetries; attempt++ {
+			if attempt > 0 {
+				log.WarnContext(ctx, "Stream interrupted, retrying", slog.Int64("offset", written), slog.Int("attempt", attempt), logging.Err(lastErr))
+				if err := sleepContext(ctx, backoff); err != nil {
+					return err
+				}
+				backoff *= 2
+				if backoff > maxBackoffDuration {
+					backoff = maxBackoffDuration
+				}
+			}
+			var got int64
+			got, totalSize, lastErr = d.streamRange(ctx, src, chunks, w, written, end, totalSize, meter)
+			written += got
+			n += got
+			if lastErr == nil || errors.Is(lastErr, errStreamWrite) || ctx.Err() != nil {
+				break
+			}
+			chunks.failed()
+		}
+		if lastErr != nil {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			return lastErr
+		}
+		chunks.observe(n, time.Since(began))
+		if totalSize == 0 && n < want {
+			break // size unknown and the server had no more data
+		}
+	}
+	switch {
+	case written == 0:
+		return fmt.Errorf("empty download: 0 bytes written")
+	case d.expectedSize > 0 && written != d.expectedSize:
+		return fmt.Errorf("%w: wrote %d bytes, expected %d", ErrIntegrity, written, d.expectedSize)
+	}
+	return nil
+}
+
+// streamRange fetches bytes start..end (inclusive) and copies them to w. It
+// returns the bytes written and the total size, which is learned from
+// Content-Range when it was unknown. A 200 response (Range ignored) has its
+// first start bytes discarded and is copied to the end.
+func (d *Downloader) streamRange(ctx context.Context, src *mediaURL, chunks *chunkSizer, w io.Writer, start, end, totalSize int64, meter *progressMeter) (int64, int64, error) {
+	resp, err := d.fetchRange(ctx, src, chunks, start, end)
+	if err != nil {
+		return 0, totalSize, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := d.rangeBody(ctx, resp, start, end, totalSize)
+	if err != nil {
+		return 0, totalSize, err
+	}
+	if totalSize == 0 {
+		totalSize = responseTotal(resp)
+		meter.setTotal(totalSize, d.chunkSize)
+	}
+
+	buf := make([]byte, copyBufferSizeBytes)
+	var written int64
+	for {
+		n, rerr := body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return written, totalSize, fmt.Errorf("%w: %v", errStreamWrite, werr)
+			}
+			written += int64(n)
+			meter.update(start+written, int((start+written-1)/d.chunkSize)+1)
+			if err := d.throttle(ctx, n); err != nil {
+				return written, totalSize, err
+			}
+		}
+		if rerr == io.EOF {
+			if resp.StatusCode == http.StatusPartialContent && totalSize > 0 && start+written < min(end+1, totalSize) {
+				return written, totalSize, io.ErrUnexpectedEOF
+			}
+			return written, totalSize, nil
+		}
+		if rerr != nil {
+			return written, totalSize, rerr
+		}
+	}
+}
+
+// contentRangeTotal returns the complete length from a "bytes a-b/total"
+// header, or 0 when it is absent or unknown ("*").
+func contentRangeTotal(cr string) int64 {
+	i := strings.LastIndex(cr, "/")
+	if i < 0 {
+		return 0
+	}
+	v, err := strconv.ParseInt(strings.TrimSpace(cr[i+1:]), 10, 64)
+	if err != nil || v < 0 {
+		return 0
+	}
+	return v
+}
@@ -0,0 +1,97 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// flakyServer serves data but breaks off every response to a request that
+// starts at a chunk boundary halfway; retries start mid-chunk and succeed.
+// With ignoreRange it always answers 200 with the whole file.
+func flakyServer(data []byte, ignoreRange bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a, b int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &a, &b); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if b >= len(data) {
+			b = len(data) - 1
+		}
+		drop := b-a > 1 && a%defaultChunkSizeBytes == 0
+		if ignoreRange {
+			a, b = 0, len(data)-1
+		} else {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", a, b, len(data)))
+		}
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", b-a+1))
+		if !ignoreRange {
+			w.WriteHeader(http.StatusPartialContent)
+		}
+		if drop {
+			_, _ = w.Write(data[a : a+(b-a+1)/2])
+			return
+		}
+		_, _ = w.Write(data[a : b+1])
+	}))
+}
+
+// onlyWriter hides any Seek/WriteAt methods of the underlying buffer.
+type onlyWriter struct{ buf bytes.Buffer }
+
+func (w *onlyWriter) Write(p []byte) (int, error) { return w.buf.Write(p) }
+
+func TestDownloadTo(t *testing.T) {
+	for _, ignoreRange := range []bool{false, true} {
+		t.Run(fmt.Sprintf("ignoreRange=%v", ignoreRange), func(t *testing.T) {
+			data := testData(2<<20 + 333)
+			server := flakyServer(data, ignoreRange)
+			defer server.Close()
+
+			var last Progress
+			dl := New(server.Client(), func(p Progress) { last = p }, 0)
+			var w onlyWriter
+			if err := dl.DownloadTo(context.Background(), server.URL, &w); err != nil {
+				t.Fatalf("DownloadTo failed: %v", err)
+			}
+			if !bytes.Equal(w.buf.Bytes(), data) {
+				t.Fatalf("content mismatch: got %d bytes, want %d", w.buf.Len(), len(data))
+			}
+			if last.DownloadedSize != int64(len(data)) || last.Percent != 100 {
+				t.Errorf("unexpected final progress %+v", last)
+			}
+		})
+	}
+}
+
+type failingWriter struct{ calls int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.calls++
+	return 0, errors.New("broken pipe")
+}
+
+func TestDownloadToWriteError(t *testing.T) {
+	server := makeServer(testData(1 << 20))
+	defer server.Close()
+
+	w := &failingWriter{}
+	err := New(server.Client(), nil, 0).DownloadTo(context.Background(), server.URL, w)
+	if !errors.Is(err, errStreamWrite) || w.calls != 1 {
+		t.Errorf("expected one failed write without retries, got err=%v calls=%d", err, w.calls)
+	}
+}
+
+func TestContentRangeTotal(t *testing.T) {
+	tests := map[string]int64{"bytes 0-1/1000": 1000, "bytes 0-1/*": 0, "": 0, "garbage": 0}
+	for in, want := range tests {
+		if got := contentRangeTotal(in); got != want {
+			t.Errorf("contentRangeTotal(%q) = %d, want %d", in, got, want)
+		}
+	}
+}
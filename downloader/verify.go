@@ -0,0 +1,134 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// checksumSuffix is appended to the output path for the SHA-256 sidecar.
+const checksumSuffix = ".sha256"
+
+// ErrIntegrity is returned (wrapped) when a download does not match what the
+// server or the format announced: wrong size, a response for another byte
+// range, or a damaged container.
+var ErrIntegrity = errors.New("downloader: integrity check failed")
+
+// WithExpectedSize sets the size the file must have, e.g. the format's
+// content length. A server reporting another size fails the download
+// before anything is fetched; when the server reports none, the expected
+// size is used to request the file. Zero (the default) disables the check.
+func (d *Downloader) WithExpectedSize(size int64) *Downloader {
+	if size < 0 {
+		size = 0
+	}
+	d.expectedSize = size
+	return d
+}
+
+// WithContainerCheck makes Download check the structure of the completed
+// file before renaming it: an MP4 must consist of whole top-level boxes
+// including moov and mdat, a WebM must start with an EBML header and hold
+// tracks and a cluster. An empty Container (the default) disables the
+// check. DownloadTo cannot check containers.
+func (d *Downloader) WithContainerCheck(c Container) *Downloader {
+	d.container = c
+	return d
+}
+
+// WithChecksum makes Download compute the SHA-256 of the completed file and
+// write it to "<output>.sha256" in the format of sha256sum.
+func (d *Downloader) WithChecksum(enabled bool) *Downloader {
+	d.checksum = enabled
+	return d
+}
+
+// checkContentRange reports whether a 206 response covers the requested
+// range of a file of totalSize bytes (0 when unknown). Servers that omit
+// Content-Range are trusted.
+func checkContentRange(resp *http.Response, start, totalSize int64) error {
+	cr := resp.Header.Get(headerContentRange)
+	if cr == "" {
+		return nil
+	}
+	var first, last int64
+	if _, err := fmt.Sscanf(cr, "bytes %d-%d", &first, &last); err != nil {
+		return fmt.Errorf("%w: malformed Content-Range %q", ErrIntegrity, cr)
+	}
+	if first != start {
+		return fmt.Errorf("%w: requested offset %d, got Content-Range %q", ErrIntegrity, start, cr)
+	}
+	if total := contentRangeTotal(cr); totalSize > 0 && total > 0 && total != totalSize {
+		return fmt.Errorf("%w: file size changed from %d to %d", ErrIntegrity, totalSize, total)
+	}
+	return nil
+}
+
+// checkRemoteSize compares the size reported by the server with the
+// expected size.
+func (d *Downloader) checkRemoteSize(size int64) error {
+	if d.expectedSize > 0 && size > 0 && size != d.expectedSize {
+		return fmt.Errorf("%w: server reports %d bytes, expected %d", ErrIntegrity, size, d.expectedSize)
+	}
+	return nil
+}
+
+// verify checks the completed temporary file at path against totalSize (0
+// when unknown), the expected size and the container, and writes the
+// checksum sidecar for outputPath when enabled.
+func (d *Downloader) verify(path, outputPath string, totalSize int64) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	size := fi.Size()
+	switch {
+	case size == 0:
+		return fmt.Errorf("empty download: 0 bytes written")
+	case totalSize > 0 && size != totalSize:
+		return fmt.Errorf("%w: wrote %d bytes, server reported %d", ErrIntegrity, size, totalSize)
+	case d.expectedSize > 0 && size != d.expectedSize:
+		return fmt.Errorf("%w: wrote %d bytes, expected %d", ErrIntegrity, size, d.expectedSize)
+	}
+	if d.container != "" {
+		if err := CheckContainer(path, d.container); err != nil {
+			return err
+		}
+	}
+	if d.checksum {
+		sum, err := WriteChecksum(path, outputPath)
+		if err != nil {
+			return fmt.Errorf("failed to write checksum: %v", err)
+		}
+		d.log().Info("Checksum written", slog.String("sha256", sum))
+	}
+	return nil
+}
+
+// WriteChecksum computes the SHA-256 of the file at path and writes it to
+// "<outputPath>.sha256" as "<hex>  <base name of outputPath>", the format
+// read by sha256sum -c. path and outputPath differ when the file is hashed
+// before being moved to outputPath. It returns the hex digest.
+func WriteChecksum(path, outputPath string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	line := sum + "  " + filepath.Base(outputPath) + "\n"
+	if err := os.WriteFile(outputPath+checksumSuffix, []byte(line), 0644); err != nil {
+		return "", err
+	}
+	return sum, nil
+}
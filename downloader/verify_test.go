@@ -0,0 +1,188 @@
+package downloader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDownloadExpectedSize(t *testing.T) {
+	data := testData(3 << 20)
+	server := makeServer(data)
+	defer server.Close()
+
+	for _, connections := range []int{1, 4} {
+		out := filepath.Join(t.TempDir(), "file.bin")
+		err := New(server.Client(), nil, 0).WithConnections(connections).WithExpectedSize(int64(len(data))+1).Download(context.Background(), server.URL, out)
+		if !errors.Is(err, ErrIntegrity) {
+			t.Fatalf("connections=%d: error = %v, want ErrIntegrity", connections, err)
+		}
+		if _, err := os.Stat(out + temporaryFileSuffix); !os.IsNotExist(err) {
+			t.Errorf("connections=%d: temporary file created: %v", connections, err)
+		}
+	}
+}
+
+// TestDownloadRangeIgnoredMidway covers a server that honours Range for the
+// first chunk only and then sends the whole file with 200.
+func TestDownloadRangeIgnoredMidway(t *testing.T) {
+	data := testData(2<<20 + 7)
+	ranged := makeServer(data)
+	defer ranged.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.Header.Get("Range"), "bytes=0-") {
+			ranged.Config.Handler.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+
+	out := filepath.Join(t.TempDir(), "file.bin")
+	if err := New(server.Client(), nil, 0).Download(context.Background(), server.URL, out); err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil || string(got) != string(data) {
+		t.Fatalf("content mismatch: err=%v got=%d want=%d", err, len(got), len(data))
+	}
+}
+
+func TestDownloadWrongContentRange(t *testing.T) {
+	data := testData(2 << 20)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a, b int
+		_, _ = fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &a, &b)
+		// Always answers with the start of the file.
+		n := b - a + 1
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", n-1, len(data)))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(data[:n])
+	}))
+	defer server.Close()
+
+	for _, connections := range []int{1, 4} {
+		out := filepath.Join(t.TempDir(), "file.bin")
+		err := New(server.Client(), nil, 0).WithConnections(connections).Download(context.Background(), server.URL, out)
+		if !errors.Is(err, ErrIntegrity) {
+			t.Fatalf("connections=%d: error = %v, want ErrIntegrity", connections, err)
+		}
+		if _, err := os.Stat(out); !os.IsNotExist(err) {
+			t.Errorf("connections=%d: output written: %v", connections, err)
+		}
+	}
+}
+
+func TestDownloadTruncatedServer(t *testing.T) {
+	data := testData(2<<20 + 7)
+	claimed := len(data) + 1<<20
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a, b int
+		_, _ = fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &a, &b)
+		// Claims more bytes than it has and sends empty ranges past the end.
+		a, b = min(a, len(data)), min(b+1, len(data))
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", a, b-1, claimed))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(data[a:b])
+	}))
+	defer server.Close()
+
+	out := filepath.Join(t.TempDir(), "file.bin")
+	if err := New(server.Client(), nil, 0).Download(context.Background(), server.URL, out); err == nil {
+		t.Fatal("expected an error for a truncated file")
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("output written: %v", err)
+	}
+}
+
+func TestDownloadUnknownSize(t *testing.T) {
+	data := testData(2<<20 + 7)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a, b int
+		_, _ = fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &a, &b)
+		a, b = min(a, len(data)), min(b+1, len(data))
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/*", a, b-1))
+		w.WriteHeader(http.StatusPartialContent)
+		w.(http.Flusher).Flush() // no Content-Length
+		if r.Method != http.MethodHead {
+			_, _ = w.Write(data[a:b])
+		}
+	}))
+	defer server.Close()
+
+	out := filepath.Join(t.TempDir(), "file.bin")
+	if err := New(server.Client(), nil, 0).Download(context.Background(), server.URL, out); err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil || string(got) != string(data) {
+		t.Fatalf("content mismatch: err=%v got=%d want=%d", err, len(got), len(data))
+	}
+}
+
+func TestDownloadContainerCheck(t *testing.T) {
+	mp4 := testMP4()
+	good := makeServer(mp4)
+	defer good.Close()
+	bad := makeServer([]byte("<html>not found</html>"))
+	defer bad.Close()
+
+	out := filepath.Join(t.TempDir(), "file.mp4")
+	if err := New(good.Client(), nil, 0).WithContainerCheck(ContainerMP4).Download(context.Background(), good.URL, out); err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	out = filepath.Join(t.TempDir(), "file.mp4")
+	err := New(bad.Client(), nil, 0).WithContainerCheck(ContainerMP4).Download(context.Background(), bad.URL, out)
+	if !errors.Is(err, ErrIntegrity) {
+		t.Fatalf("error = %v, want ErrIntegrity", err)
+	}
+	for _, p := range []string{out, out + temporaryFileSuffix, out + resumeStateSuffix} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s left behind: %v", p, err)
+		}
+	}
+}
+
+func TestDownloadChecksum(t *testing.T) {
+	data := testData(3<<20 + 5)
+	server := makeServer(data)
+	defer server.Close()
+
+	for _, connections := range []int{1, 3} {
+		out := filepath.Join(t.TempDir(), "file.bin")
+		if err := New(server.Client(), nil, 0).WithConnections(connections).WithChecksum(true).Download(context.Background(), server.URL, out); err != nil {
+			t.Fatalf("connections=%d: download failed: %v", connections, err)
+		}
+		sum := sha256.Sum256(data)
+		want := hex.EncodeToString(sum[:]) + "  file.bin\n"
+		got, err := os.ReadFile(out + checksumSuffix)
+		if err != nil || string(got) != want {
+			t.Errorf("connections=%d: checksum sidecar = %q, %v; want %q", connections, got, err, want)
+		}
+	}
+}
+
+func TestDownloadToExpectedSize(t *testing.T) {
+	data := testData(1<<20 + 3)
+	server := makeServer(data)
+	defer server.Close()
+
+	var w strings.Builder
+	err := New(server.Client(), nil, 0).WithExpectedSize(10).DownloadTo(context.Background(), server.URL, &w)
+	if !errors.Is(err, ErrIntegrity) {
+		t.Fatalf("error = %v, want ErrIntegrity", err)
+	}
+	if w.Len() != 0 {
+		t.Errorf("wrote %d bytes before failing", w.Len())
+	}
+}
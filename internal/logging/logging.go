@@ -0,0 +1,122 @@
+// Package logging holds the helpers shared by packages that accept a
+// *slog.Logger: the fallback logger, common attribute keys and redaction of
+// secrets in URLs and headers.
+package logging
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Attribute keys used across packages.
+const (
+	KeyVideoID = "video_id"
+	KeyItag    = "itag"
+	KeyStage   = "stage"
+	KeyURL     = "url"
+	KeyStatus  = "status"
+	KeyError   = "error"
+)
+
+// Stages used with KeyStage.
+const (
+	StageInnertube   = "innertube"
+	StageResolve     = "resolve"
+	StageDecipher    = "decipher"
+	StageDownload    = "download"
+	StageRemux       = "remux"
+	StagePostProcess = "postprocess"
+	StageHTTP        = "http"
+)
+
+// Redacted replaces secret values in logged URLs and headers.
+const Redacted = "REDACTED"
+
+// secretParams are query parameters that carry signatures or keys.
+var secretParams = []string{"sig", "signature", "lsig", "s", "key", "pot"}
+
+// textURLRe matches the URLs embedded in a message, such as the quoted URL
+// of a *url.Error.
+var textURLRe = regexp.MustCompile(`https?://[^\s"'<>]+`)
+
+// secretHeaders are request and response headers that carry credentials.
+var secretHeaders = map[string]bool{
+	"Authorization":           true,
+	"Cookie":                  true,
+	"Set-Cookie":              true,
+	"X-Goog-Visitor-Id":       true,
+	"X-Goog-Ext-123-Botguard": true,
+}
+
+// Or returns l, or slog.Default() when l is nil.
+func Or(l *slog.Logger) *slog.Logger {
+	if l != nil {
+		return l
+	}
+	return slog.Default()
+}
+
+// RedactURL returns rawURL with the values of signature and key query
+// parameters replaced by Redacted.
+func RedactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "[invalid URL]"
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = RedactQuery(u.RawQuery)
+	}
+	return u.String()
+}
+
+// RedactQuery is RedactURL for a bare query string, such as a
+// signatureCipher value.
+func RedactQuery(rawQuery string) string {
+	q, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "[invalid query]"
+	}
+	changed := false
+	for _, name := range secretParams {
+		if _, ok := q[name]; ok {
+			q.Set(name, Redacted)
+			changed = true
+		}
+	}
+	if !changed {
+		return rawQuery
+	}
+	return q.Encode()
+}
+
+// URL returns a KeyURL attribute holding the redacted rawURL.
+func URL(rawURL string) slog.Attr {
+	return slog.String(KeyURL, RedactURL(rawURL))
+}
+
+// Err returns a KeyError attribute for err, with the URLs in its message
+// redacted: a failed request's *url.Error, however wrapped, carries the full
+// signed URL.
+func Err(err error) slog.Attr {
+	if err == nil {
+		return slog.String(KeyError, "")
+	}
+	return slog.String(KeyError, textURLRe.ReplaceAllStringFunc(err.Error(), RedactURL))
+}
+
+// Headers returns h as a group attribute named key, with credentials
+// redacted.
+func Headers(key string, h http.Header) slog.Attr {
+	attrs := make([]any, 0, len(h))
+	for name, values := range h {
+		v := strings.Join(values, ", ")
+		if secretHeaders[http.CanonicalHeaderKey(name)] {
+			v = Redacted
+		}
+		attrs = append(attrs, slog.String(name, v))
+	}
+	return slog.Group(key, attrs...)
+}
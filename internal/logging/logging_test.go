@@ -0,0 +1,82 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRedactURL(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"https://rr1.googlevideo.com/videoplayback?itag=18&sig=AbC&n=xyz", "https://rr1.googlevideo.com/videoplayback?itag=18&n=xyz&sig=REDACTED"},
+		{"https://www.youtube.com/youtubei/v1/player?key=AIza", "https://www.youtube.com/youtubei/v1/player?key=REDACTED"},
+		{"https://example.com/a?lsig=1&signature=2", "https://example.com/a?lsig=REDACTED&signature=REDACTED"},
+		{"https://example.com/plain?itag=18", "https://example.com/plain?itag=18"},
+		{"https://example.com/path", "https://example.com/path"},
+		{"://bad", "[invalid URL]"},
+	}
+	for _, tt := range tests {
+		if got := RedactURL(tt.in); got != tt.want {
+			t.Errorf("RedactURL(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRedactQuery(t *testing.T) {
+	got := RedactQuery("s=SECRET&sp=sig&url=https%3A%2F%2Fexample.com%2Fv")
+	if strings.Contains(got, "SECRET") || !strings.Contains(got, "s=REDACTED") || !strings.Contains(got, "sp=sig") {
+		t.Errorf("RedactQuery = %q", got)
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	var buf bytes.Buffer
+	l := slog.New(slog.NewTextHandler(&buf, nil))
+	h := http.Header{}
+	h.Set("Content-Length", "42")
+	h.Set("Cookie", "SID=secret")
+	l.Info("response", Headers("headers", h))
+	out := buf.String()
+	if strings.Contains(out, "secret") || !strings.Contains(out, "headers.Cookie=REDACTED") || !strings.Contains(out, "headers.Content-Length=42") {
+		t.Errorf("unexpected log line %q", out)
+	}
+}
+
+func TestErr(t *testing.T) {
+	urlErr := &url.Error{
+		Op:  "Get",
+		URL: "https://rr1.googlevideo.com/videoplayback?itag=18&sig=SIGSECRET&lsig=LSIGSECRET&pot=POTSECRET&range=0-99",
+		Err: errors.New("connection reset by peer"),
+	}
+	for _, err := range []error{
+		fmt.Errorf("range request failed: %w", urlErr),
+		fmt.Errorf("download range 0-99 failed: %v", urlErr),
+	} {
+		var buf bytes.Buffer
+		slog.New(slog.NewTextHandler(&buf, nil)).Warn("Range request failed", Err(err))
+		out := buf.String()
+		if strings.Contains(out, "SECRET") || !strings.Contains(out, "sig=REDACTED") || !strings.Contains(out, "connection reset by peer") {
+			t.Errorf("unexpected log line %q", out)
+		}
+	}
+	if got := Err(nil).Value.String(); got != "" {
+		t.Errorf("Err(nil) = %q", got)
+	}
+}
+
+func TestOr(t *testing.T) {
+	if Or(nil) != slog.Default() {
+		t.Error("Or(nil) should return slog.Default()")
+	}
+	l := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	if Or(l) != l {
+		t.Error("Or(l) should return l")
+	}
+}
@@ -12,6 +12,13 @@ const (
 	ExtM4A = "m4a"
 	// ExtWebM is the file extension for WebM media.
 	ExtWebM = "webm"
+	// ExtWebA is the file extension for audio-only WebM media. Many audio
+	// players refuse ".webm" files without a video track.
+	ExtWebA = "weba"
+	// ExtMKV is the file extension for Matroska media.
+	ExtMKV = "mkv"
+	// ExtOpus is the file extension for Opus audio in an Ogg container.
+	ExtOpus = "opus"
 
 	// MimeVideoMP4 is the MIME type for MP4 video.
 	MimeVideoMP4 = "video/mp4"
@@ -39,8 +46,10 @@ func ExtFromMime(mime string) string {
 		return DefaultExt
 	case MimeAudioMP4:
 		return ExtM4A
-	case MimeVideoWebM, MimeAudioWebM:
+	case MimeVideoWebM:
 		return ExtWebM
+	case MimeAudioWebM:
+		return ExtWebA
 	}
 	// Try subtype
 	parts := strings.Split(base, "/")
@@ -7,7 +7,7 @@ func TestExtFromMime(t *testing.T) {
 		"video/mp4":                  "mp4",
 		"audio/mp4":                  "m4a",
 		"video/webm":                 "webm",
-		"audio/webm":                 "webm",
+		"audio/webm":                 "weba",
 		"video/unknown":              "unknown",
 		"":                           "mp4",
 		"video/mp4; codecs=\"avc1\"": "mp4",
@@ -0,0 +1,215 @@
+// Package mp4 reads the tracks of ISO base media (MP4) files, fragmented as
+// served for DASH or not, and muxes them into a single non-fragmented file.
+package mp4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// maxBoxSize bounds the boxes read into memory: everything but mdat.
+const maxBoxSize = 64 << 20
+
+// ErrInvalid is returned for input that is not a well-formed MP4 file.
+var ErrInvalid = errors.New("mp4: invalid file")
+
+// containers are the box types whose payload is parsed into children.
+var containers = map[string]bool{
+	"moov": true, "trak": true, "edts": true, "mdia": true, "minf": true,
+	"dinf": true, "stbl": true, "mvex": true, "moof": true, "traf": true,
+}
+
+// box is a parsed box. raw is the whole box including its header, data its
+// payload.
+type box struct {
+	typ      string
+	raw      []byte
+	data     []byte
+	children []box
+}
+
+// child returns the first child of the given type, descending through path.
+func (b *box) child(path ...string) *box {
+	cur := b
+	for _, typ := range path {
+		var next *box
+		for i := range cur.children {
+			if cur.children[i].typ == typ {
+				next = &cur.children[i]
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		cur = next
+	}
+	return cur
+}
+
+// all returns the children of the given type.
+func (b *box) all(typ string) []*box {
+	var out []*box
+	for i := range b.children {
+		if b.children[i].typ == typ {
+			out = append(out, &b.children[i])
+		}
+	}
+	return out
+}
+
+// parseBoxes splits p into boxes, descending into container boxes.
+func parseBoxes(p []byte) ([]box, error) {
+	var out []box
+	for len(p) > 0 {
+		size, hdr, typ, err := boxHeader(p)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			size = uint64(len(p))
+		}
+		if size < uint64(hdr) || size > uint64(len(p)) {
+			return nil, fmt.Errorf("%w: box %q of %d bytes overruns its parent", ErrInvalid, typ, size)
+		}
+		b := box{typ: typ, raw: p[:size], data: p[hdr:size]}
+		if containers[typ] {
+			if b.children, err = parseBoxes(b.data); err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, b)
+		p = p[size:]
+	}
+	return out, nil
+}
+
+// boxHeader decodes the header at the start of p. A size of 0 means the box
+// extends to the end of its parent.
+func boxHeader(p []byte) (size uint64, hdr int, typ string, err error) {
+	if len(p) < 8 {
+		return 0, 0, "", fmt.Errorf("%w: truncated box header", ErrInvalid)
+	}
+	size, hdr, typ = uint64(binary.BigEndian.Uint32(p)), 8, string(p[4:8])
+	if size == 1 {
+		if len(p) < 16 {
+			return 0, 0, "", fmt.Errorf("%w: truncated box header", ErrInvalid)
+		}
+		size, hdr = binary.BigEndian.Uint64(p[8:]), 16
+	}
+	return size, hdr, typ, nil
+}
+
+// walkTop calls fn for every top-level box of the size-byte file in r. The
+// payload of mdat is not read; fn gets it as nil with the box offset.
+func walkTop(r io.ReaderAt, size int64, fn func(b *box, off int64) error) error {
+	var hdr [16]byte
+	for off := int64(0); off < size; {
+		n, err := r.ReadAt(hdr[:min(int64(len(hdr)), size-off)], off)
+		if n < 8 {
+			if err == nil || err == io.EOF {
+				err = fmt.Errorf("%w: truncated box header at offset %d", ErrInvalid, off)
+			}
+			return err
+		}
+		boxSize, hdrLen, typ, err := boxHeader(hdr[:n])
+		if err != nil {
+			return err
+		}
+		if boxSize == 0 {
+			boxSize = uint64(size - off)
+		}
+		if boxSize < uint64(hdrLen) || boxSize > uint64(size-off) {
+			return fmt.Errorf("%w: box %q at offset %d overruns the file", ErrInvalid, typ, off)
+		}
+		b := &box{typ: typ}
+		if typ == "moov" || typ == "moof" {
+			if boxSize > maxBoxSize {
+				return fmt.Errorf("%w: %q box of %d bytes is too large", ErrInvalid, typ, boxSize)
+			}
+			b.raw = make([]byte, boxSize)
+			if _, err := r.ReadAt(b.raw, off); err != nil {
+				return err
+			}
+			b.data = b.raw[hdrLen:]
+			if b.children, err = parseBoxes(b.data); err != nil {
+				return err
+			}
+		}
+		if err := fn(b, off); err != nil {
+			return err
+		}
+		off += int64(boxSize)
+	}
+	return nil
+}
+
+// parser reads big-endian fields from a box payload. Reading past the end
+// sets a sticky error and yields zeros.
+type parser struct {
+	p   []byte
+	bad bool
+}
+
+func (r *parser) take(n int) []byte {
+	if r.bad || len(r.p) < n {
+		r.bad = true
+		return make([]byte, n)
+	}
+	b := r.p[:n]
+	r.p = r.p[n:]
+	return b
+}
+
+func (r *parser) u16() uint16 { return binary.BigEndian.Uint16(r.take(2)) }
+func (r *parser) u32() uint32 { return binary.BigEndian.Uint32(r.take(4)) }
+func (r *parser) u64() uint64 { return binary.BigEndian.Uint64(r.take(8)) }
+func (r *parser) skip(n int)  { r.take(n) }
+
+// fullHeader reads the version and flags of a full box.
+func (r *parser) fullHeader() (uint8, uint32) {
+	v := r.u32()
+	return uint8(v >> 24), v & 0xFFFFFF
+}
+
+// count reads an entry count and checks that that many entries of
+// entrySize bytes fit in the rest of the payload.
+func (r *parser) count(entrySize int) int {
+	n := r.u32()
+	if r.bad || uint64(n)*uint64(entrySize) > uint64(len(r.p)) {
+		r.bad = true
+		return 0
+	}
+	return int(n)
+}
+
+// err returns ErrInvalid naming the box when a read ran past the end.
+func (r *parser) err(typ string) error {
+	if r.bad {
+		return fmt.Errorf("%w: truncated %q box", ErrInvalid, typ)
+	}
+	return nil
+}
+
+// mkbox encodes a box from its type and payload parts.
+func mkbox(typ string, payload ...[]byte) []byte {
+	n := 8
+	for _, p := range payload {
+		n += len(p)
+	}
+	b := make([]byte, 0, n)
+	b = binary.BigEndian.AppendUint32(b, uint32(n))
+	b = append(b, typ...)
+	for _, p := range payload {
+		b = append(b, p...)
+	}
+	return b
+}
+
+// fullbox encodes a full box, which starts with a version and flags.
+func fullbox(typ string, version uint8, flags uint32, payload ...[]byte) []byte {
+	vf := binary.BigEndian.AppendUint32(nil, uint32(version)<<24|flags&0xFFFFFF)
+	return mkbox(typ, append([][]byte{vf}, payload...)...)
+}
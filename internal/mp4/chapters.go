@@ -0,0 +1,225 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"time"
+	"unicode/utf8"
+)
+
+// Chapter is a chapter written by WriteMetadata. It lasts until the start
+// of the next one, or the end of the movie.
+type Chapter struct {
+	Start time.Duration
+	Title string
+}
+
+// maxNeroChapters bounds the chapters of a chpl box, whose count is a byte.
+const maxNeroChapters = 255
+
+// chplBox encodes a Nero chapter list: start times in units of 100 ns and
+// titles of at most 255 bytes.
+func chplBox(chapters []Chapter) []byte {
+	chapters = chapters[:min(len(chapters), maxNeroChapters)]
+	p := []byte{0, 0, 0, 0, byte(len(chapters))} // reserved, count
+	for _, c := range chapters {
+		title := truncateUTF8(c.Title, 255)
+		p = binary.BigEndian.AppendUint64(p, uint64(c.Start/100))
+		p = append(p, byte(len(title)))
+		p = append(p, title...)
+	}
+	return fullbox("chpl", 1, 0, p)
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// chapterTrack is a QuickTime chapter track: a disabled text track whose
+// samples are the chapter titles, referenced from the other tracks by a
+// tref/chap box. Its sample data is stored in an mdat of its own.
+type chapterTrack struct {
+	t        *Track
+	id       uint32
+	duration uint64 // in the movie timescale
+	data     []byte // mdat payload
+	// replaces are the IDs of chapter tracks the file already had.
+	replaces map[uint32]bool
+}
+
+// newChapterTrack builds the chapter track of moov, or returns nil when its
+// movie header gives no duration to fit the chapters in.
+func newChapterTrack(moov *box, chapters []Chapter) *chapterTrack {
+	mvhd := moov.child("mvhd")
+	if mvhd == nil || len(mvhd.data) < 4 {
+		return nil
+	}
+	p := parser{p: mvhd.data}
+	var timescale uint32
+	var duration uint64
+	if v, _ := p.fullHeader(); v == 1 {
+		p.skip(16)
+		timescale, duration = p.u32(), p.u64()
+	} else {
+		p.skip(8)
+		timescale, duration = p.u32(), uint64(p.u32())
+	}
+	if p.err("mvhd") != nil || timescale == 0 || duration == 0 {
+		return nil
+	}
+	total := duration * 1000 / uint64(timescale) // milliseconds
+	if total == 0 {
+		return nil
+	}
+
+	ct := &chapterTrack{
+		t: &Track{
+			Handler:   "text",
+			Timescale: 1000,
+			language:  0x55C4, // "und"
+			stsd:      fullbox("stsd", 0, 0, u32(1), tx3gEntry()),
+			disabled:  true,
+		},
+		id:       binary.BigEndian.Uint32(mvhd.data[len(mvhd.data)-4:]),
+		duration: duration,
+		replaces: map[uint32]bool{},
+	}
+	// The first sample starts the movie, whatever the first chapter's start.
+	var starts []uint64
+	var titles []string
+	for _, c := range chapters {
+		start := uint64(max(c.Start, 0) / time.Millisecond)
+		if len(starts) == 0 {
+			start = 0
+		} else if start <= starts[len(starts)-1] || start >= total {
+			continue
+		}
+		starts = append(starts, start)
+		titles = append(titles, c.Title)
+	}
+	if len(starts) == 0 {
+		return nil
+	}
+	for i, start := range starts {
+		end := total
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		title := truncateUTF8(titles[i], 0xFFFF)
+		sample := binary.BigEndian.AppendUint16(nil, uint16(len(title)))
+		sample = append(sample, title...)
+		sample = append(sample, mkbox("encd", u32(0x100))...) // UTF-8
+		ct.t.Samples = append(ct.t.Samples, Sample{Size: uint32(len(sample)), Duration: uint32(end - start), Sync: true})
+		ct.data = append(ct.data, sample...)
+	}
+
+	for _, trak := range moov.all("trak") {
+		for _, id := range chapterRefs(trak) {
+			ct.replaces[id] = true
+		}
+	}
+	for _, trak := range moov.all("trak") {
+		if id := trackID(trak); id >= ct.id && !ct.replaces[id] {
+			ct.id = id + 1
+		}
+	}
+	return ct
+}
+
+// trak builds the trak box of the chapter track, with its samples at
+// offset.
+func (ct *chapterTrack) trak(offset int64) []byte {
+	return track(ct.t, ct.id, ct.duration, sampleTable(ct.t, []int64{offset}, []int{len(ct.t.Samples)}, true))
+}
+
+// tx3gEntry is the 3GPP timed text sample entry of chapter tracks, with the
+// default style that players ignore for chapters anyway.
+func tx3gEntry() []byte {
+	p := make([]byte, 6)                                       // reserved
+	p = binary.BigEndian.AppendUint16(p, 1)                    // data_reference_index
+	p = binary.BigEndian.AppendUint32(p, 0)                    // display flags
+	p = append(p, 1, 0xFF)                                     // horizontal and vertical justification
+	p = append(p, 0, 0, 0, 0)                                  // background color
+	p = append(p, make([]byte, 8)...)                          // text box
+	p = append(p, 0, 0, 0, 0, 0, 1, 0, 18, 255, 255, 255, 255) // style: font 1, 18 pt, white
+	p = append(p, mkbox("ftab", []byte{0, 1, 0, 1, 5}, []byte("Serif"))...)
+	return mkbox("tx3g", p)
+}
+
+// chapterRefs returns the track IDs in the tref/chap box of trak.
+func chapterRefs(trak *box) []uint32 {
+	tref := trak.child("tref")
+	if tref == nil {
+		return nil
+	}
+	refs, err := parseBoxes(tref.data)
+	if err != nil {
+		return nil
+	}
+	var ids []uint32
+	for _, r := range refs {
+		if r.typ != "chap" {
+			continue
+		}
+		for p := r.data; len(p) >= 4; p = p[4:] {
+			ids = append(ids, binary.BigEndian.Uint32(p))
+		}
+	}
+	return ids
+}
+
+// trackID returns the track ID in the tkhd box of trak, or 0.
+func trackID(trak *box) uint32 {
+	tkhd := trak.child("tkhd")
+	if tkhd == nil {
+		return 0
+	}
+	p := parser{p: tkhd.data}
+	if v, _ := p.fullHeader(); v == 1 {
+		p.skip(16)
+	} else {
+		p.skip(8)
+	}
+	return p.u32()
+}
+
+// handlerType returns the handler type of trak, e.g. "vide".
+func handlerType(trak *box) string {
+	hdlr := trak.child("mdia", "hdlr")
+	if hdlr == nil || len(hdlr.data) < 12 {
+		return ""
+	}
+	return string(hdlr.data[8:12])
+}
+
+// withChapterRef encodes trak with a tref/chap box that points at the
+// chapter track id instead of any previous chapter reference.
+func withChapterRef(trak *box, id uint32) []byte {
+	refs := [][]byte{mkbox("chap", u32(id))}
+	if tref := trak.child("tref"); tref != nil {
+		if children, err := parseBoxes(tref.data); err == nil {
+			for _, r := range children {
+				if r.typ != "chap" {
+					refs = append(refs, r.raw)
+				}
+			}
+		}
+	}
+	var parts [][]byte
+	for _, c := range trak.children {
+		switch c.typ {
+		case "tref":
+		case "tkhd":
+			parts = append(parts, c.raw, mkbox("tref", refs...))
+		default:
+			parts = append(parts, c.raw)
+		}
+	}
+	return mkbox("trak", parts...)
+}
@@ -0,0 +1,94 @@
+package mp4
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Cut returns the parts of tracks that play from start to end, ready to be
+// muxed on their own. The cut points are moved back to the last sync sample
+// at or before them in the first video track (or the first track), so that
+// the part starts with a keyframe; the other tracks are cut at the same
+// times. The parts share the sample data of tracks. Cut returns nil when no
+// sync sample of the reference track falls in the span, as when it is
+// shorter than the keyframe interval: those samples belong to the part cut
+// at the next sync sample.
+func Cut(tracks []*Track, start, end time.Duration) ([]*Track, error) {
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("mp4: no tracks to cut")
+	}
+	if end <= start {
+		return nil, fmt.Errorf("mp4: empty cut from %v to %v", start, end)
+	}
+	ref := tracks[0]
+	for _, t := range tracks {
+		if t.Handler == "vide" {
+			ref = t
+			break
+		}
+	}
+	first, from := ref.syncSample(start)
+	last, to := ref.syncSample(end)
+	if first == last {
+		return nil, nil
+	}
+	if first == 0 {
+		// The first part takes whatever precedes the first keyframe.
+		from = math.Inf(-1)
+	}
+	var parts []*Track
+	for _, t := range tracks {
+		a, b := first, last
+		if t != ref {
+			a, b = t.sampleAt(from), t.sampleAt(to)
+		}
+		if a == b {
+			continue
+		}
+		part := *t
+		part.Samples = t.Samples[a:b:b]
+		if a > 0 && t != ref {
+			// Only the video keeps its edit, which offsets the composition
+			// times of every part alike.
+			part.mediaTime = 0
+		}
+		parts = append(parts, &part)
+	}
+	return parts, nil
+}
+
+// syncSample returns the index and presentation time, in seconds, of the
+// last sync sample of t presented at or before at. Times at or past the end
+// of t yield the number of samples and +Inf.
+func (t *Track) syncSample(at time.Duration) (int, float64) {
+	target := at.Seconds()
+	index, pts := 0, 0.0
+	var decodeTime int64
+	for i, s := range t.Samples {
+		p := float64(decodeTime+int64(s.CompositionOffset)-t.mediaTime) / float64(t.Timescale)
+		if s.Sync && p <= target && (i == 0 || p > pts) {
+			index, pts = i, p
+		}
+		decodeTime += int64(s.Duration)
+	}
+	if target >= float64(decodeTime-t.mediaTime)/float64(t.Timescale) {
+		return len(t.Samples), math.Inf(1)
+	}
+	return index, pts
+}
+
+// sampleAt returns the index of the first sample of t presented at or after
+// at, in seconds, or the number of samples. Samples are taken to be
+// presented in decoding order, as audio is.
+func (t *Track) sampleAt(at float64) int {
+	decodeTime := -t.mediaTime
+	for i, s := range t.Samples {
+		// Half a tick of slack absorbs rounding between timescales.
+		if (float64(decodeTime)+0.5)/float64(t.Timescale) >= at {
+			return i
+		}
+		decodeTime += int64(s.Duration)
+	}
+	return len(t.Samples)
+}
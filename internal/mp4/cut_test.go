@@ -0,0 +1,68 @@
+package mp4
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestCut(t *testing.T) {
+	// Three seconds of 25 fps video with a keyframe every second, and of
+	// 48 kHz AAC.
+	video := testSamples('v', 75)
+	audio := testSamples('a', 141)
+	vt := readTracks(t, testFile("vide", "avc1", 12800, 512, video))[0]
+	at := readTracks(t, testFile("soun", "mp4a", 48000, 1024, audio))[0]
+	for i := range vt.Samples {
+		vt.Samples[i].Sync = i%25 == 0
+	}
+	at.mediaTime = 1024
+
+	for _, tc := range []struct {
+		start, end    time.Duration
+		video, audio  [2]int
+		audioEditKept bool
+	}{
+		{0, 1500 * time.Millisecond, [2]int{0, 25}, [2]int{0, 48}, true},
+		{1500 * time.Millisecond, 2 * time.Second, [2]int{25, 50}, [2]int{48, 95}, false},
+		{2 * time.Second, time.Hour, [2]int{50, 75}, [2]int{95, 141}, false},
+	} {
+		parts, err := Cut([]*Track{at, vt}, tc.start, tc.end)
+		if err != nil {
+			t.Fatalf("Cut(%v, %v) error = %v", tc.start, tc.end, err)
+		}
+		if len(parts) != 2 {
+			t.Fatalf("Cut(%v, %v) = %d tracks", tc.start, tc.end, len(parts))
+		}
+		a, v := parts[0], parts[1]
+		if len(v.Samples) != tc.video[1]-tc.video[0] || v.Samples[0] != vt.Samples[tc.video[0]] {
+			t.Errorf("Cut(%v, %v): video has %d samples, want %v", tc.start, tc.end, len(v.Samples), tc.video)
+		}
+		if len(a.Samples) != tc.audio[1]-tc.audio[0] || a.Samples[0] != at.Samples[tc.audio[0]] {
+			t.Errorf("Cut(%v, %v): audio has %d samples, want %v", tc.start, tc.end, len(a.Samples), tc.audio)
+		}
+		if (a.mediaTime != 0) != tc.audioEditKept {
+			t.Errorf("Cut(%v, %v): audio media time = %d", tc.start, tc.end, a.mediaTime)
+		}
+
+		var out bytes.Buffer
+		if err := Mux(&out, parts...); err != nil {
+			t.Fatalf("Mux() error = %v", err)
+		}
+		got := readTracks(t, out.Bytes())
+		for k, s := range got[1].Samples {
+			data, _ := got[1].ReadSample(k)
+			if !bytes.Equal(data, video[tc.video[0]+k]) || s.Sync != (k == 0) {
+				t.Fatalf("Cut(%v, %v): video sample %d = %x", tc.start, tc.end, k, data)
+			}
+		}
+	}
+
+	// A span without a keyframe yields nothing.
+	if parts, err := Cut([]*Track{vt, at}, 1200*time.Millisecond, 1800*time.Millisecond); err != nil || parts != nil {
+		t.Errorf("Cut() within a GOP = %v, %v", parts, err)
+	}
+	if _, err := Cut([]*Track{vt}, time.Second, time.Second); err == nil {
+		t.Error("expected an error for an empty span")
+	}
+}
@@ -0,0 +1,194 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Sizes of the fixed fields of sample entries before their child boxes.
+const (
+	visualEntryFields = 78
+	audioEntryFields  = 28
+)
+
+// sampleEntry returns the first sample entry of the track as a box.
+func (t *Track) sampleEntry() (*box, error) {
+	if len(t.stsd) < 16 {
+		return nil, fmt.Errorf("%w: track %d: truncated stsd box", ErrInvalid, t.ID)
+	}
+	entries, err := parseBoxes(t.stsd[16:])
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%w: track %d has no sample entry", ErrInvalid, t.ID)
+	}
+	return &entries[0], nil
+}
+
+// entryFields returns the fixed fields and the child boxes of the first
+// sample entry.
+func (t *Track) entryFields() ([]byte, []box, error) {
+	entry, err := t.sampleEntry()
+	if err != nil {
+		return nil, nil, err
+	}
+	n := 0
+	switch t.Handler {
+	case "vide":
+		n = visualEntryFields
+	case "soun":
+		n = audioEntryFields
+		// QuickTime sound entries of version 1 and 2 carry more fields.
+		if len(entry.data) >= 10 {
+			switch binary.BigEndian.Uint16(entry.data[8:]) {
+			case 1:
+				n += 16
+			case 2:
+				n += 36
+			}
+		}
+	default:
+		return entry.data, nil, nil
+	}
+	if len(entry.data) < n {
+		return nil, nil, fmt.Errorf("%w: track %d: truncated %q sample entry", ErrInvalid, t.ID, entry.typ)
+	}
+	children, err := parseBoxes(entry.data[n:])
+	if err != nil {
+		return nil, nil, err
+	}
+	return entry.data[:n], children, nil
+}
+
+// VideoSize returns the width and height in pixels from the visual sample
+// entry, or zeros for other tracks.
+func (t *Track) VideoSize() (width, height int) {
+	fields, _, err := t.entryFields()
+	if err != nil || t.Handler != "vide" {
+		return 0, 0
+	}
+	return int(binary.BigEndian.Uint16(fields[24:])), int(binary.BigEndian.Uint16(fields[26:]))
+}
+
+// AudioFormat returns the channel count and sample rate from the audio
+// sample entry, or zeros for other tracks.
+func (t *Track) AudioFormat() (channels int, sampleRate float64) {
+	fields, _, err := t.entryFields()
+	if err != nil || t.Handler != "soun" {
+		return 0, 0
+	}
+	return int(binary.BigEndian.Uint16(fields[16:])), float64(binary.BigEndian.Uint32(fields[24:]) >> 16)
+}
+
+// CodecConfig returns the payload of the box typ ("avcC", "av1C", "vpcC",
+// "esds", ...) in the first sample entry, or nil when there is none.
+func (t *Track) CodecConfig(typ string) []byte {
+	_, children, err := t.entryFields()
+	if err != nil {
+		return nil
+	}
+	for _, c := range children {
+		if c.typ == typ {
+			return c.data
+		}
+	}
+	return nil
+}
+
+// PresentationTimes returns when each sample is shown: its decode time plus
+// composition offset, less the start of the edit list.
+func (t *Track) PresentationTimes() []time.Duration {
+	out := make([]time.Duration, len(t.Samples))
+	var decodeTime int64
+	for i, s := range t.Samples {
+		ticks := decodeTime + int64(s.CompositionOffset) - t.mediaTime
+		out[i] = time.Duration(ticks) * time.Second / time.Duration(t.Timescale)
+		decodeTime += int64(s.Duration)
+	}
+	return out
+}
+
+// ReadSample returns the data of sample i.
+func (t *Track) ReadSample(i int) ([]byte, error) {
+	s := t.Samples[i]
+	b := make([]byte, s.Size)
+	if _, err := t.src.ReadAt(b, s.Offset); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("mp4: read sample %d of track %d: %w", i, t.ID, err)
+	}
+	return b, nil
+}
+
+// DecoderSpecificInfo returns the decoder configuration carried in the
+// payload of an esds box: for AAC, the AudioSpecificConfig.
+func DecoderSpecificInfo(esds []byte) ([]byte, error) {
+	const (
+		tagES              = 0x03
+		tagDecoderConfig   = 0x04
+		tagDecoderSpecific = 0x05
+	)
+	if len(esds) < 4 {
+		return nil, fmt.Errorf("%w: truncated esds box", ErrInvalid)
+	}
+	p := esds[4:] // version and flags
+	for _, tag := range []byte{tagES, tagDecoderConfig, tagDecoderSpecific} {
+		body, err := descriptor(p, tag)
+		if err != nil {
+			return nil, err
+		}
+		switch tag {
+		case tagES:
+			if len(body) < 3 {
+				return nil, fmt.Errorf("%w: truncated ES descriptor", ErrInvalid)
+			}
+			flags := body[2]
+			skip := 3
+			if flags&0x80 != 0 { // streamDependenceFlag
+				skip += 2
+			}
+			if flags&0x40 != 0 && len(body) > skip { // URL_Flag
+				skip += 1 + int(body[skip])
+			}
+			if flags&0x20 != 0 { // OCRstreamFlag
+				skip += 2
+			}
+			if skip > len(body) {
+				return nil, fmt.Errorf("%w: truncated ES descriptor", ErrInvalid)
+			}
+			p = body[skip:]
+		case tagDecoderConfig:
+			if len(body) < 13 {
+				return nil, fmt.Errorf("%w: truncated decoder config descriptor", ErrInvalid)
+			}
+			p = body[13:]
+		default:
+			return body, nil
+		}
+	}
+	return nil, nil
+}
+
+// descriptor returns the body of the MPEG-4 descriptor with the given tag at
+// the start of p. Its length has up to four bytes of seven bits.
+func descriptor(p []byte, tag byte) ([]byte, error) {
+	if len(p) < 2 || p[0] != tag {
+		return nil, fmt.Errorf("%w: missing descriptor 0x%02x", ErrInvalid, tag)
+	}
+	size, i := 0, 1
+	for ; i < len(p) && i <= 4; i++ {
+		size = size<<7 | int(p[i]&0x7F)
+		if p[i]&0x80 == 0 {
+			break
+		}
+	}
+	i++
+	if i > len(p) || size > len(p)-i {
+		return nil, fmt.Errorf("%w: truncated descriptor 0x%02x", ErrInvalid, tag)
+	}
+	return p[i : i+size], nil
+}
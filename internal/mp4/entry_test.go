@@ -0,0 +1,81 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// testTrack returns a track whose only sample entry is entry.
+func testTrack(handler string, entry []byte) *Track {
+	return &Track{ID: 1, Handler: handler, stsd: fullbox("stsd", 0, 0, u32s(1), entry)}
+}
+
+func TestTrackSampleEntry(t *testing.T) {
+	avcC := []byte{1, 0x64, 0, 0x28, 0xFF}
+	video := testTrack("vide", mkbox("avc1", make([]byte, 24),
+		binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(nil, 1920), 1080),
+		make([]byte, visualEntryFields-28), mkbox("avcC", avcC)))
+	if w, h := video.VideoSize(); w != 1920 || h != 1080 {
+		t.Errorf("VideoSize() = %dx%d", w, h)
+	}
+	if got := video.CodecConfig("avcC"); !bytes.Equal(got, avcC) {
+		t.Errorf("CodecConfig(avcC) = %x", got)
+	}
+	if got := video.CodecConfig("esds"); got != nil {
+		t.Errorf("CodecConfig(esds) = %x, want nil", got)
+	}
+	if ch, rate := video.AudioFormat(); ch != 0 || rate != 0 {
+		t.Errorf("AudioFormat() of a video track = %d, %v", ch, rate)
+	}
+
+	asc := []byte{0x12, 0x10}
+	esds := append(u32s(0),
+		0x03, 0x80, 0x80, 0x80, 25, 0, 1, 0, // ES_Descriptor, four-byte length
+		0x04, 17, 0x40, 0x15, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, // DecoderConfigDescriptor
+		0x05, 2, asc[0], asc[1], // DecoderSpecificInfo
+		0x06, 1, 2) // SLConfigDescriptor
+	audio := testTrack("soun", mkbox("mp4a", make([]byte, 8), []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 0, 16, 0, 0, 0, 0},
+		u32s(44100<<16), mkbox("esds", esds)))
+	if ch, rate := audio.AudioFormat(); ch != 2 || rate != 44100 {
+		t.Errorf("AudioFormat() = %d, %v", ch, rate)
+	}
+	got, err := DecoderSpecificInfo(audio.CodecConfig("esds"))
+	if err != nil || !bytes.Equal(got, asc) {
+		t.Errorf("DecoderSpecificInfo() = %x, %v, want %x", got, err, asc)
+	}
+	for name, data := range map[string][]byte{
+		"empty":     nil,
+		"no ES":     append(u32s(0), 0x04, 0),
+		"truncated": esds[:len(esds)-8],
+	} {
+		if _, err := DecoderSpecificInfo(data); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: error = %v, want ErrInvalid", name, err)
+		}
+	}
+}
+
+func TestTrackSamples(t *testing.T) {
+	src := []byte("0123456789")
+	tr := &Track{Timescale: 1000, mediaTime: 20, src: bytes.NewReader(src), Samples: []Sample{
+		{Offset: 0, Size: 4, Duration: 40, CompositionOffset: 20, Sync: true},
+		{Offset: 4, Size: 3, Duration: 40, CompositionOffset: 60},
+		{Offset: 7, Size: 3, Duration: 40, CompositionOffset: 0},
+		{Offset: 8, Size: 3, Duration: 40},
+	}}
+	want := []time.Duration{0, 80 * time.Millisecond, 60 * time.Millisecond, 100 * time.Millisecond}
+	for i, pts := range tr.PresentationTimes() {
+		if pts != want[i] {
+			t.Errorf("presentation time %d = %v, want %v", i, pts, want[i])
+		}
+	}
+	if b, err := tr.ReadSample(1); err != nil || string(b) != "456" {
+		t.Errorf("ReadSample(1) = %q, %v", b, err)
+	}
+	if _, err := tr.ReadSample(3); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("ReadSample(3) error = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
@@ -0,0 +1,281 @@
+package mp4
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Data types of the values of iTunes metadata items.
+const (
+	dataTypeUTF8 = 1
+	dataTypeJPEG = 13
+	dataTypePNG  = 14
+)
+
+// Metadata is the iTunes-style metadata that WriteMetadata stores in the
+// ilst box of a file, with its chapters. Empty fields are left out.
+type Metadata struct {
+	Title  string
+	Artist string
+	// Date is the release date, e.g. "2024-05-31".
+	Date        string
+	Description string
+	Comment     string
+	// Cover is a JPEG or PNG image.
+	Cover []byte
+	// Chapters are written as a Nero chapter list (udta/chpl) and, in
+	// files that are not fragmented, as a QuickTime chapter track.
+	Chapters []Chapter
+}
+
+// topBox is a top-level box of a file: its parsed form (see walkTop) and
+// where it lies.
+type topBox struct {
+	b         *box
+	off, size int64
+}
+
+// WriteMetadata copies the MP4 file src of size bytes to w with meta in
+// moov/udta/meta/ilst, replacing any metadata the file had. The moov box
+// keeps its place; when the media data follows it, the chunk offsets of the
+// sample tables and the absolute base data offsets of fragments are moved
+// by the change in its size. An mfra box is dropped because its offsets
+// would be stale. The samples of a chapter track go in an mdat appended to
+// the file.
+func WriteMetadata(w io.Writer, src io.ReaderAt, size int64, meta Metadata) error {
+	ilst, err := ilstBox(meta)
+	if err != nil {
+		return err
+	}
+	var (
+		boxes []topBox
+		moov  = -1
+	)
+	err = walkTop(src, size, func(b *box, off int64) error {
+		if n := len(boxes); n > 0 {
+			boxes[n-1].size = off - boxes[n-1].off
+		}
+		if b.typ == "moov" && moov < 0 {
+			moov = len(boxes)
+		}
+		boxes = append(boxes, topBox{b: b, off: off})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if moov < 0 {
+		return fmt.Errorf("%w: no moov box", ErrInvalid)
+	}
+	last := &boxes[len(boxes)-1]
+	last.size = size - last.off
+
+	m := boxes[moov]
+	udta := [][]byte{fullbox("meta", 0, 0, fullbox("hdlr", 0, 0, make([]byte, 4), []byte("mdirappl"), make([]byte, 8), []byte{0}), ilst)}
+	var chapters *chapterTrack
+	if len(meta.Chapters) > 0 {
+		udta = append(udta, chplBox(meta.Chapters))
+		// Nothing can follow a last box whose size runs to the end of the
+		// file.
+		var hdr [4]byte
+		if _, err := src.ReadAt(hdr[:], last.off); err != nil {
+			return err
+		}
+		if m.b.child("mvex") == nil && binary.BigEndian.Uint32(hdr[:]) != 0 {
+			chapters = newChapterTrack(m.b, meta.Chapters)
+		}
+	}
+	newMoov := moovWithMeta(m.b, udta, chapters, 0)
+	if delta := int64(len(newMoov)) - m.size; delta != 0 {
+		end := m.off + m.size
+		if err := shiftChunkOffsets(m.b, end, delta); err != nil {
+			return err
+		}
+		for _, t := range boxes[moov+1:] {
+			if t.b.typ == "moof" {
+				shiftBaseDataOffsets(t.b, end, delta)
+			}
+		}
+	}
+	var chapterData []byte
+	if chapters != nil {
+		chapterData = mkbox("mdat", chapters.data)
+		outSize := int64(len(newMoov))
+		for i, t := range boxes {
+			if i != moov && t.b.typ != "mfra" {
+				outSize += t.size
+			}
+		}
+		newMoov = moovWithMeta(m.b, udta, chapters, outSize+8)
+	} else {
+		newMoov = moovWithMeta(m.b, udta, nil, 0)
+	}
+
+	bw := bufio.NewWriterSize(w, copyBufferSize)
+	for i, t := range boxes {
+		var err error
+		switch {
+		case i == moov:
+			_, err = bw.Write(newMoov)
+		case t.b.typ == "mfra":
+		case t.b.raw != nil:
+			_, err = bw.Write(t.b.raw)
+		default:
+			_, err = io.Copy(bw, io.NewSectionReader(src, t.off, t.size))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := bw.Write(chapterData); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// moovWithMeta encodes moov with a udta box holding the given boxes instead
+// of any previous ones of their types. With chapters, the chapter track is
+// added with its samples at chapterOffset, replacing any previous one, and
+// the video and audio tracks refer to it.
+func moovWithMeta(moov *box, udta [][]byte, chapters *chapterTrack, chapterOffset int64) []byte {
+	replaced := map[string]bool{}
+	for _, b := range udta {
+		replaced[string(b[4:8])] = true
+	}
+	var parts, kept [][]byte
+	found := false
+	for _, c := range moov.children {
+		switch {
+		case c.typ == "mvhd" && chapters != nil && len(c.data) >= 4:
+			mvhd := bytes.Clone(c.raw)
+			binary.BigEndian.PutUint32(mvhd[len(mvhd)-4:], chapters.id+1) // next_track_ID
+			parts = append(parts, mvhd)
+		case c.typ == "trak" && chapters != nil:
+			switch id := trackID(&c); {
+			case chapters.replaces[id]:
+			case handlerType(&c) == "vide" || handlerType(&c) == "soun":
+				parts = append(parts, withChapterRef(&c, chapters.id))
+			default:
+				parts = append(parts, c.raw)
+			}
+		case c.typ != "udta":
+			parts = append(parts, c.raw)
+		case !found:
+			found = true
+			// An unreadable udta, e.g. with the zero terminator that
+			// QuickTime writes, is replaced as a whole.
+			if children, err := parseBoxes(c.data); err == nil {
+				for _, u := range children {
+					if !replaced[u.typ] {
+						kept = append(kept, u.raw)
+					}
+				}
+			}
+			parts = append(parts, mkbox("udta", append(kept, udta...)...))
+		}
+	}
+	if !found {
+		parts = append(parts, mkbox("udta", udta...))
+	}
+	if chapters != nil {
+		parts = append(parts, chapters.trak(chapterOffset))
+	}
+	return mkbox("moov", parts...)
+}
+
+// ilstBox encodes the metadata items of meta.
+func ilstBox(meta Metadata) ([]byte, error) {
+	var items [][]byte
+	for _, f := range []struct{ typ, value string }{
+		{"\xa9nam", meta.Title},
+		{"\xa9ART", meta.Artist},
+		{"\xa9day", meta.Date},
+		{"desc", meta.Description},
+		{"\xa9cmt", meta.Comment},
+	} {
+		if f.value != "" {
+			items = append(items, ilstItem(f.typ, dataTypeUTF8, []byte(f.value)))
+		}
+	}
+	if len(meta.Cover) > 0 {
+		var typ uint32
+		switch {
+		case bytes.HasPrefix(meta.Cover, []byte{0xFF, 0xD8, 0xFF}):
+			typ = dataTypeJPEG
+		case bytes.HasPrefix(meta.Cover, []byte("\x89PNG")):
+			typ = dataTypePNG
+		default:
+			return nil, fmt.Errorf("mp4: cover art is neither JPEG nor PNG")
+		}
+		items = append(items, ilstItem("covr", typ, meta.Cover))
+	}
+	return mkbox("ilst", items...), nil
+}
+
+// ilstItem encodes a metadata item with a single value.
+func ilstItem(typ string, dataType uint32, value []byte) []byte {
+	return mkbox(typ, mkbox("data", u32(dataType), u32(0), value))
+}
+
+func u32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+
+// shiftChunkOffsets adds delta to the chunk offsets of every track of moov
+// that point at or after from, in place.
+func shiftChunkOffsets(moov *box, from, delta int64) error {
+	for _, trak := range moov.all("trak") {
+		stbl := trak.child("mdia", "minf", "stbl")
+		if stbl == nil {
+			continue
+		}
+		if stco := stbl.child("stco"); stco != nil {
+			p := stco.data
+			if len(p) < 8 || uint64(len(p)-8) < uint64(binary.BigEndian.Uint32(p[4:]))*4 {
+				return fmt.Errorf("%w: truncated %q box", ErrInvalid, "stco")
+			}
+			for i := range int(binary.BigEndian.Uint32(p[4:])) {
+				e := p[8+4*i:]
+				off := int64(binary.BigEndian.Uint32(e))
+				if off < from {
+					continue
+				}
+				if off+delta > math.MaxUint32 {
+					return fmt.Errorf("mp4: chunk offset %d does not fit in 32 bits after adding metadata", off+delta)
+				}
+				binary.BigEndian.PutUint32(e, uint32(off+delta))
+			}
+		}
+		if co64 := stbl.child("co64"); co64 != nil {
+			p := co64.data
+			if len(p) < 8 || uint64(len(p)-8) < uint64(binary.BigEndian.Uint32(p[4:]))*8 {
+				return fmt.Errorf("%w: truncated %q box", ErrInvalid, "co64")
+			}
+			for i := range int(binary.BigEndian.Uint32(p[4:])) {
+				e := p[8+8*i:]
+				if off := int64(binary.BigEndian.Uint64(e)); off >= from {
+					binary.BigEndian.PutUint64(e, uint64(off+delta))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// shiftBaseDataOffsets adds delta to the absolute base data offsets of the
+// track fragments of moof that point at or after from, in place. Offsets
+// relative to the moof need no change.
+func shiftBaseDataOffsets(moof *box, from, delta int64) {
+	for _, traf := range moof.all("traf") {
+		tfhd := traf.child("tfhd")
+		if tfhd == nil || len(tfhd.data) < 16 || binary.BigEndian.Uint32(tfhd.data)&tfhdBaseDataOffset == 0 {
+			continue
+		}
+		e := tfhd.data[8:]
+		if off := int64(binary.BigEndian.Uint64(e)); off >= from {
+			binary.BigEndian.PutUint64(e, uint64(off+delta))
+		}
+	}
+}
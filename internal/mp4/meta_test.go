@@ -0,0 +1,216 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+// ilstItems returns the values of the metadata items of file by type.
+func ilstItems(t *testing.T, file []byte) map[string][]byte {
+	t.Helper()
+	items := map[string][]byte{}
+	err := walkTop(bytes.NewReader(file), int64(len(file)), func(b *box, _ int64) error {
+		if b.typ != "moov" {
+			return nil
+		}
+		udta := b.child("udta")
+		if udta == nil {
+			t.Fatal("moov has no udta")
+		}
+		children, err := parseBoxes(udta.data)
+		if err != nil || len(children) == 0 || children[0].typ != "meta" {
+			t.Fatalf("udta = %v, %v", children, err)
+		}
+		meta, err := parseBoxes(children[0].data[4:])
+		if err != nil || len(meta) != 2 || meta[0].typ != "hdlr" || meta[1].typ != "ilst" {
+			t.Fatalf("meta = %v, %v", meta, err)
+		}
+		ilst, err := parseBoxes(meta[1].data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range ilst {
+			data := item.data[8:]
+			items[item.typ] = data[8:]
+			items[item.typ+"/type"] = data[:4]
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return items
+}
+
+func TestWriteMetadata(t *testing.T) {
+	video := testSamples('v', 50)
+	audio := testSamples('a', 94)
+	vt := readTracks(t, testFile("vide", "avc1", 12800, 512, video))[0]
+	at := readTracks(t, testFile("soun", "mp4a", 48000, 1024, audio))[0]
+	var muxed bytes.Buffer
+	if err := Mux(&muxed, vt, at); err != nil {
+		t.Fatal(err)
+	}
+	cover := append([]byte{0xFF, 0xD8, 0xFF, 0xE0}, "jpeg"...)
+	meta := Metadata{Title: "Title", Artist: "Channel", Date: "2024-05-31", Comment: "https://youtu.be/x", Cover: cover}
+
+	var out bytes.Buffer
+	if err := WriteMetadata(&out, bytes.NewReader(muxed.Bytes()), int64(muxed.Len()), meta); err != nil {
+		t.Fatalf("WriteMetadata() error = %v", err)
+	}
+	file := out.Bytes()
+	items := ilstItems(t, file)
+	for typ, want := range map[string]string{"\xa9nam": "Title", "\xa9ART": "Channel", "\xa9day": "2024-05-31", "\xa9cmt": "https://youtu.be/x", "covr": string(cover)} {
+		if got := string(items[typ]); got != want {
+			t.Errorf("item %q = %q, want %q", typ, got, want)
+		}
+	}
+	if _, ok := items["desc"]; ok {
+		t.Error("empty description was written")
+	}
+	if typ := binary.BigEndian.Uint32(items["covr/type"]); typ != dataTypeJPEG {
+		t.Errorf("cover data type = %d", typ)
+	}
+
+	// The chunk offsets follow the larger moov box.
+	tracks := readTracks(t, file)
+	for i, want := range [][][]byte{video, audio} {
+		for k, s := range tracks[i].Samples {
+			if data := file[s.Offset : s.Offset+int64(s.Size)]; !bytes.Equal(data, want[k]) {
+				t.Fatalf("track %d sample %d = %x, want %x", i, k, data, want[k])
+			}
+		}
+	}
+
+	// Writing again replaces the metadata.
+	var again bytes.Buffer
+	if err := WriteMetadata(&again, bytes.NewReader(file), int64(len(file)), Metadata{Title: "New"}); err != nil {
+		t.Fatalf("WriteMetadata() again error = %v", err)
+	}
+	items = ilstItems(t, again.Bytes())
+	if len(items) != 2 || string(items["\xa9nam"]) != "New" {
+		t.Errorf("items after rewrite = %q", items)
+	}
+	if n := len(readTracks(t, again.Bytes())[1].Samples); n != len(audio) {
+		t.Errorf("got %d audio samples after rewrite", n)
+	}
+}
+
+func TestWriteMetadataFragmented(t *testing.T) {
+	samples := testSamples('v', 20)
+	file := testFile("vide", "avc1", 12800, 512, samples)
+	var out bytes.Buffer
+	if err := WriteMetadata(&out, bytes.NewReader(file), int64(len(file)), Metadata{Title: "Title"}); err != nil {
+		t.Fatalf("WriteMetadata() error = %v", err)
+	}
+	got := out.Bytes()
+	tr := readTracks(t, got)[0]
+	for k, s := range tr.Samples {
+		if data := got[s.Offset : s.Offset+int64(s.Size)]; !bytes.Equal(data, samples[k]) {
+			t.Fatalf("sample %d = %x, want %x", k, data, samples[k])
+		}
+	}
+}
+
+func TestWriteMetadataChapters(t *testing.T) {
+	video := testSamples('v', 50)
+	audio := testSamples('a', 94)
+	vt := readTracks(t, testFile("vide", "avc1", 12800, 512, video))[0]
+	at := readTracks(t, testFile("soun", "mp4a", 48000, 1024, audio))[0]
+	var muxed bytes.Buffer
+	if err := Mux(&muxed, vt, at); err != nil {
+		t.Fatal(err)
+	}
+	chapters := []Chapter{{0, "Intro"}, {time.Second, "Main"}, {5 * time.Second, "Past the end"}}
+	var out bytes.Buffer
+	if err := WriteMetadata(&out, bytes.NewReader(muxed.Bytes()), int64(muxed.Len()), Metadata{Title: "Title", Chapters: chapters}); err != nil {
+		t.Fatalf("WriteMetadata() error = %v", err)
+	}
+	file := out.Bytes()
+
+	// The Nero list has every chapter.
+	var moov *box
+	_ = walkTop(bytes.NewReader(file), int64(len(file)), func(b *box, _ int64) error {
+		if b.typ == "moov" {
+			moov = b
+		}
+		return nil
+	})
+	udta, _ := parseBoxes(moov.child("udta").data)
+	if len(udta) != 2 || udta[1].typ != "chpl" {
+		t.Fatalf("udta = %v", udta)
+	}
+	p := parser{p: udta[1].data}
+	p.fullHeader()
+	p.skip(4)
+	if n := p.take(1); n[0] != 3 {
+		t.Fatalf("chpl has %d chapters", n[0])
+	}
+	for _, c := range chapters {
+		start, title := p.u64(), p.take(int(p.take(1)[0]))
+		if time.Duration(start)*100 != c.Start || string(title) != c.Title {
+			t.Errorf("chpl entry = %d %q, want %v %q", start, title, c.Start, c.Title)
+		}
+	}
+
+	// The chapter track holds the chapters that start within the movie, of
+	// 2.005 s (the audio track),
+	// and the media tracks refer to it.
+	tracks := readTracks(t, file)
+	if len(tracks) != 3 || tracks[2].Handler != "text" || tracks[2].ID != 3 {
+		t.Fatalf("tracks = %+v", tracks)
+	}
+	text := tracks[2]
+	if len(text.Samples) != 2 || text.Samples[0].Duration != 1000 || text.Samples[1].Duration != 1005 {
+		t.Errorf("chapter samples = %+v", text.Samples)
+	}
+	if s, _ := text.ReadSample(1); !bytes.HasPrefix(s, []byte("\x00\x04Main")) {
+		t.Errorf("second chapter sample = %q", s)
+	}
+	for _, trak := range moov.all("trak")[:2] {
+		if refs := chapterRefs(trak); len(refs) != 1 || refs[0] != 3 {
+			t.Errorf("track %d chapter references = %v", trackID(trak), refs)
+		}
+	}
+	for i, want := range [][][]byte{video, audio} {
+		for k := range tracks[i].Samples {
+			if data, _ := tracks[i].ReadSample(k); !bytes.Equal(data, want[k]) {
+				t.Fatalf("track %d sample %d = %x, want %x", i, k, data, want[k])
+			}
+		}
+	}
+
+	// Writing again replaces the chapter track.
+	var again bytes.Buffer
+	if err := WriteMetadata(&again, bytes.NewReader(file), int64(len(file)), Metadata{Chapters: chapters[:1]}); err != nil {
+		t.Fatalf("WriteMetadata() again error = %v", err)
+	}
+	tracks = readTracks(t, again.Bytes())
+	if len(tracks) != 3 || tracks[2].ID != 4 || len(tracks[2].Samples) != 1 || tracks[2].Samples[0].Duration != 2005 {
+		t.Errorf("tracks after rewrite = %d, last %+v", len(tracks), *tracks[len(tracks)-1])
+	}
+
+	// Fragmented files only get the Nero list.
+	frag := testFile("vide", "avc1", 12800, 512, video)
+	out.Reset()
+	if err := WriteMetadata(&out, bytes.NewReader(frag), int64(len(frag)), Metadata{Chapters: chapters}); err != nil {
+		t.Fatalf("WriteMetadata(fragmented) error = %v", err)
+	}
+	if tracks := readTracks(t, out.Bytes()); len(tracks) != 1 || !bytes.Contains(out.Bytes(), []byte("chpl")) {
+		t.Errorf("fragmented file has %d tracks", len(tracks))
+	}
+}
+
+func TestWriteMetadataInvalid(t *testing.T) {
+	ftyp := mkbox("ftyp", []byte("isom"))
+	if err := WriteMetadata(&bytes.Buffer{}, bytes.NewReader(ftyp), int64(len(ftyp)), Metadata{}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("file without moov: error = %v, want ErrInvalid", err)
+	}
+	file := testFile("vide", "avc1", 12800, 512, testSamples('v', 2))
+	if err := WriteMetadata(&bytes.Buffer{}, bytes.NewReader(file), int64(len(file)), Metadata{Cover: []byte("GIF89a")}); err == nil {
+		t.Error("expected an error for a GIF cover")
+	}
+}
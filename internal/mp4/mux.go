@@ -0,0 +1,375 @@
+package mp4
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+const (
+	// movieTimescale is the timescale of the movie and track headers.
+	movieTimescale = 1000
+	// copyBufferSize is the write buffer for the media data.
+	copyBufferSize = 1 << 20
+)
+
+// identityMatrix is the transformation matrix of mvhd and tkhd.
+var identityMatrix = []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000}
+
+// chunk is a run of consecutive samples of one track written together.
+type chunk struct {
+	track        int
+	first, count int
+	start        float64 // decode time of the first sample, in seconds
+	size         int64
+}
+
+// Mux writes the tracks as one non-fragmented MP4 file: an ftyp, a moov with
+// the complete sample table of every track, then a single mdat. The moov
+// comes before the media data ("faststart") so that players can start before
+// the whole file has arrived, and the tracks are interleaved in chunks of
+// half a second. Sample data is copied from the files the tracks were read
+// from.
+func Mux(w io.Writer, tracks ...*Track) error {
+	if len(tracks) == 0 {
+		return fmt.Errorf("mp4: no tracks to mux")
+	}
+	chunks, err := layout(tracks)
+	if err != nil {
+		return err
+	}
+	var payload int64
+	for _, c := range chunks {
+		payload += c.size
+	}
+	mdatHeader := append(binary.BigEndian.AppendUint32(nil, uint32(payload+8)), "mdat"...)
+	if payload+8 > math.MaxUint32 {
+		// A size of 1 means that a 64-bit size follows the type.
+		mdatHeader = append(binary.BigEndian.AppendUint32(nil, 1), "mdat"...)
+		mdatHeader = binary.BigEndian.AppendUint64(mdatHeader, uint64(payload+16))
+	}
+
+	ftyp := fileType(tracks)
+	// The chunk offset entries have a fixed size, so the movie box can be
+	// measured before the offsets are known. 64-bit offsets are only used
+	// when 32 bits cannot reach the end of the file.
+	co64 := false
+	moov := movie(tracks, chunks, 0, co64)
+	if int64(len(ftyp)+len(moov)+len(mdatHeader))+payload > math.MaxUint32 {
+		co64 = true
+		moov = movie(tracks, chunks, 0, co64)
+	}
+	moov = movie(tracks, chunks, int64(len(ftyp)+len(moov)+len(mdatHeader)), co64)
+
+	bw := bufio.NewWriterSize(w, copyBufferSize)
+	for _, p := range [][]byte{ftyp, moov, mdatHeader} {
+		if _, err := bw.Write(p); err != nil {
+			return err
+		}
+	}
+	for _, c := range chunks {
+		if err := copySamples(bw, tracks[c.track], c.first, c.count); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// layout splits every track into chunks of about half a second and orders
+// the chunks of all tracks by start time.
+func layout(tracks []*Track) ([]chunk, error) {
+	var chunks []chunk
+	for i, t := range tracks {
+		if len(t.Samples) == 0 {
+			return nil, fmt.Errorf("mp4: track %d has no samples", t.ID)
+		}
+		span := uint64(t.Timescale / 2)
+		var decodeTime, chunkTime uint64
+		for k, s := range t.Samples {
+			if k == 0 || decodeTime-chunkTime >= span {
+				chunks = append(chunks, chunk{track: i, first: k, start: float64(decodeTime) / float64(t.Timescale)})
+				chunkTime = decodeTime
+			}
+			c := &chunks[len(chunks)-1]
+			c.count++
+			c.size += int64(s.Size)
+			decodeTime += uint64(s.Duration)
+		}
+	}
+	sort.SliceStable(chunks, func(a, b int) bool { return chunks[a].start < chunks[b].start })
+	return chunks, nil
+}
+
+// copySamples copies count samples of t from first on, reading runs of
+// adjacent samples at once.
+func copySamples(w io.Writer, t *Track, first, count int) error {
+	var start, length int64
+	flush := func() error {
+		if length == 0 {
+			return nil
+		}
+		n, err := io.Copy(w, io.NewSectionReader(t.src, start, length))
+		if err == nil && n < length {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return fmt.Errorf("mp4: copy samples of track %d: %w", t.ID, err)
+		}
+		length = 0
+		return nil
+	}
+	for _, s := range t.Samples[first : first+count] {
+		if length > 0 && s.Offset != start+length {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if length == 0 {
+			start = s.Offset
+		}
+		length += int64(s.Size)
+	}
+	return flush()
+}
+
+// fileType builds the ftyp box, listing the brands of the video codecs.
+func fileType(tracks []*Track) []byte {
+	brands := []string{"isom", "iso2"}
+	for _, t := range tracks {
+		if c := t.Codec(); c == "avc1" || c == "av01" {
+			brands = append(brands, c)
+		}
+	}
+	p := append([]byte("isom"), 0, 0, 2, 0)
+	for _, b := range append(brands, "mp41") {
+		p = append(p, b...)
+	}
+	return mkbox("ftyp", p)
+}
+
+// movie builds the moov box with chunk offsets counted from base, the
+// position of the mdat payload.
+func movie(tracks []*Track, chunks []chunk, base int64, co64 bool) []byte {
+	offsets := make([][]int64, len(tracks))
+	counts := make([][]int, len(tracks))
+	pos := base
+	for _, c := range chunks {
+		offsets[c.track] = append(offsets[c.track], pos)
+		counts[c.track] = append(counts[c.track], c.count)
+		pos += c.size
+	}
+
+	var duration uint64
+	traks := make([][]byte, len(tracks))
+	for i, t := range tracks {
+		d := toMovieTime(t.Duration()-min(uint64(t.mediaTime), t.Duration()), t.Timescale)
+		duration = max(duration, d)
+		traks[i] = track(t, uint32(i+1), d, sampleTable(t, offsets[i], counts[i], co64))
+	}
+
+	var p []byte
+	if duration > math.MaxUint32 {
+		p = make([]byte, 16) // creation and modification time
+		p = binary.BigEndian.AppendUint32(p, movieTimescale)
+		p = binary.BigEndian.AppendUint64(p, duration)
+	} else {
+		p = make([]byte, 8)
+		p = binary.BigEndian.AppendUint32(p, movieTimescale)
+		p = binary.BigEndian.AppendUint32(p, uint32(duration))
+	}
+	p = binary.BigEndian.AppendUint32(p, 0x00010000) // rate 1.0
+	p = binary.BigEndian.AppendUint16(p, 0x0100)     // volume 1.0
+	p = append(p, make([]byte, 10)...)
+	p = appendMatrix(p)
+	p = append(p, make([]byte, 24)...) // pre_defined
+	p = binary.BigEndian.AppendUint32(p, uint32(len(tracks)+1))
+	mvhd := fullbox("mvhd", version(duration), 0, p)
+	return mkbox("moov", append([][]byte{mvhd}, traks...)...)
+}
+
+// track builds the trak box of t with the given ID, presentation duration
+// in the movie timescale and sample table.
+func track(t *Track, id uint32, duration uint64, stbl []byte) []byte {
+	var p []byte
+	v := version(duration)
+	if v == 1 {
+		p = make([]byte, 16)
+	} else {
+		p = make([]byte, 8)
+	}
+	p = binary.BigEndian.AppendUint32(p, id)
+	p = append(p, 0, 0, 0, 0)
+	if v == 1 {
+		p = binary.BigEndian.AppendUint64(p, duration)
+	} else {
+		p = binary.BigEndian.AppendUint32(p, uint32(duration))
+	}
+	p = append(p, make([]byte, 8+2+2)...) // reserved, layer, alternate group
+	if t.Handler == "soun" {
+		p = binary.BigEndian.AppendUint16(p, 0x0100)
+	} else {
+		p = binary.BigEndian.AppendUint16(p, 0)
+	}
+	p = append(p, 0, 0)
+	p = appendMatrix(p)
+	p = binary.BigEndian.AppendUint32(p, t.width)
+	p = binary.BigEndian.AppendUint32(p, t.height)
+	var flags uint32 = 0x000003 // enabled, in movie
+	if t.disabled {
+		flags = 0
+	}
+	parts := [][]byte{fullbox("tkhd", v, flags, p)}
+
+	if t.mediaTime > 0 {
+		e := binary.BigEndian.AppendUint32(nil, 1)
+		e = binary.BigEndian.AppendUint32(e, uint32(min(duration, math.MaxUint32)))
+		e = binary.BigEndian.AppendUint32(e, uint32(min(t.mediaTime, math.MaxInt32)))
+		e = binary.BigEndian.AppendUint32(e, 0x00010000) // rate 1.0
+		parts = append(parts, mkbox("edts", fullbox("elst", 0, 0, e)))
+	}
+
+	mediaDuration := t.Duration()
+	mv := version(mediaDuration)
+	if mv == 1 {
+		p = make([]byte, 16)
+		p = binary.BigEndian.AppendUint32(p, t.Timescale)
+		p = binary.BigEndian.AppendUint64(p, mediaDuration)
+	} else {
+		p = make([]byte, 8)
+		p = binary.BigEndian.AppendUint32(p, t.Timescale)
+		p = binary.BigEndian.AppendUint32(p, uint32(mediaDuration))
+	}
+	p = binary.BigEndian.AppendUint16(p, t.language)
+	p = append(p, 0, 0)
+	mdhd := fullbox("mdhd", mv, 0, p)
+
+	hdlr := t.hdlr
+	if hdlr == nil {
+		hdlr = fullbox("hdlr", 0, 0, make([]byte, 4), []byte(t.Handler), make([]byte, 13))
+	}
+	mediaHeader := t.mediaHeader
+	if mediaHeader == nil {
+		switch t.Handler {
+		case "vide":
+			mediaHeader = fullbox("vmhd", 0, 1, make([]byte, 8))
+		case "soun":
+			mediaHeader = fullbox("smhd", 0, 0, make([]byte, 4))
+		default:
+			mediaHeader = fullbox("nmhd", 0, 0)
+		}
+	}
+	dinf := mkbox("dinf", fullbox("dref", 0, 0, binary.BigEndian.AppendUint32(nil, 1), fullbox("url ", 0, 1)))
+	minf := mkbox("minf", mediaHeader, dinf, stbl)
+	return mkbox("trak", append(parts, mkbox("mdia", mdhd, hdlr, minf))...)
+}
+
+// sampleTable builds the stbl box of t, whose chunks start at offsets and
+// hold counts samples.
+func sampleTable(t *Track, offsets []int64, counts []int, co64 bool) []byte {
+	parts := [][]byte{t.stsd}
+
+	// stts and ctts are run-length encoded.
+	var stts, ctts []byte
+	var sttsN, cttsN uint32
+	var negative, composition bool
+	for i, s := range t.Samples {
+		if i == 0 || s.Duration != t.Samples[i-1].Duration {
+			stts = binary.BigEndian.AppendUint32(stts, 0)
+			stts = binary.BigEndian.AppendUint32(stts, s.Duration)
+			sttsN++
+		}
+		binary.BigEndian.PutUint32(stts[len(stts)-8:], binary.BigEndian.Uint32(stts[len(stts)-8:])+1)
+		if i == 0 || s.CompositionOffset != t.Samples[i-1].CompositionOffset {
+			ctts = binary.BigEndian.AppendUint32(ctts, 0)
+			ctts = binary.BigEndian.AppendUint32(ctts, uint32(s.CompositionOffset))
+			cttsN++
+		}
+		binary.BigEndian.PutUint32(ctts[len(ctts)-8:], binary.BigEndian.Uint32(ctts[len(ctts)-8:])+1)
+		negative = negative || s.CompositionOffset < 0
+		composition = composition || s.CompositionOffset != 0
+	}
+	parts = append(parts, fullbox("stts", 0, 0, binary.BigEndian.AppendUint32(nil, sttsN), stts))
+	if composition {
+		var v uint8
+		if negative {
+			v = 1
+		}
+		parts = append(parts, fullbox("ctts", v, 0, binary.BigEndian.AppendUint32(nil, cttsN), ctts))
+	}
+
+	var stss []byte
+	for i, s := range t.Samples {
+		if s.Sync {
+			stss = binary.BigEndian.AppendUint32(stss, uint32(i+1))
+		}
+	}
+	if len(stss) < 4*len(t.Samples) {
+		parts = append(parts, fullbox("stss", 0, 0, binary.BigEndian.AppendUint32(nil, uint32(len(stss)/4)), stss))
+	}
+
+	var stsc []byte
+	var stscN uint32
+	for i, n := range counts {
+		if i == 0 || n != counts[i-1] {
+			stsc = binary.BigEndian.AppendUint32(stsc, uint32(i+1))
+			stsc = binary.BigEndian.AppendUint32(stsc, uint32(n))
+			stsc = binary.BigEndian.AppendUint32(stsc, 1) // sample_description_index
+			stscN++
+		}
+	}
+	parts = append(parts, fullbox("stsc", 0, 0, binary.BigEndian.AppendUint32(nil, stscN), stsc))
+
+	uniform := t.Samples[0].Size
+	for _, s := range t.Samples {
+		if s.Size != uniform {
+			uniform = 0
+			break
+		}
+	}
+	stsz := binary.BigEndian.AppendUint32(nil, uniform)
+	stsz = binary.BigEndian.AppendUint32(stsz, uint32(len(t.Samples)))
+	if uniform == 0 {
+		for _, s := range t.Samples {
+			stsz = binary.BigEndian.AppendUint32(stsz, s.Size)
+		}
+	}
+	parts = append(parts, fullbox("stsz", 0, 0, stsz))
+
+	stco := binary.BigEndian.AppendUint32(nil, uint32(len(offsets)))
+	for _, off := range offsets {
+		if co64 {
+			stco = binary.BigEndian.AppendUint64(stco, uint64(off))
+		} else {
+			stco = binary.BigEndian.AppendUint32(stco, uint32(off))
+		}
+	}
+	if co64 {
+		parts = append(parts, fullbox("co64", 0, 0, stco))
+	} else {
+		parts = append(parts, fullbox("stco", 0, 0, stco))
+	}
+	return mkbox("stbl", parts...)
+}
+
+// toMovieTime converts d from timescale to the movie timescale, rounding.
+func toMovieTime(d uint64, timescale uint32) uint64 {
+	return (d*movieTimescale + uint64(timescale)/2) / uint64(timescale)
+}
+
+// version returns the full box version needed to store duration.
+func version(duration uint64) uint8 {
+	if duration > math.MaxUint32 {
+		return 1
+	}
+	return 0
+}
+
+func appendMatrix(p []byte) []byte {
+	for _, v := range identityMatrix {
+		p = binary.BigEndian.AppendUint32(p, v)
+	}
+	return p
+}
@@ -0,0 +1,97 @@
+package mp4
+
+import (
+	"bytes"
+	"testing"
+)
+
+func readTracks(t *testing.T, file []byte) []*Track {
+	t.Helper()
+	tracks, err := ReadTracks(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatalf("ReadTracks() error = %v", err)
+	}
+	return tracks
+}
+
+func TestMux(t *testing.T) {
+	// Three seconds of 25 fps video and of 48 kHz AAC.
+	video := testSamples('v', 75)
+	audio := testSamples('a', 141)
+	videoFile := testFile("vide", "avc1", 12800, 512, video)
+	audioFile := testFile("soun", "mp4a", 48000, 1024, audio)
+	vt := readTracks(t, videoFile)[0]
+	at := readTracks(t, audioFile)[0]
+	vt.Samples[3].CompositionOffset = 1024
+	vt.mediaTime = 1024
+
+	var out bytes.Buffer
+	if err := Mux(&out, vt, at); err != nil {
+		t.Fatalf("Mux() error = %v", err)
+	}
+	file := out.Bytes()
+
+	var top []string
+	if err := walkTop(bytes.NewReader(file), int64(len(file)), func(b *box, _ int64) error {
+		top = append(top, b.typ)
+		return nil
+	}); err != nil {
+		t.Fatalf("walk output: %v", err)
+	}
+	if got := top; len(got) != 3 || got[0] != "ftyp" || got[1] != "moov" || got[2] != "mdat" {
+		t.Fatalf("top-level boxes = %v, want [ftyp moov mdat]", got)
+	}
+	if !bytes.Contains(file[:40], []byte("avc1")) {
+		t.Error("ftyp does not list the avc1 brand")
+	}
+
+	tracks := readTracks(t, file)
+	if len(tracks) != 2 {
+		t.Fatalf("got %d tracks, want 2", len(tracks))
+	}
+	for i, want := range [][][]byte{video, audio} {
+		tr := tracks[i]
+		src := []*Track{vt, at}[i]
+		if tr.Handler != src.Handler || tr.Timescale != src.Timescale || tr.Codec() != src.Codec() || tr.language != 0x15C7 {
+			t.Errorf("track %d = %s %s %d lang %x", i, tr.Handler, tr.Codec(), tr.Timescale, tr.language)
+		}
+		if len(tr.Samples) != len(want) {
+			t.Fatalf("track %d: got %d samples, want %d", i, len(tr.Samples), len(want))
+		}
+		for k, s := range tr.Samples {
+			if data := file[s.Offset : s.Offset+int64(s.Size)]; !bytes.Equal(data, want[k]) {
+				t.Fatalf("track %d sample %d = %x, want %x", i, k, data, want[k])
+			}
+			o := src.Samples[k]
+			if s.Duration != o.Duration || s.Sync != o.Sync || s.CompositionOffset != o.CompositionOffset {
+				t.Errorf("track %d sample %d = %+v, want %+v", i, k, s, o)
+			}
+		}
+	}
+	if tracks[0].mediaTime != 1024 || tracks[1].mediaTime != 0 {
+		t.Errorf("edit list media times = %d, %d", tracks[0].mediaTime, tracks[1].mediaTime)
+	}
+	if tracks[0].width != 640<<16 || tracks[0].height != 360<<16 {
+		t.Errorf("video size = %dx%d", tracks[0].width>>16, tracks[0].height>>16)
+	}
+
+	// The tracks are interleaved: audio data starts before the video ends.
+	firstAudio := tracks[1].Samples[0].Offset
+	lastVideo := tracks[0].Samples[len(video)-1].Offset
+	if firstAudio > lastVideo {
+		t.Error("tracks are not interleaved")
+	}
+}
+
+func TestMuxShortSource(t *testing.T) {
+	file := testFile("soun", "mp4a", 48000, 1024, testSamples('a', 4))
+	tr := readTracks(t, file)[0]
+	tr.src = bytes.NewReader(file[:len(file)-2])
+	var out bytes.Buffer
+	if err := Mux(&out, tr); err == nil {
+		t.Fatal("expected an error for a truncated source")
+	}
+	if err := Mux(&out); err == nil {
+		t.Fatal("expected an error without tracks")
+	}
+}
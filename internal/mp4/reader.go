@@ -0,0 +1,523 @@
+package mp4
+
+import (
+	"fmt"
+	"io"
+)
+
+// Flags of the tfhd and trun boxes.
+const (
+	tfhdBaseDataOffset  = 0x000001
+	tfhdSampleDescIndex = 0x000002
+	tfhdDefaultDuration = 0x000008
+	tfhdDefaultSize     = 0x000010
+	tfhdDefaultFlags    = 0x000020
+
+	trunDataOffset       = 0x000001
+	trunFirstSampleFlags = 0x000004
+	trunDuration         = 0x000100
+	trunSize             = 0x000200
+	trunFlags            = 0x000400
+	trunCompositionTime  = 0x000800
+
+	// sampleNonSync is the sample_is_non_sync_sample bit of sample flags.
+	sampleNonSync = 0x00010000
+	// maxRunSamples bounds the sample count of a trun without per-sample
+	// fields.
+	maxRunSamples = 1 << 20
+)
+
+// Sample is one sample (a video frame or a block of audio) of a track.
+type Sample struct {
+	// Offset is the position of the sample data in the source file.
+	Offset int64
+	Size   uint32
+	// Duration is in the track timescale.
+	Duration uint32
+	// CompositionOffset is the presentation time minus the decode time.
+	CompositionOffset int32
+	// Sync marks samples that can be decoded on their own (keyframes).
+	Sync bool
+}
+
+// Track is a track read by ReadTracks: its sample table and the boxes
+// describing the media, which Mux copies as they are.
+type Track struct {
+	ID uint32
+	// Handler is the media type from hdlr: "vide", "soun", ...
+	Handler   string
+	Timescale uint32
+	Samples   []Sample
+
+	width, height uint32 // 16.16 fixed point, from tkhd
+	language      uint16 // packed ISO-639-2/T code, from mdhd
+	// mediaTime is where presentation starts, in the timescale, from a
+	// single-entry edit list.
+	mediaTime   int64
+	stsd        []byte
+	hdlr        []byte
+	mediaHeader []byte
+	disabled    bool // written without the enabled flags, for chapter tracks
+
+	src        io.ReaderAt
+	decodeTime uint64
+}
+
+// Codec returns the type of the track's first sample entry, e.g. "avc1",
+// "av01" or "mp4a".
+func (t *Track) Codec() string {
+	// stsd: header, version and flags, entry count, then the first entry's
+	// size and type.
+	if len(t.stsd) < 24 {
+		return ""
+	}
+	return string(t.stsd[20:24])
+}
+
+// Duration returns the sum of the sample durations, in the timescale.
+func (t *Track) Duration() uint64 {
+	var d uint64
+	for _, s := range t.Samples {
+		d += uint64(s.Duration)
+	}
+	return d
+}
+
+// trackDefaults are the sample defaults of a trex or tfhd box.
+type trackDefaults struct {
+	duration, size, flags uint32
+}
+
+// ReadTracks reads the tracks of the MP4 file in r, which is size bytes
+// long. The samples of fragmented files are collected from all movie
+// fragments in order. Sample data is not read: it stays in r, which must
+// remain readable until the tracks are muxed.
+func ReadTracks(r io.ReaderAt, size int64) ([]*Track, error) {
+	var (
+		tracks []*Track
+		byID   = map[uint32]*Track{}
+		trex   = map[uint32]trackDefaults{}
+	)
+	err := walkTop(r, size, func(b *box, off int64) error {
+		switch b.typ {
+		case "moov":
+			if tracks != nil {
+				return fmt.Errorf("%w: more than one moov box", ErrInvalid)
+			}
+			for _, trak := range b.all("trak") {
+				t, err := readTrack(trak, r, size)
+				if err != nil {
+					return err
+				}
+				tracks = append(tracks, t)
+				byID[t.ID] = t
+			}
+			if len(tracks) == 0 {
+				return fmt.Errorf("%w: no tracks", ErrInvalid)
+			}
+			if mvex := b.child("mvex"); mvex != nil {
+				for _, x := range mvex.all("trex") {
+					p := parser{p: x.data}
+					p.fullHeader()
+					id := p.u32()
+					p.skip(4) // default_sample_description_index
+					trex[id] = trackDefaults{duration: p.u32(), size: p.u32(), flags: p.u32()}
+					if err := p.err("trex"); err != nil {
+						return err
+					}
+				}
+			}
+		case "moof":
+			if tracks == nil {
+				return fmt.Errorf("%w: movie fragment before moov", ErrInvalid)
+			}
+			return readFragment(b, off, size, byID, trex)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if tracks == nil {
+		return nil, fmt.Errorf("%w: no moov box", ErrInvalid)
+	}
+	for _, t := range tracks {
+		if len(t.Samples) == 0 {
+			return nil, fmt.Errorf("%w: track %d has no samples", ErrInvalid, t.ID)
+		}
+	}
+	return tracks, nil
+}
+
+// readTrack reads a trak box and, for non-fragmented files, its sample
+// table.
+func readTrack(trak *box, src io.ReaderAt, size int64) (*Track, error) {
+	t := &Track{src: src, language: 0x55C4} // "und"
+	tkhd := trak.child("tkhd")
+	mdhd := trak.child("mdia", "mdhd")
+	hdlr := trak.child("mdia", "hdlr")
+	minf := trak.child("mdia", "minf")
+	stsd := trak.child("mdia", "minf", "stbl", "stsd")
+	if tkhd == nil || mdhd == nil || hdlr == nil || minf == nil || stsd == nil {
+		return nil, fmt.Errorf("%w: incomplete trak box", ErrInvalid)
+	}
+
+	p := parser{p: tkhd.data}
+	v, _ := p.fullHeader()
+	if v == 1 {
+		p.skip(16)
+	} else {
+		p.skip(8)
+	}
+	t.ID = p.u32()
+	p.skip(4) // reserved
+	if v == 1 {
+		p.skip(8)
+	} else {
+		p.skip(4)
+	}
+	p.skip(8 + 2 + 2 + 2 + 2 + 36) // reserved, layer, group, volume, matrix
+	t.width, t.height = p.u32(), p.u32()
+	if err := p.err("tkhd"); err != nil {
+		return nil, err
+	}
+
+	p = parser{p: mdhd.data}
+	if v, _ := p.fullHeader(); v == 1 {
+		p.skip(16)
+		t.Timescale = p.u32()
+		p.skip(8)
+	} else {
+		p.skip(8)
+		t.Timescale = p.u32()
+		p.skip(4)
+	}
+	t.language = p.u16()
+	if err := p.err("mdhd"); err != nil {
+		return nil, err
+	}
+	if t.Timescale == 0 {
+		return nil, fmt.Errorf("%w: track %d has a zero timescale", ErrInvalid, t.ID)
+	}
+
+	p = parser{p: hdlr.data}
+	p.fullHeader()
+	p.skip(4) // pre_defined
+	t.Handler = string(p.take(4))
+	if err := p.err("hdlr"); err != nil {
+		return nil, err
+	}
+	t.hdlr, t.stsd = hdlr.raw, stsd.raw
+	for _, c := range minf.children {
+		switch c.typ {
+		case "vmhd", "smhd", "sthd", "nmhd":
+			t.mediaHeader = c.raw
+		}
+	}
+
+	if elst := trak.child("edts", "elst"); elst != nil {
+		mediaTime, err := readEditList(elst)
+		if err != nil {
+			return nil, err
+		}
+		t.mediaTime = mediaTime
+	}
+
+	if err := t.readSampleTable(trak.child("mdia", "minf", "stbl"), size); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// readEditList returns the media time of the edit list, when it has a
+// single edit that starts the presentation inside the media (as written for
+// streams with B-frames). Other edit lists are ignored.
+func readEditList(elst *box) (int64, error) {
+	p := parser{p: elst.data}
+	v, _ := p.fullHeader()
+	entrySize := 12
+	if v == 1 {
+		entrySize = 20
+	}
+	if n := p.count(entrySize); n != 1 {
+		return 0, p.err("elst")
+	}
+	var mediaTime int64
+	if v == 1 {
+		p.skip(8)
+		mediaTime = int64(p.u64())
+	} else {
+		p.skip(4)
+		mediaTime = int64(int32(p.u32()))
+	}
+	return max(mediaTime, 0), p.err("elst")
+}
+
+// readSampleTable fills the samples from the stbl box. The sample table of
+// a fragmented file is empty.
+func (t *Track) readSampleTable(stbl *box, fileSize int64) error {
+	stsz := stbl.child("stsz")
+	stts := stbl.child("stts")
+	stsc := stbl.child("stsc")
+	stco := stbl.child("stco")
+	if stco == nil {
+		stco = stbl.child("co64")
+	}
+	if stsz == nil || stts == nil || stsc == nil || stco == nil {
+		return nil // fragmented file; samples come from the fragments
+	}
+
+	p := parser{p: stsz.data}
+	p.fullHeader()
+	uniform := p.u32()
+	var n int
+	if uniform == 0 {
+		n = p.count(4)
+	} else if n = int(p.u32()); uint64(n)*uint64(uniform) > uint64(fileSize) {
+		p.bad = true
+	}
+	if err := p.err("stsz"); err != nil {
+		return err
+	}
+	if n == 0 {
+		return nil
+	}
+	samples := make([]Sample, n)
+	for i := range samples {
+		samples[i].Size = uniform
+		if uniform == 0 {
+			samples[i].Size = p.u32()
+		}
+		samples[i].Sync = true
+	}
+
+	p = parser{p: stts.data}
+	p.fullHeader()
+	i := 0
+	for e := p.count(8); e > 0; e-- {
+		count, delta := p.u32(), p.u32()
+		for ; count > 0 && i < n; count-- {
+			samples[i].Duration = delta
+			i++
+		}
+	}
+	if err := p.err("stts"); err != nil {
+		return err
+	}
+
+	if ctts := stbl.child("ctts"); ctts != nil {
+		p = parser{p: ctts.data}
+		p.fullHeader()
+		i := 0
+		for e := p.count(8); e > 0; e-- {
+			count, offset := p.u32(), int32(p.u32())
+			for ; count > 0 && i < n; count-- {
+				samples[i].CompositionOffset = offset
+				i++
+			}
+		}
+		if err := p.err("ctts"); err != nil {
+			return err
+		}
+	}
+
+	if stss := stbl.child("stss"); stss != nil {
+		for i := range samples {
+			samples[i].Sync = false
+		}
+		p = parser{p: stss.data}
+		p.fullHeader()
+		for e := p.count(4); e > 0; e-- {
+			if k := p.u32(); k >= 1 && int(k) <= n {
+				samples[k-1].Sync = true
+			}
+		}
+		if err := p.err("stss"); err != nil {
+			return err
+		}
+	}
+
+	p = parser{p: stco.data}
+	p.fullHeader()
+	var offsets []int64
+	if stco.typ == "co64" {
+		offsets = make([]int64, p.count(8))
+		for i := range offsets {
+			offsets[i] = int64(p.u64())
+		}
+	} else {
+		offsets = make([]int64, p.count(4))
+		for i := range offsets {
+			offsets[i] = int64(p.u32())
+		}
+	}
+	if err := p.err(stco.typ); err != nil {
+		return err
+	}
+
+	type stscEntry struct{ firstChunk, perChunk uint32 }
+	p = parser{p: stsc.data}
+	p.fullHeader()
+	entries := make([]stscEntry, p.count(12))
+	for i := range entries {
+		entries[i] = stscEntry{firstChunk: p.u32(), perChunk: p.u32()}
+		p.skip(4) // sample_description_index
+	}
+	if err := p.err("stsc"); err != nil {
+		return err
+	}
+
+	i = 0
+	for e, entry := range entries {
+		last := uint32(len(offsets))
+		if e+1 < len(entries) {
+			last = min(last, entries[e+1].firstChunk-1)
+		}
+		for c := entry.firstChunk; c >= 1 && c <= last && i < n; c++ {
+			off := offsets[c-1]
+			for k := uint32(0); k < entry.perChunk && i < n; k++ {
+				samples[i].Offset = off
+				off += int64(samples[i].Size)
+				i++
+			}
+		}
+	}
+	if i < n {
+		return fmt.Errorf("%w: track %d: chunks hold %d of %d samples", ErrInvalid, t.ID, i, n)
+	}
+	for _, s := range samples {
+		if err := t.addSample(s, fileSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readFragment appends the samples of a moof box at offset moofOff.
+func readFragment(moof *box, moofOff, fileSize int64, byID map[uint32]*Track, trex map[uint32]trackDefaults) error {
+	for _, traf := range moof.all("traf") {
+		tfhd := traf.child("tfhd")
+		if tfhd == nil {
+			return fmt.Errorf("%w: traf without tfhd", ErrInvalid)
+		}
+		p := parser{p: tfhd.data}
+		_, flags := p.fullHeader()
+		id := p.u32()
+		t := byID[id]
+		if t == nil {
+			return fmt.Errorf("%w: fragment of unknown track %d", ErrInvalid, id)
+		}
+		def := trex[id]
+		// Without an explicit base offset, data offsets are relative to
+		// the moof box (default-base-is-moof, and the only case for the
+		// first traf otherwise).
+		base := moofOff
+		if flags&tfhdBaseDataOffset != 0 {
+			base = int64(p.u64())
+		}
+		if flags&tfhdSampleDescIndex != 0 {
+			p.skip(4)
+		}
+		if flags&tfhdDefaultDuration != 0 {
+			def.duration = p.u32()
+		}
+		if flags&tfhdDefaultSize != 0 {
+			def.size = p.u32()
+		}
+		if flags&tfhdDefaultFlags != 0 {
+			def.flags = p.u32()
+		}
+		if err := p.err("tfhd"); err != nil {
+			return err
+		}
+
+		if tfdt := traf.child("tfdt"); tfdt != nil {
+			p := parser{p: tfdt.data}
+			var decodeTime uint64
+			if v, _ := p.fullHeader(); v == 1 {
+				decodeTime = p.u64()
+			} else {
+				decodeTime = uint64(p.u32())
+			}
+			if err := p.err("tfdt"); err != nil {
+				return err
+			}
+			t.seek(decodeTime)
+		}
+
+		pos := base
+		for _, trun := range traf.all("trun") {
+			p := parser{p: trun.data}
+			_, flags := p.fullHeader()
+			perSample := 0
+			for _, f := range []uint32{trunDuration, trunSize, trunFlags, trunCompositionTime} {
+				if flags&f != 0 {
+					perSample += 4
+				}
+			}
+			n := p.u32()
+			if flags&trunDataOffset != 0 {
+				pos = base + int64(int32(p.u32()))
+			}
+			firstFlags, hasFirst := def.flags, false
+			if flags&trunFirstSampleFlags != 0 {
+				firstFlags, hasFirst = p.u32(), true
+			}
+			if perSample > 0 && uint64(n)*uint64(perSample) > uint64(len(p.p)) || perSample == 0 && n > maxRunSamples {
+				p.bad = true
+			}
+			if err := p.err("trun"); err != nil {
+				return err
+			}
+			for i := uint32(0); i < n; i++ {
+				s := Sample{Offset: pos, Duration: def.duration, Size: def.size}
+				sampleFlags := def.flags
+				if i == 0 && hasFirst {
+					sampleFlags = firstFlags
+				}
+				if flags&trunDuration != 0 {
+					s.Duration = p.u32()
+				}
+				if flags&trunSize != 0 {
+					s.Size = p.u32()
+				}
+				if flags&trunFlags != 0 {
+					sampleFlags = p.u32()
+				}
+				if flags&trunCompositionTime != 0 {
+					s.CompositionOffset = int32(p.u32())
+				}
+				s.Sync = sampleFlags&sampleNonSync == 0
+				if err := t.addSample(s, fileSize); err != nil {
+					return err
+				}
+				pos += int64(s.Size)
+			}
+		}
+	}
+	return nil
+}
+
+// seek moves the decode time to a fragment's base decode time. A gap or
+// overlap after the previous sample is absorbed by changing its duration.
+func (t *Track) seek(decodeTime uint64) {
+	if len(t.Samples) == 0 || decodeTime == t.decodeTime {
+		return
+	}
+	last := &t.Samples[len(t.Samples)-1]
+	start := t.decodeTime - uint64(last.Duration)
+	if decodeTime > start && decodeTime-start <= 0xFFFFFFFF {
+		last.Duration = uint32(decodeTime - start)
+		t.decodeTime = decodeTime
+	}
+}
+
+// addSample appends s after checking that its data lies inside the file.
+func (t *Track) addSample(s Sample, fileSize int64) error {
+	if s.Offset < 0 || s.Offset+int64(s.Size) > fileSize {
+		return fmt.Errorf("%w: track %d: sample %d lies outside the file", ErrInvalid, t.ID, len(t.Samples))
+	}
+	t.Samples = append(t.Samples, s)
+	t.decodeTime += uint64(s.Duration)
+	return nil
+}
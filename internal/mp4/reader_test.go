@@ -0,0 +1,140 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// u32s encodes big-endian 32-bit values.
+func u32s(v ...uint32) []byte {
+	var b []byte
+	for _, x := range v {
+		b = binary.BigEndian.AppendUint32(b, x)
+	}
+	return b
+}
+
+// testInit builds the ftyp and moov of a fragmented file with one track.
+func testInit(handler, codec string, timescale uint32, defaultDuration uint32) []byte {
+	tkhd := fullbox("tkhd", 0, 3, make([]byte, 8), u32s(1), make([]byte, 8), make([]byte, 8+2+2+2+2), u32s(identityMatrix...), u32s(640<<16, 360<<16))
+	mdhd := fullbox("mdhd", 0, 0, make([]byte, 8), u32s(timescale, 0), []byte{0x15, 0xC7, 0, 0}) // "eng"
+	hdlr := fullbox("hdlr", 0, 0, make([]byte, 4), []byte(handler), make([]byte, 12), []byte("test\x00"))
+	stsd := fullbox("stsd", 0, 0, u32s(1), mkbox(codec, make([]byte, 16)))
+	empty := fullbox("stts", 0, 0, u32s(0))
+	stbl := mkbox("stbl", stsd, empty,
+		fullbox("stsc", 0, 0, u32s(0)), fullbox("stsz", 0, 0, u32s(0, 0)), fullbox("stco", 0, 0, u32s(0)))
+	minf := mkbox("minf", fullbox("vmhd", 0, 1, make([]byte, 8)), stbl)
+	trak := mkbox("trak", tkhd, mkbox("mdia", mdhd, hdlr, minf))
+	mvex := mkbox("mvex", fullbox("trex", 0, 0, u32s(1, 1, defaultDuration, 0, sampleNonSync)))
+	moov := mkbox("moov", fullbox("mvhd", 0, 0, make([]byte, 96)), trak, mvex)
+	return append(mkbox("ftyp", []byte("dash\x00\x00\x00\x00iso6mp41")), moov...)
+}
+
+// testFragment builds a moof and mdat for the samples, the first of which is
+// a keyframe, starting at decodeTime.
+func testFragment(decodeTime uint32, samples [][]byte) []byte {
+	trun := func(dataOffset uint32) []byte {
+		p := u32s(uint32(len(samples)), dataOffset, 0) // first sample flags: sync
+		for _, s := range samples {
+			p = append(p, u32s(uint32(len(s)))...)
+		}
+		return fullbox("trun", 0, trunDataOffset|trunFirstSampleFlags|trunSize, p)
+	}
+	traf := func(dataOffset uint32) []byte {
+		return mkbox("traf",
+			fullbox("tfhd", 0, 0x020000, u32s(1)), // default-base-is-moof
+			fullbox("tfdt", 0, 0, u32s(decodeTime)),
+			trun(dataOffset))
+	}
+	moofSize := len(mkbox("moof", fullbox("mfhd", 0, 0, u32s(1)), traf(0)))
+	moof := mkbox("moof", fullbox("mfhd", 0, 0, u32s(1)), traf(uint32(moofSize+8)))
+	return append(moof, mkbox("mdat", bytes.Join(samples, nil))...)
+}
+
+// testSamples returns n samples of distinct content.
+func testSamples(tag byte, n int) [][]byte {
+	out := make([][]byte, n)
+	for i := range out {
+		out[i] = bytes.Repeat([]byte{tag, byte(i)}, 3+i%4)
+	}
+	return out
+}
+
+// testFile builds a fragmented file with two fragments of the samples.
+func testFile(handler, codec string, timescale, duration uint32, samples [][]byte) []byte {
+	half := len(samples) / 2
+	b := testInit(handler, codec, timescale, duration)
+	b = append(b, testFragment(0, samples[:half])...)
+	return append(b, testFragment(uint32(half)*duration, samples[half:])...)
+}
+
+func TestReadTracksFragmented(t *testing.T) {
+	samples := testSamples('v', 10)
+	file := testFile("vide", "avc1", 15360, 512, samples)
+	tracks, err := ReadTracks(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatalf("ReadTracks() error = %v", err)
+	}
+	if len(tracks) != 1 {
+		t.Fatalf("got %d tracks, want 1", len(tracks))
+	}
+	tr := tracks[0]
+	if tr.Handler != "vide" || tr.Codec() != "avc1" || tr.Timescale != 15360 || tr.width != 640<<16 {
+		t.Errorf("track = %s %s %d %d", tr.Handler, tr.Codec(), tr.Timescale, tr.width>>16)
+	}
+	if len(tr.Samples) != len(samples) {
+		t.Fatalf("got %d samples, want %d", len(tr.Samples), len(samples))
+	}
+	for i, s := range tr.Samples {
+		data := file[s.Offset : s.Offset+int64(s.Size)]
+		if !bytes.Equal(data, samples[i]) {
+			t.Errorf("sample %d = %x, want %x", i, data, samples[i])
+		}
+		if s.Duration != 512 {
+			t.Errorf("sample %d duration = %d", i, s.Duration)
+		}
+		if want := i == 0 || i == len(samples)/2; s.Sync != want {
+			t.Errorf("sample %d sync = %v, want %v", i, s.Sync, want)
+		}
+	}
+}
+
+func TestReadTracksDecodeTimeGap(t *testing.T) {
+	samples := testSamples('a', 4)
+	file := testInit("soun", "mp4a", 48000, 1024)
+	file = append(file, testFragment(0, samples[:2])...)
+	file = append(file, testFragment(3000, samples[2:])...)
+	tracks, err := ReadTracks(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatalf("ReadTracks() error = %v", err)
+	}
+	if got := tracks[0].Samples[1].Duration; got != 3000-1024 {
+		t.Errorf("duration before the gap = %d, want %d", got, 3000-1024)
+	}
+	if got := tracks[0].Duration(); got != 3000+2*1024 {
+		t.Errorf("track duration = %d", got)
+	}
+}
+
+func TestReadTracksInvalid(t *testing.T) {
+	file := testFile("vide", "avc1", 1000, 40, testSamples('v', 4))
+	tests := map[string][]byte{
+		"empty":              nil,
+		"html":               []byte("<html><body>Error</body></html>"),
+		"truncated":          file[:len(file)-3],
+		"no fragments":       testInit("vide", "avc1", 1000, 40),
+		"fragment only":      testFragment(0, testSamples('v', 2)),
+		"box overruns":       append(mkbox("ftyp", []byte("isom")), 0, 0, 1, 0, 'm', 'o', 'o', 'v'),
+		"garbage moov child": append(mkbox("ftyp", nil), mkbox("moov", []byte{0, 0, 0, 99, 't', 'r', 'a', 'k'})...),
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ReadTracks(bytes.NewReader(data), int64(len(data)))
+			if !errors.Is(err, ErrInvalid) {
+				t.Errorf("ReadTracks() error = %v, want ErrInvalid", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package ogg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// OpusSampleRate is the rate of Opus granule positions.
+const OpusSampleRate = 48000
+
+// ErrInvalidOpusHead is returned when the identification header is malformed.
+var ErrInvalidOpusHead = errors.New("ogg: invalid OpusHead")
+
+// OpusHead builds a mapping-family-0 identification header for mono or
+// stereo streams.
+func OpusHead(channels int, preSkip uint16, inputSampleRate uint32) []byte {
+	h := make([]byte, 19)
+	copy(h, "OpusHead")
+	h[8] = 1
+	h[9] = byte(channels)
+	binary.LittleEndian.PutUint16(h[10:], preSkip)
+	binary.LittleEndian.PutUint32(h[12:], inputSampleRate)
+	return h
+}
+
+// OpusTags builds a comment header with the given vendor string and
+// "KEY=value" comments.
+func OpusTags(vendor string, comments ...string) []byte {
+	var b bytes.Buffer
+	b.WriteString("OpusTags")
+	_ = binary.Write(&b, binary.LittleEndian, uint32(len(vendor)))
+	b.WriteString(vendor)
+	_ = binary.Write(&b, binary.LittleEndian, uint32(len(comments)))
+	for _, c := range comments {
+		_ = binary.Write(&b, binary.LittleEndian, uint32(len(c)))
+		b.WriteString(c)
+	}
+	return b.Bytes()
+}
+
+// OpusPacketSamples returns the number of 48 kHz samples encoded in an Opus
+// packet, derived from its TOC byte (RFC 6716 section 3.1).
+func OpusPacketSamples(p []byte) int {
+	if len(p) == 0 {
+		return 0
+	}
+	toc := p[0]
+	config := toc >> 3
+	var frame int
+	switch {
+	case config < 12: // SILK: 10, 20, 40, 60 ms
+		frame = []int{480, 960, 1920, 2880}[config%4]
+	case config < 16: // Hybrid: 10, 20 ms
+		frame = []int{480, 960}[config%2]
+	default: // CELT: 2.5, 5, 10, 20 ms
+		frame = []int{120, 240, 480, 960}[config%4]
+	}
+	switch toc & 0x03 {
+	case 0:
+		return frame
+	case 1, 2:
+		return 2 * frame
+	}
+	if len(p) < 2 {
+		return 0
+	}
+	return int(p[1]&0x3F) * frame
+}
+
+// OpusWriter writes an Ogg Opus stream, computing granule positions from the
+// packets.
+type OpusWriter struct {
+	ow      *Writer
+	granule int64
+	pending []byte
+	hasPend bool
+}
+
+// NewOpusWriter writes the identification header head (an "OpusHead" packet,
+// as found in Matroska CodecPrivate) and a comment header, each on its own
+// page, and returns a writer for the audio packets.
+func NewOpusWriter(w io.Writer, serial uint32, head []byte, tags []byte) (*OpusWriter, error) {
+	if len(head) < 19 || string(head[:8]) != "OpusHead" {
+		return nil, ErrInvalidOpusHead
+	}
+	ow := NewWriter(w, serial)
+	for _, h := range [][]byte{head, tags} {
+		if err := ow.WritePacket(h, 0); err != nil {
+			return nil, err
+		}
+		if err := ow.Flush(); err != nil {
+			return nil, err
+		}
+	}
+	return &OpusWriter{ow: ow}, nil
+}
+
+// WritePacket appends an audio packet. The last packet is held back until
+// Close so that end trimming can be applied to its granule position.
+func (o *OpusWriter) WritePacket(p []byte) error {
+	if o.hasPend {
+		if err := o.writePending(0); err != nil {
+			return err
+		}
+	}
+	o.pending = append(o.pending[:0], p...)
+	o.hasPend = true
+	return nil
+}
+
+// Close writes the last packet, dropping trimSamples samples from the end of
+// the stream, and ends the stream.
+func (o *OpusWriter) Close(trimSamples int64) error {
+	if o.hasPend {
+		if err := o.writePending(trimSamples); err != nil {
+			return err
+		}
+	}
+	return o.ow.Close()
+}
+
+func (o *OpusWriter) writePending(trim int64) error {
+	o.granule += int64(OpusPacketSamples(o.pending))
+	g := o.granule - trim
+	if g < 0 {
+		g = 0
+	}
+	o.hasPend = false
+	return o.ow.WritePacket(o.pending, g)
+}
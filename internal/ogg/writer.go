@@ -0,0 +1,141 @@
+// Package ogg writes Ogg bitstreams (RFC 3533) and Ogg Opus files (RFC 7845).
+package ogg
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+const (
+	flagContinued = 0x01
+	flagBOS       = 0x02
+	flagEOS       = 0x04
+
+	maxSegments = 255
+	// targetPageSize is the payload size after which a page is flushed.
+	targetPageSize = 4096
+	// noGranule marks pages on which no packet ends.
+	noGranule = -1
+)
+
+var crcTable = func() (t [256]uint32) {
+	for i := range t {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04C11DB7
+			} else {
+				r <<= 1
+			}
+		}
+		t[i] = r
+	}
+	return t
+}()
+
+// crc32 computes the Ogg page checksum (polynomial 0x04C11DB7, no reflection,
+// zero initial value and no final XOR).
+func crc32(crc uint32, p []byte) uint32 {
+	for _, b := range p {
+		crc = crc<<8 ^ crcTable[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+// Writer packs packets of a single logical bitstream into Ogg pages.
+type Writer struct {
+	w       io.Writer
+	serial  uint32
+	seq     uint32
+	started bool
+
+	segments  []byte
+	data      []byte
+	granule   int64
+	continued bool
+}
+
+// NewWriter returns a Writer for the logical bitstream with the given serial
+// number.
+func NewWriter(w io.Writer, serial uint32) *Writer {
+	return &Writer{w: w, serial: serial, granule: noGranule}
+}
+
+// WritePacket appends a packet whose last sample has the given granule
+// position. Packets are buffered into pages; call Flush to end a page after
+// the packet (e.g. for stream headers).
+func (ow *Writer) WritePacket(p []byte, granule int64) error {
+	for off := 0; ; {
+		n := len(p) - off
+		if n > maxSegments-1 {
+			n = maxSegments
+		}
+		ow.segments = append(ow.segments, byte(n))
+		ow.data = append(ow.data, p[off:off+n]...)
+		off += n
+		done := n < maxSegments
+		if done {
+			ow.granule = granule
+		}
+		if len(ow.segments) == maxSegments {
+			if err := ow.writePage(0); err != nil {
+				return err
+			}
+			ow.continued = !done
+		}
+		if done {
+			break
+		}
+	}
+	if len(ow.data) >= targetPageSize {
+		return ow.writePage(0)
+	}
+	return nil
+}
+
+// Flush writes any buffered packets as a page.
+func (ow *Writer) Flush() error {
+	if len(ow.segments) == 0 {
+		return nil
+	}
+	return ow.writePage(0)
+}
+
+// Close flushes buffered packets and marks the last page as the end of the
+// stream. It does not close the underlying writer.
+func (ow *Writer) Close() error {
+	return ow.writePage(flagEOS)
+}
+
+func (ow *Writer) writePage(flags byte) error {
+	if !ow.started {
+		flags |= flagBOS
+		ow.started = true
+	}
+	if ow.continued {
+		flags |= flagContinued
+		ow.continued = false
+	}
+	hdr := make([]byte, 27, 27+len(ow.segments))
+	copy(hdr, "OggS")
+	hdr[5] = flags
+	binary.LittleEndian.PutUint64(hdr[6:], uint64(ow.granule))
+	binary.LittleEndian.PutUint32(hdr[14:], ow.serial)
+	binary.LittleEndian.PutUint32(hdr[18:], ow.seq)
+	hdr[26] = byte(len(ow.segments))
+	hdr = append(hdr, ow.segments...)
+	crc := crc32(crc32(0, hdr), ow.data)
+	binary.LittleEndian.PutUint32(hdr[22:], crc)
+
+	if _, err := ow.w.Write(hdr); err != nil {
+		return err
+	}
+	if _, err := ow.w.Write(ow.data); err != nil {
+		return err
+	}
+	ow.seq++
+	ow.segments = ow.segments[:0]
+	ow.data = ow.data[:0]
+	ow.granule = noGranule
+	return nil
+}
@@ -0,0 +1,145 @@
+package ogg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+type page struct {
+	flags    byte
+	granule  int64
+	seq      uint32
+	segments []byte
+	data     []byte
+}
+
+func readPages(t *testing.T, b []byte) []page {
+	t.Helper()
+	var pages []page
+	for len(b) > 0 {
+		if len(b) < 27 || string(b[:4]) != "OggS" {
+			t.Fatalf("bad page header at %d bytes left", len(b))
+		}
+		n := int(b[26])
+		segs := b[27 : 27+n]
+		size := 0
+		for _, s := range segs {
+			size += int(s)
+		}
+		end := 27 + n + size
+		raw := append([]byte(nil), b[:end]...)
+		want := binary.LittleEndian.Uint32(raw[22:])
+		binary.LittleEndian.PutUint32(raw[22:], 0)
+		if got := crc32(0, raw); got != want {
+			t.Fatalf("page %d: crc %08x, want %08x", len(pages), got, want)
+		}
+		pages = append(pages, page{
+			flags:    b[5],
+			granule:  int64(binary.LittleEndian.Uint64(b[6:])),
+			seq:      binary.LittleEndian.Uint32(b[18:]),
+			segments: segs,
+			data:     b[27+n : end],
+		})
+		b = b[end:]
+	}
+	return pages
+}
+
+func TestCRC32(t *testing.T) {
+	if got := crc32(0, []byte("123456789")); got != 0x89A1897F {
+		t.Fatalf("crc32 = %08x, want 89a1897f", got)
+	}
+}
+
+func TestWriterPages(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 7)
+	if err := w.WritePacket([]byte("head"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	big := bytes.Repeat([]byte{0xAB}, 255*260) // more than 255 segments
+	if err := w.WritePacket(big, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WritePacket([]byte("tail"), 2000); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	pages := readPages(t, buf.Bytes())
+	if len(pages) != 3 {
+		t.Fatalf("expected 3 pages, got %d", len(pages))
+	}
+	if pages[0].flags != flagBOS || pages[0].granule != 0 || string(pages[0].data) != "head" {
+		t.Errorf("unexpected first page: %+v", pages[0])
+	}
+	if pages[1].flags != 0 || pages[1].granule != noGranule || len(pages[1].segments) != maxSegments {
+		t.Errorf("unexpected second page: flags=%d granule=%d segments=%d", pages[1].flags, pages[1].granule, len(pages[1].segments))
+	}
+	if pages[2].flags != flagContinued|flagEOS || pages[2].granule != 2000 {
+		t.Errorf("unexpected last page: flags=%d granule=%d", pages[2].flags, pages[2].granule)
+	}
+	if got := len(pages[1].data) + len(pages[2].data); got != len(big)+4 {
+		t.Errorf("payload size %d, want %d", got, len(big)+4)
+	}
+	for i, p := range pages {
+		if p.seq != uint32(i) {
+			t.Errorf("page %d has sequence %d", i, p.seq)
+		}
+	}
+}
+
+func TestOpusPacketSamples(t *testing.T) {
+	tests := []struct {
+		packet []byte
+		want   int
+	}{
+		{[]byte{31 << 3}, 960},          // CELT 20 ms
+		{[]byte{16 << 3}, 120},          // CELT 2.5 ms
+		{[]byte{3 << 3}, 2880},          // SILK 60 ms
+		{[]byte{13 << 3}, 960},          // Hybrid 20 ms
+		{[]byte{31<<3 | 1}, 1920},       // two frames
+		{[]byte{31<<3 | 3, 0x83}, 2880}, // three frames
+		{nil, 0},
+	}
+	for _, tt := range tests {
+		if got := OpusPacketSamples(tt.packet); got != tt.want {
+			t.Errorf("OpusPacketSamples(%v) = %d, want %d", tt.packet, got, tt.want)
+		}
+	}
+}
+
+func TestOpusWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewOpusWriter(&buf, 1, OpusHead(2, 312, 48000), OpusTags("test", "TITLE=x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := w.WritePacket([]byte{31 << 3, byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(100); err != nil {
+		t.Fatal(err)
+	}
+	pages := readPages(t, buf.Bytes())
+	if len(pages) != 3 {
+		t.Fatalf("expected 3 pages, got %d", len(pages))
+	}
+	if !bytes.HasPrefix(pages[0].data, []byte("OpusHead")) || !bytes.HasPrefix(pages[1].data, []byte("OpusTags")) {
+		t.Errorf("unexpected header pages")
+	}
+	if pages[2].granule != 3*960-100 || pages[2].flags&flagEOS == 0 {
+		t.Errorf("unexpected audio page: granule=%d flags=%d", pages[2].granule, pages[2].flags)
+	}
+	if _, err := NewOpusWriter(&buf, 1, []byte("nope"), nil); err != ErrInvalidOpusHead {
+		t.Errorf("expected ErrInvalidOpusHead, got %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"unicode/utf8"
 )
 
 const (
@@ -25,12 +26,25 @@ func ToSafeFilename(title, ext string) string {
 	}
 	name = unsafeChars.ReplaceAllString(name, "_")
 	name = strings.TrimSpace(name)
-	if len(name) > MaxFilenameLength {
-		name = name[:MaxFilenameLength]
-	}
+	name = Truncate(name, MaxFilenameLength)
 	ext = strings.TrimPrefix(strings.ToLower(ext), ".")
 	if ext == "" {
 		ext = DefaultExt
 	}
 	return filepath.Clean(name + "." + ext)
 }
+
+// Truncate returns the longest prefix of s that is at most max bytes long and
+// does not split a UTF-8 sequence.
+func Truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	if max <= 0 {
+		return ""
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
+}
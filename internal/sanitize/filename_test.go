@@ -1,6 +1,10 @@
 package sanitize
 
-import "testing"
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
 
 func TestToSafeFilename_Basics(t *testing.T) {
 	got := ToSafeFilename("Hello:/\\*?\"<>| World", "mp4")
@@ -26,3 +30,28 @@ func TestToSafeFilename_Long(t *testing.T) {
 		t.Fatalf("too long: %d", len(got))
 	}
 }
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		in   string
+		max  int
+		want string
+	}{
+		{"hello", 10, "hello"},
+		{"hello", 3, "hel"},
+		{"héllo", 2, "h"},
+		{"héllo", 3, "hé"},
+		{"日本語", 5, "日"},
+		{"日本語", 0, ""},
+		{"abc", -1, ""},
+	}
+	for _, tt := range tests {
+		if got := Truncate(tt.in, tt.max); got != tt.want {
+			t.Errorf("Truncate(%q, %d) = %q, want %q", tt.in, tt.max, got, tt.want)
+		}
+	}
+	long := strings.Repeat("é", 100)
+	if got := ToSafeFilename(long, "mp4"); !utf8.ValidString(got) {
+		t.Errorf("ToSafeFilename split a rune: %q", got)
+	}
+}
@@ -0,0 +1,369 @@
+package webm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"time"
+)
+
+// Element IDs of the metadata written by WriteMetadata.
+const (
+	idVoid             = 0xEC
+	idTags             = 0x1254C367
+	idTag              = 0x7373
+	idTargets          = 0x63C0
+	idTargetTypeValue  = 0x68CA
+	idSimpleTag        = 0x67C8
+	idTagName          = 0x45A3
+	idTagString        = 0x4487
+	idAttachments      = 0x1941A469
+	idAttachedFile     = 0x61A7
+	idFileDescription  = 0x467E
+	idFileName         = 0x466E
+	idFileMimeType     = 0x4660
+	idFileData         = 0x465C
+	idFileUID          = 0x46AE
+	idChapters         = 0x1043A770
+	idEditionEntry     = 0x45B9
+	idEditionUID       = 0x45BC
+	idChapterAtom      = 0xB6
+	idChapterUID       = 0x73C4
+	idChapterTimeStart = 0x91
+	idChapterTimeEnd   = 0x92
+	idChapterDisplay   = 0x80
+	idChapString       = 0x85
+	idChapLanguage     = 0x437C
+)
+
+// targetTypeMovie is the TargetTypeValue of tags that describe the whole
+// file.
+const targetTypeMovie = 50
+
+// Tag is a simple tag of the whole file, such as TITLE or ARTIST.
+type Tag struct {
+	Name  string
+	Value string
+}
+
+// Attachment is a file attached to a Matroska file, such as cover art.
+type Attachment struct {
+	Name        string
+	MimeType    string
+	Description string
+	Data        []byte
+}
+
+// Chapter is a chapter of the file. End may be zero when unknown.
+type Chapter struct {
+	Start time.Duration
+	End   time.Duration
+	Title string
+}
+
+// Metadata is the metadata that WriteMetadata stores in a file.
+type Metadata struct {
+	Tags []Tag
+	// Attachments are not part of WebM; only use them with Matroska.
+	Attachments []Attachment
+	Chapters    []Chapter
+}
+
+// segmentChild is a level-1 element of the segment. Elements read from the
+// source have a position relative to the segment payload; new ones have
+// their encoding in data.
+type segmentChild struct {
+	id   uint32
+	pos  int64
+	size int64 // including the header
+	data []byte
+}
+
+// WriteMetadata copies the Matroska file src of size bytes to w with meta,
+// replacing the chapters, tags and attachments the file had. They are
+// placed after the tracks, which moves the clusters: the seek head is
+// written anew and the cluster positions of the cues are updated. Frames
+// are copied unchanged. Segments and level-1 elements of unknown size, as
+// written by live encoders, are not supported.
+func WriteMetadata(w io.Writer, src io.ReaderAt, size int64, meta Metadata) error {
+	id, headerSize, hdr, err := elementHeaderAt(src, 0, size)
+	if err != nil {
+		return err
+	}
+	if id != idEBML || headerSize < 0 {
+		return fmt.Errorf("%w: missing EBML header", ErrInvalid)
+	}
+	segmentOff := hdr + headerSize
+	id, segmentSize, hdr, err := elementHeaderAt(src, segmentOff, size)
+	if err != nil {
+		return err
+	}
+	if id != idSegment || segmentSize < 0 || segmentOff+hdr+segmentSize > size {
+		return fmt.Errorf("%w: missing or truncated segment", ErrInvalid)
+	}
+	start, end := segmentOff+hdr, segmentOff+hdr+segmentSize
+
+	var children []segmentChild
+	tracks := false
+	for off := start; off < end; {
+		id, n, hdr, err := elementHeaderAt(src, off, end)
+		if err != nil {
+			return err
+		}
+		if n < 0 || off+hdr+n > end {
+			return fmt.Errorf("%w: level-1 element %#x at offset %d has an unknown or overlong size", ErrInvalid, id, off)
+		}
+		c := segmentChild{id: id, pos: off - start, size: hdr + n}
+		off += c.size
+		switch id {
+		case idSeekHead, idVoid, idChapters, idTags, idAttachments:
+			continue
+		case idCues:
+			if c.size > maxElementSize {
+				return fmt.Errorf("%w: cues of %d bytes", ErrInvalid, c.size)
+			}
+			c.data = make([]byte, c.size)
+			if _, err := src.ReadAt(c.data, start+c.pos); err != nil {
+				return err
+			}
+		}
+		children = append(children, c)
+		if id == idTracks && !tracks {
+			tracks = true
+			children = append(children, metadataElements(meta)...)
+		}
+	}
+	if !tracks {
+		return fmt.Errorf("%w: no tracks", ErrInvalid)
+	}
+
+	// Rewritten cues have the size of their final form, so the layout can be
+	// computed before the new cluster positions are known.
+	identity := func(pos int64) (int64, bool) { return pos, true }
+	for i := range children {
+		if children[i].id == idCues {
+			if children[i].data, err = rewriteCues(children[i].data, identity); err != nil {
+				return err
+			}
+		}
+		if children[i].data != nil {
+			children[i].size = int64(len(children[i].data))
+		}
+	}
+	var seeks []seekEntry
+	seen := map[uint32]bool{}
+	for _, c := range children {
+		switch c.id {
+		case idInfo, idTracks, idChapters, idTags, idAttachments, idCues:
+			if !seen[c.id] {
+				seen[c.id] = true
+				seeks = append(seeks, seekEntry{id: c.id})
+			}
+		}
+	}
+	seekHead := seekHeadElement(seeks...)
+	moved := map[int64]int64{}
+	pos := int64(len(seekHead))
+	newPos := make([]int64, len(children))
+	for i, c := range children {
+		newPos[i] = pos
+		if c.data == nil || c.id == idCues {
+			moved[c.pos] = pos
+		}
+		pos += c.size
+	}
+	for i := range seeks {
+		for k, c := range children {
+			if c.id == seeks[i].id {
+				seeks[i].pos = newPos[k]
+				break
+			}
+		}
+	}
+	seekHead = seekHeadElement(seeks...)
+	remap := func(pos int64) (int64, bool) {
+		p, ok := moved[pos]
+		return p, ok
+	}
+	for i := range children {
+		if children[i].id == idCues {
+			if children[i].data, err = rewriteCues(children[i].data, remap); err != nil {
+				return err
+			}
+		}
+	}
+
+	bw := bufio.NewWriterSize(w, 1<<20)
+	if _, err := io.Copy(bw, io.NewSectionReader(src, 0, segmentOff)); err != nil {
+		return err
+	}
+	header := appendVint8(appendID(nil, idSegment), uint64(pos))
+	for _, p := range [][]byte{header, seekHead} {
+		if _, err := bw.Write(p); err != nil {
+			return err
+		}
+	}
+	for _, c := range children {
+		if c.data != nil {
+			_, err = bw.Write(c.data)
+		} else {
+			_, err = io.Copy(bw, io.NewSectionReader(src, start+c.pos, c.size))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// metadataElements encodes the Chapters, Tags and Attachments of meta,
+// leaving out empty ones.
+func metadataElements(meta Metadata) []segmentChild {
+	var out []segmentChild
+	if len(meta.Chapters) > 0 {
+		atoms := [][]byte{uintElement(idEditionUID, 1)}
+		for i, c := range meta.Chapters {
+			atom := [][]byte{
+				uintElement(idChapterUID, uint64(i+1)),
+				uintElement(idChapterTimeStart, uint64(max(c.Start, 0))),
+			}
+			if c.End > c.Start {
+				atom = append(atom, uintElement(idChapterTimeEnd, uint64(c.End)))
+			}
+			atom = append(atom, element(idChapterDisplay,
+				element(idChapString, []byte(c.Title)),
+				element(idChapLanguage, []byte("und"))))
+			atoms = append(atoms, element(idChapterAtom, atom...))
+		}
+		out = append(out, segmentChild{id: idChapters, data: element(idChapters, element(idEditionEntry, atoms...))})
+	}
+	if len(meta.Tags) > 0 {
+		tag := [][]byte{element(idTargets, uintElement(idTargetTypeValue, targetTypeMovie))}
+		for _, t := range meta.Tags {
+			tag = append(tag, element(idSimpleTag,
+				element(idTagName, []byte(t.Name)),
+				element(idTagString, []byte(t.Value))))
+		}
+		out = append(out, segmentChild{id: idTags, data: element(idTags, element(idTag, tag...))})
+	}
+	if len(meta.Attachments) > 0 {
+		files := make([][]byte, len(meta.Attachments))
+		for i, a := range meta.Attachments {
+			var file [][]byte
+			if a.Description != "" {
+				file = append(file, element(idFileDescription, []byte(a.Description)))
+			}
+			h := fnv.New64a()
+			_, _ = h.Write(a.Data)
+			file = append(file,
+				element(idFileName, []byte(a.Name)),
+				element(idFileMimeType, []byte(a.MimeType)),
+				element(idFileData, a.Data),
+				uintElement(idFileUID, h.Sum64()|1))
+			files[i] = element(idAttachedFile, file...)
+		}
+		out = append(out, segmentChild{id: idAttachments, data: element(idAttachments, files...)})
+	}
+	return out
+}
+
+// rewriteCues re-encodes the Cues element p with the cluster positions
+// mapped through remap, written with eight bytes. It fails for a position
+// that remap does not know.
+func rewriteCues(p []byte, remap func(int64) (int64, bool)) ([]byte, error) {
+	_, n := readVint(p, true)
+	_, m := readVint(p[n:], false)
+	points, err := splitElements(p[n+m:])
+	if err != nil {
+		return nil, err
+	}
+	var out [][]byte
+	for _, cp := range points {
+		if cp.id != idCuePoint {
+			continue
+		}
+		fields, err := splitElements(cp.data)
+		if err != nil {
+			return nil, err
+		}
+		var point [][]byte
+		for _, f := range fields {
+			if f.id != idCueTrackPositions {
+				point = append(point, f.raw)
+				continue
+			}
+			positions, err := splitElements(f.data)
+			if err != nil {
+				return nil, err
+			}
+			var tp [][]byte
+			for _, e := range positions {
+				if e.id != idCueClusterPosition {
+					tp = append(tp, e.raw)
+					continue
+				}
+				var old uint64
+				for _, c := range e.data {
+					old = old<<8 | uint64(c)
+				}
+				pos, ok := remap(int64(old))
+				if !ok {
+					return nil, fmt.Errorf("%w: cue points to no cluster at position %d", ErrInvalid, old)
+				}
+				tp = append(tp, element(idCueClusterPosition, binary.BigEndian.AppendUint64(nil, uint64(pos))))
+			}
+			point = append(point, element(idCueTrackPositions, tp...))
+		}
+		out = append(out, element(idCuePoint, point...))
+	}
+	return element(idCues, out...), nil
+}
+
+// rawElement is an element of an in-memory payload.
+type rawElement struct {
+	id   uint32
+	data []byte // payload
+	raw  []byte // the whole element
+}
+
+// splitElements splits p into its elements.
+func splitElements(p []byte) ([]rawElement, error) {
+	var out []rawElement
+	for len(p) > 0 {
+		id, n := readVint(p, true)
+		size, m := readVint(p[n:], false)
+		if n == 0 || n > 4 || m == 0 || size > uint64(len(p)-n-m) {
+			return nil, fmt.Errorf("%w: bad element", ErrInvalid)
+		}
+		end := n + m + int(size)
+		out = append(out, rawElement{id: uint32(id), data: p[n+m : end], raw: p[:end]})
+		p = p[end:]
+	}
+	return out, nil
+}
+
+// elementHeaderAt reads the header of the element at off, which must end
+// before end. It returns the ID, the payload size (-1 when unknown) and the
+// header length.
+func elementHeaderAt(r io.ReaderAt, off, end int64) (id uint32, size, hdr int64, err error) {
+	var buf [12]byte
+	k, err := r.ReadAt(buf[:min(int64(len(buf)), end-off)], off)
+	if k < len(buf) && err != nil && err != io.EOF {
+		return 0, 0, 0, err
+	}
+	v, n := readVint(buf[:k], true)
+	if n == 0 || n > 4 {
+		return 0, 0, 0, fmt.Errorf("%w: bad element ID at offset %d", ErrInvalid, off)
+	}
+	s, m := readVint(buf[n:k], false)
+	if m == 0 {
+		return 0, 0, 0, fmt.Errorf("%w: bad element size at offset %d", ErrInvalid, off)
+	}
+	size = int64(s)
+	if s == uint64(1)<<(7*m)-1 {
+		size = unknownSize
+	}
+	return uint32(v), size, int64(n + m), nil
+}
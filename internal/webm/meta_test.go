@@ -0,0 +1,133 @@
+package webm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestWriteMetadata(t *testing.T) {
+	video, videoData := testMuxTrack(Track{Type: TrackTypeVideo, CodecID: "V_VP9", PixelWidth: 64, PixelHeight: 36}, 60, 40*time.Millisecond, 25)
+	audio, audioData := testMuxTrack(Track{Type: TrackTypeAudio, CodecID: "A_OPUS", SamplingFrequency: 48000, Channels: 2}, 120, 20*time.Millisecond, 1)
+	var muxed bytes.Buffer
+	if err := Mux(&muxed, DocTypeMatroska, video, audio); err != nil {
+		t.Fatal(err)
+	}
+	meta := Metadata{
+		Tags:        []Tag{{"TITLE", "Title"}, {"ARTIST", "Channel"}},
+		Attachments: []Attachment{{Name: "cover.jpg", MimeType: "image/jpeg", Data: []byte("jpeg")}},
+		Chapters:    []Chapter{{Start: 0, End: time.Second, Title: "Intro"}, {Start: time.Second, Title: "Main"}},
+	}
+	var out bytes.Buffer
+	if err := WriteMetadata(&out, bytes.NewReader(muxed.Bytes()), int64(muxed.Len()), meta); err != nil {
+		t.Fatalf("WriteMetadata() error = %v", err)
+	}
+	file := out.Bytes()
+
+	segment := find(t, file, idSegment)
+	ids, payloads, offsets := children(t, segment)
+	want := []uint32{idSeekHead, idInfo, idTracks, idChapters, idTags, idAttachments, idCluster, idCluster, idCluster, idCues}
+	if len(ids) != len(want) {
+		t.Fatalf("segment children = %x, want %x", ids, want)
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Fatalf("segment children = %x, want %x", ids, want)
+		}
+	}
+	atomIDs, atoms, _ := children(t, find(t, payloads[3], idEditionEntry))
+	if len(atomIDs) != 3 || atomIDs[1] != idChapterAtom {
+		t.Fatalf("edition children = %x", atomIDs)
+	}
+	if title := find(t, find(t, atoms[2], idChapterDisplay), idChapString); string(title) != "Main" {
+		t.Errorf("second chapter title = %q", title)
+	}
+	if start := find(t, atoms[2], idChapterTimeStart); binary.BigEndian.Uint32(start) != uint32(time.Second) {
+		t.Errorf("second chapter start = %x", start)
+	}
+	tag := find(t, find(t, payloads[4], idTag), idSimpleTag)
+	if name, value := find(t, tag, idTagName), find(t, tag, idTagString); string(name) != "TITLE" || string(value) != "Title" {
+		t.Errorf("first tag = %s=%s", name, value)
+	}
+	if data := find(t, find(t, payloads[5], idAttachedFile), idFileData); string(data) != "jpeg" {
+		t.Errorf("attachment data = %q", data)
+	}
+
+	// The seek head and the cues point at the moved elements.
+	at := map[int]uint32{}
+	for i, off := range offsets {
+		at[off] = ids[i]
+	}
+	seekIDs, seeks, _ := children(t, payloads[0])
+	if len(seekIDs) != 6 {
+		t.Errorf("seek head has %d entries, want 6", len(seekIDs))
+	}
+	for _, s := range seeks {
+		id, _ := readVint(find(t, s, idSeekID), true)
+		pos := binary.BigEndian.Uint64(find(t, s, idSeekPosition))
+		if at[int(pos)] != uint32(id) {
+			t.Errorf("seek entry %x points at element %x", id, at[int(pos)])
+		}
+	}
+	_, points, _ := children(t, payloads[9])
+	for _, p := range points {
+		pos := find(t, find(t, p, idCueTrackPositions), idCueClusterPosition)
+		if at[int(binary.BigEndian.Uint64(pos))] != idCluster {
+			t.Errorf("cue points at %x, not a cluster", pos)
+		}
+	}
+
+	// The frames are unchanged.
+	r, err := NewReader(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+	var got [2][][]byte
+	for {
+		f, err := r.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadFrame() error = %v", err)
+		}
+		got[f.Track-1] = append(got[f.Track-1], f.Data)
+	}
+	for i, want := range [][][]byte{videoData, audioData} {
+		if len(got[i]) != len(want) {
+			t.Fatalf("track %d: got %d frames, want %d", i+1, len(got[i]), len(want))
+		}
+		for k := range want {
+			if !bytes.Equal(got[i][k], want[k]) {
+				t.Fatalf("track %d frame %d = %x, want %x", i+1, k, got[i][k], want[k])
+			}
+		}
+	}
+
+	// Writing again replaces the metadata.
+	var again bytes.Buffer
+	if err := WriteMetadata(&again, bytes.NewReader(file), int64(len(file)), Metadata{Tags: []Tag{{"TITLE", "New"}}}); err != nil {
+		t.Fatalf("WriteMetadata() again error = %v", err)
+	}
+	ids, _, _ = children(t, find(t, again.Bytes(), idSegment))
+	if len(ids) != 8 || ids[3] != idTags || ids[4] != idCluster {
+		t.Errorf("segment children after rewrite = %x", ids)
+	}
+}
+
+func TestWriteMetadataInvalid(t *testing.T) {
+	for name, file := range map[string][]byte{
+		"no header":    el(idSegment),
+		"no tracks":    append(el(idEBML), el(idSegment, el(idInfo))...),
+		"unknown size": append(el(idEBML), append(appendID(nil, idSegment), 0xFF)...),
+		"live cluster": append(el(idEBML), el(idSegment, el(idTracks), append(appendID(nil, idCluster), 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF))...),
+	} {
+		err := WriteMetadata(io.Discard, bytes.NewReader(file), int64(len(file)), Metadata{})
+		if !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: error = %v, want ErrInvalid", name, err)
+		}
+	}
+}
@@ -0,0 +1,507 @@
+// Package webm implements a minimal streaming WebM (Matroska) demuxer that
+// exposes track headers and the raw frames of every block.
+package webm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Element IDs used by the demuxer.
+const (
+	idEBML            = 0x1A45DFA3
+	idSegment         = 0x18538067
+	idInfo            = 0x1549A966
+	idTimecodeScale   = 0x2AD7B1
+	idTracks          = 0x1654AE6B
+	idTrackEntry      = 0xAE
+	idTrackNumber     = 0xD7
+	idTrackType       = 0x83
+	idCodecID         = 0x86
+	idCodecPrivate    = 0x63A2
+	idCodecDelay      = 0x56AA
+	idSeekPreRoll     = 0x56BB
+	idVideo           = 0xE0
+	idPixelWidth      = 0xB0
+	idPixelHeight     = 0xBA
+	idAudio           = 0xE1
+	idSamplingFreq    = 0xB5
+	idChannels        = 0x9F
+	idCluster         = 0x1F43B675
+	idClusterTimecode = 0xE7
+	idSimpleBlock     = 0xA3
+	idBlockGroup      = 0xA0
+	idBlock           = 0xA1
+	idReferenceBlock  = 0xFB
+	idDiscardPadding  = 0x75A2
+)
+
+const (
+	defaultTimecodeScale = 1000000 // 1ms in nanoseconds
+	maxElementSize       = 64 << 20
+	unknownSize          = -1
+)
+
+// Track types of a TrackEntry.
+const (
+	TrackTypeVideo = 1
+	TrackTypeAudio = 2
+)
+
+// ErrInvalid is returned for input that is not a well-formed WebM stream.
+var ErrInvalid = errors.New("webm: invalid stream")
+
+// Track describes a track entry of the Tracks element.
+type Track struct {
+	Number uint64
+	// Type is TrackTypeVideo, TrackTypeAudio or another Matroska track type.
+	Type              uint64
+	CodecID           string
+	CodecPrivate      []byte
+	CodecDelay        time.Duration
+	SeekPreRoll       time.Duration
+	SamplingFrequency float64
+	Channels          int
+	PixelWidth        int
+	PixelHeight       int
+}
+
+// Frame is a single frame of a SimpleBlock or Block.
+type Frame struct {
+	Track    uint64
+	Timecode time.Duration
+	Keyframe bool
+	Data     []byte
+	// DiscardPadding is the duration to drop from the end of the frame, as
+	// set on the last block of Opus streams.
+	DiscardPadding time.Duration
+}
+
+// Reader demultiplexes a WebM stream.
+type Reader struct {
+	r             *bufio.Reader
+	tracks        []Track
+	timecodeScale int64
+	clusterTime   int64
+	pending       []Frame
+}
+
+// NewReader reads the stream header up to the first cluster and returns a
+// Reader positioned at the first frame.
+func NewReader(r io.Reader) (*Reader, error) {
+	wr := &Reader{r: bufio.NewReaderSize(r, 64<<10), timecodeScale: defaultTimecodeScale}
+	id, size, err := wr.readHeader()
+	if err != nil {
+		return nil, err
+	}
+	if id != idEBML {
+		return nil, fmt.Errorf("%w: missing EBML header", ErrInvalid)
+	}
+	if err := wr.skip(size); err != nil {
+		return nil, err
+	}
+	for {
+		id, size, err := wr.readHeader()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: no clusters", ErrInvalid)
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch id {
+		case idSegment, idInfo, idTracks, idAudio, idVideo:
+			// Master elements: descend into their children.
+		case idTrackEntry:
+			wr.tracks = append(wr.tracks, Track{})
+		case idCluster:
+			if len(wr.tracks) == 0 {
+				return nil, fmt.Errorf("%w: no tracks", ErrInvalid)
+			}
+			return wr, nil
+		default:
+			if err := wr.readHeaderChild(id, size); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// Tracks returns the track entries of the stream.
+func (wr *Reader) Tracks() []Track {
+	return wr.tracks
+}
+
+// ReadFrame returns the next frame. It returns io.EOF at the end of the stream.
+func (wr *Reader) ReadFrame() (Frame, error) {
+	for len(wr.pending) == 0 {
+		id, size, err := wr.readHeader()
+		if err != nil {
+			return Frame{}, err
+		}
+		switch id {
+		case idCluster:
+			// Master element: descend into its children.
+		case idClusterTimecode:
+			v, err := wr.readUint(size)
+			if err != nil {
+				return Frame{}, err
+			}
+			wr.clusterTime = int64(v)
+		case idSimpleBlock:
+			data, err := wr.readBytes(size)
+			if err != nil {
+				return Frame{}, err
+			}
+			if wr.pending, err = wr.parseBlock(data, true); err != nil {
+				return Frame{}, err
+			}
+		case idBlockGroup:
+			data, err := wr.readBytes(size)
+			if err != nil {
+				return Frame{}, err
+			}
+			if wr.pending, err = wr.parseBlockGroup(data); err != nil {
+				return Frame{}, err
+			}
+		default:
+			if err := wr.skip(size); err != nil {
+				return Frame{}, err
+			}
+		}
+	}
+	f := wr.pending[0]
+	wr.pending = wr.pending[1:]
+	return f, nil
+}
+
+// readHeaderChild stores a child element of Info or a TrackEntry.
+func (wr *Reader) readHeaderChild(id uint32, size int64) error {
+	var t *Track
+	if len(wr.tracks) > 0 {
+		t = &wr.tracks[len(wr.tracks)-1]
+	}
+	switch {
+	case id == idTimecodeScale:
+		v, err := wr.readUint(size)
+		if err != nil {
+			return err
+		}
+		if v > 0 {
+			wr.timecodeScale = int64(v)
+		}
+	case t == nil:
+		return wr.skip(size)
+	case id == idTrackNumber:
+		v, err := wr.readUint(size)
+		t.Number = v
+		return err
+	case id == idTrackType:
+		v, err := wr.readUint(size)
+		t.Type = v
+		return err
+	case id == idCodecID:
+		b, err := wr.readBytes(size)
+		t.CodecID = string(b)
+		return err
+	case id == idCodecPrivate:
+		b, err := wr.readBytes(size)
+		t.CodecPrivate = b
+		return err
+	case id == idCodecDelay:
+		v, err := wr.readUint(size)
+		t.CodecDelay = time.Duration(v)
+		return err
+	case id == idSeekPreRoll:
+		v, err := wr.readUint(size)
+		t.SeekPreRoll = time.Duration(v)
+		return err
+	case id == idSamplingFreq:
+		v, err := wr.readFloat(size)
+		t.SamplingFrequency = v
+		return err
+	case id == idChannels:
+		v, err := wr.readUint(size)
+		t.Channels = int(v)
+		return err
+	case id == idPixelWidth:
+		v, err := wr.readUint(size)
+		t.PixelWidth = int(v)
+		return err
+	case id == idPixelHeight:
+		v, err := wr.readUint(size)
+		t.PixelHeight = int(v)
+		return err
+	default:
+		return wr.skip(size)
+	}
+	return nil
+}
+
+// parseBlockGroup returns the frames of the Block in a BlockGroup, applying
+// its DiscardPadding to the last frame. Blocks with a ReferenceBlock are not
+// keyframes.
+func (wr *Reader) parseBlockGroup(data []byte) ([]Frame, error) {
+	var frames []Frame
+	var padding time.Duration
+	keyframe := true
+	for len(data) > 0 {
+		id, n := readVint(data, true)
+		size, m := readVint(data[n:], false)
+		if n == 0 || m == 0 || uint64(len(data)-n-m) < size {
+			return nil, fmt.Errorf("%w: truncated block group", ErrInvalid)
+		}
+		body := data[n+m : n+m+int(size)]
+		data = data[n+m+int(size):]
+		switch id {
+		case idBlock:
+			f, err := wr.parseBlock(body, false)
+			if err != nil {
+				return nil, err
+			}
+			frames = f
+		case idDiscardPadding:
+			var v uint64
+			for _, c := range body {
+				v = v<<8 | uint64(c)
+			}
+			padding = time.Duration(signExtend(v, int64(len(body))))
+		case idReferenceBlock:
+			keyframe = false
+		}
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("%w: block group without block", ErrInvalid)
+	}
+	for i := range frames {
+		frames[i].Keyframe = keyframe
+	}
+	frames[len(frames)-1].DiscardPadding = padding
+	return frames, nil
+}
+
+// parseBlock splits a (Simple)Block payload into frames, handling lacing.
+func (wr *Reader) parseBlock(data []byte, simple bool) ([]Frame, error) {
+	track, n := readVint(data, false)
+	if n == 0 || len(data) < n+3 {
+		return nil, fmt.Errorf("%w: short block", ErrInvalid)
+	}
+	rel := int16(binary.BigEndian.Uint16(data[n:]))
+	flags := data[n+2]
+	payload := data[n+3:]
+	ts := time.Duration((wr.clusterTime + int64(rel)) * wr.timecodeScale)
+	keyframe := !simple || flags&0x80 != 0
+
+	sizes, payload, err := laceSizes(flags&0x06, payload)
+	if err != nil {
+		return nil, err
+	}
+	frames := make([]Frame, 0, len(sizes))
+	for _, sz := range sizes {
+		frames = append(frames, Frame{Track: track, Timecode: ts, Keyframe: keyframe, Data: payload[:sz]})
+		payload = payload[sz:]
+	}
+	return frames, nil
+}
+
+// laceSizes returns the frame sizes of a laced block payload and the payload
+// without the lacing header.
+func laceSizes(lacing byte, p []byte) ([]int, []byte, error) {
+	if lacing == 0 {
+		return []int{len(p)}, p, nil
+	}
+	if len(p) < 1 {
+		return nil, nil, fmt.Errorf("%w: short laced block", ErrInvalid)
+	}
+	count := int(p[0]) + 1
+	p = p[1:]
+	sizes := make([]int, count)
+	switch lacing {
+	case 0x02: // Xiph
+		for i := 0; i < count-1; i++ {
+			for {
+				if len(p) == 0 {
+					return nil, nil, fmt.Errorf("%w: short Xiph lacing", ErrInvalid)
+				}
+				b := p[0]
+				p = p[1:]
+				sizes[i] += int(b)
+				if b != 255 {
+					break
+				}
+			}
+		}
+	case 0x04: // fixed-size
+		if len(p)%count != 0 {
+			return nil, nil, fmt.Errorf("%w: uneven fixed-size lacing", ErrInvalid)
+		}
+		for i := 0; i < count-1; i++ {
+			sizes[i] = len(p) / count
+		}
+	case 0x06: // EBML
+		v, n := readVint(p, false)
+		if n == 0 {
+			return nil, nil, fmt.Errorf("%w: bad EBML lacing", ErrInvalid)
+		}
+		p = p[n:]
+		sizes[0] = int(v)
+		for i := 1; i < count-1; i++ {
+			raw, n := readVint(p, false)
+			if n == 0 {
+				return nil, nil, fmt.Errorf("%w: bad EBML lacing", ErrInvalid)
+			}
+			p = p[n:]
+			// Differences are stored as signed values biased by 2^(7n-1)-1.
+			diff := int64(raw) - (int64(1)<<(7*n-1) - 1)
+			sizes[i] = sizes[i-1] + int(diff)
+		}
+	}
+	total := 0
+	for i := 0; i < count-1; i++ {
+		if sizes[i] < 0 {
+			return nil, nil, fmt.Errorf("%w: negative lace size", ErrInvalid)
+		}
+		total += sizes[i]
+	}
+	if total > len(p) {
+		return nil, nil, fmt.Errorf("%w: lace sizes exceed block", ErrInvalid)
+	}
+	sizes[count-1] = len(p) - total
+	return sizes, p, nil
+}
+
+// readHeader reads an element ID and data size. Unknown sizes are returned
+// as -1.
+func (wr *Reader) readHeader() (uint32, int64, error) {
+	id, err := wr.readVintRaw(true)
+	if err != nil {
+		return 0, 0, err
+	}
+	size, err := wr.readVintRaw(false)
+	if err != nil {
+		return 0, 0, unexpected(err)
+	}
+	return uint32(id), size, nil
+}
+
+// readVintRaw reads a variable-length integer from the stream. With keepMarker
+// the length marker bit is kept (element IDs); otherwise it is stripped and an
+// all-ones value yields -1 (unknown size).
+func (wr *Reader) readVintRaw(keepMarker bool) (int64, error) {
+	first, err := wr.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	n := 1
+	for mask := byte(0x80); n <= 8 && first&mask == 0; mask >>= 1 {
+		n++
+	}
+	if n > 8 || keepMarker && n > 4 {
+		return 0, fmt.Errorf("%w: bad variable-length integer", ErrInvalid)
+	}
+	buf := make([]byte, n)
+	buf[0] = first
+	if _, err := io.ReadFull(wr.r, buf[1:]); err != nil {
+		return 0, unexpected(err)
+	}
+	v, _ := readVint(buf, keepMarker)
+	if !keepMarker && v == uint64(1)<<(7*n)-1 {
+		return unknownSize, nil
+	}
+	return int64(v), nil
+}
+
+// readVint decodes a variable-length integer from b and returns it with its
+// length, or a zero length when b is too short.
+func readVint(b []byte, keepMarker bool) (uint64, int) {
+	if len(b) == 0 {
+		return 0, 0
+	}
+	n := 1
+	for mask := byte(0x80); n <= 8 && b[0]&mask == 0; mask >>= 1 {
+		n++
+	}
+	if n > 8 || len(b) < n {
+		return 0, 0
+	}
+	v := uint64(b[0])
+	if !keepMarker {
+		v &= uint64(0xFF >> n)
+	}
+	for i := 1; i < n; i++ {
+		v = v<<8 | uint64(b[i])
+	}
+	return v, n
+}
+
+func (wr *Reader) readBytes(size int64) ([]byte, error) {
+	if size < 0 || size > maxElementSize {
+		return nil, fmt.Errorf("%w: element size %d", ErrInvalid, size)
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(wr.r, b); err != nil {
+		return nil, unexpected(err)
+	}
+	return b, nil
+}
+
+func (wr *Reader) readUint(size int64) (uint64, error) {
+	if size > 8 {
+		return 0, fmt.Errorf("%w: integer of %d bytes", ErrInvalid, size)
+	}
+	b, err := wr.readBytes(size)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+func (wr *Reader) readFloat(size int64) (float64, error) {
+	b, err := wr.readBytes(size)
+	if err != nil {
+		return 0, err
+	}
+	switch len(b) {
+	case 0:
+		return 0, nil
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	}
+	return 0, fmt.Errorf("%w: float of %d bytes", ErrInvalid, len(b))
+}
+
+func (wr *Reader) skip(size int64) error {
+	if size < 0 {
+		return fmt.Errorf("%w: cannot skip element of unknown size", ErrInvalid)
+	}
+	if _, err := wr.r.Discard(int(size)); err != nil {
+		return unexpected(err)
+	}
+	return nil
+}
+
+// signExtend interprets the size-byte big-endian value v as a signed integer.
+func signExtend(v uint64, size int64) int64 {
+	if size <= 0 || size >= 8 {
+		return int64(v)
+	}
+	shift := 64 - 8*uint(size)
+	return int64(v<<shift) >> shift
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
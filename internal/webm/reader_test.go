@@ -0,0 +1,140 @@
+package webm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"testing"
+	"time"
+)
+
+// el encodes an EBML element with a minimal-length size.
+func el(id uint32, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	var out []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if b := byte(id >> shift); b != 0 || len(out) > 0 {
+			out = append(out, b)
+		}
+	}
+	return append(append(out, vint(uint64(len(body)))...), body...)
+}
+
+func vint(v uint64) []byte {
+	n := 1
+	for v >= uint64(1)<<(7*n)-1 {
+		n++
+	}
+	b := make([]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		b[i] = byte(v)
+		v >>= 8
+	}
+	b[0] |= 0x80 >> (n - 1)
+	return b
+}
+
+func uintEl(id uint32, v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return el(id, bytes.TrimLeft(b, "\x00"))
+}
+
+func block(track byte, rel int16, flags byte, payload ...byte) []byte {
+	b := []byte{0x80 | track, byte(uint16(rel) >> 8), byte(rel), flags}
+	return append(b, payload...)
+}
+
+func testStream() []byte {
+	freq := make([]byte, 8)
+	binary.BigEndian.PutUint64(freq, math.Float64bits(48000))
+	header := el(idEBML, el(0x4282, []byte("webm")))
+	tracks := el(idTracks,
+		el(idTrackEntry,
+			uintEl(idTrackNumber, 1),
+			el(idCodecID, []byte("A_OPUS")),
+			el(idCodecPrivate, []byte("OpusHead")),
+			uintEl(idCodecDelay, 6500000),
+			el(idAudio, el(idSamplingFreq, freq), uintEl(idChannels, 2)),
+		),
+	)
+	cluster1 := el(idCluster,
+		uintEl(idClusterTimecode, 0),
+		el(idSimpleBlock, block(1, 0, 0x80, 'a')),
+		// Xiph lacing: three frames of sizes 2, 1 and the remainder.
+		el(idSimpleBlock, block(1, 20, 0x80|0x02, 2, 2, 1, 'b', 'b', 'c', 'd', 'd', 'd')),
+		// EBML lacing: sizes 1, 1+1 and the remainder.
+		el(idSimpleBlock, block(1, 40, 0x80|0x06, 2, 0x81, 0xC0, 'e', 'f', 'f', 'g')),
+	)
+	cluster2 := el(idCluster,
+		uintEl(idClusterTimecode, 100),
+		el(0xEC, []byte{0, 0}), // Void
+		el(idBlockGroup, el(idBlock, block(1, 0, 0, 'i')), el(idReferenceBlock, []byte{0xEC})),
+		el(idBlockGroup, el(idBlock, block(1, 0, 0, 'h')), uintEl(idDiscardPadding, 5000000)),
+	)
+	segment := append([]byte{0x18, 0x53, 0x80, 0x67, 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, // unknown size
+		el(idInfo, uintEl(idTimecodeScale, 1000000))...)
+	segment = append(segment, tracks...)
+	segment = append(segment, cluster1...)
+	segment = append(segment, cluster2...)
+	return append(header, segment...)
+}
+
+func TestReader(t *testing.T) {
+	r, err := NewReader(bytes.NewReader(testStream()))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	tracks := r.Tracks()
+	if len(tracks) != 1 {
+		t.Fatalf("expected 1 track, got %d", len(tracks))
+	}
+	tr := tracks[0]
+	if tr.Number != 1 || tr.CodecID != "A_OPUS" || string(tr.CodecPrivate) != "OpusHead" ||
+		tr.CodecDelay != 6500*time.Microsecond || tr.SamplingFrequency != 48000 || tr.Channels != 2 {
+		t.Errorf("unexpected track: %+v", tr)
+	}
+
+	want := []struct {
+		data string
+		ts   time.Duration
+	}{
+		{"a", 0}, {"bb", 20 * time.Millisecond}, {"c", 20 * time.Millisecond}, {"ddd", 20 * time.Millisecond},
+		{"e", 40 * time.Millisecond}, {"ff", 40 * time.Millisecond}, {"g", 40 * time.Millisecond},
+		{"i", 100 * time.Millisecond}, {"h", 100 * time.Millisecond},
+	}
+	for i, w := range want {
+		f, err := r.ReadFrame()
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if string(f.Data) != w.data || f.Timecode != w.ts || f.Track != 1 {
+			t.Errorf("frame %d: got %q at %v, want %q at %v", i, f.Data, f.Timecode, w.data, w.ts)
+		}
+		if f.Keyframe != (w.data != "i") {
+			t.Errorf("frame %d: keyframe = %v", i, f.Keyframe)
+		}
+		if i == len(want)-1 && f.DiscardPadding != 5*time.Millisecond {
+			t.Errorf("expected discard padding on last frame, got %v", f.DiscardPadding)
+		}
+	}
+	if _, err := r.ReadFrame(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestReaderInvalid(t *testing.T) {
+	for _, in := range [][]byte{
+		[]byte("not webm at all"),
+		el(idEBML),
+		testStream()[:60],
+	} {
+		if _, err := NewReader(bytes.NewReader(in)); err == nil {
+			t.Errorf("expected error for %q", in)
+		} else if !errors.Is(err, ErrInvalid) && !errors.Is(err, io.ErrUnexpectedEOF) && err != io.EOF {
+			t.Errorf("unexpected error type: %v", err)
+		}
+	}
+}
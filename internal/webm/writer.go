@@ -0,0 +1,435 @@
+package webm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Element IDs used by the muxer only.
+const (
+	idEBMLVersion        = 0x4286
+	idEBMLReadVersion    = 0x42F7
+	idEBMLMaxIDLength    = 0x42F2
+	idEBMLMaxSizeLength  = 0x42F3
+	idDocType            = 0x4282
+	idDocTypeVersion     = 0x4287
+	idDocTypeReadVersion = 0x4285
+	idSeekHead           = 0x114D9B74
+	idSeek               = 0x4DBB
+	idSeekID             = 0x53AB
+	idSeekPosition       = 0x53AC
+	idMuxingApp          = 0x4D80
+	idWritingApp         = 0x5741
+	idDuration           = 0x4489
+	idTrackUID           = 0x73C5
+	idFlagLacing         = 0x9C
+	idCues               = 0x1C53BB6B
+	idCuePoint           = 0xBB
+	idCueTime            = 0xB3
+	idCueTrackPositions  = 0xB7
+	idCueTrack           = 0xF7
+	idCueClusterPosition = 0xF1
+)
+
+// Doc types written by Mux. WebM only allows VP8, VP9 and AV1 video with
+// Vorbis or Opus audio; other codecs need Matroska.
+const (
+	DocTypeWebM     = "webm"
+	DocTypeMatroska = "matroska"
+)
+
+const (
+	muxingApp = "ytdlp"
+	// maxClusterDuration is the length after which an audio-only cluster is
+	// closed. Clusters with video start at keyframes.
+	maxClusterDuration = 5000 // ms
+	// maxBlockOffset is the largest block timecode relative to its cluster.
+	maxBlockOffset = math.MaxInt16
+)
+
+// Block describes a frame written by Mux. Its data comes from the Data
+// function of its MuxTrack.
+type Block struct {
+	Timecode time.Duration
+	// Duration only counts towards the file duration; zero means the
+	// distance to the previous block.
+	Duration time.Duration
+	Keyframe bool
+	Size     int
+	// DiscardPadding is the duration to drop from the end of the frame.
+	DiscardPadding time.Duration
+}
+
+// MuxTrack is a track written by Mux: its header and its blocks in decode
+// order.
+type MuxTrack struct {
+	Track  Track
+	Blocks []Block
+	// Data returns the data of the next block of the track. Mux calls it
+	// once per block, in order.
+	Data func() ([]byte, error)
+}
+
+// muxBlock is a block placed in the output.
+type muxBlock struct {
+	track int
+	index int
+	time  int64 // ms
+}
+
+// muxCluster is a cluster of the output with its position in the segment.
+type muxCluster struct {
+	time    int64 // ms
+	blocks  []muxBlock
+	pos     int64
+	payload int64
+}
+
+// Mux writes the tracks as a Matroska file of the given doc type (DocTypeWebM
+// or DocTypeMatroska) to w. The blocks of all tracks are interleaved by
+// timecode; clusters start at video keyframes, and each such cluster gets a
+// cue point so that players can seek. The segment carries the duration and a
+// seek head pointing to the cues at the end. Timecodes are written in
+// milliseconds.
+func Mux(w io.Writer, docType string, tracks ...MuxTrack) error {
+	if len(tracks) == 0 || len(tracks) > 126 {
+		return fmt.Errorf("webm: cannot mux %d tracks", len(tracks))
+	}
+	video := -1
+	for i, t := range tracks {
+		if len(t.Blocks) == 0 {
+			return fmt.Errorf("webm: track %d has no blocks", i+1)
+		}
+		if t.Track.Type == TrackTypeVideo && video < 0 {
+			video = i
+		}
+	}
+	clusters := layoutClusters(tracks, video)
+
+	info := element(idInfo,
+		uintElement(idTimecodeScale, defaultTimecodeScale),
+		element(idMuxingApp, []byte(muxingApp)),
+		element(idWritingApp, []byte(muxingApp)),
+		floatElement(idDuration, float64(duration(tracks))/float64(time.Millisecond)))
+	trackEntries := make([][]byte, len(tracks))
+	for i, t := range tracks {
+		trackEntries[i] = trackEntry(t.Track, uint64(i+1))
+	}
+	trackList := element(idTracks, trackEntries...)
+
+	// The seek head has a fixed size, so the positions it holds can be
+	// computed first.
+	seekHead := seekHeadElement(seekEntry{idInfo, 0}, seekEntry{idTracks, 0}, seekEntry{idCues, 0})
+	pos := int64(len(seekHead) + len(info) + len(trackList))
+	for i := range clusters {
+		c := &clusters[i]
+		c.pos = pos
+		c.payload = clusterPayload(tracks, c)
+		pos += int64(len(elementHeader(idCluster, uint64(c.payload)))) + c.payload
+	}
+	cues := cuesElement(clusters, tracks, video)
+	infoPos := int64(len(seekHead))
+	seekHead = seekHeadElement(seekEntry{idInfo, infoPos}, seekEntry{idTracks, infoPos + int64(len(info))}, seekEntry{idCues, pos})
+	segmentSize := pos + int64(len(cues))
+
+	bw := bufio.NewWriterSize(w, 1<<20)
+	header := element(idEBML,
+		uintElement(idEBMLVersion, 1),
+		uintElement(idEBMLReadVersion, 1),
+		uintElement(idEBMLMaxIDLength, 4),
+		uintElement(idEBMLMaxSizeLength, 8),
+		element(idDocType, []byte(docType)),
+		uintElement(idDocTypeVersion, 4),
+		uintElement(idDocTypeReadVersion, 2))
+	header = appendVint8(appendID(header, idSegment), uint64(segmentSize))
+	for _, p := range [][]byte{header, seekHead, info, trackList} {
+		if _, err := bw.Write(p); err != nil {
+			return err
+		}
+	}
+	for i := range clusters {
+		if err := writeCluster(bw, tracks, &clusters[i]); err != nil {
+			return err
+		}
+	}
+	if _, err := bw.Write(cues); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// layoutClusters interleaves the blocks of all tracks by timecode and groups
+// them into clusters.
+func layoutClusters(tracks []MuxTrack, video int) []muxCluster {
+	var clusters []muxCluster
+	next := make([]int, len(tracks))
+	for {
+		pick := -1
+		for i, t := range tracks {
+			if next[i] < len(t.Blocks) && (pick < 0 || t.Blocks[next[i]].Timecode < tracks[pick].Blocks[next[pick]].Timecode) {
+				pick = i
+			}
+		}
+		if pick < 0 {
+			return clusters
+		}
+		b := tracks[pick].Blocks[next[pick]]
+		mb := muxBlock{track: pick, index: next[pick], time: millis(b.Timecode)}
+		next[pick]++
+
+		var cur *muxCluster
+		if len(clusters) > 0 {
+			cur = &clusters[len(clusters)-1]
+		}
+		switch {
+		case cur == nil:
+		case pick == video && b.Keyframe:
+			cur = nil
+		case video < 0 && mb.time-cur.time >= maxClusterDuration:
+			cur = nil
+		case mb.time-cur.time > maxBlockOffset || mb.time < cur.time+math.MinInt16:
+			cur = nil
+		}
+		if cur == nil {
+			clusters = append(clusters, muxCluster{time: mb.time})
+			cur = &clusters[len(clusters)-1]
+		}
+		cur.blocks = append(cur.blocks, mb)
+	}
+}
+
+// duration returns the end of the last frame of all tracks.
+func duration(tracks []MuxTrack) time.Duration {
+	var end time.Duration
+	for _, t := range tracks {
+		var last time.Duration
+		for i, b := range t.Blocks {
+			d := b.Duration
+			if d == 0 && i > 0 {
+				d = max(b.Timecode-t.Blocks[i-1].Timecode, 0)
+			}
+			if d == 0 {
+				d = last
+			}
+			last = d
+			end = max(end, b.Timecode+d)
+		}
+	}
+	return end
+}
+
+// millis converts a timecode to milliseconds, rounding and clamping negative
+// values to zero.
+func millis(d time.Duration) int64 {
+	if d < 0 {
+		return 0
+	}
+	return int64((d + time.Millisecond/2) / time.Millisecond)
+}
+
+// blockElement returns what is written before and after the data of b at
+// rel ms from its cluster: the element header with the block header, and the
+// DiscardPadding of a BlockGroup. Blocks without discard padding are written
+// as SimpleBlocks.
+func blockElement(b Block, track int, rel int64) (before, after []byte) {
+	h := []byte{0x80 | byte(track), byte(uint16(rel) >> 8), byte(rel), 0}
+	blockSize := uint64(len(h) + b.Size)
+	if b.DiscardPadding == 0 {
+		if b.Keyframe {
+			h[3] = 0x80
+		}
+		return append(elementHeader(idSimpleBlock, blockSize), h...), nil
+	}
+	after = intElement(idDiscardPadding, int64(b.DiscardPadding))
+	block := elementHeader(idBlock, blockSize)
+	before = elementHeader(idBlockGroup, uint64(len(block))+blockSize+uint64(len(after)))
+	return append(append(before, block...), h...), after
+}
+
+// clusterPayload returns the payload size of the cluster c.
+func clusterPayload(tracks []MuxTrack, c *muxCluster) int64 {
+	n := int64(len(uintElement(idClusterTimecode, uint64(c.time))))
+	for _, mb := range c.blocks {
+		b := tracks[mb.track].Blocks[mb.index]
+		before, after := blockElement(b, mb.track+1, mb.time-c.time)
+		n += int64(len(before)+len(after)) + int64(b.Size)
+	}
+	return n
+}
+
+// writeCluster writes the cluster c, reading the data of its blocks.
+func writeCluster(w io.Writer, tracks []MuxTrack, c *muxCluster) error {
+	header := append(elementHeader(idCluster, uint64(c.payload)), uintElement(idClusterTimecode, uint64(c.time))...)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	for _, mb := range c.blocks {
+		t := tracks[mb.track]
+		b := t.Blocks[mb.index]
+		data, err := t.Data()
+		if err != nil {
+			return fmt.Errorf("webm: read block %d of track %d: %w", mb.index, mb.track+1, err)
+		}
+		if len(data) != b.Size {
+			return fmt.Errorf("webm: block %d of track %d has %d bytes, expected %d", mb.index, mb.track+1, len(data), b.Size)
+		}
+		before, after := blockElement(b, mb.track+1, mb.time-c.time)
+		for _, p := range [][]byte{before, data, after} {
+			if _, err := w.Write(p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// trackEntry encodes the TrackEntry of t with the given track number.
+func trackEntry(t Track, number uint64) []byte {
+	children := [][]byte{
+		uintElement(idTrackNumber, number),
+		uintElement(idTrackUID, number),
+		uintElement(idTrackType, t.Type),
+		uintElement(idFlagLacing, 0),
+		element(idCodecID, []byte(t.CodecID)),
+	}
+	if len(t.CodecPrivate) > 0 {
+		children = append(children, element(idCodecPrivate, t.CodecPrivate))
+	}
+	if t.CodecDelay > 0 {
+		children = append(children, uintElement(idCodecDelay, uint64(t.CodecDelay)))
+	}
+	if t.SeekPreRoll > 0 {
+		children = append(children, uintElement(idSeekPreRoll, uint64(t.SeekPreRoll)))
+	}
+	switch t.Type {
+	case TrackTypeVideo:
+		children = append(children, element(idVideo,
+			uintElement(idPixelWidth, uint64(t.PixelWidth)),
+			uintElement(idPixelHeight, uint64(t.PixelHeight))))
+	case TrackTypeAudio:
+		audio := [][]byte{floatElement(idSamplingFreq, t.SamplingFrequency)}
+		if t.Channels > 0 {
+			audio = append(audio, uintElement(idChannels, uint64(t.Channels)))
+		}
+		children = append(children, element(idAudio, audio...))
+	}
+	return element(idTrackEntry, children...)
+}
+
+// seekEntry is a level-1 element listed in a SeekHead, with its position in
+// the segment.
+type seekEntry struct {
+	id  uint32
+	pos int64
+}
+
+// seekHeadElement encodes a SeekHead pointing to the entries. The positions
+// are written with eight bytes so that the size does not depend on them.
+func seekHeadElement(entries ...seekEntry) []byte {
+	seeks := make([][]byte, len(entries))
+	for i, e := range entries {
+		seeks[i] = element(idSeek,
+			element(idSeekID, appendID(nil, e.id)),
+			element(idSeekPosition, binary.BigEndian.AppendUint64(nil, uint64(e.pos))))
+	}
+	return element(idSeekHead, seeks...)
+}
+
+// cuesElement encodes a cue point for every cluster that starts with a
+// keyframe of the video track, or for every cluster without video.
+func cuesElement(clusters []muxCluster, tracks []MuxTrack, video int) []byte {
+	var points [][]byte
+	for _, c := range clusters {
+		first := c.blocks[0]
+		if video >= 0 && (first.track != video || !tracks[video].Blocks[first.index].Keyframe) {
+			continue
+		}
+		points = append(points, element(idCuePoint,
+			uintElement(idCueTime, uint64(first.time)),
+			element(idCueTrackPositions,
+				uintElement(idCueTrack, uint64(first.track+1)),
+				uintElement(idCueClusterPosition, uint64(c.pos)))))
+	}
+	return element(idCues, points...)
+}
+
+// element encodes an EBML element.
+func element(id uint32, payload ...[]byte) []byte {
+	n := 0
+	for _, p := range payload {
+		n += len(p)
+	}
+	b := elementHeader(id, uint64(n))
+	for _, p := range payload {
+		b = append(b, p...)
+	}
+	return b
+}
+
+// elementHeader encodes the ID and size of an element.
+func elementHeader(id uint32, size uint64) []byte {
+	return appendVint(appendID(nil, id), size)
+}
+
+// uintElement encodes an unsigned integer element with as few bytes as
+// possible (at least one).
+func uintElement(id uint32, v uint64) []byte {
+	b := binary.BigEndian.AppendUint64(nil, v)
+	for len(b) > 1 && b[0] == 0 {
+		b = b[1:]
+	}
+	return element(id, b)
+}
+
+// intElement encodes a signed integer element.
+func intElement(id uint32, v int64) []byte {
+	b := binary.BigEndian.AppendUint64(nil, uint64(v))
+	for len(b) > 1 && (b[0] == 0 && b[1]&0x80 == 0 || b[0] == 0xFF && b[1]&0x80 != 0) {
+		b = b[1:]
+	}
+	return element(id, b)
+}
+
+func floatElement(id uint32, v float64) []byte {
+	return element(id, binary.BigEndian.AppendUint64(nil, math.Float64bits(v)))
+}
+
+// appendID appends an element ID, whose marker bits are part of the value.
+func appendID(b []byte, id uint32) []byte {
+	started := false
+	for shift := 24; shift >= 0; shift -= 8 {
+		if c := byte(id >> shift); c != 0 || started {
+			b = append(b, c)
+			started = true
+		}
+	}
+	return b
+}
+
+// appendVint appends v as a variable-length integer of minimal length. The
+// all-ones value of each length is reserved for unknown sizes.
+func appendVint(b []byte, v uint64) []byte {
+	n := 1
+	for n < 8 && v >= uint64(1)<<(7*n)-1 {
+		n++
+	}
+	return appendVintN(b, v, n)
+}
+
+// appendVint8 appends v as an eight-byte variable-length integer.
+func appendVint8(b []byte, v uint64) []byte {
+	return appendVintN(b, v, 8)
+}
+
+func appendVintN(b []byte, v uint64, n int) []byte {
+	start := len(b)
+	for i := n - 1; i >= 0; i-- {
+		b = append(b, byte(v>>(8*i)))
+	}
+	b[start] |= 0x80 >> (n - 1)
+	return b
+}
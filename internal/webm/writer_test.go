@@ -0,0 +1,178 @@
+package webm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"testing"
+	"time"
+)
+
+// testMuxTrack returns a MuxTrack of n blocks every step, with a keyframe
+// every keyEvery blocks, and the data of each block.
+func testMuxTrack(track Track, n int, step time.Duration, keyEvery int) (MuxTrack, [][]byte) {
+	mt := MuxTrack{Track: track}
+	data := make([][]byte, n)
+	for i := range data {
+		data[i] = bytes.Repeat([]byte{byte(track.Type), byte(i)}, 3+i%5)
+		mt.Blocks = append(mt.Blocks, Block{
+			Timecode: time.Duration(i) * step,
+			Keyframe: i%keyEvery == 0,
+			Size:     len(data[i]),
+		})
+	}
+	next := 0
+	mt.Data = func() ([]byte, error) {
+		next++
+		return data[next-1], nil
+	}
+	return mt, data
+}
+
+// children splits an element payload into (id, payload, offset) triples.
+func children(t *testing.T, p []byte) (ids []uint32, payloads [][]byte, offsets []int) {
+	t.Helper()
+	for off := 0; off < len(p); {
+		id, n := readVint(p[off:], true)
+		size, m := readVint(p[off+n:], false)
+		if n == 0 || m == 0 || off+n+m+int(size) > len(p) {
+			t.Fatalf("bad element at offset %d", off)
+		}
+		ids = append(ids, uint32(id))
+		payloads = append(payloads, p[off+n+m:off+n+m+int(size)])
+		offsets = append(offsets, off)
+		off += n + m + int(size)
+	}
+	return ids, payloads, offsets
+}
+
+func find(t *testing.T, p []byte, id uint32) []byte {
+	t.Helper()
+	ids, payloads, _ := children(t, p)
+	for i := range ids {
+		if ids[i] == id {
+			return payloads[i]
+		}
+	}
+	t.Fatalf("element %x not found", id)
+	return nil
+}
+
+func TestMux(t *testing.T) {
+	video, videoData := testMuxTrack(Track{Type: TrackTypeVideo, CodecID: "V_VP9", PixelWidth: 1920, PixelHeight: 1080}, 100, 40*time.Millisecond, 25)
+	audio, audioData := testMuxTrack(Track{Type: TrackTypeAudio, CodecID: "A_OPUS", CodecPrivate: []byte("OpusHead"),
+		CodecDelay: 6500 * time.Microsecond, SeekPreRoll: 80 * time.Millisecond, SamplingFrequency: 48000, Channels: 2}, 200, 20*time.Millisecond, 1)
+	audio.Blocks[len(audio.Blocks)-1].DiscardPadding = 5 * time.Millisecond
+
+	var out bytes.Buffer
+	if err := Mux(&out, DocTypeWebM, video, audio); err != nil {
+		t.Fatalf("Mux() error = %v", err)
+	}
+	file := out.Bytes()
+
+	r, err := NewReader(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+	tracks := r.Tracks()
+	if len(tracks) != 2 || tracks[0].Type != TrackTypeVideo || tracks[0].PixelWidth != 1920 || tracks[0].PixelHeight != 1080 ||
+		tracks[1].CodecID != "A_OPUS" || tracks[1].Channels != 2 || tracks[1].SamplingFrequency != 48000 ||
+		tracks[1].CodecDelay != 6500*time.Microsecond || string(tracks[1].CodecPrivate) != "OpusHead" {
+		t.Fatalf("tracks = %+v", tracks)
+	}
+	var got [2][]Frame
+	var last time.Duration
+	for {
+		f, err := r.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadFrame() error = %v", err)
+		}
+		if f.Timecode < last {
+			t.Errorf("frame at %v after %v: tracks are not interleaved", f.Timecode, last)
+		}
+		last = f.Timecode
+		got[f.Track-1] = append(got[f.Track-1], f)
+	}
+	for i, want := range [][][]byte{videoData, audioData} {
+		src := []MuxTrack{video, audio}[i]
+		if len(got[i]) != len(want) {
+			t.Fatalf("track %d: %d frames, want %d", i+1, len(got[i]), len(want))
+		}
+		for k, f := range got[i] {
+			b := src.Blocks[k]
+			if !bytes.Equal(f.Data, want[k]) || f.Timecode != b.Timecode || f.Keyframe != b.Keyframe || f.DiscardPadding != b.DiscardPadding {
+				t.Fatalf("track %d frame %d = %+v, want %+v", i+1, k, f, b)
+			}
+		}
+	}
+
+	// Layout: EBML header, then a segment with the seek head, info, tracks,
+	// clusters and cues.
+	ids, payloads, _ := children(t, file)
+	if len(ids) != 2 || ids[0] != idEBML || ids[1] != idSegment {
+		t.Fatalf("top-level elements = %x", ids)
+	}
+	if docType := find(t, payloads[0], idDocType); string(docType) != DocTypeWebM {
+		t.Errorf("DocType = %q", docType)
+	}
+	segment := payloads[1]
+	ids, payloads, offsets := children(t, segment)
+	if ids[0] != idSeekHead || ids[1] != idInfo || ids[2] != idTracks || ids[len(ids)-1] != idCues {
+		t.Fatalf("segment elements = %x", ids)
+	}
+	info := find(t, segment, idInfo)
+	d := math.Float64frombits(binary.BigEndian.Uint64(find(t, info, idDuration)))
+	if d != 4000 {
+		t.Errorf("Duration = %v ms, want 4000", d)
+	}
+
+	clusterAt := map[uint64]bool{}
+	for i, id := range ids {
+		if id == idCluster {
+			clusterAt[uint64(offsets[i])] = true
+		}
+	}
+	if len(clusterAt) != 4 {
+		t.Errorf("got %d clusters, want one per video keyframe (4)", len(clusterAt))
+	}
+	_, seeks, _ := children(t, payloads[0])
+	cuesSeek := seeks[2]
+	if pos := binary.BigEndian.Uint64(find(t, cuesSeek, idSeekPosition)); pos != uint64(offsets[len(offsets)-1]) {
+		t.Errorf("seek head points to cues at %d, want %d", pos, offsets[len(offsets)-1])
+	}
+	_, points, _ := children(t, payloads[len(payloads)-1])
+	if len(points) != 4 {
+		t.Fatalf("got %d cue points, want 4", len(points))
+	}
+	for i, p := range points {
+		var v uint64
+		for _, c := range find(t, find(t, p, idCueTrackPositions), idCueClusterPosition) {
+			v = v<<8 | uint64(c)
+		}
+		if !clusterAt[v] {
+			t.Errorf("cue point %d points to %d, which is not a cluster", i, v)
+		}
+	}
+}
+
+func TestMuxErrors(t *testing.T) {
+	if err := Mux(io.Discard, DocTypeWebM); err == nil {
+		t.Error("expected an error without tracks")
+	}
+	audio, _ := testMuxTrack(Track{Type: TrackTypeAudio, CodecID: "A_OPUS"}, 3, 20*time.Millisecond, 1)
+	audio.Blocks[1].Size++
+	if err := Mux(io.Discard, DocTypeWebM, audio); err == nil {
+		t.Error("expected an error for a block of the wrong size")
+	}
+	readErr := errors.New("read failed")
+	audio, _ = testMuxTrack(Track{Type: TrackTypeAudio, CodecID: "A_OPUS"}, 3, 20*time.Millisecond, 1)
+	audio.Data = func() ([]byte, error) { return nil, readErr }
+	if err := Mux(io.Discard, DocTypeWebM, audio); !errors.Is(err, readErr) {
+		t.Errorf("error = %v, want %v", err, readErr)
+	}
+}
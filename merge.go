@@ -0,0 +1,399 @@
+package ytdlp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ytget/ytdlp/v2/downloader"
+	"github.com/ytget/ytdlp/v2/internal/logging"
+	"github.com/ytget/ytdlp/v2/internal/mimeext"
+	"github.com/ytget/ytdlp/v2/internal/mp4"
+	"github.com/ytget/ytdlp/v2/internal/webm"
+	"github.com/ytget/ytdlp/v2/postprocess"
+	"github.com/ytget/ytdlp/v2/types"
+)
+
+// mergeOrder returns the positions of the video-only and the audio-only
+// format in a merge selection.
+func mergeOrder(selected []types.Format) ([]int, error) {
+	if len(selected) != 2 {
+		return nil, fmt.Errorf("merge: need a video and an audio format, got %d formats", len(selected))
+	}
+	order := []int{0, 1}
+	if selected[0].HasAudio() {
+		order = []int{1, 0}
+	}
+	video, audio := selected[order[0]], selected[order[1]]
+	if !video.HasVideo() || video.HasAudio() || audio.HasVideo() || !audio.HasAudio() {
+		return nil, fmt.Errorf("merge: formats %d and %d are not one video-only and one audio-only format", selected[0].Itag, selected[1].Itag)
+	}
+	return order, nil
+}
+
+// mergeContainer returns the extension of the file that video and audio are
+// merged into: mp4 when both are MP4, webm when WebM allows both codecs and
+// mkv otherwise.
+func mergeContainer(video, audio types.Format) (string, error) {
+	vc, ac := downloader.ContainerFromMime(video.MimeType), downloader.ContainerFromMime(audio.MimeType)
+	if vc == downloader.ContainerMP4 && ac == downloader.ContainerMP4 {
+		return mimeext.ExtFromMime(video.MimeType), nil
+	}
+	if !matroskaCodec(vc, video.VCodec) {
+		return "", fmt.Errorf("merge: cannot merge video format %d (%s)", video.Itag, video.MimeType)
+	}
+	if !matroskaCodec(ac, audio.ACodec) {
+		return "", fmt.Errorf("merge: cannot merge audio format %d (%s)", audio.Itag, audio.MimeType)
+	}
+	if hasCodecPrefix(video.VCodec, "vp8", "vp9", "vp09", "av01") && hasCodecPrefix(audio.ACodec, "opus", "vorbis") {
+		return mimeext.ExtWebM, nil
+	}
+	return mimeext.ExtMKV, nil
+}
+
+// matroskaCodec reports whether codec in container c can be written to a
+// Matroska file by mergeMatroskaFiles.
+func matroskaCodec(c downloader.Container, codec string) bool {
+	switch c {
+	case downloader.ContainerMP4:
+		_, ok := mp4CodecIDs[strings.ToLower(codec[:min(len(codec), 4)])]
+		return ok
+	case downloader.ContainerWebM:
+		return true
+	}
+	return false
+}
+
+// hasCodecPrefix reports whether codec starts with one of prefixes, ignoring
+// case.
+func hasCodecPrefix(codec string, prefixes ...string) bool {
+	codec = strings.ToLower(codec)
+	for _, p := range prefixes {
+		if strings.HasPrefix(codec, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// downloadMerged downloads the video and audio formats of a merge selection
+// next to the output file and merges them into one MP4, WebM or Matroska file
+// (see mergeContainer), which it returns the path of. Pairs that none of
+// these can take are merged into Matroska by the post-processor, if one is
+// set. The parts are named
+// "<output>.f<itag>.<ext>", resume like any download and are removed after
+// merging.
+func (d *Downloader) downloadMerged(ctx context.Context, finalURLs []string, selected []types.Format, info *VideoInfo) (string, error) {
+	if d.options.ExtractAudio {
+		return "", fmt.Errorf("extract audio: selection of %d formats includes video", len(selected))
+	}
+	order, err := mergeOrder(selected)
+	if err != nil {
+		return "", err
+	}
+	ext, err := mergeContainer(selected[order[0]], selected[order[1]])
+	ffmpeg := false
+	if err != nil {
+		if d.options.PostProcessor == nil {
+			return "", err
+		}
+		// Pairs that the pure-Go muxers cannot take are merged by ffmpeg.
+		ext, ffmpeg = mimeext.ExtMKV, true
+	}
+	outputPath := d.outputPath(info.Title, "", ext)
+	base := strings.TrimSuffix(outputPath, filepath.Ext(outputPath))
+
+	parts := make([]string, len(order))
+	for i, k := range order {
+		f, finalURL := selected[k], finalURLs[k]
+		parts[i] = fmt.Sprintf("%s.f%d.%s", base, f.Itag, mimeext.ExtFromMime(f.MimeType))
+		d.log().Info("Downloading format",
+			slog.String(logging.KeyVideoID, info.ID), slog.Int(logging.KeyItag, f.Itag), logging.URL(finalURL))
+		dl := d.newFileDownloader()
+		d.configureFormat(dl, f, info)
+		// The checksum is of the merged file, not of the parts.
+		if err := dl.WithChecksum(false).Download(ctx, finalURL, parts[i]); err != nil {
+			return "", err
+		}
+	}
+
+	d.reportPhase(types.PhaseMerging)
+	d.log().Info("Merging formats", slog.String(logging.KeyVideoID, info.ID), slog.String("path", outputPath), slog.Bool("ffmpeg", ffmpeg))
+	switch {
+	case ffmpeg:
+		job := postprocess.Merge(outputPath, parts[0], parts[1])
+		job.Duration = time.Duration(info.Duration) * time.Second
+		err = d.runPostProcessor(ctx, job, types.PhaseMerging)
+	case ext == mimeext.ExtWebM || ext == mimeext.ExtMKV:
+		err = mergeMatroskaFiles(outputPath, ext, parts[0], parts[1])
+	default:
+		err = mergeMP4Files(outputPath, parts[0], parts[1])
+	}
+	if err != nil {
+		return "", fmt.Errorf("merge failed: %w", err)
+	}
+	for _, p := range parts {
+		if err := os.Remove(p); err != nil {
+			d.log().Warn("Failed to remove temporary file", slog.String("path", p), logging.Err(err))
+		}
+	}
+	return outputPath, d.finishOutput(ctx, outputPath, info)
+}
+
+// mergeMP4Files writes the video track of videoPath and the audio track of
+// audioPath into a new MP4 file at dst.
+func mergeMP4Files(dst, videoPath, audioPath string) error {
+	var tracks []*mp4.Track
+	for _, part := range []struct{ path, handler string }{{videoPath, "vide"}, {audioPath, "soun"}} {
+		f, err := os.Open(part.path)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		list, err := mp4.ReadTracks(f, fi.Size())
+		if err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(part.path), err)
+		}
+		var track *mp4.Track
+		for _, t := range list {
+			if t.Handler == part.handler {
+				track = t
+				break
+			}
+		}
+		if track == nil {
+			return fmt.Errorf("%s: no %q track", filepath.Base(part.path), part.handler)
+		}
+		tracks = append(tracks, track)
+	}
+	return muxMP4File(dst, tracks)
+}
+
+// muxMP4File writes tracks into a new MP4 file at dst.
+func muxMP4File(dst string, tracks []*mp4.Track) error {
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if err := mp4.Mux(out, tracks...); err != nil {
+		_ = out.Close()
+		_ = os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// mp4CodecIDs maps the sample entries of MP4 tracks that can be written to
+// Matroska to their codec IDs.
+var mp4CodecIDs = map[string]string{
+	"avc1": "V_MPEG4/ISO/AVC",
+	"avc3": "V_MPEG4/ISO/AVC",
+	"av01": "V_AV1",
+	"vp09": "V_VP9",
+	"mp4a": "A_AAC",
+}
+
+// mergeMatroskaFiles writes the video track of videoPath and the audio track
+// of audioPath, each an MP4 or WebM file, into a new file at dst: a WebM
+// file when ext is "webm", a Matroska file otherwise.
+func mergeMatroskaFiles(dst, ext, videoPath, audioPath string) error {
+	var tracks []webm.MuxTrack
+	for _, part := range []struct {
+		path      string
+		trackType uint64
+	}{{videoPath, webm.TrackTypeVideo}, {audioPath, webm.TrackTypeAudio}} {
+		var (
+			track webm.MuxTrack
+			c     io.Closer
+			err   error
+		)
+		if isMP4File(part.path) {
+			track, c, err = mp4MatroskaTrack(part.path, part.trackType)
+		} else {
+			track, c, err = webmMatroskaTrack(part.path, part.trackType)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(part.path), err)
+		}
+		defer func() { _ = c.Close() }()
+		tracks = append(tracks, track)
+	}
+
+	docType := webm.DocTypeMatroska
+	if ext == mimeext.ExtWebM {
+		docType = webm.DocTypeWebM
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if err := webm.Mux(out, docType, tracks...); err != nil {
+		_ = out.Close()
+		_ = os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// isMP4File reports whether the part at path is an MP4 file, by its
+// extension.
+func isMP4File(path string) bool {
+	ext := strings.TrimPrefix(filepath.Ext(path), ".")
+	return ext == mimeext.DefaultExt || ext == mimeext.ExtM4A
+}
+
+// webmMatroskaTrack reads the frames of the first track of trackType in the
+// WebM file at path. The data of the frames is read again while muxing, from
+// the returned file.
+func webmMatroskaTrack(path string, trackType uint64) (webm.MuxTrack, io.Closer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return webm.MuxTrack{}, nil, err
+	}
+	r, err := webm.NewReader(f)
+	if err != nil {
+		_ = f.Close()
+		return webm.MuxTrack{}, nil, err
+	}
+	var track *webm.Track
+	for _, t := range r.Tracks() {
+		if t.Type == trackType {
+			track = &t
+			break
+		}
+	}
+	if track == nil {
+		_ = f.Close()
+		return webm.MuxTrack{}, nil, fmt.Errorf("no track of type %d", trackType)
+	}
+	mt := webm.MuxTrack{Track: *track}
+	for {
+		fr, err := r.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			_ = f.Close()
+			return webm.MuxTrack{}, nil, err
+		}
+		if fr.Track == track.Number {
+			mt.Blocks = append(mt.Blocks, webm.Block{
+				Timecode:       fr.Timecode,
+				Keyframe:       fr.Keyframe,
+				Size:           len(fr.Data),
+				DiscardPadding: fr.DiscardPadding,
+			})
+		}
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		_ = f.Close()
+		return webm.MuxTrack{}, nil, err
+	}
+	if r, err = webm.NewReader(f); err != nil {
+		_ = f.Close()
+		return webm.MuxTrack{}, nil, err
+	}
+	mt.Data = func() ([]byte, error) {
+		for {
+			fr, err := r.ReadFrame()
+			if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			}
+			if err != nil || fr.Track == track.Number {
+				return fr.Data, err
+			}
+		}
+	}
+	return mt, f, nil
+}
+
+// mp4MatroskaTrack reads the samples of the first track of trackType in the
+// MP4 file at path, converting its codec configuration to Matroska. The
+// samples are read while muxing, from the returned file.
+func mp4MatroskaTrack(path string, trackType uint64) (webm.MuxTrack, io.Closer, error) {
+	handler := "soun"
+	if trackType == webm.TrackTypeVideo {
+		handler = "vide"
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return webm.MuxTrack{}, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return webm.MuxTrack{}, nil, err
+	}
+	list, err := mp4.ReadTracks(f, fi.Size())
+	if err != nil {
+		_ = f.Close()
+		return webm.MuxTrack{}, nil, err
+	}
+	for _, t := range list {
+		if t.Handler == handler {
+			mt, err := matroskaTrackFromMP4(t)
+			if err != nil {
+				_ = f.Close()
+				return webm.MuxTrack{}, nil, err
+			}
+			return mt, f, nil
+		}
+	}
+	_ = f.Close()
+	return webm.MuxTrack{}, nil, fmt.Errorf("no %q track", handler)
+}
+
+// matroskaTrackFromMP4 converts an MP4 track to a Matroska one.
+func matroskaTrackFromMP4(t *mp4.Track) (webm.MuxTrack, error) {
+	codec := t.Codec()
+	id, ok := mp4CodecIDs[codec]
+	if !ok {
+		return webm.MuxTrack{}, fmt.Errorf("cannot write %q samples to Matroska", codec)
+	}
+	track := webm.Track{CodecID: id}
+	var err error
+	switch codec {
+	case "avc1", "avc3":
+		track.CodecPrivate = t.CodecConfig("avcC")
+	case "av01":
+		track.CodecPrivate = t.CodecConfig("av1C")
+	case "mp4a":
+		if track.CodecPrivate, err = mp4.DecoderSpecificInfo(t.CodecConfig("esds")); err != nil {
+			return webm.MuxTrack{}, err
+		}
+	}
+	if t.Handler == "vide" {
+		track.Type = webm.TrackTypeVideo
+		track.PixelWidth, track.PixelHeight = t.VideoSize()
+	} else {
+		track.Type = webm.TrackTypeAudio
+		track.Channels, track.SamplingFrequency = t.AudioFormat()
+	}
+
+	mt := webm.MuxTrack{Track: track, Blocks: make([]webm.Block, len(t.Samples))}
+	for i, pts := range t.PresentationTimes() {
+		s := t.Samples[i]
+		mt.Blocks[i] = webm.Block{
+			Timecode: pts,
+			Duration: time.Duration(s.Duration) * time.Second / time.Duration(t.Timescale),
+			Keyframe: s.Sync,
+			Size:     int(s.Size),
+		}
+	}
+	next := 0
+	mt.Data = func() ([]byte, error) {
+		next++
+		return t.ReadSample(next - 1)
+	}
+	return mt, nil
+}
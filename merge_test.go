@@ -0,0 +1,300 @@
+package ytdlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ytget/ytdlp/v2/downloader"
+	"github.com/ytget/ytdlp/v2/internal/mimeext"
+	"github.com/ytget/ytdlp/v2/internal/mp4"
+	"github.com/ytget/ytdlp/v2/internal/webm"
+	"github.com/ytget/ytdlp/v2/types"
+)
+
+// mp4Box encodes an MP4 box from its type and payload parts.
+func mp4Box(typ string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	return append(append(binary.BigEndian.AppendUint32(nil, uint32(8+len(body))), typ...), body...)
+}
+
+func be32(v ...uint32) []byte {
+	var b []byte
+	for _, x := range v {
+		b = binary.BigEndian.AppendUint32(b, x)
+	}
+	return b
+}
+
+// fragmentedMP4 builds a DASH-style file with one track of n samples in a
+// single movie fragment.
+func fragmentedMP4(handler, codec string, timescale, duration uint32, n int) []byte {
+	matrix := be32(0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000)
+	tkhd := mp4Box("tkhd", be32(3, 0, 0, 1, 0, 0), make([]byte, 8+8), matrix, be32(0, 0))
+	mdhd := mp4Box("mdhd", be32(0, 0, 0, timescale, 0), []byte{0x55, 0xC4, 0, 0})
+	hdlr := mp4Box("hdlr", be32(0, 0), []byte(handler), make([]byte, 13))
+	stbl := mp4Box("stbl", mp4Box("stsd", be32(0, 1), mp4Box(codec, make([]byte, 16))),
+		mp4Box("stts", be32(0, 0)), mp4Box("stsc", be32(0, 0)), mp4Box("stsz", be32(0, 0, 0)), mp4Box("stco", be32(0, 0)))
+	trak := mp4Box("trak", tkhd, mp4Box("mdia", mdhd, hdlr, mp4Box("minf", stbl)))
+	moov := mp4Box("moov", mp4Box("mvhd", make([]byte, 100)), trak,
+		mp4Box("mvex", mp4Box("trex", be32(0, 1, 1, duration, 0, 0))))
+
+	var data, sizes []byte
+	for i := 0; i < n; i++ {
+		sample := bytes.Repeat([]byte{handler[0], byte(i)}, 5+i%3)
+		data = append(data, sample...)
+		sizes = append(sizes, be32(uint32(len(sample)))...)
+	}
+	traf := func(offset uint32) []byte {
+		return mp4Box("traf", mp4Box("tfhd", be32(0x020000, 1)), mp4Box("tfdt", be32(0, 0)),
+			mp4Box("trun", be32(0x000201, uint32(n), offset), sizes))
+	}
+	moofSize := len(mp4Box("moof", traf(0)))
+	file := append(mp4Box("ftyp", []byte("dash\x00\x00\x00\x00iso6")), moov...)
+	file = append(file, mp4Box("moof", traf(uint32(moofSize+8)))...)
+	return append(file, mp4Box("mdat", data)...)
+}
+
+func TestDownloadMerged(t *testing.T) {
+	files := map[string][]byte{
+		"/video": fragmentedMP4("vide", "avc1", 12800, 512, 50),
+		"/audio": fragmentedMP4("soun", "mp4a", 44100, 1024, 86),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "media", time.Time{}, bytes.NewReader(files[r.URL.Path]))
+	}))
+	defer srv.Close()
+
+	var (
+		mu     sync.Mutex
+		phases []types.Phase
+	)
+	dir := t.TempDir()
+	d := New().WithHTTPClient(srv.Client()).WithOutputPath(dir).WithContainerCheck(true).WithProgress(func(p Progress) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(phases) == 0 || phases[len(phases)-1] != p.Phase {
+			phases = append(phases, p.Phase)
+		}
+	})
+	selected := []types.Format{
+		{Itag: 140, MimeType: `audio/mp4; codecs="mp4a.40.2"`, ACodec: "mp4a.40.2"},
+		{Itag: 137, MimeType: `video/mp4; codecs="avc1.640028"`, VCodec: "avc1.640028"},
+	}
+	info := &VideoInfo{ID: "dQw4w9WgXcQ", Title: "merged"}
+	out, err := d.downloadMerged(context.Background(), []string{srv.URL + "/audio", srv.URL + "/video"}, selected, info)
+	if err != nil {
+		t.Fatalf("downloadMerged failed: %v", err)
+	}
+	if want := filepath.Join(dir, "merged.mp4"); out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+	if err := downloader.CheckContainer(out, downloader.ContainerMP4); err != nil {
+		t.Errorf("merged file: %v", err)
+	}
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fi, _ := f.Stat()
+	tracks, err := mp4.ReadTracks(f, fi.Size())
+	if err != nil {
+		t.Fatalf("read merged file: %v", err)
+	}
+	if len(tracks) != 2 || tracks[0].Handler != "vide" || tracks[1].Handler != "soun" ||
+		len(tracks[0].Samples) != 50 || len(tracks[1].Samples) != 86 {
+		t.Errorf("merged tracks = %d", len(tracks))
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d entries, want only the merged file", len(entries))
+	}
+	want := []types.Phase{types.PhaseDownloadingVideo, types.PhaseDownloadingAudio, types.PhaseMerging}
+	if len(phases) != len(want) {
+		t.Fatalf("phases = %v, want %v", phases, want)
+	}
+	for i := range want {
+		if phases[i] != want[i] {
+			t.Errorf("phases = %v, want %v", phases, want)
+		}
+	}
+}
+
+// webmPart builds a WebM file with one track of n frames every step.
+func webmPart(t *testing.T, track webm.Track, n int, step time.Duration) []byte {
+	t.Helper()
+	mt := webm.MuxTrack{Track: track}
+	for i := 0; i < n; i++ {
+		mt.Blocks = append(mt.Blocks, webm.Block{Timecode: time.Duration(i) * step, Keyframe: i%10 == 0, Size: 4})
+	}
+	next := 0
+	mt.Data = func() ([]byte, error) {
+		next++
+		return []byte{byte(track.Type), byte(next), 0, 0}, nil
+	}
+	var b bytes.Buffer
+	if err := webm.Mux(&b, webm.DocTypeWebM, mt); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestMergeMatroskaFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	vp9 := write("v.f248.webm", webmPart(t, webm.Track{Type: webm.TrackTypeVideo, CodecID: "V_VP9", PixelWidth: 1920, PixelHeight: 1080}, 50, 40*time.Millisecond))
+	avc := write("v.f137.mp4", fragmentedMP4("vide", "avc1", 12800, 512, 50))
+	opus := write("a.f251.weba", webmPart(t, webm.Track{Type: webm.TrackTypeAudio, CodecID: "A_OPUS", SamplingFrequency: 48000, Channels: 2}, 100, 20*time.Millisecond))
+
+	for _, tc := range []struct {
+		ext, video, videoCodec string
+	}{
+		{mimeext.ExtWebM, vp9, "V_VP9"},
+		{mimeext.ExtMKV, avc, "V_MPEG4/ISO/AVC"},
+	} {
+		t.Run(tc.ext, func(t *testing.T) {
+			out := filepath.Join(dir, "merged."+tc.ext)
+			if err := mergeMatroskaFiles(out, tc.ext, tc.video, opus); err != nil {
+				t.Fatalf("mergeMatroskaFiles failed: %v", err)
+			}
+			if err := downloader.CheckContainer(out, downloader.ContainerWebM); err != nil {
+				t.Errorf("merged file: %v", err)
+			}
+			f, err := os.Open(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			r, err := webm.NewReader(f)
+			if err != nil {
+				t.Fatalf("read merged file: %v", err)
+			}
+			tracks := r.Tracks()
+			if len(tracks) != 2 || tracks[0].CodecID != tc.videoCodec || tracks[1].CodecID != "A_OPUS" || tracks[1].Channels != 2 {
+				t.Fatalf("tracks = %+v", tracks)
+			}
+			frames := map[uint64]int{}
+			for {
+				fr, err := r.ReadFrame()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("ReadFrame() error = %v", err)
+				}
+				frames[fr.Track]++
+			}
+			if frames[1] != 50 || frames[2] != 100 {
+				t.Errorf("frames per track = %v", frames)
+			}
+		})
+	}
+}
+
+func TestMergeOrder(t *testing.T) {
+	video := types.Format{Itag: 137, MimeType: "video/mp4", VCodec: "avc1"}
+	audio := types.Format{Itag: 140, MimeType: "audio/mp4", ACodec: "mp4a.40.2"}
+	if order, err := mergeOrder([]types.Format{audio, video}); err != nil || order[0] != 1 || order[1] != 0 {
+		t.Errorf("mergeOrder(audio, video) = %v, %v", order, err)
+	}
+	muxed := types.Format{Itag: 18, MimeType: "video/mp4", VCodec: "avc1", ACodec: "mp4a.40.2"}
+	for name, list := range map[string][]types.Format{
+		"two videos":  {video, video},
+		"progressive": {muxed, audio},
+		"one format":  {video},
+	} {
+		if _, err := mergeOrder(list); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	d := New().WithExtractAudio(AudioFormatBest)
+	if _, err := d.downloadMerged(context.Background(), []string{"", ""}, []types.Format{video, audio}, &VideoInfo{}); err == nil {
+		t.Error("expected an error when extracting audio")
+	}
+}
+
+func TestMergeContainer(t *testing.T) {
+	avc := types.Format{Itag: 137, MimeType: `video/mp4; codecs="avc1.640028"`, VCodec: "avc1.640028"}
+	av1 := types.Format{Itag: 399, MimeType: `video/mp4; codecs="av01.0.08M.08"`, VCodec: "av01.0.08M.08"}
+	vp9 := types.Format{Itag: 248, MimeType: `video/webm; codecs="vp9"`, VCodec: "vp9"}
+	aac := types.Format{Itag: 140, MimeType: `audio/mp4; codecs="mp4a.40.2"`, ACodec: "mp4a.40.2"}
+	opus := types.Format{Itag: 251, MimeType: `audio/webm; codecs="opus"`, ACodec: "opus"}
+	tests := []struct {
+		video, audio types.Format
+		want         string
+	}{
+		{avc, aac, mimeext.DefaultExt},
+		{av1, aac, mimeext.DefaultExt},
+		{vp9, opus, mimeext.ExtWebM},
+		{av1, opus, mimeext.ExtWebM},
+		{avc, opus, mimeext.ExtMKV},
+		{vp9, aac, mimeext.ExtMKV},
+	}
+	for _, tt := range tests {
+		if got, err := mergeContainer(tt.video, tt.audio); err != nil || got != tt.want {
+			t.Errorf("mergeContainer(%d, %d) = %q, %v, want %q", tt.video.Itag, tt.audio.Itag, got, err, tt.want)
+		}
+	}
+	hevc := types.Format{Itag: 1, MimeType: `video/mp4; codecs="hvc1"`, VCodec: "hvc1"}
+	flv := types.Format{Itag: 5, MimeType: "video/x-flv", VCodec: "h263"}
+	for _, video := range []types.Format{hevc, flv} {
+		if _, err := mergeContainer(video, opus); err == nil {
+			t.Errorf("mergeContainer(%d, opus): expected an error", video.Itag)
+		}
+	}
+}
+
+func TestDownloadMergedFFmpeg(t *testing.T) {
+	files := map[string][]byte{"/video": []byte("hevc"), "/audio": []byte("opus")}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "media", time.Time{}, bytes.NewReader(files[r.URL.Path]))
+	}))
+	defer srv.Close()
+
+	selected := []types.Format{
+		{Itag: 1, MimeType: `video/mp4; codecs="hvc1.1.6.L120.90"`, VCodec: "hvc1.1.6.L120.90"},
+		{Itag: 251, MimeType: `audio/webm; codecs="opus"`, ACodec: "opus"},
+	}
+	urls := []string{srv.URL + "/video", srv.URL + "/audio"}
+	info := &VideoInfo{ID: "dQw4w9WgXcQ", Title: "merged", Duration: 60}
+	dir := t.TempDir()
+	if _, err := New().WithHTTPClient(srv.Client()).WithOutputPath(dir).downloadMerged(context.Background(), urls, selected, info); err == nil {
+		t.Fatal("expected an error without a post-processor")
+	}
+
+	var phases []types.Phase
+	d := New().WithHTTPClient(srv.Client()).WithOutputPath(dir).WithPostProcessor(fakeFFmpeg(t)).WithProgress(func(p Progress) {
+		phases = append(phases, p.Phase)
+	})
+	out, err := d.downloadMerged(context.Background(), urls, selected, info)
+	if err != nil {
+		t.Fatalf("downloadMerged failed: %v", err)
+	}
+	if want := filepath.Join(dir, "merged.mkv"); out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+	if b, err := os.ReadFile(out); err != nil || string(b) != "hevcopus" {
+		t.Errorf("ffmpeg inputs = %q, %v; want video then audio", b, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("directory holds %d entries, want only the merged file", len(entries))
+	}
+	if last := phases[len(phases)-1]; last != types.PhaseMerging {
+		t.Errorf("last phase = %v, want merging", last)
+	}
+}
@@ -0,0 +1,158 @@
+package ytdlp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ytget/ytdlp/v2/internal/logging"
+	"github.com/ytget/ytdlp/v2/internal/mimeext"
+	"github.com/ytget/ytdlp/v2/internal/mp4"
+	"github.com/ytget/ytdlp/v2/internal/webm"
+)
+
+// maxThumbnailSize bounds the thumbnails downloaded as cover art.
+const maxThumbnailSize = 10 << 20
+
+// embedMetadata rewrites the file at path with the metadata and chapters of
+// info and, for containers that take cover art, its thumbnail. The new file
+// replaces path only once it is complete. A thumbnail that cannot be fetched
+// is logged and left out.
+func (d *Downloader) embedMetadata(ctx context.Context, path string, info *VideoInfo) error {
+	log := d.log().With(slog.String(logging.KeyVideoID, info.ID), slog.String(logging.KeyStage, logging.StagePostProcess))
+	videoURL := "https://www.youtube.com/watch?v=" + info.ID
+	var write func(w io.Writer, src io.ReaderAt, size int64) error
+	switch ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), ".")); ext {
+	case mimeext.DefaultExt, mimeext.ExtM4A:
+		meta := mp4.Metadata{
+			Title:       info.Title,
+			Artist:      info.Author,
+			Date:        info.UploadDate,
+			Description: info.Description,
+			Comment:     videoURL,
+		}
+		for _, c := range info.Chapters {
+			meta.Chapters = append(meta.Chapters, mp4.Chapter{Start: c.Start, Title: c.Title})
+		}
+		meta.Cover, _ = d.fetchThumbnail(ctx, info, log)
+		write = func(w io.Writer, src io.ReaderAt, size int64) error {
+			return mp4.WriteMetadata(w, src, size, meta)
+		}
+	case mimeext.ExtWebM, mimeext.ExtWebA, mimeext.ExtMKV:
+		var meta webm.Metadata
+		for _, t := range []webm.Tag{
+			{Name: "TITLE", Value: info.Title},
+			{Name: "ARTIST", Value: info.Author},
+			{Name: "DATE_RELEASED", Value: info.UploadDate},
+			{Name: "DESCRIPTION", Value: info.Description},
+			{Name: "COMMENT", Value: videoURL},
+		} {
+			if t.Value != "" {
+				meta.Tags = append(meta.Tags, t)
+			}
+		}
+		for _, c := range info.Chapters {
+			meta.Chapters = append(meta.Chapters, webm.Chapter{Start: c.Start, End: c.End, Title: c.Title})
+		}
+		if ext == mimeext.ExtMKV {
+			if cover, mime := d.fetchThumbnail(ctx, info, log); cover != nil {
+				name := "cover.jpg"
+				if mime == "image/png" {
+					name = "cover.png"
+				}
+				meta.Attachments = []webm.Attachment{{Name: name, MimeType: mime, Description: "Thumbnail", Data: cover}}
+			}
+		}
+		write = func(w io.Writer, src io.ReaderAt, size int64) error {
+			return webm.WriteMetadata(w, src, size, meta)
+		}
+	default:
+		log.Debug("Container does not take embedded metadata", slog.String("path", path))
+		return nil
+	}
+
+	log.Info("Embedding metadata", slog.String("path", path))
+	return rewriteFile(path, write)
+}
+
+// rewriteFile writes a new version of the file at path with write, which
+// reads the current one, and replaces path with it.
+func rewriteFile(path string, write func(w io.Writer, src io.ReaderAt, size int64) error) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := write(out, in, fi.Size()); err != nil {
+		_ = out.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	_ = in.Close()
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// fetchThumbnail downloads the thumbnail of info and returns it with its
+// MIME type, or nil when there is none or it is not a JPEG or PNG image.
+func (d *Downloader) fetchThumbnail(ctx context.Context, info *VideoInfo, log *slog.Logger) ([]byte, string) {
+	if info.Thumbnail == "" {
+		return nil, ""
+	}
+	data, err := d.getThumbnail(ctx, info.Thumbnail)
+	if err != nil {
+		log.Warn("Failed to fetch thumbnail", logging.URL(info.Thumbnail), logging.Err(err))
+		return nil, ""
+	}
+	mime := http.DetectContentType(data)
+	if mime != "image/jpeg" && mime != "image/png" {
+		log.Warn("Thumbnail left out", logging.URL(info.Thumbnail), slog.String("type", mime))
+		return nil, ""
+	}
+	return data, mime
+}
+
+// getThumbnail downloads the image at url.
+func (d *Downloader) getThumbnail(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.newHTTPClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxThumbnailSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxThumbnailSize {
+		return nil, fmt.Errorf("thumbnail exceeds %d bytes", maxThumbnailSize)
+	}
+	return data, nil
+}
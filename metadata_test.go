@@ -0,0 +1,122 @@
+package ytdlp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ytget/ytdlp/v2/internal/mp4"
+	"github.com/ytget/ytdlp/v2/internal/webm"
+	"github.com/ytget/ytdlp/v2/types"
+	"github.com/ytget/ytdlp/v2/youtube/innertube"
+)
+
+// testPNG is the start of a PNG image, enough for content sniffing.
+var testPNG = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDRcover")
+
+func TestEmbedMetadata(t *testing.T) {
+	media := fragmentedMP4("vide", "avc1", 12800, 512, 20)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/thumb":
+			_, _ = w.Write(testPNG)
+		case "/video":
+			http.ServeContent(w, r, "media", time.Time{}, bytes.NewReader(media))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	info := &VideoInfo{ID: "dQw4w9WgXcQ", Title: "Title", Author: "Channel", UploadDate: "2024-05-31", Thumbnail: srv.URL + "/thumb",
+		Chapters: []types.Chapter{{Start: 0, End: 400 * time.Millisecond, Title: "Intro"}, {Start: 400 * time.Millisecond, End: time.Second, Title: "Main"}}}
+	out := filepath.Join(t.TempDir(), "out.mp4")
+	d := New().WithHTTPClient(srv.Client()).WithOutputPath(out).WithEmbedMetadata(true).WithChecksum(true)
+	f := types.Format{Itag: 137, MimeType: "video/mp4", VCodec: "avc1.640028", Size: int64(len(media))}
+	if _, err := d.downloadFormat(context.Background(), d.newFileDownloader(), srv.URL+"/video", f, info, ""); err != nil {
+		t.Fatalf("downloadFormat failed: %v", err)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range [][]byte{[]byte("\xa9nam"), []byte("Title"), []byte("Channel"), []byte("2024-05-31"), []byte("watch?v=dQw4w9WgXcQ"), testPNG, []byte("chpl"), []byte("Main")} {
+		if !bytes.Contains(b, want) {
+			t.Errorf("output lacks %q", want)
+		}
+	}
+	if tracks, err := mp4.ReadTracks(bytes.NewReader(b), int64(len(b))); err != nil || len(tracks[0].Samples) != 20 {
+		t.Errorf("read output: %v", err)
+	}
+	// The checksum is of the file with the metadata.
+	sum := sha256.Sum256(b)
+	if got, err := os.ReadFile(out + ".sha256"); err != nil || !strings.HasPrefix(string(got), hex.EncodeToString(sum[:])) {
+		t.Errorf("checksum sidecar = %q, %v", got, err)
+	}
+
+	// Matroska gets tags, chapters and the thumbnail as an attachment; a missing
+	// thumbnail only leaves out the cover.
+	dir := t.TempDir()
+	mkv := filepath.Join(dir, "out.mkv")
+	part := webmPart(t, webm.Track{Type: webm.TrackTypeAudio, CodecID: "A_OPUS", SamplingFrequency: 48000}, 20, 20*time.Millisecond)
+	if err := os.WriteFile(mkv, part, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.embedMetadata(context.Background(), mkv, info); err != nil {
+		t.Fatalf("embedMetadata(mkv) error = %v", err)
+	}
+	if b, _ := os.ReadFile(mkv); !bytes.Contains(b, []byte("DATE_RELEASED")) || !bytes.Contains(b, testPNG) || !bytes.Contains(b, []byte("cover.png")) || !bytes.Contains(b, []byte("Intro")) {
+		t.Error("mkv lacks the tags, the chapters or the cover")
+	}
+	info.Thumbnail = srv.URL + "/missing"
+	if err := d.embedMetadata(context.Background(), mkv, info); err != nil {
+		t.Fatalf("embedMetadata without thumbnail error = %v", err)
+	}
+	if b, _ := os.ReadFile(mkv); bytes.Contains(b, testPNG) || !bytes.Contains(b, []byte("Channel")) {
+		t.Error("mkv kept the old cover or lost the tags")
+	}
+
+	// Other containers are left alone, and broken files are not replaced.
+	opus := filepath.Join(dir, "out.opus")
+	if err := os.WriteFile(opus, []byte("OggS"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.embedMetadata(context.Background(), opus, info); err != nil {
+		t.Errorf("embedMetadata(opus) error = %v", err)
+	}
+	bad := filepath.Join(dir, "bad.webm")
+	if err := os.WriteFile(bad, []byte("not webm"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.embedMetadata(context.Background(), bad, info); err == nil {
+		t.Error("expected an error for a broken file")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 3 {
+		t.Errorf("directory holds %d entries, want 3", len(entries))
+	}
+}
+
+func TestVideoInfoMetadata(t *testing.T) {
+	if got := uploadDate("", "2024-05-31T10:00:00-07:00"); got != "2024-05-31" {
+		t.Errorf("uploadDate() = %q", got)
+	}
+	if got := uploadDate("bad"); got != "" {
+		t.Errorf("uploadDate(bad) = %q", got)
+	}
+	small := innertube.Thumbnails{Thumbnails: []innertube.Thumbnail{{URL: "s", Width: 120, Height: 90}, {URL: "m", Width: 480, Height: 360}}}
+	large := innertube.Thumbnails{Thumbnails: []innertube.Thumbnail{{URL: "l", Width: 1280, Height: 720}}}
+	if got := largestThumbnail(small, large); got != "l" {
+		t.Errorf("largestThumbnail() = %q, want l", got)
+	}
+	if got := largestThumbnail(); got != "" {
+		t.Errorf("largestThumbnail() of nothing = %q", got)
+	}
+}
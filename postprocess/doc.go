@@ -0,0 +1,4 @@
+// Package postprocess runs merge, remux, audio extraction and conversion
+// jobs with an external ffmpeg binary, for codecs and re-encoding that the
+// pure-Go muxers cannot handle.
+package postprocess
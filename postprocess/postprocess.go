@@ -0,0 +1,265 @@
+package postprocess
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ytget/ytdlp/v2/internal/logging"
+)
+
+const (
+	binaryName     = "ffmpeg"
+	maxStderrBytes = 4 << 10 // stderr kept for errors
+	// waitDelay bounds the wait for ffmpeg's pipes after it was killed.
+	waitDelay = 5 * time.Second
+)
+
+// Operations of the jobs built by this package, as reported in Job.Op.
+const (
+	OpMerge        = "merge"
+	OpRemux        = "remux"
+	OpExtractAudio = "extract_audio"
+	OpConvert      = "convert"
+)
+
+// ErrNotFound is returned when no ffmpeg binary can be located.
+var ErrNotFound = errors.New("postprocess: ffmpeg not found")
+
+// Error reports an ffmpeg job that could not be started or failed.
+type Error struct {
+	Op string
+	// ExitCode is ffmpeg's exit status, or -1 when it did not exit normally
+	// (not started, killed or cancelled).
+	ExitCode int
+	// Stderr holds the end of ffmpeg's error output.
+	Stderr string
+	// Err is the underlying error, e.g. an *exec.ExitError or the context's
+	// error.
+	Err error
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("postprocess: ffmpeg %s failed: %v", e.Op, e.Err)
+	if line := lastLine(e.Stderr); line != "" {
+		msg += ": " + line
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Job is one ffmpeg run: it reads Inputs in order and writes Output, with
+// Args as the output options. The output format follows the extension of
+// Output.
+type Job struct {
+	// Op names the job in errors, logs and progress events.
+	Op     string
+	Inputs []string
+	Output string
+	Args   []string
+	// Duration is the media duration, used to compute Progress.Percent.
+	// Zero leaves the percentage unknown.
+	Duration time.Duration
+}
+
+// Merge returns a job that copies the first video stream of video and the
+// first audio stream of audio into dst without re-encoding.
+func Merge(dst, video, audio string) Job {
+	return Job{Op: OpMerge, Inputs: []string{video, audio}, Output: dst,
+		Args: []string{"-map", "0:v:0", "-map", "1:a:0", "-c", "copy"}}
+}
+
+// Remux returns a job that copies all streams of src into the container of
+// dst without re-encoding.
+func Remux(dst, src string) Job {
+	return Job{Op: OpRemux, Inputs: []string{src}, Output: dst, Args: []string{"-map", "0", "-c", "copy"}}
+}
+
+// audioCodec is how ExtractAudio encodes one audio format.
+type audioCodec struct {
+	ext  string
+	args []string
+}
+
+// audioCodecs are the formats accepted by ExtractAudio.
+var audioCodecs = map[string]audioCodec{
+	"mp3":    {"mp3", []string{"-c:a", "libmp3lame", "-q:a", "2"}},
+	"aac":    {"m4a", []string{"-c:a", "aac", "-b:a", "192k"}},
+	"m4a":    {"m4a", []string{"-c:a", "aac", "-b:a", "192k"}},
+	"opus":   {"opus", []string{"-c:a", "libopus", "-b:a", "128k"}},
+	"vorbis": {"ogg", []string{"-c:a", "libvorbis", "-q:a", "5"}},
+	"flac":   {"flac", []string{"-c:a", "flac"}},
+	"wav":    {"wav", []string{"-c:a", "pcm_s16le"}},
+}
+
+// AudioExt returns the file extension of audio extracted in format (mp3,
+// aac, m4a, opus, vorbis, flac or wav), or "" when the format is not
+// supported.
+func AudioExt(format string) string {
+	return audioCodecs[strings.ToLower(format)].ext
+}
+
+// ExtractAudio returns a job that encodes the first audio stream of src in
+// format (see AudioExt) into dst, dropping any video.
+func ExtractAudio(dst, src, format string) (Job, error) {
+	c, ok := audioCodecs[strings.ToLower(format)]
+	if !ok {
+		return Job{}, fmt.Errorf("postprocess: unsupported audio format %q", format)
+	}
+	args := append([]string{"-map", "0:a:0", "-vn"}, c.args...)
+	return Job{Op: OpExtractAudio, Inputs: []string{src}, Output: dst, Args: args}, nil
+}
+
+// Convert returns a job that converts src into dst with the given ffmpeg
+// output options, e.g. "-c:v", "libx264", "-crf", "23".
+func Convert(dst, src string, args ...string) Job {
+	return Job{Op: OpConvert, Inputs: []string{src}, Output: dst, Args: args}
+}
+
+// PostProcessor runs Jobs with an ffmpeg binary.
+type PostProcessor struct {
+	path     string
+	logger   *slog.Logger
+	progress func(Progress)
+}
+
+// New returns a PostProcessor that looks up ffmpeg in PATH.
+func New() *PostProcessor {
+	return &PostProcessor{}
+}
+
+// WithPath sets the ffmpeg binary to run, or the directory holding it.
+// An empty path looks ffmpeg up in PATH.
+func (p *PostProcessor) WithPath(path string) *PostProcessor {
+	p.path = strings.TrimSpace(path)
+	return p
+}
+
+// WithLogger sets the logger for diagnostics; command lines are logged at
+// debug level. A nil logger (the default) uses slog.Default().
+func (p *PostProcessor) WithLogger(l *slog.Logger) *PostProcessor {
+	p.logger = l
+	return p
+}
+
+// WithProgress registers a callback that receives the progress ffmpeg
+// reports while a job runs.
+func (p *PostProcessor) WithProgress(f func(Progress)) *PostProcessor {
+	p.progress = f
+	return p
+}
+
+// log returns the configured logger or slog.Default().
+func (p *PostProcessor) log() *slog.Logger {
+	return logging.Or(p.logger).With(slog.String(logging.KeyStage, logging.StagePostProcess))
+}
+
+// Locate returns the path of the ffmpeg binary, or an error wrapping
+// ErrNotFound.
+func (p *PostProcessor) Locate() (string, error) {
+	name := binaryName
+	if p.path != "" {
+		name = p.path
+		if fi, err := os.Stat(name); err == nil && fi.IsDir() {
+			name = filepath.Join(name, binaryName)
+		}
+	}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrNotFound, err)
+	}
+	return path, nil
+}
+
+// Run runs job and waits for it to finish, reporting its progress. The
+// output is overwritten if it exists and removed if the job fails. Failures
+// of ffmpeg are returned as *Error; cancelling ctx kills ffmpeg.
+func (p *PostProcessor) Run(ctx context.Context, job Job) error {
+	if len(job.Inputs) == 0 || job.Output == "" {
+		return fmt.Errorf("postprocess: %s job needs inputs and an output", job.Op)
+	}
+	bin, err := p.Locate()
+	if err != nil {
+		return err
+	}
+	args := []string{"-hide_banner", "-nostdin", "-nostats", "-loglevel", "error", "-y", "-progress", "pipe:1"}
+	for _, in := range job.Inputs {
+		args = append(args, "-i", argPath(in))
+	}
+	args = append(append(args, job.Args...), argPath(job.Output))
+
+	log := p.log().With(slog.String("op", job.Op))
+	log.Debug("Running ffmpeg", slog.String("path", bin), slog.Any("args", args))
+	cmd := exec.CommandContext(ctx, bin, args...)
+	cmd.WaitDelay = waitDelay
+	stderr := &tailBuffer{max: maxStderrBytes}
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return &Error{Op: job.Op, ExitCode: -1, Err: err}
+	}
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return &Error{Op: job.Op, ExitCode: -1, Err: err}
+	}
+	readProgress(stdout, job, p.progress)
+	if err := cmd.Wait(); err != nil {
+		_ = os.Remove(job.Output)
+		code := -1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			code = exitErr.ExitCode()
+		}
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		e := &Error{Op: job.Op, ExitCode: code, Stderr: stderr.String(), Err: err}
+		log.Warn("ffmpeg failed", slog.Int("exit_code", code), logging.Err(e))
+		return e
+	}
+	log.Debug("ffmpeg finished", slog.Duration("elapsed", time.Since(start)))
+	return nil
+}
+
+// argPath returns path as an ffmpeg argument. Relative paths get a "./"
+// prefix, so that a name starting with "-" is not taken for an option, nor
+// one with a colon for a protocol.
+func argPath(path string) string {
+	sep := string(filepath.Separator)
+	if filepath.IsAbs(path) || strings.HasPrefix(path, "."+sep) || strings.HasPrefix(path, ".."+sep) {
+		return path
+	}
+	return "." + sep + path
+}
+
+// tailBuffer is an io.Writer that keeps the last max bytes written to it.
+type tailBuffer struct {
+	max int
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if over := len(b.buf) - b.max; over > 0 {
+		b.buf = append(b.buf[:0], b.buf[over:]...)
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string { return string(b.buf) }
+
+// lastLine returns the last non-empty line of s.
+func lastLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		s = s[i+1:]
+	}
+	return strings.TrimSpace(s)
+}
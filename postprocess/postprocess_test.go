@@ -0,0 +1,183 @@
+package postprocess
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeFFmpeg writes a shell script named ffmpeg into a new directory and
+// returns the directory. The script records its arguments in "args" next to
+// itself before running body; $out is the output path (the last argument).
+func fakeFFmpeg(t *testing.T, body string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+	dir := t.TempDir()
+	script := "#!/bin/sh\nprintf '%s\\n' \"$@\" > \"$(dirname \"$0\")/args\"\nfor out; do :; done\n" + body + "\n"
+	if err := os.WriteFile(filepath.Join(dir, binaryName), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// recordedArgs returns the arguments of the last run of the fake ffmpeg in
+// dir.
+func recordedArgs(t *testing.T, dir string) []string {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+}
+
+func TestRunMerge(t *testing.T) {
+	bin := fakeFFmpeg(t, `
+echo out_time_us=1000000; echo total_size=2048; echo speed=2.5x; echo progress=continue
+echo out_time_us=N/A; echo total_size=4096; echo speed=N/A; echo progress=end
+echo merged > "$out"`)
+	out := filepath.Join(t.TempDir(), "out.mkv")
+
+	var (
+		mu     sync.Mutex
+		events []Progress
+	)
+	p := New().WithPath(bin).WithProgress(func(pr Progress) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, pr)
+	})
+	job := Merge(out, "video.mp4", "audio.webm")
+	job.Duration = 4 * time.Second
+	if err := p.Run(context.Background(), job); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if b, err := os.ReadFile(out); err != nil || string(b) != "merged\n" {
+		t.Errorf("output = %q, %v", b, err)
+	}
+	args := strings.Join(recordedArgs(t, bin), " ")
+	for _, want := range []string{"-progress pipe:1", "-y", "-i ./video.mp4 -i ./audio.webm -map 0:v:0 -map 1:a:0 -c copy " + out} {
+		if !strings.Contains(args, want) {
+			t.Errorf("args %q lack %q", args, want)
+		}
+	}
+
+	want := []Progress{
+		{Op: OpMerge, OutTime: time.Second, Duration: 4 * time.Second, Percent: 25, Size: 2048, Speed: 2.5},
+		{Op: OpMerge, OutTime: time.Second, Duration: 4 * time.Second, Percent: 100, Size: 4096, Speed: 2.5, Done: true},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("events = %+v, want %+v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("event %d = %+v, want %+v", i, events[i], want[i])
+		}
+	}
+}
+
+func TestRunRelativePaths(t *testing.T) {
+	bin := fakeFFmpeg(t, `echo remuxed > "$out"`)
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(wd) }()
+
+	if err := New().WithPath(bin).Run(context.Background(), Remux("-out.mp4", "-in:1.webm")); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "-out.mp4")); err != nil || string(b) != "remuxed\n" {
+		t.Errorf("output = %q, %v", b, err)
+	}
+	args := strings.Join(recordedArgs(t, bin), " ")
+	if !strings.Contains(args, "-i ./-in:1.webm ") || !strings.HasSuffix(args, " ./-out.mp4") {
+		t.Errorf("relative paths passed as %q", args)
+	}
+	for _, p := range []string{"/tmp/a.mp4", "./a.mp4", "../a.mp4"} {
+		if got := argPath(p); got != p {
+			t.Errorf("argPath(%q) = %q", p, got)
+		}
+	}
+}
+
+func TestRunFailure(t *testing.T) {
+	bin := fakeFFmpeg(t, `echo partial > "$out"; echo "Stream map '0:v:0' matches no streams." >&2; exit 1`)
+	out := filepath.Join(t.TempDir(), "out.mp4")
+	err := New().WithPath(filepath.Join(bin, binaryName)).Run(context.Background(), Remux(out, "in.webm"))
+	var e *Error
+	if !errors.As(err, &e) {
+		t.Fatalf("Run() error = %v, want *Error", err)
+	}
+	if e.Op != OpRemux || e.ExitCode != 1 || !strings.Contains(e.Stderr, "matches no streams") {
+		t.Errorf("error = %+v", e)
+	}
+	if !strings.Contains(err.Error(), "matches no streams") {
+		t.Errorf("message %q lacks ffmpeg's error", err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("output of the failed job was kept: %v", err)
+	}
+}
+
+func TestRunCancel(t *testing.T) {
+	bin := fakeFFmpeg(t, `exec sleep 10`)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := New().WithPath(bin).Run(ctx, Convert(filepath.Join(t.TempDir(), "out.mp4"), "in.mp4", "-c:v", "libx264"))
+	var e *Error
+	if !errors.As(err, &e) || !errors.Is(err, context.DeadlineExceeded) || e.ExitCode != -1 {
+		t.Fatalf("Run() error = %v, want a cancelled *Error", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("Run() did not return promptly after cancellation")
+	}
+}
+
+func TestLocate(t *testing.T) {
+	if _, err := New().WithPath(filepath.Join(t.TempDir(), "missing")).Locate(); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Locate() of a missing binary: error = %v, want ErrNotFound", err)
+	}
+	err := New().WithPath(t.TempDir()).Run(context.Background(), Remux("out.mkv", "in.mp4"))
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Run() without ffmpeg: error = %v, want ErrNotFound", err)
+	}
+	bin := fakeFFmpeg(t, "")
+	if path, err := New().WithPath(bin).Locate(); err != nil || path != filepath.Join(bin, binaryName) {
+		t.Errorf("Locate() of a directory = %q, %v", path, err)
+	}
+	if err := New().WithPath(bin).Run(context.Background(), Job{Op: OpConvert}); err == nil {
+		t.Error("expected an error for a job without inputs")
+	}
+}
+
+func TestExtractAudio(t *testing.T) {
+	job, err := ExtractAudio("out.mp3", "in.mp4", "MP3")
+	if err != nil {
+		t.Fatalf("ExtractAudio() error = %v", err)
+	}
+	if args := strings.Join(job.Args, " "); job.Op != OpExtractAudio || args != "-map 0:a:0 -vn -c:a libmp3lame -q:a 2" {
+		t.Errorf("job = %+v", job)
+	}
+	for format, ext := range map[string]string{"mp3": "mp3", "aac": "m4a", "vorbis": "ogg", "wav": "wav", "ape": ""} {
+		if got := AudioExt(format); got != ext {
+			t.Errorf("AudioExt(%q) = %q, want %q", format, got, ext)
+		}
+	}
+	if _, err := ExtractAudio("out.ape", "in.mp4", "ape"); err == nil {
+		t.Error("expected an error for an unsupported format")
+	}
+}
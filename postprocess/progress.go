@@ -0,0 +1,67 @@
+package postprocess
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Progress describes a running job as reported by ffmpeg's -progress
+// output.
+type Progress struct {
+	Op string
+	// OutTime is the media time written so far.
+	OutTime time.Duration
+	// Duration is Job.Duration; Percent is only set when it is known.
+	Duration time.Duration
+	Percent  float64
+	// Size is the number of bytes written so far.
+	Size int64
+	// Speed is the processing speed as a multiple of real time.
+	Speed float64
+	// Done is set on the last event of a job.
+	Done bool
+}
+
+// readProgress reads the key=value blocks that ffmpeg writes with -progress
+// until r ends, calling f (if not nil) at the end of each block. Values
+// ffmpeg reports as "N/A" keep their previous value.
+func readProgress(r io.Reader, job Job, f func(Progress)) {
+	p := Progress{Op: job.Op, Duration: job.Duration}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(sc.Text()), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "out_time_us":
+			if us, err := strconv.ParseInt(value, 10, 64); err == nil && us >= 0 {
+				p.OutTime = time.Duration(us) * time.Microsecond
+			}
+		case "total_size":
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+				p.Size = n
+			}
+		case "speed":
+			if x, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "x"), 64); err == nil {
+				p.Speed = x
+			}
+		case "progress":
+			p.Done = value == "end"
+			if p.Duration > 0 {
+				p.Percent = min(float64(p.OutTime)/float64(p.Duration)*100, 100)
+				if p.Done {
+					p.Percent = 100
+				}
+			}
+			if f != nil {
+				f(p)
+			}
+		}
+	}
+	// Drain whatever is left so that ffmpeg never blocks on a full pipe.
+	_, _ = io.Copy(io.Discard, r)
+}
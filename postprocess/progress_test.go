@@ -0,0 +1,50 @@
+package postprocess
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadProgress(t *testing.T) {
+	input := `frame=12
+out_time_us=N/A
+total_size=N/A
+speed=N/A
+progress=continue
+out_time_us=90000000
+out_time=00:01:30.000000
+total_size=1048576
+speed= 1.5x
+garbage
+progress=continue
+out_time_us=130000000
+progress=end
+`
+	var got []Progress
+	readProgress(strings.NewReader(input), Job{Op: OpConvert, Duration: 2 * time.Minute}, func(p Progress) {
+		got = append(got, p)
+	})
+	want := []Progress{
+		{Op: OpConvert, Duration: 2 * time.Minute},
+		{Op: OpConvert, OutTime: 90 * time.Second, Duration: 2 * time.Minute, Percent: 75, Size: 1 << 20, Speed: 1.5},
+		{Op: OpConvert, OutTime: 130 * time.Second, Duration: 2 * time.Minute, Percent: 100, Size: 1 << 20, Speed: 1.5, Done: true},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("event %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	// Without a duration the percentage stays unknown; a nil callback only
+	// drains the input.
+	got = nil
+	readProgress(strings.NewReader(input), Job{Op: OpRemux}, func(p Progress) { got = append(got, p) })
+	if got[1].Percent != 0 {
+		t.Errorf("percent without duration = %v", got[1].Percent)
+	}
+	readProgress(strings.NewReader(input), Job{}, nil)
+}
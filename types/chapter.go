@@ -0,0 +1,12 @@
+package types
+
+import "time"
+
+// Chapter is a titled section of a video.
+type Chapter struct {
+	Start time.Duration
+	// End is the start of the next chapter, or the end of the video for the
+	// last one.
+	End   time.Duration
+	Title string
+}
@@ -0,0 +1,21 @@
+package types
+
+// Phase tells which step of a download a progress event belongs to.
+type Phase string
+
+// Progress phases, in the order they usually occur.
+const (
+	PhaseResolving        Phase = "resolving"
+	PhaseDeciphering      Phase = "deciphering"
+	PhaseDownloading      Phase = "downloading"
+	PhaseDownloadingVideo Phase = "downloading_video"
+	PhaseDownloadingAudio Phase = "downloading_audio"
+	PhaseMerging          Phase = "merging"
+	PhasePostProcessing   Phase = "post_processing"
+)
+
+// IsDownload reports whether p is one of the downloading phases, whose
+// events carry sizes, speed and ETA.
+func (p Phase) IsDownload() bool {
+	return p == PhaseDownloading || p == PhaseDownloadingVideo || p == PhaseDownloadingAudio
+}
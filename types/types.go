@@ -9,6 +9,127 @@ type Format struct {
 	Bitrate         int
 	Size            int64
 	SignatureCipher string
+
+	// SizeEstimated is true when Size was estimated from the bitrate and
+	// duration because the stream did not report its content length.
+	SizeEstimated bool
+
+	// Adaptive is true for DASH (video-only or audio-only) streams and false
+	// for progressive streams that carry both audio and video.
+	Adaptive bool
+
+	Width  int
+	Height int
+	FPS    int
+
+	// VCodec and ACodec hold the codec strings from the MIME type codecs
+	// parameter (e.g., "avc1.64001F", "mp4a.40.2"). Empty means no such track.
+	VCodec string
+	ACodec string
+
+	AudioSampleRate int
+	AudioChannels   int
+	AudioQuality    string
+
+	AverageBitrate   int
+	ApproxDurationMs int64
+	LastModified     int64
+
+	InitRange  *ByteRange
+	IndexRange *ByteRange
+
+	ProjectionType string
+	ColorInfo      *ColorInfo
+
+	// DynamicRange, Projection and StereoLayout are normalized from
+	// ColorInfo, ProjectionType and the stereo layout of video formats (see
+	// the DynamicRange*, Projection* and Stereo* constants). They are empty
+	// for audio-only formats.
+	DynamicRange string
+	Projection   string
+	StereoLayout string
+
+	// AudioTrack is set for adaptive audio formats of videos that offer
+	// several audio tracks. Language is the BCP-47 tag of the track (e.g.,
+	// "de", "en-US") derived from the track ID.
+	AudioTrack *AudioTrack
+	Language   string
+}
+
+// Dynamic range values of Format.DynamicRange. DynamicRangeHDR is used for
+// HDR streams whose transfer function is not reported.
+const (
+	DynamicRangeSDR   = "SDR"
+	DynamicRangeHDR10 = "HDR10"
+	DynamicRangeHLG   = "HLG"
+	DynamicRangeHDR   = "HDR"
+)
+
+// Projection values of Format.Projection.
+const (
+	ProjectionRectangular     = "rectangular"
+	ProjectionEquirectangular = "equirectangular"
+	ProjectionMesh            = "mesh"
+)
+
+// Stereo layout values of Format.StereoLayout.
+const (
+	StereoMono      = "mono"
+	StereoLeftRight = "left_right"
+	StereoTopBottom = "top_bottom"
+)
+
+// IsProgressive reports whether the format carries both audio and video in a
+// single stream.
+func (f Format) IsProgressive() bool {
+	return !f.Adaptive
+}
+
+// HasVideo reports whether the format carries a video track.
+func (f Format) HasVideo() bool {
+	return f.VCodec != ""
+}
+
+// HasAudio reports whether the format carries an audio track.
+func (f Format) HasAudio() bool {
+	return f.ACodec != ""
+}
+
+// IsHDR reports whether the format is a high dynamic range video stream.
+func (f Format) IsHDR() bool {
+	return f.DynamicRange != "" && f.DynamicRange != DynamicRangeSDR
+}
+
+// IsSpherical reports whether the format is a 360° or VR video stream.
+func (f Format) IsSpherical() bool {
+	return f.Projection == ProjectionEquirectangular || f.Projection == ProjectionMesh
+}
+
+// Is3D reports whether the format is a stereoscopic video stream.
+func (f Format) Is3D() bool {
+	return f.StereoLayout != "" && f.StereoLayout != StereoMono
+}
+
+// AudioTrack identifies one of several audio tracks (e.g., dubbed or
+// audio-description tracks) of a video.
+type AudioTrack struct {
+	ID          string
+	DisplayName string
+	IsDefault   bool
+}
+
+// ByteRange is an inclusive byte range inside a media stream.
+type ByteRange struct {
+	Start int64
+	End   int64
+}
+
+// ColorInfo describes the color characteristics of a video stream as reported
+// by the player response (e.g., "COLOR_PRIMARIES_BT709").
+type ColorInfo struct {
+	Primaries               string
+	TransferCharacteristics string
+	MatrixCoefficients      string
 }
 
 // VideoInfo describes video information.
@@ -76,6 +76,25 @@ func TestFormatZeroValues(t *testing.T) {
 	}
 }
 
+func TestFormatVideoAttributes(t *testing.T) {
+	tests := []struct {
+		format               Format
+		hdr, spherical, is3D bool
+	}{
+		{Format{}, false, false, false},
+		{Format{DynamicRange: DynamicRangeSDR, Projection: ProjectionRectangular, StereoLayout: StereoMono}, false, false, false},
+		{Format{DynamicRange: DynamicRangeHDR10}, true, false, false},
+		{Format{DynamicRange: DynamicRangeHLG, Projection: ProjectionEquirectangular}, true, true, false},
+		{Format{Projection: ProjectionMesh, StereoLayout: StereoLeftRight}, false, true, true},
+	}
+	for _, tt := range tests {
+		f := tt.format
+		if f.IsHDR() != tt.hdr || f.IsSpherical() != tt.spherical || f.Is3D() != tt.is3D {
+			t.Errorf("%+v: IsHDR=%v IsSpherical=%v Is3D=%v", f, f.IsHDR(), f.IsSpherical(), f.Is3D())
+		}
+	}
+}
+
 func TestPlaylistItem(t *testing.T) {
 	item := PlaylistItem{
 		VideoID: "abc123",
@@ -111,3 +130,20 @@ func TestPlaylistItemZeroValues(t *testing.T) {
 		t.Errorf("Expected Index 0, got %d", item.Index)
 	}
 }
+
+func TestPhaseIsDownload(t *testing.T) {
+	tests := map[Phase]bool{
+		PhaseResolving:        false,
+		PhaseDeciphering:      false,
+		PhaseDownloading:      true,
+		PhaseDownloadingVideo: true,
+		PhaseDownloadingAudio: true,
+		PhaseMerging:          false,
+		PhasePostProcessing:   false,
+	}
+	for phase, want := range tests {
+		if got := phase.IsDownload(); got != want {
+			t.Errorf("%q.IsDownload() = %v, want %v", phase, got, want)
+		}
+	}
+}
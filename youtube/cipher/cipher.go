@@ -3,6 +3,7 @@ package cipher
 import (
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"regexp"
 	"strconv"
@@ -11,6 +12,7 @@ import (
 	"time"
 
 	"github.com/robertkrimen/otto"
+	"github.com/ytget/ytdlp/v2/internal/logging"
 )
 
 const (
@@ -64,14 +66,15 @@ func init() {
 
 			// Log metrics
 			metrics.mu.Lock()
-			fmt.Printf("[METRICS] Total requests: %d, Cache hits: %d (%.1f%%), Cache misses: %d (%.1f%%), Avg decipher time: %v\n",
-				metrics.totalRequests,
-				metrics.cacheHits,
-				float64(metrics.cacheHits)/float64(metrics.totalRequests)*100,
-				metrics.cacheMisses,
-				float64(metrics.cacheMisses)/float64(metrics.totalRequests)*100,
-				metrics.avgDecipherTime,
-			)
+			if metrics.totalRequests > 0 {
+				slog.Debug("Decipher metrics",
+					slog.String(logging.KeyStage, logging.StageDecipher),
+					slog.Int64("requests", metrics.totalRequests),
+					slog.Int64("cache_hits", metrics.cacheHits),
+					slog.Int64("cache_misses", metrics.cacheMisses),
+					slog.Duration("avg_time", metrics.avgDecipherTime),
+				)
+			}
 			metrics.mu.Unlock()
 		}
 	}()
@@ -212,10 +215,46 @@ func DebugGetPlayerJS(httpClient *http.Client, playerJSURL string) ([]byte, stri
 	return body, "network", nil
 }
 
+// Decipherer deciphers signatures and n parameters with a given HTTP client
+// and logger. The caches and metrics are shared by all Decipherers.
+type Decipherer struct {
+	httpClient *http.Client
+	logger     *slog.Logger
+}
+
+// NewDecipherer returns a Decipherer that downloads player.js with
+// httpClient.
+func NewDecipherer(httpClient *http.Client) *Decipherer {
+	return &Decipherer{httpClient: httpClient}
+}
+
+// WithLogger sets the logger for diagnostics; nil uses slog.Default().
+// Signatures are never logged, only their length.
+func (d *Decipherer) WithLogger(l *slog.Logger) *Decipherer {
+	d.logger = l
+	return d
+}
+
+func (d *Decipherer) log() *slog.Logger {
+	return logging.Or(d.logger).With(slog.String(logging.KeyStage, logging.StageDecipher))
+}
+
 // Decipher decrypts a signature using multiple fallback methods.
 func Decipher(httpClient *http.Client, playerJSURL string, signature string) (string, error) {
+	return NewDecipherer(httpClient).Decipher(playerJSURL, signature)
+}
+
+// DecipherN decodes the n-parameter (throttling) if player.js contains ncode().
+func DecipherN(httpClient *http.Client, playerJSURL string, nval string) (string, error) {
+	return NewDecipherer(httpClient).DecipherN(playerJSURL, nval)
+}
+
+// Decipher decrypts a signature using multiple fallback methods.
+func (d *Decipherer) Decipher(playerJSURL string, signature string) (string, error) {
 	start := time.Now()
-	fmt.Printf("[DEBUG] Starting Decipher for signature: %s\n", signature)
+	log := d.log()
+	log.Debug("Deciphering signature", slog.Int("length", len(signature)))
+	httpClient := d.httpClient
 
 	// Update metrics
 	metrics.mu.Lock()
@@ -226,7 +265,7 @@ func Decipher(httpClient *http.Client, playerJSURL string, signature string) (st
 	signatureCacheMu.Lock()
 	if entry, ok := signatureCache[signature]; ok && time.Now().Before(entry.expAt) {
 		signatureCacheMu.Unlock()
-		fmt.Printf("[DEBUG] Using cached signature\n")
+		log.Debug("Using cached signature")
 
 		// Update cache hit metrics
 		metrics.mu.Lock()
@@ -244,15 +283,15 @@ func Decipher(httpClient *http.Client, playerJSURL string, signature string) (st
 
 	playerJSContent, err := getPlayerJS(httpClient, playerJSURL)
 	if err != nil {
-		fmt.Printf("[ERROR] Failed to get player.js: %v\n", err)
+		log.Debug("Failed to get player.js", logging.Err(err))
 		return "", NewError(ErrCodePlayerJSDownload, "Failed to download player.js", err)
 	}
-	fmt.Printf("[DEBUG] Got player.js content, size: %d bytes, time: %v\n", len(playerJSContent), time.Since(start))
+	log.Debug("Got player.js", slog.Int("bytes", len(playerJSContent)), slog.Duration("elapsed", time.Since(start)))
 
 	// Method 1: Minimal JS environment (preferred)
-	fmt.Printf("[DEBUG] Trying minimal JS decipher method\n")
+	log.Debug("Trying minimal JS decipher method")
 	if out, ok := tryMiniJSDecipher(string(playerJSContent), signature); ok {
-		fmt.Printf("[DEBUG] Minimal JS decipher successful\n")
+		log.Debug("Minimal JS decipher successful")
 		// Cache successful result
 		signatureCacheMu.Lock()
 		signatureCache[signature] = signatureCacheEntry{
@@ -262,12 +301,12 @@ func Decipher(httpClient *http.Client, playerJSURL string, signature string) (st
 		signatureCacheMu.Unlock()
 		return out, nil
 	}
-	fmt.Printf("[DEBUG] Minimal JS decipher failed\n")
+	log.Debug("Minimal JS decipher failed")
 
 	// Method 2: Regex parser (fast fallback)
-	fmt.Printf("[DEBUG] Trying regex decipher method\n")
-	if out, ok := tryRegexDecipher(string(playerJSContent), signature); ok {
-		fmt.Printf("[DEBUG] Regex decipher successful\n")
+	log.Debug("Trying regex decipher method")
+	if out, ok := tryRegexDecipher(string(playerJSContent), signature, log); ok {
+		log.Debug("Regex decipher successful")
 		// Cache successful result
 		signatureCacheMu.Lock()
 		signatureCache[signature] = signatureCacheEntry{
@@ -277,12 +316,12 @@ func Decipher(httpClient *http.Client, playerJSURL string, signature string) (st
 		signatureCacheMu.Unlock()
 		return out, nil
 	}
-	fmt.Printf("[DEBUG] Regex decipher failed\n")
+	log.Debug("Regex decipher failed")
 
 	// Method 3: Full otto execution (last resort)
-	fmt.Printf("[DEBUG] Trying otto decipher method\n")
+	log.Debug("Trying otto decipher method")
 	if out, ok := tryOttoDecipher(string(playerJSContent), signature); ok {
-		fmt.Printf("[DEBUG] Otto decipher successful\n")
+		log.Debug("Otto decipher successful")
 		// Cache successful result
 		signatureCacheMu.Lock()
 		signatureCache[signature] = signatureCacheEntry{
@@ -292,12 +331,12 @@ func Decipher(httpClient *http.Client, playerJSURL string, signature string) (st
 		signatureCacheMu.Unlock()
 		return out, nil
 	}
-	fmt.Printf("[DEBUG] Otto decipher failed\n")
+	log.Debug("Otto decipher failed")
 
 	// Method 4: Pattern-based fallback (last guard)
-	fmt.Printf("[DEBUG] Trying pattern fallback method\n")
+	log.Debug("Trying pattern fallback method")
 	if out, ok := tryPatternFallback(string(playerJSContent), signature); ok {
-		fmt.Printf("[DEBUG] Pattern fallback successful\n")
+		log.Debug("Pattern fallback successful")
 		// Cache successful result
 		signatureCacheMu.Lock()
 		signatureCache[signature] = signatureCacheEntry{
@@ -307,7 +346,7 @@ func Decipher(httpClient *http.Client, playerJSURL string, signature string) (st
 		signatureCacheMu.Unlock()
 		return out, nil
 	}
-	fmt.Printf("[DEBUG] Pattern fallback failed\n")
+	log.Warn("All decipher methods failed", slog.Duration("elapsed", time.Since(start)))
 
 	// Update timing metrics
 	elapsed := time.Since(start)
@@ -548,9 +587,10 @@ func tryMiniJSDecipher(playerJS string, signature string) (string, bool) {
 	return res, true
 }
 
-// DecipherN decodes the n-parameter (throttling) if player.js contains ncode().
-func DecipherN(httpClient *http.Client, playerJSURL string, nval string) (string, error) {
-	playerJSContent, err := getPlayerJS(httpClient, playerJSURL)
+// DecipherN decodes the n-parameter (throttling) if player.js contains
+// ncode(). The original value is returned when ncode() is missing or fails.
+func (d *Decipherer) DecipherN(playerJSURL string, nval string) (string, error) {
+	playerJSContent, err := getPlayerJS(d.httpClient, playerJSURL)
 	if err != nil {
 		return "", err
 	}
@@ -569,6 +609,7 @@ func DecipherN(httpClient *http.Client, playerJSURL string, nval string) (string
 	// Try to call ncode; if absent – return the original value
 	fn, err := vm.Get(ncodeFuncName)
 	if err != nil || !fn.IsFunction() {
+		d.log().Debug("player.js has no ncode function, keeping n")
 		return nval, nil
 	}
 	value, err := vm.Call(ncodeFuncName, nil, nval)
@@ -1,9 +1,12 @@
 package cipher
 
 import (
+	"bytes"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -318,6 +321,32 @@ func TestDecipherWithOtto(t *testing.T) {
 	}
 }
 
+func TestDeciphererLogger(t *testing.T) {
+	playerJSContent, err := os.ReadFile("testdata/player.js")
+	if err != nil {
+		t.Fatalf("Failed to read test player.js: %v", err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(playerJSContent)
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	d := NewDecipherer(server.Client()).
+		WithLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	sig := "LoggerTestSignature0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	if _, err := d.Decipher(server.URL, sig); err != nil {
+		t.Fatalf("Decipher returned an error: %v", err)
+	}
+	logs := buf.String()
+	if strings.Contains(logs, sig) {
+		t.Errorf("signature leaked into logs: %s", logs)
+	}
+	if !strings.Contains(logs, "stage=decipher") || !strings.Contains(logs, "length=") {
+		t.Errorf("unexpected logs: %s", logs)
+	}
+}
+
 func TestDecipherN(t *testing.T) {
 	playerJSContent, err := os.ReadFile("testdata/player.js")
 	if err != nil {
@@ -653,7 +682,7 @@ func TestTryRegexDecipher(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, success := tryRegexDecipher(tt.playerJS, tt.signature)
+			result, success := tryRegexDecipher(tt.playerJS, tt.signature, nil)
 			if success != tt.success {
 				t.Errorf("Expected success %v, got %v", tt.success, success)
 			}
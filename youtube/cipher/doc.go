@@ -71,7 +71,13 @@ The package includes built-in metrics that can be used to monitor performance:
 - Average decryption time
 - Total requests processed
 
-These metrics are logged periodically and can be used for monitoring and optimization.
+These metrics are logged periodically at debug level through slog.Default().
+
+# Logging
+
+Decipherer carries an optional *slog.Logger (see NewDecipherer and
+WithLogger); the package-level Decipher and DecipherN use slog.Default().
+Messages carry stage=decipher. Signatures are never logged, only their length.
 
 # Error Codes
 
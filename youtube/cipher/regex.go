@@ -3,12 +3,14 @@ package cipher
 import (
 	"crypto/sha1"
 	"encoding/hex"
-	"fmt"
+	"log/slog"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/ytget/ytdlp/v2/internal/logging"
 )
 
 type regexStep struct {
@@ -27,25 +29,16 @@ func cacheKeyForJS(playerJS string) string {
 }
 
 // tryRegexDecipher attempts to parse player.js and decipher signature without JS execution.
-func tryRegexDecipher(playerJS string, signature string) (string, bool) {
+func tryRegexDecipher(playerJS string, signature string, log *slog.Logger) (string, bool) {
 	start := time.Now()
-	fmt.Printf("[DEBUG] Starting regex decipher for signature: %s\n", signature)
-
 	key := cacheKeyForJS(playerJS)
-	fmt.Printf("[DEBUG] Generated cache key: %s\n", key)
 
 	regexParseMu.Lock()
 	steps, ok := regexParseCache[key]
 	regexParseMu.Unlock()
 
-	if ok {
-		fmt.Printf("[DEBUG] Found cached steps for key\n")
-	} else {
-		fmt.Printf("[DEBUG] No cached steps found, parsing player.js\n")
-	}
-
 	if !ok {
-		fmt.Printf("[DEBUG] Starting regex parsing, elapsed: %v\n", time.Since(start))
+		logging.Or(log).Debug("Parsing player.js for decipher steps", slog.String("player_js", key[:12]))
 		var parsed []regexStep
 		// 1) Find candidate decipher function (name, param, body)
 		// Try multiple regex patterns for different function formats
@@ -250,6 +243,7 @@ func tryRegexDecipher(playerJS string, signature string) (string, bool) {
 		regexParseCache[key] = parsed
 		regexParseMu.Unlock()
 		steps = parsed
+		logging.Or(log).Debug("Parsed decipher steps", slog.Int("steps", len(parsed)), slog.Duration("elapsed", time.Since(start)))
 	}
 
 	// Apply transforms
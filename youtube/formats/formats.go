@@ -2,18 +2,21 @@ package formats
 
 import (
 	"fmt"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
 
+	"github.com/ytget/ytdlp/v2/internal/logging"
 	"github.com/ytget/ytdlp/v2/types"
 	"github.com/ytget/ytdlp/v2/youtube/cipher"
 	"github.com/ytget/ytdlp/v2/youtube/innertube"
 )
 
-var heightRe = regexp.MustCompile(`([0-9]{3,4})p`)
+// labelFPSRe matches the frame rate in quality labels such as "1080p60".
+var labelFPSRe = regexp.MustCompile(`[0-9]{3,4}p([0-9]{2,3})`)
 
 func getSubtype(mime string) string {
 	mime = strings.ToLower(strings.TrimSpace(mime))
@@ -27,9 +30,9 @@ func getSubtype(mime string) string {
 	return ""
 }
 
-func parseHeight(label string) int {
-	m := heightRe.FindStringSubmatch(label)
-	if len(m) >= 2 {
+// parseLabelFPS extracts the frame rate from a quality label like "2160p60 HDR".
+func parseLabelFPS(label string) int {
+	if m := labelFPSRe.FindStringSubmatch(label); m != nil {
 		if v, err := strconv.Atoi(m[1]); err == nil {
 			return v
 		}
@@ -37,61 +40,215 @@ func parseHeight(label string) int {
 	return 0
 }
 
-// ParseFormats parses the InnerTube player response and returns a list of
-// available media formats (both progressive and adaptive) with minimal fields.
-func ParseFormats(data *innertube.PlayerResponse) ([]types.Format, error) {
-	var formats []types.Format
-	allFormats := append(data.StreamingData.Formats, data.StreamingData.AdaptiveFormats...)
-
-	for _, formatData := range allFormats {
-		f, ok := formatData.(map[string]any)
-		if !ok {
-			continue
+// dynamicRange classifies a video format as SDR, HDR10 (PQ) or HLG from its
+// transfer characteristics, falling back to the quality label and the VP9
+// profile 2 codec string when no color info is reported.
+func dynamicRange(colorInfo *innertube.ColorInfo, qualityLabel, vcodec string) string {
+	if colorInfo != nil {
+		switch tc := colorInfo.TransferCharacteristics; {
+		case strings.HasSuffix(tc, "SMPTEST2084"):
+			return types.DynamicRangeHDR10
+		case strings.HasSuffix(tc, "ARIB_STD_B67"):
+			return types.DynamicRangeHLG
+		case tc != "":
+			return types.DynamicRangeSDR
 		}
+	}
+	if strings.Contains(strings.ToUpper(qualityLabel), "HDR") || strings.HasPrefix(vcodec, "vp09.02") {
+		return types.DynamicRangeHDR
+	}
+	return types.DynamicRangeSDR
+}
 
-		var itag int
-		if v, ok := f["itag"].(float64); ok {
-			iTAG := int(v)
-			itag = iTAG
-		}
+// projection normalizes projectionType (e.g. "EQUIRECTANGULAR_THREED_TOP_BOTTOM")
+// and stereoLayout (e.g. "STEREO_LAYOUT_LEFT_RIGHT") of a video format.
+func projection(projectionType, stereoLayout string) (string, string) {
+	proj, stereo := types.ProjectionRectangular, types.StereoMono
+	switch pt := strings.ToUpper(projectionType); {
+	case strings.HasPrefix(pt, "EQUIRECTANGULAR"):
+		proj = types.ProjectionEquirectangular
+		if strings.HasSuffix(pt, "TOP_BOTTOM") {
+			stereo = types.StereoTopBottom
+		}
+	case pt == "MESH":
+		proj = types.ProjectionMesh
+	}
+	switch sl := strings.ToUpper(stereoLayout); {
+	case strings.HasSuffix(sl, "LEFT_RIGHT"):
+		stereo = types.StereoLeftRight
+	case strings.HasSuffix(sl, "TOP_BOTTOM"):
+		stereo = types.StereoTopBottom
+	}
+	return proj, stereo
+}
 
-		var bitrate int
-		if v, ok := f["bitrate"].(float64); ok {
-			bitrate = int(v)
+// splitCodecs splits the codecs parameter of a MIME type into video and audio
+// codec strings. For audio/* types every codec is treated as audio; for video/*
+// types the first codec is video and the second (if any) is audio.
+func splitCodecs(mime string) (vcodec, acodec string) {
+	lower := strings.ToLower(mime)
+	i := strings.Index(lower, "codecs=")
+	if i < 0 {
+		return "", ""
+	}
+	raw := strings.Trim(strings.TrimSpace(mime[i+len("codecs="):]), `"`)
+	var codecs []string
+	for _, c := range strings.Split(raw, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			codecs = append(codecs, c)
 		}
+	}
+	if len(codecs) == 0 {
+		return "", ""
+	}
+	if strings.HasPrefix(strings.TrimSpace(lower), "audio/") {
+		return "", codecs[0]
+	}
+	vcodec = codecs[0]
+	if len(codecs) > 1 {
+		acodec = codecs[1]
+	}
+	return vcodec, acodec
+}
 
-		var size int64
-		if v, ok := f["contentLength"].(string); ok {
-			if parsed, err := strconv.ParseInt(v, 10, 64); err == nil {
-				size = parsed
-			}
-		}
+// trackLanguage extracts the language tag from an audio track ID such as
+// "de.3" or "en-US.4".
+func trackLanguage(id string) string {
+	lang, _, _ := strings.Cut(id, ".")
+	return lang
+}
 
-		mimeType, _ := f["mimeType"].(string)
-		quality, _ := f["qualityLabel"].(string)
+// EstimateSize estimates the size in bytes of f from its average bitrate
+// (or peak bitrate when unknown) and approximate duration. It returns 0 when
+// either is missing.
+func EstimateSize(f types.Format) int64 {
+	br := f.AverageBitrate
+	if br <= 0 {
+		br = f.Bitrate
+	}
+	if br <= 0 || f.ApproxDurationMs <= 0 {
+		return 0
+	}
+	return int64(br) * f.ApproxDurationMs / 8000
+}
 
-		format := types.Format{
-			Itag:     itag,
-			MimeType: mimeType,
-			Quality:  quality,
-			Bitrate:  bitrate,
-			Size:     size,
+// parseFormat converts a single streamingData format into types.Format.
+func parseFormat(f innertube.Format, adaptive bool) types.Format {
+	format := types.Format{
+		Itag:             f.Itag,
+		URL:              f.URL,
+		MimeType:         f.MimeType,
+		Quality:          f.QualityLabel,
+		Bitrate:          f.Bitrate,
+		Size:             int64(f.ContentLength),
+		Adaptive:         adaptive,
+		Width:            f.Width,
+		Height:           f.Height,
+		FPS:              f.FPS,
+		AudioSampleRate:  int(f.AudioSampleRate),
+		AudioChannels:    f.AudioChannels,
+		AudioQuality:     f.AudioQuality,
+		AverageBitrate:   f.AverageBitrate,
+		ApproxDurationMs: int64(f.ApproxDurationMs),
+		LastModified:     int64(f.LastModified),
+		ProjectionType:   f.ProjectionType,
+	}
+	if f.URL == "" {
+		format.SignatureCipher = f.SignatureCipher
+	}
+	format.VCodec, format.ACodec = splitCodecs(f.MimeType)
+	if format.VCodec != "" || f.QualityLabel != "" {
+		if format.FPS == 0 {
+			format.FPS = parseLabelFPS(f.QualityLabel)
 		}
-
-		if urlVal, ok := f["url"].(string); ok {
-			format.URL = urlVal
-		} else if sc, ok := f["signatureCipher"].(string); ok {
-			format.SignatureCipher = sc
+		format.DynamicRange = dynamicRange(f.ColorInfo, f.QualityLabel, format.VCodec)
+		format.Projection, format.StereoLayout = projection(f.ProjectionType, f.StereoLayout)
+	}
+	if format.Size == 0 {
+		if est := EstimateSize(format); est > 0 {
+			format.Size = est
+			format.SizeEstimated = true
+		}
+	}
+	if f.InitRange != nil {
+		format.InitRange = &types.ByteRange{Start: int64(f.InitRange.Start), End: int64(f.InitRange.End)}
+	}
+	if f.IndexRange != nil {
+		format.IndexRange = &types.ByteRange{Start: int64(f.IndexRange.Start), End: int64(f.IndexRange.End)}
+	}
+	if f.AudioTrack != nil {
+		format.AudioTrack = &types.AudioTrack{
+			ID:          f.AudioTrack.ID,
+			DisplayName: f.AudioTrack.DisplayName,
+			IsDefault:   f.AudioTrack.AudioIsDefault,
+		}
+		format.Language = trackLanguage(f.AudioTrack.ID)
+	}
+	if f.ColorInfo != nil {
+		format.ColorInfo = &types.ColorInfo{
+			Primaries:               f.ColorInfo.Primaries,
+			TransferCharacteristics: f.ColorInfo.TransferCharacteristics,
+			MatrixCoefficients:      f.ColorInfo.MatrixCoefficients,
 		}
+	}
+	return format
+}
 
-		formats = append(formats, format)
+// ParseFormats parses the InnerTube player response and returns a list of
+// available media formats (both progressive and adaptive) with their stream
+// metadata.
+func ParseFormats(data *innertube.PlayerResponse) ([]types.Format, error) {
+	formats := make([]types.Format, 0, len(data.StreamingData.Formats)+len(data.StreamingData.AdaptiveFormats))
+	for _, f := range data.StreamingData.Formats {
+		formats = append(formats, parseFormat(f, false))
+	}
+	for _, f := range data.StreamingData.AdaptiveFormats {
+		formats = append(formats, parseFormat(f, true))
 	}
 	return formats, nil
 }
 
+// Resolver turns format URLs and signature ciphers into playable URLs with a
+// given HTTP client and logger.
+type Resolver struct {
+	cipher *cipher.Decipherer
+	logger *slog.Logger
+}
+
+// NewResolver returns a Resolver that downloads player.js with httpClient.
+func NewResolver(httpClient *http.Client) *Resolver {
+	return &Resolver{cipher: cipher.NewDecipherer(httpClient)}
+}
+
+// WithLogger sets the logger for diagnostics, also used for deciphering; nil
+// uses slog.Default(). Logged URLs have their signatures redacted.
+func (r *Resolver) WithLogger(l *slog.Logger) *Resolver {
+	r.logger = l
+	r.cipher.WithLogger(l)
+	return r
+}
+
+func (r *Resolver) log() *slog.Logger {
+	return logging.Or(r.logger).With(slog.String(logging.KeyStage, logging.StageResolve))
+}
+
 // DecryptSignatures decrypts signatures for formats that use a signatureCipher
 // by invoking cipher.Decipher and updating the URL in-place.
 func DecryptSignatures(httpClient *http.Client, formats []types.Format, playerJSURL string) error {
+	return NewResolver(httpClient).DecryptSignatures(formats, playerJSURL)
+}
+
+// ResolveFormatURL builds the final downloadable URL for a selected format.
+// If URL is present, optionally decodes 'n'. If signatureCipher is present, deciphers 's' and builds URL.
+func ResolveFormatURL(httpClient *http.Client, f types.Format, playerJSURL string) (string, error) {
+	return NewResolver(httpClient).ResolveFormatURL(f, playerJSURL)
+}
+
+// DecryptSignatures is the package-level DecryptSignatures using r's HTTP
+// client and logger. Formats that cannot be deciphered are skipped with a
+// warning.
+func (r *Resolver) DecryptSignatures(formats []types.Format, playerJSURL string) error {
+	log := r.log()
 	successCount := 0
 	totalCount := 0
 	skippedCount := 0
@@ -110,7 +267,7 @@ func DecryptSignatures(httpClient *http.Client, formats []types.Format, playerJS
 
 		parsedCipher, err := url.ParseQuery(formats[i].SignatureCipher)
 		if err != nil {
-			fmt.Printf("Warning: Failed to parse cipher for format %d: %v\n", formats[i].Itag, err)
+			log.Warn("Failed to parse signature cipher", slog.Int(logging.KeyItag, formats[i].Itag), logging.Err(err))
 			skippedCount++
 			continue
 		}
@@ -122,23 +279,23 @@ func DecryptSignatures(httpClient *http.Client, formats []types.Format, playerJS
 		}
 		cipherURL := parsedCipher.Get("url")
 		if cipherURL == "" || sig == "" {
-			fmt.Printf("Warning: Missing signature or URL for format %d\n", formats[i].Itag)
+			log.Warn("Signature cipher lacks signature or URL", slog.Int(logging.KeyItag, formats[i].Itag))
 			skippedCount++
 			continue
 		}
 
 		// Try to decrypt with timeout
-		decipheredSig, err := cipher.Decipher(httpClient, playerJSURL, sig)
+		decipheredSig, err := r.cipher.Decipher(playerJSURL, sig)
 		if err != nil {
 			// Log error but continue with other formats
-			fmt.Printf("Warning: Failed to decipher signature for format %d: %v\n", formats[i].Itag, err)
+			log.Warn("Failed to decipher signature", slog.Int(logging.KeyItag, formats[i].Itag), logging.Err(err))
 			skippedCount++
 			continue
 		}
 
 		finalURL, err := url.Parse(cipherURL)
 		if err != nil {
-			fmt.Printf("Warning: Failed to parse URL for format %d: %v\n", formats[i].Itag, err)
+			log.Warn("Failed to parse cipher URL", slog.Int(logging.KeyItag, formats[i].Itag), logging.Err(err))
 			skippedCount++
 			continue
 		}
@@ -147,7 +304,7 @@ func DecryptSignatures(httpClient *http.Client, formats []types.Format, playerJS
 		query.Set(sp, decipheredSig)
 		// Apply n-parameter decoding if present
 		if nval := query.Get("n"); nval != "" {
-			if nOut, err := cipher.DecipherN(httpClient, playerJSURL, nval); err == nil && nOut != "" {
+			if nOut, err := r.cipher.DecipherN(playerJSURL, nval); err == nil && nOut != "" {
 				query.Set("n", nOut)
 			}
 		}
@@ -165,145 +322,111 @@ func DecryptSignatures(httpClient *http.Client, formats []types.Format, playerJS
 		successCount++
 	}
 
-	fmt.Printf("Signature decryption: %d/%d formats processed successfully, %d skipped\n", successCount, totalCount, skippedCount)
-
-	// If we have no formats with URLs, try to use formats without signatures
+	log.Debug("Signature decryption finished",
+		slog.Int("available", successCount), slog.Int("ciphered", totalCount), slog.Int("skipped", skippedCount))
 	if successCount == 0 {
-		fmt.Printf("No formats with decrypted signatures, trying formats without signatures...\n")
-		for i := range formats {
-			if formats[i].URL != "" {
-				successCount++
-			}
-		}
-		fmt.Printf("Total formats available: %d\n", successCount)
+		log.Warn("No formats with a playable URL")
 	}
 
 	return nil
 }
 
-// SelectFormat chooses the best format according to criteria without requiring direct URLs.
-// Supported selectors:
-//   - ext: file extension ("mp4", "webm")
-//   - itag=NN: specific format by itag (e.g., "itag=22" for 720p MP4)
-//   - best: highest quality (height, then bitrate)
-//   - worst: lowest quality
-//   - height<=NNN: height no more than NNN (e.g., "height<=720")
-//   - height>=NNN: height no less than NNN (e.g., "height>=480")
-//
-// If selector is absent or no match found, heuristic is used:
-// prefer itag 22 (720p MP4), then itag 18 (360p MP4),
-// then progressive mp4 with avc1, else first available.
+// SelectFormat chooses a single format according to the selector. It is a
+// convenience wrapper around SelectFormats that returns the first selected
+// format, or nil when the selector is invalid or matches nothing.
 func SelectFormat(formats []types.Format, quality, ext string) *types.Format {
-	all := make([]types.Format, 0, len(formats))
-	all = append(all, formats...)
+	selected, err := SelectFormats(formats, quality, ext)
+	if err != nil || len(selected) == 0 {
+		return nil
+	}
+	return &selected[0]
+}
+
+// SelectFormats chooses formats according to a selector (see Selector for the
+// grammar) and an optional extension filter ("mp4", "webm").
+//
+// Legacy selectors such as "itag=22" and "height<=720" are accepted and
+// treated as filters on "best". A merge request ("bestvideo+bestaudio")
+// returns one format per part. When the selector is empty, a default
+// heuristic is used: prefer itag 22 (720p MP4), then itag 18 (360p MP4), then
+// progressive mp4 with avc1, then any format with a direct URL.
+//
+// Invalid selectors yield a *SelectorError; selectors that match nothing
+// yield an error wrapping ErrNoMatchingFormat.
+func SelectFormats(formats []types.Format, selector, ext string) ([]types.Format, error) {
+	return SelectFormatsSorted(formats, selector, "", ext)
+}
+
+// SelectFormatsSorted is like SelectFormats but ranks candidates for
+// best/worst according to sortSpec (see SortOrder). When a sort spec is given
+// and the selector is empty, "best" is used instead of the default heuristic.
+// The selector may name a preset (see Preset).
+func SelectFormatsSorted(formats []types.Format, selector, sortSpec, ext string) ([]types.Format, error) {
+	selector, sortSpec = ExpandPreset(selector, sortSpec)
+	order, err := ParseSortOrder(sortSpec)
+	if err != nil {
+		return nil, err
+	}
 
 	// filter by extension if provided
-	filtered := make([]types.Format, 0, len(all))
-	for i := range all {
-		if mimeSubtypeEquals(all[i], ext) {
-			filtered = append(filtered, all[i])
+	filtered := make([]types.Format, 0, len(formats))
+	for i := range formats {
+		if mimeSubtypeEquals(formats[i], ext) {
+			filtered = append(filtered, formats[i])
 		}
 	}
 	if len(filtered) == 0 {
-		filtered = all
-	}
-
-	q := strings.TrimSpace(strings.ToLower(quality))
-	// explicit itag selector
-	if strings.HasPrefix(q, "itag=") {
-		val := strings.TrimPrefix(q, "itag=")
-		if it, err := strconv.Atoi(val); err == nil {
-			for i := range filtered {
-				if itagEquals(filtered[i], it) {
-					return &filtered[i]
-				}
-			}
-		}
+		filtered = append(filtered, formats...)
 	}
 
-	// height constraints
-	var minH, maxH int
-	if strings.HasPrefix(q, "height<=") {
-		if v, err := strconv.Atoi(strings.TrimPrefix(q, "height<=")); err == nil {
-			maxH = v
-		}
-	}
-	if strings.HasPrefix(q, "height>=") {
-		if v, err := strconv.Atoi(strings.TrimPrefix(q, "height>=")); err == nil {
-			minH = v
-		}
-	}
-	if minH > 0 || maxH > 0 {
-		tmp := filtered[:0]
-		for i := range filtered {
-			if withinHeight(filtered[i], minH, maxH) {
-				tmp = append(tmp, filtered[i])
-			}
-		}
-		if len(tmp) > 0 {
-			filtered = tmp
+	if strings.TrimSpace(selector) == "" {
+		if strings.TrimSpace(sortSpec) != "" {
+			selector = "best"
+		} else if f := defaultFormat(filtered); f != nil {
+			return []types.Format{*f}, nil
+		} else {
+			return nil, ErrNoMatchingFormat
 		}
 	}
 
-	// best/worst using height then bitrate
-	if q == "best" || q == "worst" {
-		best := filtered[0]
-		for _, f := range filtered[1:] {
-			if betterByHeightThenBitrate(f, best) {
-				best = f
-			}
-		}
-		if q == "best" {
-			return &best
-		}
-		// worst: pick opposite
-		worst := filtered[0]
-		for _, f := range filtered[1:] {
-			if betterByHeightThenBitrate(worst, f) {
-				worst = f
-			}
-		}
-		return &worst
+	sel, err := ParseSelector(selector)
+	if err != nil {
+		return nil, err
 	}
+	return sel.SelectWithOrder(filtered, order)
+}
 
-	// Backward compatibility: itag 22 -> 18
-	var itag22, itag18 *types.Format
-	for i := range filtered {
-		if filtered[i].Itag == 22 {
-			iTAG22 := filtered[i]
-			itag22 = &iTAG22
-		}
-		if filtered[i].Itag == 18 {
-			iTAG18 := filtered[i]
-			itag18 = &iTAG18
-		}
-	}
-	if itag22 != nil {
-		return itag22
+// defaultFormat implements the heuristic used when no selector is given.
+func defaultFormat(formats []types.Format) *types.Format {
+	if len(formats) == 0 {
+		return nil
 	}
-	if itag18 != nil {
-		return itag18
+	for _, itag := range []int{22, 18} {
+		for i := range formats {
+			if itagEquals(formats[i], itag) {
+				return &formats[i]
+			}
+		}
 	}
-
 	// progressive mp4 with avc1 preference
-	for i := range filtered {
-		if strings.Contains(filtered[i].MimeType, "video/mp4") && strings.Contains(filtered[i].MimeType, "avc1") {
-			return &filtered[i]
+	for i := range formats {
+		if isProgressiveAVC(formats[i]) {
+			return &formats[i]
 		}
 	}
 	// prefer any with direct URL
-	for i := range filtered {
-		if hasDirectURL(filtered[i]) {
-			return &filtered[i]
+	for i := range formats {
+		if hasDirectURL(formats[i]) {
+			return &formats[i]
 		}
 	}
-	// fallback
-	return &filtered[0]
+	return &formats[0]
 }
 
-// ResolveFormatURL builds the final downloadable URL for a selected format.
-// If URL is present, optionally decodes 'n'. If signatureCipher is present, deciphers 's' and builds URL.
-func ResolveFormatURL(httpClient *http.Client, f types.Format, playerJSURL string) (string, error) {
+// ResolveFormatURL is the package-level ResolveFormatURL using r's HTTP
+// client and logger.
+func (r *Resolver) ResolveFormatURL(f types.Format, playerJSURL string) (string, error) {
+	r.log().Debug("Resolving format URL", slog.Int(logging.KeyItag, f.Itag), slog.Bool("ciphered", strings.TrimSpace(f.URL) == ""))
 	if strings.TrimSpace(f.URL) != "" {
 		u, err := url.Parse(f.URL)
 		if err != nil {
@@ -311,7 +434,7 @@ func ResolveFormatURL(httpClient *http.Client, f types.Format, playerJSURL strin
 		}
 		q := u.Query()
 		if nval := q.Get("n"); nval != "" {
-			if nout, err := cipher.DecipherN(httpClient, playerJSURL, nval); err == nil && nout != "" {
+			if nout, err := r.cipher.DecipherN(playerJSURL, nval); err == nil && nout != "" {
 				q.Set("n", nout)
 				u.RawQuery = q.Encode()
 			}
@@ -344,7 +467,7 @@ func ResolveFormatURL(httpClient *http.Client, f types.Format, playerJSURL strin
 	if cipherURL == "" || sig == "" {
 		return "", fmt.Errorf("signatureCipher missing signature or url")
 	}
-	decodedSig, err := cipher.Decipher(httpClient, playerJSURL, sig)
+	decodedSig, err := r.cipher.Decipher(playerJSURL, sig)
 	if err != nil {
 		return "", fmt.Errorf("decipher signature failed: %v", err)
 	}
@@ -355,7 +478,7 @@ func ResolveFormatURL(httpClient *http.Client, f types.Format, playerJSURL strin
 	q := u.Query()
 	q.Set(sp, decodedSig)
 	if nval := q.Get("n"); nval != "" {
-		if nout, err := cipher.DecipherN(httpClient, playerJSURL, nval); err == nil && nout != "" {
+		if nout, err := r.cipher.DecipherN(playerJSURL, nval); err == nil && nout != "" {
 			q.Set("n", nout)
 		}
 	}
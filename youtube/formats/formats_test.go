@@ -1,7 +1,11 @@
 package formats
 
 import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
 	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/ytget/ytdlp/v2/types"
@@ -10,9 +14,9 @@ import (
 
 func TestSelectFormat_Ext_Itag(t *testing.T) {
 	list := []types.Format{
-		{Itag: 18, MimeType: "video/mp4", URL: "u1", Quality: "360p", Bitrate: 500000},
-		{Itag: 22, MimeType: "video/mp4", URL: "u2", Quality: "720p", Bitrate: 2000000},
-		{Itag: 100, MimeType: "video/webm", URL: "u3", Quality: "1080p", Bitrate: 3000000},
+		{Itag: 18, MimeType: "video/mp4", URL: "u1", Quality: "360p", Height: 360, Bitrate: 500000},
+		{Itag: 22, MimeType: "video/mp4", URL: "u2", Quality: "720p", Height: 720, Bitrate: 2000000},
+		{Itag: 100, MimeType: "video/webm", URL: "u3", Quality: "1080p", Height: 1080, Bitrate: 3000000},
 	}
 	if f := SelectFormat(list, "", "webm"); f == nil || f.URL != "u3" {
 		t.Fatalf("ext webm -> u3, got %+v", f)
@@ -24,9 +28,9 @@ func TestSelectFormat_Ext_Itag(t *testing.T) {
 
 func TestSelectFormat_BestWorst_Height(t *testing.T) {
 	list := []types.Format{
-		{Itag: 18, MimeType: "video/mp4", URL: "u1", Quality: "360p", Bitrate: 500000},
-		{Itag: 22, MimeType: "video/mp4", URL: "u2", Quality: "720p", Bitrate: 2000000},
-		{Itag: 100, MimeType: "video/webm", URL: "u3", Quality: "1080p", Bitrate: 3000000},
+		{Itag: 18, MimeType: "video/mp4", URL: "u1", Quality: "360p", Height: 360, Bitrate: 500000},
+		{Itag: 22, MimeType: "video/mp4", URL: "u2", Quality: "720p", Height: 720, Bitrate: 2000000},
+		{Itag: 100, MimeType: "video/webm", URL: "u3", Quality: "1080p", Height: 1080, Bitrate: 3000000},
 	}
 	if f := SelectFormat(list, "best", ""); f == nil || f.URL != "u3" {
 		t.Fatalf("best -> u3, got %+v", f)
@@ -52,28 +56,25 @@ func TestParseFormats(t *testing.T) {
 
 	// Test with valid data
 	data = &innertube.PlayerResponse{
-		StreamingData: struct {
-			Formats         []any `json:"formats"`
-			AdaptiveFormats []any `json:"adaptiveFormats"`
-		}{
-			Formats: []any{
-				map[string]any{
-					"itag":          float64(18),
-					"mimeType":      "video/mp4",
-					"qualityLabel":  "360p",
-					"bitrate":       float64(500000),
-					"contentLength": "1000000",
-					"url":           "https://example.com/video.mp4",
+		StreamingData: innertube.StreamingData{
+			Formats: []innertube.Format{
+				{
+					Itag:          18,
+					MimeType:      "video/mp4",
+					QualityLabel:  "360p",
+					Bitrate:       500000,
+					ContentLength: 1000000,
+					URL:           "https://example.com/video.mp4",
 				},
 			},
-			AdaptiveFormats: []any{
-				map[string]any{
-					"itag":            float64(22),
-					"mimeType":        "video/mp4",
-					"qualityLabel":    "720p",
-					"bitrate":         float64(2000000),
-					"contentLength":   "2000000",
-					"signatureCipher": "s=abc123",
+			AdaptiveFormats: []innertube.Format{
+				{
+					Itag:            22,
+					MimeType:        "video/mp4",
+					QualityLabel:    "720p",
+					Bitrate:         2000000,
+					ContentLength:   2000000,
+					SignatureCipher: "s=abc123",
 				},
 			},
 		},
@@ -105,30 +106,178 @@ func TestParseFormats(t *testing.T) {
 	if formats[1].SignatureCipher != "s=abc123" {
 		t.Errorf("Expected SignatureCipher 's=abc123', got '%s'", formats[1].SignatureCipher)
 	}
+	if formats[0].Adaptive || !formats[1].Adaptive {
+		t.Errorf("Expected progressive then adaptive, got %v/%v", formats[0].Adaptive, formats[1].Adaptive)
+	}
+}
+
+func TestParseFormatsMetadata(t *testing.T) {
+	raw := `{"streamingData":{"adaptiveFormats":[
+		{"itag":299,"mimeType":"video/mp4; codecs=\"avc1.64002a\"","bitrate":6000000,"averageBitrate":4500000,
+		 "width":1920,"height":1080,"fps":60,"qualityLabel":"1080p60","contentLength":"123456",
+		 "approxDurationMs":"212000","lastModified":"1700000000000000","projectionType":"RECTANGULAR",
+		 "initRange":{"start":"0","end":"739"},"indexRange":{"start":"740","end":"1299"},
+		 "colorInfo":{"primaries":"COLOR_PRIMARIES_BT709","transferCharacteristics":"COLOR_TRANSFER_CHARACTERISTICS_BT709"},
+		 "url":"https://example.com/v"},
+		{"itag":251,"mimeType":"audio/webm; codecs=\"opus\"","bitrate":130000,"audioQuality":"AUDIO_QUALITY_MEDIUM",
+		 "audioSampleRate":"48000","audioChannels":2,"url":"https://example.com/a",
+		 "audioTrack":{"id":"de-DE.3","displayName":"German (Germany)","audioIsDefault":false}}
+	]}}`
+	var pr innertube.PlayerResponse
+	if err := json.Unmarshal([]byte(raw), &pr); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	list, err := ParseFormats(&pr)
+	if err != nil || len(list) != 2 {
+		t.Fatalf("expected 2 formats, got %d (%v)", len(list), err)
+	}
+	v := list[0]
+	if v.Width != 1920 || v.Height != 1080 || v.FPS != 60 {
+		t.Errorf("unexpected dimensions: %dx%d@%d", v.Width, v.Height, v.FPS)
+	}
+	if v.VCodec != "avc1.64002a" || v.ACodec != "" || !v.Adaptive {
+		t.Errorf("unexpected codecs: %q/%q adaptive=%v", v.VCodec, v.ACodec, v.Adaptive)
+	}
+	if v.AverageBitrate != 4500000 || v.ApproxDurationMs != 212000 || v.LastModified != 1700000000000000 {
+		t.Errorf("unexpected bitrate/duration/lastModified: %+v", v)
+	}
+	if v.InitRange == nil || v.InitRange.End != 739 || v.IndexRange == nil || v.IndexRange.Start != 740 {
+		t.Errorf("unexpected ranges: %+v %+v", v.InitRange, v.IndexRange)
+	}
+	if v.ProjectionType != "RECTANGULAR" || v.ColorInfo == nil || v.ColorInfo.Primaries != "COLOR_PRIMARIES_BT709" {
+		t.Errorf("unexpected projection/color: %q %+v", v.ProjectionType, v.ColorInfo)
+	}
+	a := list[1]
+	if a.VCodec != "" || a.ACodec != "opus" || a.HasVideo() || !a.HasAudio() {
+		t.Errorf("unexpected audio codecs: %q/%q", a.VCodec, a.ACodec)
+	}
+	if a.AudioSampleRate != 48000 || a.AudioChannels != 2 || a.AudioQuality != "AUDIO_QUALITY_MEDIUM" {
+		t.Errorf("unexpected audio fields: %+v", a)
+	}
+	if a.AudioTrack == nil || a.AudioTrack.ID != "de-DE.3" || a.AudioTrack.DisplayName != "German (Germany)" || a.AudioTrack.IsDefault {
+		t.Errorf("unexpected audio track: %+v", a.AudioTrack)
+	}
+	if a.Language != "de-DE" || v.Language != "" {
+		t.Errorf("unexpected languages: %q/%q", a.Language, v.Language)
+	}
+}
+
+func TestParseFormatsEstimatedSize(t *testing.T) {
+	pr := &innertube.PlayerResponse{}
+	pr.StreamingData.AdaptiveFormats = []innertube.Format{
+		{Itag: 137, MimeType: `video/mp4; codecs="avc1.640028"`, Bitrate: 5000000, AverageBitrate: 4000000, ApproxDurationMs: 10000, URL: "https://example.com/v"},
+		{Itag: 140, MimeType: `audio/mp4; codecs="mp4a.40.2"`, Bitrate: 128000, ApproxDurationMs: 10000, ContentLength: 160000, URL: "https://example.com/a"},
+		{Itag: 251, MimeType: `audio/webm; codecs="opus"`, Bitrate: 128000, URL: "https://example.com/o"},
+	}
+	list, err := ParseFormats(pr)
+	if err != nil || len(list) != 3 {
+		t.Fatalf("expected 3 formats, got %d (%v)", len(list), err)
+	}
+	if list[0].Size != 5000000 || !list[0].SizeEstimated {
+		t.Errorf("expected estimated size 5000000, got %d (estimated=%v)", list[0].Size, list[0].SizeEstimated)
+	}
+	if list[1].Size != 160000 || list[1].SizeEstimated {
+		t.Errorf("expected exact size 160000, got %d (estimated=%v)", list[1].Size, list[1].SizeEstimated)
+	}
+	if list[2].Size != 0 || list[2].SizeEstimated {
+		t.Errorf("expected unknown size without duration, got %d (estimated=%v)", list[2].Size, list[2].SizeEstimated)
+	}
+}
+
+func TestParseFormatsVideoAttributes(t *testing.T) {
+	pr := &innertube.PlayerResponse{}
+	pr.StreamingData.AdaptiveFormats = []innertube.Format{
+		{Itag: 337, MimeType: `video/webm; codecs="vp09.02.51.10.01.09.16.09.00"`, QualityLabel: "2160p60 HDR", FPS: 60,
+			ColorInfo: &innertube.ColorInfo{Primaries: "COLOR_PRIMARIES_BT2020", TransferCharacteristics: "COLOR_TRANSFER_CHARACTERISTICS_SMPTEST2084"}},
+		{Itag: 701, MimeType: `video/mp4; codecs="av01.0.12M.10"`, QualityLabel: "1080p HDR",
+			ColorInfo: &innertube.ColorInfo{TransferCharacteristics: "COLOR_TRANSFER_CHARACTERISTICS_ARIB_STD_B67"}},
+		{Itag: 334, MimeType: `video/webm; codecs="vp09.02.20.10.01.09.16.09.00"`, QualityLabel: "720p50 HDR"},
+		{Itag: 137, MimeType: `video/mp4; codecs="avc1.640028"`, QualityLabel: "1080p", ProjectionType: "EQUIRECTANGULAR_THREED_TOP_BOTTOM",
+			ColorInfo: &innertube.ColorInfo{TransferCharacteristics: "COLOR_TRANSFER_CHARACTERISTICS_BT709"}},
+		{Itag: 136, MimeType: `video/mp4; codecs="avc1.4d401f"`, QualityLabel: "720p", ProjectionType: "MESH", StereoLayout: "STEREO_LAYOUT_LEFT_RIGHT"},
+		{Itag: 140, MimeType: `audio/mp4; codecs="mp4a.40.2"`},
+	}
+	list, err := ParseFormats(pr)
+	if err != nil || len(list) != 6 {
+		t.Fatalf("expected 6 formats, got %d (%v)", len(list), err)
+	}
+	tests := []struct {
+		dr, proj, stereo string
+		fps              int
+	}{
+		{types.DynamicRangeHDR10, types.ProjectionRectangular, types.StereoMono, 60},
+		{types.DynamicRangeHLG, types.ProjectionRectangular, types.StereoMono, 0},
+		{types.DynamicRangeHDR, types.ProjectionRectangular, types.StereoMono, 50},
+		{types.DynamicRangeSDR, types.ProjectionEquirectangular, types.StereoTopBottom, 0},
+		{types.DynamicRangeSDR, types.ProjectionMesh, types.StereoLeftRight, 0},
+		{"", "", "", 0},
+	}
+	for i, tt := range tests {
+		f := list[i]
+		if f.DynamicRange != tt.dr || f.Projection != tt.proj || f.StereoLayout != tt.stereo || f.FPS != tt.fps {
+			t.Errorf("itag %d: got %q/%q/%q@%d, want %q/%q/%q@%d", f.Itag,
+				f.DynamicRange, f.Projection, f.StereoLayout, f.FPS, tt.dr, tt.proj, tt.stereo, tt.fps)
+		}
+	}
+
+	sdr, err := SelectFormats(list, "bv[dynamic_range=SDR][projection=rectangular]/bv[dynamic_range=SDR]", "")
+	if err != nil || sdr[0].Itag != 137 {
+		t.Errorf("expected SDR itag 137, got %v (%v)", itagsOf(sdr), err)
+	}
+	flat, err := SelectFormats(list, "bv[dynamic_range=sdr][stereo_layout=mono]/bv[dynamic_range=hdr10]", "")
+	if err != nil || flat[0].Itag != 337 {
+		t.Errorf("expected fallback to HDR10 itag 337, got %v (%v)", itagsOf(flat), err)
+	}
+	hdr, err := SelectFormatsSorted(list, "bv", "hdr,res", "")
+	if err != nil || hdr[0].Itag != 337 {
+		t.Errorf("expected HDR10 first with hdr sort, got %v (%v)", itagsOf(hdr), err)
+	}
+	pref, err := SelectFormatsSorted(list, "bv", "hdr:sdr,res", "")
+	if err != nil || pref[0].DynamicRange != types.DynamicRangeSDR {
+		t.Errorf("expected SDR first with hdr:sdr, got %v (%v)", itagsOf(pref), err)
+	}
+}
+
+func TestSplitCodecs(t *testing.T) {
+	tests := []struct {
+		mime, v, a string
+	}{
+		{`video/mp4; codecs="avc1.42001E, mp4a.40.2"`, "avc1.42001E", "mp4a.40.2"},
+		{`video/webm; codecs="vp9"`, "vp9", ""},
+		{`audio/mp4; codecs="mp4a.40.2"`, "", "mp4a.40.2"},
+		{`video/mp4`, "", ""},
+	}
+	for _, tt := range tests {
+		v, a := splitCodecs(tt.mime)
+		if v != tt.v || a != tt.a {
+			t.Errorf("splitCodecs(%q) = %q, %q; want %q, %q", tt.mime, v, a, tt.v, tt.a)
+		}
+	}
 }
 
 func TestParseFormatsWithInvalidData(t *testing.T) {
+	// Integer fields may arrive as strings or numbers; anything else is
+	// rejected at decode time.
+	var pr innertube.PlayerResponse
+	if err := json.Unmarshal([]byte(`{"streamingData":{"formats":[{"itag":18,"contentLength":12}]}}`), &pr); err != nil {
+		t.Fatalf("unexpected decode error for numeric contentLength: %v", err)
+	}
+	if err := json.Unmarshal([]byte(`{"streamingData":{"formats":[{"itag":18,"contentLength":"12 bytes"}]}}`), &pr); err == nil {
+		t.Fatal("expected decode error for malformed contentLength")
+	}
+
+	// A format without url/signatureCipher is still listed with default values.
 	data := &innertube.PlayerResponse{
-		StreamingData: struct {
-			Formats         []any `json:"formats"`
-			AdaptiveFormats []any `json:"adaptiveFormats"`
-		}{
-			Formats: []any{
-				"invalid format data", // Not a map
-				map[string]any{
-					"itag": "invalid", // Invalid itag type
-				},
-			},
+		StreamingData: innertube.StreamingData{
+			Formats: []innertube.Format{{Itag: 18}},
 		},
 	}
-
 	formats, err := ParseFormats(data)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
-	// The function still creates formats even with invalid data, but with default values
-	if len(formats) != 1 {
-		t.Errorf("Expected 1 format (with invalid itag), got %d", len(formats))
+	if len(formats) != 1 || formats[0].URL != "" || formats[0].SignatureCipher != "" {
+		t.Errorf("Expected 1 format without url, got %+v", formats)
 	}
 }
 
@@ -229,94 +378,6 @@ func TestResolveFormatURL(t *testing.T) {
 	}
 }
 
-func TestParseHeight(t *testing.T) {
-	tests := []struct {
-		name     string
-		label    string
-		expected int
-	}{
-		{
-			name:     "Valid height 720p",
-			label:    "720p",
-			expected: 720,
-		},
-		{
-			name:     "Valid height 1080p",
-			label:    "1080p",
-			expected: 1080,
-		},
-		{
-			name:     "Valid height 480p",
-			label:    "480p",
-			expected: 480,
-		},
-		{
-			name:     "Valid height 1440p",
-			label:    "1440p",
-			expected: 1440,
-		},
-		{
-			name:     "Invalid height 2p",
-			label:    "2p",
-			expected: 0,
-		},
-		{
-			name:     "Invalid height 12p",
-			label:    "12p",
-			expected: 0,
-		},
-		{
-			name:     "No height in label",
-			label:    "audio only",
-			expected: 0,
-		},
-		{
-			name:     "Empty label",
-			label:    "",
-			expected: 0,
-		},
-		{
-			name:     "Height with extra text",
-			label:    "720p - High quality",
-			expected: 720,
-		},
-		{
-			name:     "Height with prefix text",
-			label:    "Video 1080p",
-			expected: 1080,
-		},
-		{
-			name:     "Height with multiple numbers",
-			label:    "720p 1080p",
-			expected: 720, // Should match first occurrence
-		},
-		{
-			name:     "Height with invalid format",
-			label:    "720",
-			expected: 0,
-		},
-		{
-			name:     "Height with lowercase p",
-			label:    "720p",
-			expected: 720,
-		},
-		{
-			name:     "Height with uppercase P",
-			label:    "720P",
-			expected: 0, // Regex is case sensitive
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			result := parseHeight(tt.label)
-			if result != tt.expected {
-				t.Errorf("Expected height %d, got %d for label: %s", tt.expected, result, tt.label)
-			}
-		})
-	}
-}
-
 func TestDecryptSignatures(t *testing.T) {
 	// Test with empty formats
 	formats := []types.Format{}
@@ -381,3 +442,21 @@ func TestDecryptSignatures(t *testing.T) {
 		t.Fatalf("Expected no error, got %v", err)
 	}
 }
+
+func TestResolverLogger(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewResolver(&http.Client{}).WithLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+	list := []types.Format{{Itag: 140, SignatureCipher: "s=SECRET&sp=sig"}}
+	if err := r.DecryptSignatures(list, "https://example.com/player.js"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	logs := buf.String()
+	if strings.Contains(logs, "SECRET") {
+		t.Errorf("signature leaked into logs: %s", logs)
+	}
+	for _, want := range []string{"level=WARN", "stage=resolve", "itag=140"} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("logs do not contain %q: %s", want, logs)
+		}
+	}
+}
@@ -30,31 +30,34 @@ func itagEquals(format types.Format, itag int) bool {
 	return itag > 0 && format.Itag == itag
 }
 
-// withinHeight checks whether the format's Quality label height is within [minHeight, maxHeight] constraints.
-// If a bound equals 0, that constraint is ignored (e.g., minHeight=0, maxHeight=720 means "any height up to 720p").
-// If both bounds are 0, all formats pass the check.
-func withinHeight(format types.Format, minHeight int, maxHeight int) bool {
-	if minHeight <= 0 && maxHeight <= 0 {
+// hasVideo reports whether the format carries video. Formats without codec
+// information are classified by MIME type: video/* streams carry video.
+func hasVideo(format types.Format) bool {
+	if format.VCodec != "" {
 		return true
 	}
-	h := parseHeight(format.Quality)
-	if minHeight > 0 && h < minHeight {
+	if format.ACodec != "" {
 		return false
 	}
-	if maxHeight > 0 && h > maxHeight {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(format.MimeType)), "video/")
+}
+
+// hasAudio reports whether the format carries audio. Formats without codec
+// information are classified by MIME type: audio/* and progressive streams
+// carry audio.
+func hasAudio(format types.Format) bool {
+	if format.ACodec != "" {
+		return true
+	}
+	if format.VCodec != "" {
 		return false
 	}
-	return true
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(format.MimeType)), "audio/") || format.IsProgressive()
 }
 
-// betterByHeightThenBitrate compares two formats and returns true when candidate is better than current
-// using height as primary criterion and bitrate as a tiebreaker.
-// This is used for implementing "best" and "worst" selectors.
-func betterByHeightThenBitrate(candidate types.Format, current types.Format) bool {
-	candidateHeight := parseHeight(candidate.Quality)
-	currentHeight := parseHeight(current.Quality)
-	if candidateHeight != currentHeight {
-		return candidateHeight > currentHeight
-	}
-	return candidate.Bitrate > current.Bitrate
+// isProgressiveAVC reports whether the format is a progressive MP4 stream with
+// an H.264 (avc1) video track, the most widely playable combination.
+func isProgressiveAVC(format types.Format) bool {
+	return format.IsProgressive() && getSubtype(format.MimeType) == "mp4" &&
+		strings.HasPrefix(strings.ToLower(format.VCodec), "avc1")
 }
@@ -28,30 +28,40 @@ func TestMimeSubtypeEquals(t *testing.T) {
 	}
 }
 
-func TestWithinHeight(t *testing.T) {
-	f := types.Format{Quality: "720p"}
-	if !withinHeight(f, 0, 0) {
-		t.Fatal("no bounds should pass")
+func TestHasVideoHasAudio(t *testing.T) {
+	tests := []struct {
+		name         string
+		f            types.Format
+		video, audio bool
+	}{
+		{"progressive with codecs", types.Format{MimeType: "video/mp4", VCodec: "avc1", ACodec: "mp4a.40.2"}, true, true},
+		{"video only", types.Format{MimeType: "video/webm", VCodec: "vp9", Adaptive: true}, true, false},
+		{"audio only", types.Format{MimeType: "audio/mp4", ACodec: "mp4a.40.2", Adaptive: true}, false, true},
+		{"progressive without codecs", types.Format{MimeType: "video/mp4"}, true, true},
+		{"adaptive video without codecs", types.Format{MimeType: "video/mp4", Adaptive: true}, true, false},
+		{"adaptive audio without codecs", types.Format{MimeType: "audio/webm", Adaptive: true}, false, true},
 	}
-	if !withinHeight(f, 480, 1080) {
-		t.Fatal("720p should be within 480..1080")
-	}
-	if withinHeight(f, 1080, 0) {
-		t.Fatal("720p should not be >=1080")
-	}
-	if withinHeight(f, 0, 360) {
-		t.Fatal("720p should not be <=360")
+	for _, tt := range tests {
+		if got := hasVideo(tt.f); got != tt.video {
+			t.Errorf("%s: hasVideo = %v", tt.name, got)
+		}
+		if got := hasAudio(tt.f); got != tt.audio {
+			t.Errorf("%s: hasAudio = %v", tt.name, got)
+		}
 	}
 }
 
-func TestBetterByHeightThenBitrate(t *testing.T) {
-	a := types.Format{Quality: "720p", Bitrate: 1}
-	b := types.Format{Quality: "1080p", Bitrate: 1}
-	if !betterByHeightThenBitrate(b, a) {
-		t.Fatal("1080p should be better than 720p")
-	}
-	c := types.Format{Quality: "720p", Bitrate: 100}
-	if !betterByHeightThenBitrate(c, a) {
-		t.Fatal("higher bitrate should be better at same height")
+func TestIsProgressiveAVC(t *testing.T) {
+	f := types.Format{MimeType: "video/mp4; codecs=\"avc1.42001E, mp4a.40.2\"", VCodec: "avc1.42001E", ACodec: "mp4a.40.2"}
+	if !isProgressiveAVC(f) {
+		t.Fatal("progressive avc1 mp4 should match")
+	}
+	f.Adaptive = true
+	if isProgressiveAVC(f) {
+		t.Fatal("adaptive stream should not match")
+	}
+	g := types.Format{MimeType: "video/webm; codecs=\"vp9\"", VCodec: "vp9"}
+	if isProgressiveAVC(g) {
+		t.Fatal("webm vp9 should not match")
 	}
 }
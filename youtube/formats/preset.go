@@ -0,0 +1,116 @@
+package formats
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Preset is a named format selector plus sort order, usable wherever a
+// selector is accepted (e.g. "apple" instead of a full selector).
+type Preset struct {
+	Name        string
+	Selector    string
+	Sort        string
+	Description string
+}
+
+// Built-in presets.
+var builtinPresets = []Preset{
+	{
+		Name:        "apple",
+		Selector:    "bv[vcodec^=avc1][ext=mp4]+ba[ext=m4a]/b[vcodec^=avc1][acodec^=mp4a][ext=mp4]",
+		Sort:        "res:1080,fps:30,br",
+		Description: "H.264 + AAC in MP4 up to 1080p30; plays on iOS, macOS and most smart TVs",
+	},
+	{
+		Name:        "web",
+		Selector:    "b[ext=mp4]/b[ext=webm]/b",
+		Sort:        "res:1080,proto,br",
+		Description: "Single-file MP4 (or WebM) that plays in any browser, up to 1080p",
+	},
+	{
+		Name:        "smallest",
+		Selector:    "b",
+		Sort:        "+size,+br,+res,+fps",
+		Description: "Smallest file that has both audio and video",
+	},
+	{
+		Name:        "archive",
+		Selector:    "bv+ba/b",
+		Sort:        "res,fps,vcodec,acodec,br",
+		Description: "Highest quality video merged with the best audio, preferring modern codecs; best single file otherwise",
+	},
+}
+
+var presetNameRe = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+var presetRegistry = struct {
+	sync.RWMutex
+	byName map[string]Preset
+}{byName: func() map[string]Preset {
+	m := make(map[string]Preset, len(builtinPresets))
+	for _, p := range builtinPresets {
+		m[p.Name] = p
+	}
+	return m
+}()}
+
+// RegisterPreset adds or replaces a preset. The name must be lower-case
+// ([a-z][a-z0-9_-]*) and must not itself be a valid selector such as "best"
+// or "mp4"; the selector and sort spec must compile.
+func RegisterPreset(p Preset) error {
+	p.Name = strings.TrimSpace(p.Name)
+	if !presetNameRe.MatchString(p.Name) {
+		return fmt.Errorf("invalid preset name %q", p.Name)
+	}
+	if _, err := ParseSelector(p.Name); err == nil {
+		return fmt.Errorf("preset name %q conflicts with a format selector", p.Name)
+	}
+	if _, err := ParseSelector(p.Selector); err != nil {
+		return fmt.Errorf("preset %q: %w", p.Name, err)
+	}
+	if _, err := ParseSortOrder(p.Sort); err != nil {
+		return fmt.Errorf("preset %q: %w", p.Name, err)
+	}
+	presetRegistry.Lock()
+	defer presetRegistry.Unlock()
+	presetRegistry.byName[p.Name] = p
+	return nil
+}
+
+// LookupPreset returns the preset with the given name (case-insensitive).
+func LookupPreset(name string) (Preset, bool) {
+	presetRegistry.RLock()
+	defer presetRegistry.RUnlock()
+	p, ok := presetRegistry.byName[strings.ToLower(strings.TrimSpace(name))]
+	return p, ok
+}
+
+// Presets returns all registered presets sorted by name.
+func Presets() []Preset {
+	presetRegistry.RLock()
+	out := make([]Preset, 0, len(presetRegistry.byName))
+	for _, p := range presetRegistry.byName {
+		out = append(out, p)
+	}
+	presetRegistry.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// ExpandPreset resolves selector when it names a preset and returns the
+// preset's selector and sort spec. An explicit sortSpec takes precedence over
+// the preset's. Other selectors are returned unchanged.
+func ExpandPreset(selector, sortSpec string) (string, string) {
+	p, ok := LookupPreset(selector)
+	if !ok {
+		return selector, sortSpec
+	}
+	if strings.TrimSpace(sortSpec) == "" {
+		sortSpec = p.Sort
+	}
+	return p.Selector, sortSpec
+}
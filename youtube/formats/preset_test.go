@@ -0,0 +1,88 @@
+package formats
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ytget/ytdlp/v2/types"
+)
+
+func TestBuiltinPresetsCompile(t *testing.T) {
+	for _, p := range Presets() {
+		if _, err := ParseSelector(p.Selector); err != nil {
+			t.Errorf("preset %q: selector: %v", p.Name, err)
+		}
+		if _, err := ParseSortOrder(p.Sort); err != nil {
+			t.Errorf("preset %q: sort: %v", p.Name, err)
+		}
+	}
+	for _, name := range []string{"apple", "web", "smallest", "archive"} {
+		if _, ok := LookupPreset(name); !ok {
+			t.Errorf("missing builtin preset %q", name)
+		}
+	}
+}
+
+func TestSelectFormats_Presets(t *testing.T) {
+	list := append(selectorTestFormats(),
+		selectorTestFormats()[0],
+	)
+	list[len(list)-1].Itag = 22
+	list[len(list)-1].Height = 720
+	list[len(list)-1].Bitrate = 1500000
+	list[len(list)-1].Size = 60 << 20
+
+	tests := map[string][]int{
+		"apple":    {137, 140},
+		"APPLE":    {137, 140},
+		"web":      {22},
+		"smallest": {18},
+		"archive":  {313, 251},
+	}
+	for name, want := range tests {
+		got, err := SelectFormats(list, name, "")
+		if err != nil || fmt.Sprint(itagsOf(got)) != fmt.Sprint(want) {
+			t.Errorf("%q: got %v (%v), want %v", name, itagsOf(got), err, want)
+		}
+	}
+	// Without adaptive streams both fall back to a single file.
+	progressive := []types.Format{list[0], list[len(list)-1]}
+	for _, name := range []string{"apple", "archive"} {
+		got, err := SelectFormats(progressive, name, "")
+		if err != nil || len(got) != 1 || got[0].Itag != 22 {
+			t.Errorf("%q without adaptive formats: got %v (%v), want 22", name, itagsOf(got), err)
+		}
+	}
+}
+
+func TestRegisterPreset(t *testing.T) {
+	if err := RegisterPreset(Preset{Name: "tiny-audio", Selector: "ba", Sort: "+abr"}); err != nil {
+		t.Fatalf("RegisterPreset: %v", err)
+	}
+	got, err := SelectFormats(selectorTestFormats(), "tiny-audio", "")
+	if err != nil || len(got) != 1 || got[0].Itag != 140 {
+		t.Errorf("tiny-audio: got %v (%v), want 140", itagsOf(got), err)
+	}
+	if sel, sortSpec := ExpandPreset("tiny-audio", "abr"); sel != "ba" || sortSpec != "abr" {
+		t.Errorf("explicit sort should win, got %q %q", sel, sortSpec)
+	}
+
+	bad := []Preset{
+		{Name: "", Selector: "b"},
+		{Name: "Has Space", Selector: "b"},
+		{Name: "best", Selector: "b"},
+		{Name: "mp4", Selector: "b"},
+		{Name: "broken", Selector: "bv[colour=red]"},
+		{Name: "broken", Selector: "b", Sort: "loudness"},
+	}
+	for _, p := range bad {
+		if err := RegisterPreset(p); err == nil {
+			t.Errorf("expected error for %+v", p)
+		}
+	}
+	var se *SelectorError
+	if err := RegisterPreset(Preset{Name: "broken", Selector: "b+"}); !errors.As(err, &se) {
+		t.Errorf("expected *SelectorError, got %v", err)
+	}
+}
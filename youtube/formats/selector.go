@@ -0,0 +1,570 @@
+package formats
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ytget/ytdlp/v2/internal/mimeext"
+	"github.com/ytget/ytdlp/v2/types"
+)
+
+// ErrNoMatchingFormat is returned when a valid selector matches no format.
+var ErrNoMatchingFormat = errors.New("no format matches selector")
+
+// SelectorError reports a syntax error in a format selector.
+type SelectorError struct {
+	Selector string
+	Offset   int
+	Reason   string
+}
+
+// Error implements the error interface.
+func (e *SelectorError) Error() string {
+	return fmt.Sprintf("invalid format selector %q at offset %d: %s", e.Selector, e.Offset, e.Reason)
+}
+
+// Selector is a compiled format selector expression.
+//
+// The grammar follows yt-dlp:
+//
+//	selector := merge ("/" merge)*        fallbacks, first match wins
+//	merge    := single ("+" single)*      formats to download and merge
+//	single   := atom? ("[" filter "]")*
+//	atom     := best | worst | bestvideo | worstvideo | bestaudio | worstaudio
+//	          | b | w | bv | wv | ba | wa (each optionally suffixed with "*")
+//	          | <itag> | mp4 | webm | m4a | 3gp
+//	filter   := key ["!"] op ["?"] value
+//
+// Numeric keys (height, width, fps, filesize, tbr, abr, vbr, asr,
+// audio_channels, itag) accept =, !=, <, <=, >, >= and values with K/M/G
+// suffixes (decimal, or binary with "i", e.g. 100MiB). String keys (ext,
+// vcodec, acodec, format_id, format_note, language, dynamic_range, projection,
+// stereo_layout) accept = (equals), ^= (prefix), $= (suffix) and *=
+// (contains), each negatable with "!". A "?" after the operator lets formats
+// with an unknown value pass. Codecs that are absent compare equal to "none".
+type Selector struct {
+	raw  string
+	root selectorNode
+}
+
+// String returns the selector source text.
+func (s *Selector) String() string {
+	return s.raw
+}
+
+// Select evaluates the selector against formats and returns the chosen
+// formats: one for a plain selection, several for a "+" merge request.
+// Candidates are ranked with DefaultSortSpec.
+func (s *Selector) Select(formats []types.Format) ([]types.Format, error) {
+	order, _ := ParseSortOrder(DefaultSortSpec)
+	return s.SelectWithOrder(formats, order)
+}
+
+// SelectWithOrder is like Select but ranks candidates for best/worst with the
+// given sort order.
+func (s *Selector) SelectWithOrder(formats []types.Format, order *SortOrder) ([]types.Format, error) {
+	out := s.root.eval(formats, order)
+	if len(out) == 0 {
+		return nil, fmt.Errorf("%w: %q", ErrNoMatchingFormat, s.raw)
+	}
+	return out, nil
+}
+
+// selectorNode is a node of the compiled selector AST.
+type selectorNode interface {
+	eval(formats []types.Format, order *SortOrder) []types.Format
+}
+
+// fallbackNode returns the result of the first alternative that matches.
+type fallbackNode struct {
+	alts []selectorNode
+}
+
+func (n *fallbackNode) eval(formats []types.Format, order *SortOrder) []types.Format {
+	for _, alt := range n.alts {
+		if out := alt.eval(formats, order); len(out) > 0 {
+			return out
+		}
+	}
+	return nil
+}
+
+// mergeNode requires every part to match and concatenates the results.
+type mergeNode struct {
+	parts []selectorNode
+}
+
+func (n *mergeNode) eval(formats []types.Format, order *SortOrder) []types.Format {
+	var out []types.Format
+	for _, part := range n.parts {
+		got := part.eval(formats, order)
+		if len(got) == 0 {
+			return nil
+		}
+		out = append(out, got...)
+	}
+	return out
+}
+
+// pickKind defines which formats an atom considers.
+type pickKind int
+
+const (
+	pickCombined  pickKind = iota // video and audio in one stream
+	pickAny                       // any stream
+	pickVideoOnly                 // video without audio
+	pickVideo                     // any stream with video
+	pickAudioOnly                 // audio without video
+	pickAudio                     // any stream with audio
+	pickItag                      // specific itag
+	pickExt                       // specific extension
+)
+
+// pickNode selects the best or worst format of a kind that passes all filters.
+type pickNode struct {
+	kind    pickKind
+	worst   bool
+	itag    int
+	ext     string
+	filters []filter
+}
+
+func (n *pickNode) eval(formats []types.Format, order *SortOrder) []types.Format {
+	var chosen *types.Format
+	for i := range formats {
+		f := formats[i]
+		if !n.accepts(f) {
+			continue
+		}
+		if chosen == nil {
+			chosen = &formats[i]
+			continue
+		}
+		c := order.Compare(f, *chosen)
+		if (n.worst && c < 0) || (!n.worst && c > 0) {
+			chosen = &formats[i]
+		}
+	}
+	if chosen == nil {
+		return nil
+	}
+	return []types.Format{*chosen}
+}
+
+func (n *pickNode) accepts(f types.Format) bool {
+	v, a := hasVideo(f), hasAudio(f)
+	switch n.kind {
+	case pickCombined:
+		if !v || !a {
+			return false
+		}
+	case pickVideoOnly:
+		if !v || a {
+			return false
+		}
+	case pickVideo:
+		if !v {
+			return false
+		}
+	case pickAudioOnly:
+		if !a || v {
+			return false
+		}
+	case pickAudio:
+		if !a {
+			return false
+		}
+	case pickItag:
+		if !itagEquals(f, n.itag) {
+			return false
+		}
+	case pickExt:
+		if formatExt(f) != n.ext {
+			return false
+		}
+	}
+	for _, flt := range n.filters {
+		if !flt.match(f) {
+			return false
+		}
+	}
+	return true
+}
+
+// atomKinds maps named atoms to their kind and direction.
+var atomKinds = map[string]struct {
+	kind  pickKind
+	worst bool
+}{
+	"best": {pickCombined, false}, "b": {pickCombined, false},
+	"worst": {pickCombined, true}, "w": {pickCombined, true},
+	"best*": {pickAny, false}, "b*": {pickAny, false},
+	"worst*": {pickAny, true}, "w*": {pickAny, true},
+	"bestvideo": {pickVideoOnly, false}, "bv": {pickVideoOnly, false},
+	"worstvideo": {pickVideoOnly, true}, "wv": {pickVideoOnly, true},
+	"bestvideo*": {pickVideo, false}, "bv*": {pickVideo, false},
+	"worstvideo*": {pickVideo, true}, "wv*": {pickVideo, true},
+	"bestaudio": {pickAudioOnly, false}, "ba": {pickAudioOnly, false},
+	"worstaudio": {pickAudioOnly, true}, "wa": {pickAudioOnly, true},
+	"bestaudio*": {pickAudio, false}, "ba*": {pickAudio, false},
+	"worstaudio*": {pickAudio, true}, "wa*": {pickAudio, true},
+}
+
+// selectableExts lists extensions accepted as selector atoms.
+var selectableExts = map[string]bool{"mp4": true, "webm": true, "m4a": true, "3gp": true}
+
+// filterKeys lists supported filter keys and whether they are numeric.
+var filterKeys = map[string]bool{
+	"height": true, "width": true, "fps": true, "filesize": true,
+	"tbr": true, "abr": true, "vbr": true, "asr": true,
+	"audio_channels": true, "itag": true,
+	"ext": false, "vcodec": false, "acodec": false,
+	"format_id": false, "format_note": false, "language": false,
+	"dynamic_range": false, "projection": false, "stereo_layout": false,
+}
+
+// filter is a single bracketed condition such as [height<=720].
+type filter struct {
+	key      string
+	op       string
+	negate   bool
+	optional bool
+	str      string
+	num      float64
+}
+
+func (flt filter) match(f types.Format) bool {
+	if filterKeys[flt.key] {
+		v, known := numericField(f, flt.key)
+		if !known {
+			return flt.optional
+		}
+		switch flt.op {
+		case "=":
+			return v == flt.num
+		case "!=":
+			return v != flt.num
+		case "<":
+			return v < flt.num
+		case "<=":
+			return v <= flt.num
+		case ">":
+			return v > flt.num
+		case ">=":
+			return v >= flt.num
+		}
+		return false
+	}
+	v, known := stringField(f, flt.key)
+	if !known {
+		return flt.optional
+	}
+	var ok bool
+	switch flt.op {
+	case "=":
+		ok = v == flt.str
+	case "^=":
+		ok = strings.HasPrefix(v, flt.str)
+	case "$=":
+		ok = strings.HasSuffix(v, flt.str)
+	case "*=":
+		ok = strings.Contains(v, flt.str)
+	}
+	return ok != flt.negate
+}
+
+// numericField returns a numeric attribute of f and whether it is known.
+func numericField(f types.Format, key string) (float64, bool) {
+	var v float64
+	switch key {
+	case "height":
+		v = float64(f.Height)
+	case "width":
+		v = float64(f.Width)
+	case "fps":
+		v = float64(f.FPS)
+	case "filesize":
+		v = float64(f.Size)
+	case "tbr":
+		v = float64(f.Bitrate) / 1000
+	case "abr":
+		if hasAudio(f) && !hasVideo(f) {
+			v = float64(f.Bitrate) / 1000
+		}
+	case "vbr":
+		if hasVideo(f) && !hasAudio(f) {
+			v = float64(f.Bitrate) / 1000
+		}
+	case "asr":
+		v = float64(f.AudioSampleRate)
+	case "audio_channels":
+		v = float64(f.AudioChannels)
+	case "itag":
+		v = float64(f.Itag)
+	}
+	return v, v != 0
+}
+
+// stringField returns a string attribute of f and whether it is known.
+func stringField(f types.Format, key string) (string, bool) {
+	switch key {
+	case "ext":
+		return formatExt(f), f.MimeType != ""
+	case "vcodec":
+		if f.VCodec == "" {
+			return "none", !hasVideo(f)
+		}
+		return strings.ToLower(f.VCodec), true
+	case "acodec":
+		if f.ACodec == "" {
+			return "none", !hasAudio(f)
+		}
+		return strings.ToLower(f.ACodec), true
+	case "format_id":
+		return strconv.Itoa(f.Itag), f.Itag != 0
+	case "format_note":
+		return strings.ToLower(f.Quality), f.Quality != ""
+	case "language":
+		return strings.ToLower(f.Language), f.Language != ""
+	case "dynamic_range":
+		return strings.ToLower(f.DynamicRange), f.DynamicRange != ""
+	case "projection":
+		return f.Projection, f.Projection != ""
+	case "stereo_layout":
+		return f.StereoLayout, f.StereoLayout != ""
+	}
+	return "", false
+}
+
+// formatExt returns the file extension that the format would be saved with.
+func formatExt(f types.Format) string {
+	ext := mimeext.ExtFromMime(f.MimeType)
+	if ext == mimeext.ExtWebA {
+		// Selectors follow yt-dlp, which calls WebM audio "webm".
+		return mimeext.ExtWebM
+	}
+	return ext
+}
+
+// legacySelectorRe matches bare "key<op>value" selectors accepted before the
+// bracket grammar existed (e.g. "itag=22", "height<=720").
+var legacySelectorRe = regexp.MustCompile(`^([a-z_]+)\s*(=|!=|<=|>=|<|>)\s*([^\[\]/+]+)$`)
+
+// ParseSelector compiles a selector expression. Syntax errors are reported as
+// *SelectorError.
+func ParseSelector(s string) (*Selector, error) {
+	src := strings.TrimSpace(s)
+	if m := legacySelectorRe.FindStringSubmatch(strings.ToLower(src)); m != nil {
+		if m[1] == "itag" && m[2] == "=" {
+			// "itag=NN" picks that itag regardless of its kind.
+			src = strings.TrimSpace(m[3])
+		} else {
+			src = "[" + src + "]"
+		}
+	}
+	p := &selectorParser{src: src, raw: s}
+	if p.src == "" {
+		return nil, p.errorf("empty selector")
+	}
+	root, err := p.parseFallback()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return nil, p.errorf("unexpected %q", p.src[p.pos])
+	}
+	return &Selector{raw: s, root: root}, nil
+}
+
+// selectorParser is a recursive-descent parser over the selector text.
+type selectorParser struct {
+	src string
+	raw string
+	pos int
+}
+
+func (p *selectorParser) errorf(format string, args ...any) error {
+	return &SelectorError{Selector: p.raw, Offset: p.pos, Reason: fmt.Sprintf(format, args...)}
+}
+
+func (p *selectorParser) skipSpace() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *selectorParser) consume(c byte) bool {
+	p.skipSpace()
+	if p.pos < len(p.src) && p.src[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *selectorParser) parseFallback() (selectorNode, error) {
+	var alts []selectorNode
+	for {
+		n, err := p.parseMerge()
+		if err != nil {
+			return nil, err
+		}
+		alts = append(alts, n)
+		if !p.consume('/') {
+			break
+		}
+	}
+	if len(alts) == 1 {
+		return alts[0], nil
+	}
+	return &fallbackNode{alts: alts}, nil
+}
+
+func (p *selectorParser) parseMerge() (selectorNode, error) {
+	var parts []selectorNode
+	for {
+		n, err := p.parseSingle()
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, n)
+		if !p.consume('+') {
+			break
+		}
+	}
+	if len(parts) == 1 {
+		return parts[0], nil
+	}
+	return &mergeNode{parts: parts}, nil
+}
+
+func (p *selectorParser) parseSingle() (selectorNode, error) {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.src) && isAtomChar(p.src[p.pos]) {
+		p.pos++
+	}
+	atom := strings.ToLower(p.src[start:p.pos])
+
+	n := &pickNode{kind: pickCombined}
+	k, named := atomKinds[atom]
+	switch {
+	case atom == "":
+		if p.pos >= len(p.src) || p.src[p.pos] != '[' {
+			return nil, p.errorf("expected format")
+		}
+	case named:
+		n.kind, n.worst = k.kind, k.worst
+	case isDigits(atom):
+		n.kind = pickItag
+		n.itag, _ = strconv.Atoi(atom)
+	case selectableExts[atom]:
+		n.kind = pickExt
+		n.ext = atom
+	default:
+		p.pos = start
+		return nil, p.errorf("unknown format %q", atom)
+	}
+
+	for p.pos < len(p.src) && p.src[p.pos] == '[' {
+		p.pos++
+		end := strings.IndexByte(p.src[p.pos:], ']')
+		if end < 0 {
+			return nil, p.errorf("unterminated filter")
+		}
+		flt, err := p.parseFilter(p.src[p.pos : p.pos+end])
+		if err != nil {
+			return nil, err
+		}
+		n.filters = append(n.filters, flt)
+		p.pos += end + 1
+	}
+	return n, nil
+}
+
+// filterRe splits a filter body into key, negation, operator, optional marker and value.
+var filterRe = regexp.MustCompile(`^\s*([a-z_]+)\s*(!?)(<=|>=|!=|\^=|\$=|\*=|<|>|=)(\??)\s*(.*?)\s*$`)
+
+func (p *selectorParser) parseFilter(body string) (filter, error) {
+	m := filterRe.FindStringSubmatch(body)
+	if m == nil {
+		return filter{}, p.errorf("malformed filter %q", body)
+	}
+	flt := filter{key: m[1], negate: m[2] == "!", op: m[3], optional: m[4] == "?", str: strings.ToLower(m[5])}
+	numeric, ok := filterKeys[flt.key]
+	if !ok {
+		return filter{}, p.errorf("unknown filter key %q", flt.key)
+	}
+	if flt.str == "" {
+		return filter{}, p.errorf("missing value for %q", flt.key)
+	}
+	if numeric {
+		// The negation group takes the "!" of "!=".
+		if flt.negate && flt.op == "=" {
+			flt.op, flt.negate = "!=", false
+		}
+		if flt.negate || strings.ContainsAny(flt.op, "^$*") {
+			return filter{}, p.errorf("operator %s%s is not valid for numeric key %q", m[2], flt.op, flt.key)
+		}
+		v, err := parseSize(flt.str)
+		if err != nil {
+			return filter{}, p.errorf("invalid number %q for %q", m[5], flt.key)
+		}
+		flt.num = v
+		return flt, nil
+	}
+	switch flt.op {
+	case "!=":
+		flt.op, flt.negate = "=", !flt.negate
+	case "<", "<=", ">", ">=":
+		return filter{}, p.errorf("operator %s is not valid for string key %q", flt.op, flt.key)
+	}
+	return flt, nil
+}
+
+// sizeRe matches numbers with an optional K/M/G/T multiplier.
+var sizeRe = regexp.MustCompile(`^([0-9]*\.?[0-9]+)\s*([kmgt]i?)?b?$`)
+
+// parseSize parses values like "720", "1.5k", "100M" or "2GiB". Plain
+// suffixes are decimal; suffixes with "i" are binary.
+func parseSize(s string) (float64, error) {
+	m := sizeRe.FindStringSubmatch(strings.ToLower(strings.TrimSpace(s)))
+	if m == nil {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	v, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, err
+	}
+	if m[2] == "" {
+		return v, nil
+	}
+	base := 1000.0
+	if strings.HasSuffix(m[2], "i") {
+		base = 1024
+	}
+	for i := 0; i < strings.Index("kmgt", m[2][:1])+1; i++ {
+		v *= base
+	}
+	return v, nil
+}
+
+func isAtomChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '*'
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
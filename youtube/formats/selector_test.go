@@ -0,0 +1,164 @@
+package formats
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ytget/ytdlp/v2/types"
+)
+
+func selectorTestFormats() []types.Format {
+	return []types.Format{
+		{Itag: 18, MimeType: `video/mp4; codecs="avc1.42001E, mp4a.40.2"`, VCodec: "avc1.42001E", ACodec: "mp4a.40.2", Height: 360, FPS: 30, Bitrate: 500000, Size: 20 << 20},
+		{Itag: 137, MimeType: `video/mp4; codecs="avc1.640028"`, VCodec: "avc1.640028", Adaptive: true, Height: 1080, FPS: 30, Bitrate: 4000000, Size: 150 << 20},
+		{Itag: 299, MimeType: `video/mp4; codecs="avc1.64002a"`, VCodec: "avc1.64002a", Adaptive: true, Height: 1080, FPS: 60, Bitrate: 6000000, Size: 250 << 20},
+		{Itag: 248, MimeType: `video/webm; codecs="vp9"`, VCodec: "vp9", Adaptive: true, Height: 1080, FPS: 30, Bitrate: 3000000, Size: 90 << 20},
+		{Itag: 313, MimeType: `video/webm; codecs="vp9"`, VCodec: "vp9", Adaptive: true, Height: 2160, FPS: 30, Bitrate: 18000000},
+		{Itag: 140, MimeType: `audio/mp4; codecs="mp4a.40.2"`, ACodec: "mp4a.40.2", Adaptive: true, Bitrate: 130000, AudioSampleRate: 44100},
+		{Itag: 251, MimeType: `audio/webm; codecs="opus"`, ACodec: "opus", Adaptive: true, Bitrate: 160000, AudioSampleRate: 48000},
+	}
+}
+
+func itagsOf(list []types.Format) []int {
+	out := make([]int, len(list))
+	for i, f := range list {
+		out[i] = f.Itag
+	}
+	return out
+}
+
+func TestSelectFormats_Grammar(t *testing.T) {
+	tests := []struct {
+		selector string
+		want     []int
+	}{
+		{"best", []int{18}},
+		{"b*", []int{313}},
+		{"bestvideo", []int{313}},
+		{"bv[height<=1080]", []int{299}},
+		{"bv[height<=1080][fps<=30]", []int{137}},
+		{"bv[fps>30]", []int{299}},
+		{"bv[vcodec^=avc1][ext=mp4][height<=1080][fps<60]", []int{137}},
+		{"bv[vcodec!^=avc1][height<=1080]", []int{248}},
+		{"bv[filesize<100M]", []int{248}},
+		{"bv[filesize<100M][vcodec^=avc1]", []int{}},
+		{"bv[filesize<100M][vcodec^=avc1]/bv[height=1080][vcodec*=avc]", []int{299}},
+		{"bestaudio", []int{251}},
+		{"ba[ext=m4a]", []int{140}},
+		{"worstaudio", []int{140}},
+		{"worstvideo", []int{248}},
+		{"bv[height<=1080]+ba[acodec=opus]", []int{299, 251}},
+		{"bv*+ba/b", []int{313, 251}},
+		{"140", []int{140}},
+		{"webm", []int{313}},
+		{"[height<=720]", []int{18}},
+		{"itag=140", []int{140}},
+		{"height<=720", []int{18}},
+		{"height!=720", []int{18}},
+		{"height!=360", []int{}},
+		{"[fps!=30]", []int{}},
+		{"bv[fps!=30]", []int{299}},
+		{"bv[height!=2160][fps!=60]", []int{137}},
+		{"bv[acodec=none][asr>?0]", []int{313}},
+		{"ba[asr>=48k]", []int{251}},
+	}
+	for _, tt := range tests {
+		got, err := SelectFormats(selectorTestFormats(), tt.selector, "")
+		if len(tt.want) == 0 {
+			if !errors.Is(err, ErrNoMatchingFormat) {
+				t.Errorf("%q: expected ErrNoMatchingFormat, got %v (%v)", tt.selector, err, itagsOf(got))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.selector, err)
+			continue
+		}
+		gotItags := itagsOf(got)
+		if len(gotItags) != len(tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.selector, gotItags, tt.want)
+			continue
+		}
+		for i := range gotItags {
+			if gotItags[i] != tt.want[i] {
+				t.Errorf("%q: got %v, want %v", tt.selector, gotItags, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestParseSelector_Errors(t *testing.T) {
+	tests := []string{
+		"",
+		"bestest",
+		"bv[height<=",
+		"bv[colour=red]",
+		"bv[height^=10]",
+		"bv[height!<720]",
+		"bv[ext<mp4]",
+		"bv[height<=abc]",
+		"bv+",
+		"bv/ba)",
+	}
+	for _, s := range tests {
+		_, err := ParseSelector(s)
+		var se *SelectorError
+		if !errors.As(err, &se) {
+			t.Errorf("%q: expected *SelectorError, got %v", s, err)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]float64{
+		"720":    720,
+		"1.5k":   1500,
+		"100M":   100e6,
+		"100MB":  100e6,
+		"2GiB":   2 << 30,
+		"10KiB":  10240,
+		"0.5mib": 512 << 10,
+	}
+	for in, want := range tests {
+		got, err := parseSize(in)
+		if err != nil || got != want {
+			t.Errorf("parseSize(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := parseSize("ten"); err == nil {
+		t.Error("expected error for non-numeric value")
+	}
+}
+
+func TestSelectFormats_AudioLanguage(t *testing.T) {
+	list := []types.Format{
+		{Itag: 137, MimeType: `video/mp4; codecs="avc1.640028"`, VCodec: "avc1.640028", Adaptive: true, Height: 1080, Bitrate: 4000000},
+		{Itag: 251, MimeType: `audio/webm; codecs="opus"`, ACodec: "opus", Adaptive: true, Bitrate: 140000, Language: "de", AudioTrack: &types.AudioTrack{ID: "de.3", DisplayName: "German"}},
+		{Itag: 251, MimeType: `audio/webm; codecs="opus"`, ACodec: "opus", Adaptive: true, Bitrate: 130000, Language: "en", AudioTrack: &types.AudioTrack{ID: "en.4", DisplayName: "English (original)", IsDefault: true}},
+		{Itag: 140, MimeType: `audio/mp4; codecs="mp4a.40.2"`, ACodec: "mp4a.40.2", Adaptive: true, Bitrate: 130000, Language: "de-AT", AudioTrack: &types.AudioTrack{ID: "de-AT.3", DisplayName: "German (Austria)"}},
+	}
+	tests := []struct {
+		selector, sort string
+		want           string
+	}{
+		{"ba", "", "en"},
+		{"ba[language=de]", "", "de"},
+		{"ba[language^=de][ext=m4a]", "", "de-AT"},
+		{"ba", "lang:de,br", "de"},
+		{"ba", "lang:fr,br", "en"},
+	}
+	for _, tt := range tests {
+		got, err := SelectFormatsSorted(list, tt.selector, tt.sort, "")
+		if err != nil {
+			t.Errorf("%q/%q: unexpected error: %v", tt.selector, tt.sort, err)
+			continue
+		}
+		if len(got) != 1 || got[0].Language != tt.want {
+			t.Errorf("%q/%q: got %+v, want language %q", tt.selector, tt.sort, got, tt.want)
+		}
+	}
+	if _, err := SelectFormats(list, "ba[language=fr]", ""); !errors.Is(err, ErrNoMatchingFormat) {
+		t.Errorf("expected ErrNoMatchingFormat for missing language, got %v", err)
+	}
+}
@@ -0,0 +1,356 @@
+package formats
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ytget/ytdlp/v2/types"
+)
+
+// DefaultSortSpec is the ranking used when no sort spec is given: the default
+// audio track first, then highest resolution, then frame rate, then bitrate.
+const DefaultSortSpec = "lang,res,fps,br"
+
+// videoCodecOrder ranks video codec families from most to least preferred.
+var videoCodecOrder = []string{"av01", "vp9", "h265", "h264", "vp8"}
+
+// audioCodecOrder ranks audio codec families from most to least preferred.
+var audioCodecOrder = []string{"opus", "vorbis", "aac", "mp3"}
+
+// dynamicRangeOrder ranks dynamic ranges from most to least preferred.
+var dynamicRangeOrder = []string{"hdr10", "hlg", "hdr", "sdr"}
+
+// extOrder ranks container extensions from most to least preferred.
+var extOrder = []string{"mp4", "m4a", "webm", "3gp"}
+
+// sortKeyNames lists supported sort keys and whether they take a numeric limit.
+var sortKeyNames = map[string]bool{
+	"res": true, "fps": true, "size": true, "br": true, "tbr": true,
+	"abr": true, "vbr": true, "asr": true,
+	"vcodec": false, "acodec": false, "ext": false, "lang": false, "hdr": false,
+	"proto": false, "hasvid": false, "hasaud": false,
+}
+
+// sortKey is a single compiled component of a sort spec.
+type sortKey struct {
+	name    string
+	reverse bool
+	limit   float64
+	prefer  string
+}
+
+// SortOrder is a compiled format sort spec.
+//
+// A spec is a comma-separated list of keys, most significant first, in the
+// form "[+]key[:value]" (yt-dlp format_sort). By default larger values and
+// preferred codecs rank first; a "+" prefix reverses the key. Formats with an
+// unknown (zero) numeric value always rank after those with a known one.
+//
+// Numeric keys: res (height), fps, size (filesize), br/tbr (total bitrate),
+// abr, vbr, asr. With a value (e.g. "res:1080", "size:100M") formats at or
+// below the limit rank first, largest first, followed by those above it,
+// smallest first.
+//
+// Preference keys: vcodec (av01 > vp9 > h265 > h264 > vp8), acodec (opus >
+// vorbis > aac > mp3) and ext (mp4 > m4a > webm > 3gp). A value such as
+// "vcodec:avc1" moves that codec to the front of the default order. lang
+// prefers the default audio track over dubbed ones; "lang:de" prefers tracks
+// in that language (matching "de" and "de-*"), then the default track. hdr
+// ranks HDR10 > HLG > HDR > SDR; "hdr:sdr" prefers SDR instead.
+//
+// Flag keys: proto (direct URLs before ones that need signature deciphering),
+// hasvid and hasaud.
+type SortOrder struct {
+	spec string
+	keys []sortKey
+}
+
+// ParseSortOrder compiles a sort spec. The keys of DefaultSortSpec that the
+// spec leaves out are appended to it as tie-breakers, as yt-dlp does, so an
+// empty spec yields DefaultSortSpec. Syntax errors are reported as
+// *SelectorError.
+func ParseSortOrder(spec string) (*SortOrder, error) {
+	src := strings.TrimSpace(spec)
+	if src == "" {
+		src = DefaultSortSpec
+	}
+	o := &SortOrder{spec: src}
+	offset := 0
+	for _, part := range strings.Split(src, ",") {
+		fail := func(format string, args ...any) error {
+			return &SelectorError{Selector: spec, Offset: offset, Reason: fmt.Sprintf(format, args...)}
+		}
+		item := strings.ToLower(strings.TrimSpace(part))
+		if item == "" {
+			return nil, fail("empty sort key")
+		}
+		var k sortKey
+		if strings.HasPrefix(item, "+") {
+			k.reverse = true
+			item = item[1:]
+		}
+		name, value, hasValue := strings.Cut(item, ":")
+		k.name = strings.TrimSpace(name)
+		if k.name == "tbr" {
+			k.name = "br"
+		}
+		numeric, ok := sortKeyNames[k.name]
+		if !ok {
+			return nil, fail("unknown sort key %q", k.name)
+		}
+		if hasValue {
+			value = strings.TrimSpace(value)
+			switch {
+			case value == "":
+				return nil, fail("missing value for sort key %q", k.name)
+			case numeric:
+				v, err := parseSize(value)
+				if err != nil {
+					return nil, fail("invalid limit %q for sort key %q", value, k.name)
+				}
+				k.limit = v
+			case k.name == "vcodec" || k.name == "acodec" || k.name == "ext" || k.name == "lang" || k.name == "hdr":
+				k.prefer = value
+			default:
+				return nil, fail("sort key %q does not take a value", k.name)
+			}
+		}
+		o.keys = append(o.keys, k)
+		offset += len(part) + 1
+	}
+	if src != DefaultSortSpec {
+		def, _ := ParseSortOrder(DefaultSortSpec)
+		for _, k := range def.keys {
+			if !o.hasKey(k.name) {
+				o.keys = append(o.keys, k)
+			}
+		}
+	}
+	return o, nil
+}
+
+// hasKey reports whether o sorts by the key name.
+func (o *SortOrder) hasKey(name string) bool {
+	for _, k := range o.keys {
+		if k.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the sort spec.
+func (o *SortOrder) String() string {
+	return o.spec
+}
+
+// Compare returns a positive number when a ranks above b, a negative number
+// when b ranks above a, and 0 when they are equivalent.
+func (o *SortOrder) Compare(a, b types.Format) int {
+	for _, k := range o.keys {
+		if c := k.compare(a, b); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// Sort returns a copy of formats ordered from best to worst. The order of
+// equivalent formats is preserved.
+func (o *SortOrder) Sort(formats []types.Format) []types.Format {
+	out := make([]types.Format, len(formats))
+	copy(out, formats)
+	sort.SliceStable(out, func(i, j int) bool {
+		return o.Compare(out[i], out[j]) > 0
+	})
+	return out
+}
+
+// Sort returns a copy of formats ordered from best to worst according to spec
+// (see SortOrder).
+func Sort(formats []types.Format, spec string) ([]types.Format, error) {
+	o, err := ParseSortOrder(spec)
+	if err != nil {
+		return nil, err
+	}
+	return o.Sort(formats), nil
+}
+
+// compare ranks a against b for this key, honoring the reverse flag.
+func (k sortKey) compare(a, b types.Format) int {
+	if sortKeyNames[k.name] {
+		av, bv := sortValue(a, k.name), sortValue(b, k.name)
+		if av == 0 || bv == 0 {
+			// Unknown values rank last regardless of direction.
+			return compareBool(av != 0, bv != 0)
+		}
+		c := compareLimited(av, bv, k.limit)
+		if k.reverse {
+			c = -c
+		}
+		return c
+	}
+	c := k.comparePreference(a, b)
+	if k.reverse {
+		c = -c
+	}
+	return c
+}
+
+// comparePreference ranks a against b for preference and flag keys.
+func (k sortKey) comparePreference(a, b types.Format) int {
+	switch k.name {
+	case "vcodec":
+		return compareRank(codecRank(videoCodecFamily(a), k.prefer, videoCodecOrder), codecRank(videoCodecFamily(b), k.prefer, videoCodecOrder))
+	case "acodec":
+		return compareRank(codecRank(audioCodecFamily(a), k.prefer, audioCodecOrder), codecRank(audioCodecFamily(b), k.prefer, audioCodecOrder))
+	case "ext":
+		return compareRank(codecRank(formatExt(a), k.prefer, extOrder), codecRank(formatExt(b), k.prefer, extOrder))
+	case "lang":
+		return languageScore(a, k.prefer) - languageScore(b, k.prefer)
+	case "hdr":
+		return compareRank(codecRank(strings.ToLower(a.DynamicRange), k.prefer, dynamicRangeOrder), codecRank(strings.ToLower(b.DynamicRange), k.prefer, dynamicRangeOrder))
+	case "proto":
+		return compareBool(hasDirectURL(a), hasDirectURL(b))
+	case "hasvid":
+		return compareBool(hasVideo(a), hasVideo(b))
+	case "hasaud":
+		return compareBool(hasAudio(a), hasAudio(b))
+	}
+	return 0
+}
+
+// sortValue returns the numeric attribute used by a numeric sort key.
+func sortValue(f types.Format, name string) float64 {
+	switch name {
+	case "res":
+		return float64(f.Height)
+	case "size":
+		return float64(f.Size)
+	case "br":
+		return float64(f.Bitrate) / 1000
+	}
+	v, _ := numericField(f, name)
+	return v
+}
+
+// compareLimited compares two numeric values where larger is better. With a
+// positive limit, values within the limit beat values above it, and among
+// values above the limit the smaller one wins.
+func compareLimited(a, b, limit float64) int {
+	if limit > 0 {
+		aOver, bOver := a > limit, b > limit
+		switch {
+		case aOver && bOver:
+			return compareFloat(b, a)
+		case aOver:
+			return -1
+		case bOver:
+			return 1
+		}
+	}
+	return compareFloat(a, b)
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a > b:
+		return 1
+	case a < b:
+		return -1
+	}
+	return 0
+}
+
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	}
+	return -1
+}
+
+// compareRank compares two ranks where a lower rank is better.
+func compareRank(a, b int) int {
+	return b - a
+}
+
+// codecRank returns the position of value in order, with prefer moved to the
+// front. Unknown values rank after known ones and "none" ranks last.
+func codecRank(value, prefer string, order []string) int {
+	if value == "none" {
+		return len(order) + 2
+	}
+	if prefer != "" && (value == prefer || value == normalizeCodecAlias(prefer)) {
+		return -1
+	}
+	for i, v := range order {
+		if v == value {
+			return i
+		}
+	}
+	return len(order) + 1
+}
+
+// languageScore ranks the audio track of f: 2 when it is in the preferred
+// language, 1 when it is the default (or only) track, 0 otherwise.
+func languageScore(f types.Format, prefer string) int {
+	if prefer != "" && languageMatches(f.Language, prefer) {
+		return 2
+	}
+	if f.AudioTrack == nil || f.AudioTrack.IsDefault {
+		return 1
+	}
+	return 0
+}
+
+// languageMatches reports whether the language tag lang equals want or is a
+// regional variant of it ("de-AT" matches "de").
+func languageMatches(lang, want string) bool {
+	lang, want = strings.ToLower(lang), strings.ToLower(want)
+	return lang != "" && (lang == want || strings.HasPrefix(lang, want+"-"))
+}
+
+// normalizeCodecAlias maps user-facing codec names to codec families.
+func normalizeCodecAlias(name string) string {
+	switch name {
+	case "avc", "avc1", "avc3", "h264":
+		return "h264"
+	case "hevc", "hev1", "hvc1", "h265":
+		return "h265"
+	case "vp09", "vp9":
+		return "vp9"
+	case "av1", "av01":
+		return "av01"
+	case "mp4a", "aac":
+		return "aac"
+	}
+	return name
+}
+
+// videoCodecFamily normalizes the video codec of f, e.g. "avc1.64001F" -> "h264".
+func videoCodecFamily(f types.Format) string {
+	if !hasVideo(f) {
+		return "none"
+	}
+	c := strings.ToLower(f.VCodec)
+	if i := strings.IndexByte(c, '.'); i >= 0 {
+		c = c[:i]
+	}
+	return normalizeCodecAlias(c)
+}
+
+// audioCodecFamily normalizes the audio codec of f, e.g. "mp4a.40.2" -> "aac".
+func audioCodecFamily(f types.Format) string {
+	if !hasAudio(f) {
+		return "none"
+	}
+	c := strings.ToLower(f.ACodec)
+	if i := strings.IndexByte(c, '.'); i >= 0 {
+		c = c[:i]
+	}
+	return normalizeCodecAlias(c)
+}
@@ -0,0 +1,104 @@
+package formats
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ytget/ytdlp/v2/types"
+)
+
+func TestSort_Specs(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []int
+	}{
+		// default: res, fps, br; its keys also break ties of other specs
+		{"", []int{313, 299, 137, 248, 18, 251, 140}},
+		{"res:1080,fps", []int{299, 137, 248, 18, 313, 251, 140}},
+		{"res:1080,vcodec:av01,br", []int{248, 299, 137, 18, 313, 251, 140}},
+		{"res:1080,vcodec:avc1,br", []int{299, 137, 248, 18, 313, 251, 140}},
+		{"hasaud,+size", []int{18, 251, 140, 248, 137, 299, 313}},
+		{"acodec,abr", []int{251, 140, 18, 313, 299, 137, 248}},
+		{"proto,res", []int{313, 299, 137, 248, 18, 251, 140}},
+	}
+	for _, tt := range tests {
+		got, err := Sort(selectorTestFormats(), tt.spec)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.spec, err)
+			continue
+		}
+		gotItags := itagsOf(got)
+		for i := range tt.want {
+			if gotItags[i] != tt.want[i] {
+				t.Errorf("%q: got %v, want %v", tt.spec, gotItags, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestSort_AV1Preference(t *testing.T) {
+	list := []types.Format{
+		{Itag: 137, VCodec: "avc1.640028", Height: 1080, Adaptive: true},
+		{Itag: 399, VCodec: "av01.0.08M.08", Height: 1080, Adaptive: true},
+		{Itag: 248, VCodec: "vp9", Height: 1080, Adaptive: true},
+		{Itag: 401, VCodec: "av01.0.12M.08", Height: 2160, Adaptive: true},
+	}
+	got, err := Sort(list, "res:1080,vcodec")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []int{399, 248, 137, 401}
+	for i, f := range got {
+		if f.Itag != want[i] {
+			t.Fatalf("got %v, want %v", itagsOf(got), want)
+		}
+	}
+}
+
+func TestSort_DefaultTieBreakers(t *testing.T) {
+	list := []types.Format{
+		{Itag: 394, VCodec: "av01.0.00M.08", Height: 144, Adaptive: true},
+		{Itag: 137, VCodec: "avc1.640028", Height: 1080, Adaptive: true},
+		{Itag: 399, VCodec: "av01.0.08M.08", Height: 1080, Adaptive: true},
+		{Itag: 397, VCodec: "av01.0.05M.08", Height: 480, Adaptive: true},
+	}
+	got, err := Sort(list, "vcodec:av01")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []int{399, 397, 394, 137}
+	for i, f := range got {
+		if f.Itag != want[i] {
+			t.Fatalf("got %v, want %v", itagsOf(got), want)
+		}
+	}
+}
+
+func TestParseSortOrder_Errors(t *testing.T) {
+	for _, spec := range []string{"res,,fps", "colour", "res:big", "proto:https", "vcodec:"} {
+		_, err := ParseSortOrder(spec)
+		var se *SelectorError
+		if !errors.As(err, &se) {
+			t.Errorf("%q: expected *SelectorError, got %v", spec, err)
+		}
+	}
+}
+
+func TestSelectFormatsSorted(t *testing.T) {
+	got, err := SelectFormatsSorted(selectorTestFormats(), "bv", "res:1080,+size", "")
+	if err != nil || len(got) != 1 || got[0].Itag != 248 {
+		t.Fatalf("bv with res:1080,+size -> 248, got %v (%v)", itagsOf(got), err)
+	}
+	got, err = SelectFormatsSorted(selectorTestFormats(), "worstvideo", "res,fps", "")
+	if err != nil || len(got) != 1 || got[0].Itag != 248 {
+		t.Fatalf("worstvideo with res,fps,br -> 248, got %v (%v)", itagsOf(got), err)
+	}
+	got, err = SelectFormatsSorted(selectorTestFormats(), "", "+res", "")
+	if err != nil || len(got) != 1 || got[0].Itag != 18 {
+		t.Fatalf("empty selector with sort uses best -> 18, got %v (%v)", itagsOf(got), err)
+	}
+	if _, err := SelectFormatsSorted(selectorTestFormats(), "best", "nope", ""); err == nil {
+		t.Fatal("expected error for invalid sort spec")
+	}
+}
@@ -0,0 +1,172 @@
+package innertube
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/ytget/ytdlp/v2/internal/logging"
+	"github.com/ytget/ytdlp/v2/types"
+)
+
+// GetNextResponse fetches the raw /next response of a video, the watch page
+// data that carries its chapters among others. The request is made as the
+// WEB client whatever client is configured, because only the web page data
+// is parsed. The request is bound to ctx.
+func (c *Client) GetNextResponse(ctx context.Context, videoID string) ([]byte, error) {
+	c.ensureKey(videoID, false)
+	if c.apiKey == "" {
+		return nil, errors.New("innertube: api key not found")
+	}
+	ver := c.clientVer
+	if c.clientName != clientNameWEB || ver == "" {
+		ver = defaultClientVersion
+	}
+	bodyBytes, err := json.Marshal(map[string]any{
+		"context": map[string]any{
+			"client": map[string]any{
+				"clientName":    clientNameWEB,
+				"clientVersion": ver,
+			},
+		},
+		"videoId": videoID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", nextURL+"?key="+c.apiKey, bytes.NewBuffer(bodyBytes))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", headerContentTypeJSON)
+	req.Header.Set("User-Agent", userAgentValue)
+	req.Header.Set("Accept", "*/*")
+	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
+	req.Header.Set("Referer", "https://www.youtube.com/")
+	req.Header.Set("Origin", "https://www.youtube.com")
+	req.Header.Set("X-YouTube-Client-Name", clientCodeFromName(clientNameWEB))
+	req.Header.Set("X-YouTube-Client-Version", ver)
+	if visitorID, err := c.getVisitorID(); err == nil && visitorID != "" {
+		req.Header.Set("x-goog-visitor-id", visitorID)
+	}
+	resp, err := c.doWithBotguardRetry(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+	c.log().Debug("Next response received",
+		slog.String(logging.KeyVideoID, videoID),
+		slog.Int(logging.KeyStatus, resp.StatusCode),
+		slog.Int("bytes", len(body)))
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("innertube: next request failed with status %d", resp.StatusCode)
+	}
+	return body, nil
+}
+
+// ParseChapters returns the chapters found in a raw player or /next
+// response: the chapter markers of the player bar, else the chapter list of
+// the engagement panel. The last chapter ends at duration. It returns nil
+// when there are none or the response is not JSON.
+func ParseChapters(raw []byte, duration time.Duration) []types.Chapter {
+	var root any
+	if err := json.Unmarshal(raw, &root); err != nil {
+		return nil
+	}
+	var chapters []types.Chapter
+	collectChapters(root, "chapterRenderer", &chapters)
+	if len(chapters) == 0 {
+		collectChapters(root, "macroMarkersListItemRenderer", &chapters)
+	}
+	return finishChapters(chapters, duration)
+}
+
+// collectChapters appends the start and title of every renderer of the
+// given kind below node.
+func collectChapters(node any, kind string, out *[]types.Chapter) {
+	switch v := node.(type) {
+	case map[string]any:
+		if r, ok := v[kind].(map[string]any); ok {
+			if c, ok := chapterFromRenderer(kind, r); ok {
+				*out = append(*out, c)
+			}
+			return
+		}
+		for _, val := range v {
+			collectChapters(val, kind, out)
+		}
+	case []any:
+		for _, val := range v {
+			collectChapters(val, kind, out)
+		}
+	}
+}
+
+// chapterFromRenderer reads a chapterRenderer, which holds the start in
+// milliseconds, or a macroMarkersListItemRenderer, whose watch endpoint
+// holds it in seconds.
+func chapterFromRenderer(kind string, r map[string]any) (types.Chapter, bool) {
+	c := types.Chapter{Title: textOf(r["title"])}
+	if kind == "chapterRenderer" {
+		ms, ok := r["timeRangeStartMillis"].(float64)
+		c.Start = time.Duration(ms) * time.Millisecond
+		return c, ok && c.Title != ""
+	}
+	onTap, _ := r["onTap"].(map[string]any)
+	endpoint, _ := onTap["watchEndpoint"].(map[string]any)
+	s, ok := endpoint["startTimeSeconds"].(float64)
+	c.Start = time.Duration(s) * time.Second
+	return c, ok && c.Title != ""
+}
+
+// textOf returns the value of a text object in its simpleText or runs form.
+func textOf(v any) string {
+	m, _ := v.(map[string]any)
+	if s, ok := m["simpleText"].(string); ok {
+		return s
+	}
+	var out string
+	runs, _ := m["runs"].([]any)
+	for _, r := range runs {
+		if run, ok := r.(map[string]any); ok {
+			s, _ := run["text"].(string)
+			out += s
+		}
+	}
+	return out
+}
+
+// finishChapters sorts chapters by start, drops repeated starts (the same
+// list appears in several places of a response) and sets each end to the
+// next start, or to duration for the last one.
+func finishChapters(chapters []types.Chapter, duration time.Duration) []types.Chapter {
+	if len(chapters) == 0 {
+		return nil
+	}
+	sort.SliceStable(chapters, func(a, b int) bool { return chapters[a].Start < chapters[b].Start })
+	out := chapters[:1]
+	for _, c := range chapters[1:] {
+		if c.Start != out[len(out)-1].Start {
+			out = append(out, c)
+		}
+	}
+	for i := range out {
+		if i+1 < len(out) {
+			out[i].End = out[i+1].Start
+		} else {
+			out[i].End = max(duration, out[i].Start)
+		}
+	}
+	return out
+}
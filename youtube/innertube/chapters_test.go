@@ -0,0 +1,99 @@
+package innertube
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ytget/ytdlp/v2/types"
+)
+
+func TestParseChapters(t *testing.T) {
+	markers := `{"playerOverlays":{"playerOverlayRenderer":{"decoratedPlayerBarRenderer":{"decoratedPlayerBarRenderer":{"playerBar":{"multiMarkersPlayerBarRenderer":{"markersMap":[{"key":"DESCRIPTION_CHAPTERS","value":{"chapters":[
+		{"chapterRenderer":{"title":{"simpleText":"Intro"},"timeRangeStartMillis":0}},
+		{"chapterRenderer":{"title":{"simpleText":"Main part"},"timeRangeStartMillis":95000}},
+		{"chapterRenderer":{"title":{"runs":[{"text":"Q"},{"text":"&A"}]},"timeRangeStartMillis":600500}}
+	]}}]}}}}}}}`
+	want := []types.Chapter{
+		{Start: 0, End: 95 * time.Second, Title: "Intro"},
+		{Start: 95 * time.Second, End: 600500 * time.Millisecond, Title: "Main part"},
+		{Start: 600500 * time.Millisecond, End: 15 * time.Minute, Title: "Q&A"},
+	}
+	checkChapters(t, ParseChapters([]byte(markers), 15*time.Minute), want)
+
+	panel := `{"engagementPanels":[{"engagementPanelSectionListRenderer":{"content":{"macroMarkersListRenderer":{"contents":[
+		{"macroMarkersListItemRenderer":{"title":{"simpleText":"Second"},"onTap":{"watchEndpoint":{"startTimeSeconds":30}}}},
+		{"macroMarkersListItemRenderer":{"title":{"simpleText":"First"},"onTap":{"watchEndpoint":{}}}},
+		{"macroMarkersListItemRenderer":{"title":{"simpleText":"First"},"onTap":{"watchEndpoint":{"startTimeSeconds":0}}}},
+		{"macroMarkersListItemRenderer":{"title":{"simpleText":"Again"},"onTap":{"watchEndpoint":{"startTimeSeconds":30}}}}
+	]}}}}]}`
+	checkChapters(t, ParseChapters([]byte(panel), time.Minute), []types.Chapter{
+		{Start: 0, End: 30 * time.Second, Title: "First"},
+		{Start: 30 * time.Second, End: time.Minute, Title: "Second"},
+	})
+
+	if got := ParseChapters([]byte(`{"videoDetails":{}}`), time.Minute); got != nil {
+		t.Errorf("chapters of a response without any = %+v", got)
+	}
+	if got := ParseChapters([]byte("<html>"), time.Minute); got != nil {
+		t.Errorf("chapters of a non-JSON body = %+v", got)
+	}
+}
+
+func checkChapters(t *testing.T, got, want []types.Chapter) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("chapters = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("chapter %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestGetNextResponse(t *testing.T) {
+	var request map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("key") != "k" {
+			http.Error(w, "no key", http.StatusForbidden)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		_, _ = w.Write([]byte(`{"contents":{}}`))
+	}))
+	defer srv.Close()
+	oldNextURL := nextURL
+	nextURL = srv.URL
+	defer func() { nextURL = oldNextURL }()
+
+	it := New(&http.Client{Timeout: 5 * time.Second}).WithClient("ANDROID", "20.10.38")
+	it.apiKey = "k"
+	it.visitorID.value = "v"
+	it.visitorID.updated = time.Now()
+	body, err := it.GetNextResponse(context.Background(), "vid")
+	if err != nil {
+		t.Fatalf("GetNextResponse() error = %v", err)
+	}
+	if string(body) != `{"contents":{}}` {
+		t.Errorf("body = %s", body)
+	}
+	client := request["context"].(map[string]any)["client"].(map[string]any)
+	if request["videoId"] != "vid" || client["clientName"] != "WEB" || client["clientVersion"] != defaultClientVersion {
+		t.Errorf("request = %v", request)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := it.GetNextResponse(ctx, "vid"); err == nil {
+		t.Error("expected an error for a canceled context")
+	}
+
+	it.apiKey = "wrong"
+	if _, err := it.GetNextResponse(context.Background(), "vid"); err == nil {
+		t.Error("expected an error for a failed request")
+	}
+}
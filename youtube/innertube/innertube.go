@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"regexp"
 	"strconv"
@@ -16,12 +17,14 @@ import (
 
 	"github.com/andybalholm/brotli"
 	"github.com/ytget/ytdlp/v2/internal/botguard"
+	"github.com/ytget/ytdlp/v2/internal/logging"
 	"github.com/ytget/ytdlp/v2/types"
 )
 
 var (
 	playerURL = "https://www.youtube.com/youtubei/v1/player"
 	browseURL = "https://www.youtube.com/youtubei/v1/browse"
+	nextURL   = "https://www.youtube.com/youtubei/v1/next"
 )
 
 const (
@@ -87,6 +90,7 @@ type Client struct {
 		ttl    time.Duration
 		debug  bool
 	}
+	logger *slog.Logger
 }
 
 // New creates a new InnerTube client with HTTP/1.1 transport.
@@ -156,19 +160,15 @@ func (c *Client) WithBotguardTTL(ttl time.Duration) *Client {
 	return c
 }
 
-// PlayerResponse represents a response from the InnerTube /player endpoint.
-type PlayerResponse struct {
-	StreamingData struct {
-		Formats         []any `json:"formats"`
-		AdaptiveFormats []any `json:"adaptiveFormats"`
-	} `json:"streamingData"`
-	VideoDetails struct {
-		Title string `json:"title"`
-	} `json:"videoDetails"`
-	PlayabilityStatus struct {
-		Status string `json:"status"`
-		Reason string `json:"reason"`
-	} `json:"playabilityStatus"`
+// WithLogger sets the logger for diagnostics. Responses are logged at debug
+// level with credentials redacted; nil (the default) uses slog.Default().
+func (c *Client) WithLogger(l *slog.Logger) *Client {
+	c.logger = l
+	return c
+}
+
+func (c *Client) log() *slog.Logger {
+	return logging.Or(c.logger).With(slog.String(logging.KeyStage, logging.StageInnertube))
 }
 
 func (c *Client) ensureKey(videoOrPlaylistID string, isPlaylist bool) {
@@ -320,13 +320,6 @@ func (c *Client) GetPlayerResponse(videoID string) (*PlayerResponse, error) {
 	}
 	defer func() { _ = resp.Body.Close() }()
 
-	// Log response status and headers for debugging
-	fmt.Printf("Response status: %d\n", resp.StatusCode)
-	fmt.Printf("Response headers:\n")
-	for k, v := range resp.Header {
-		fmt.Printf("  %s: %s\n", k, v)
-	}
-
 	// Handle compressed response
 	var reader io.Reader = resp.Body
 	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
@@ -337,8 +330,7 @@ func (c *Client) GetPlayerResponse(videoID string) (*PlayerResponse, error) {
 		}
 		defer func() {
 			if err := gzReader.Close(); err != nil {
-				// Log error but don't fail the operation
-				fmt.Printf("[warning] failed to close gzip reader: %v\n", err)
+				c.log().Warn("Failed to close gzip reader", logging.Err(err))
 			}
 		}()
 		reader = gzReader
@@ -357,13 +349,18 @@ func (c *Client) GetPlayerResponse(videoID string) (*PlayerResponse, error) {
 		return nil, fmt.Errorf("failed to read response body: %v", err)
 	}
 
-	// Log decompressed response for debugging
-	fmt.Printf("Decompressed response body: %s\n", string(body))
+	c.log().Debug("Player response received",
+		slog.String(logging.KeyVideoID, videoID),
+		slog.String("client", name),
+		slog.Int(logging.KeyStatus, resp.StatusCode),
+		slog.Int("bytes", len(body)),
+		logging.Headers("headers", resp.Header))
 
 	var playerResponse PlayerResponse
 	if err := json.Unmarshal(body, &playerResponse); err != nil {
 		return nil, fmt.Errorf("failed to parse response: %v\nBody: %s", err, string(body))
 	}
+	playerResponse.Raw = body
 
 	return &playerResponse, nil
 }
@@ -670,8 +667,7 @@ func (c *Client) refreshVisitorID() error {
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
-			// Log error but don't fail the operation
-			fmt.Printf("[warning] failed to close response body: %v\n", err)
+			c.log().Warn("Failed to close response body", logging.Err(err))
 		}
 	}()
 
@@ -715,11 +711,11 @@ func (c *Client) doWithBotguardRetry(req *http.Request) (*http.Response, error)
 	// Optionally run preflight attestation in Force mode
 	if c.bg.mode == botguard.Force {
 		if c.bg.debug {
-			fmt.Println("[botguard] force mode preflight attestation")
+			c.log().Info("Botguard: force mode preflight attestation")
 		}
 		if err := c.maybeApplyBotguard(req); err != nil {
-			// Log error but continue with request
-			fmt.Printf("[warning] failed to apply botguard: %v\n", err)
+			// Continue with the request without a token.
+			c.log().Warn("Failed to apply Botguard token", logging.Err(err))
 		}
 	}
 
@@ -732,7 +728,7 @@ func (c *Client) doWithBotguardRetry(req *http.Request) (*http.Response, error)
 	// Auto mode: perform attestation and retry once
 	if c.bg.mode == botguard.Auto || c.bg.mode == botguard.Force {
 		if c.bg.debug {
-			fmt.Println("[botguard] 403 detected, attempting attestation and retry")
+			c.log().Info("Botguard: 403 detected, attempting attestation and retry")
 		}
 		if err := c.maybeApplyBotguard(req); err == nil {
 			return c.HTTPClient.Do(req)
@@ -763,7 +759,7 @@ func (c *Client) maybeApplyBotguard(req *http.Request) error {
 	if c.bg.cache != nil {
 		if out, ok := c.bg.cache.Get(key); ok && (out.ExpiresAt.IsZero() || time.Until(out.ExpiresAt) > 0) {
 			if c.bg.debug {
-				fmt.Println("[botguard] cache hit: applying cached token")
+				c.log().Info("Botguard: cache hit, applying cached token")
 			}
 			if out.Token != "" {
 				req.Header.Set("x-goog-ext-123-botguard", out.Token)
@@ -771,13 +767,13 @@ func (c *Client) maybeApplyBotguard(req *http.Request) error {
 			return nil
 		}
 		if c.bg.debug {
-			fmt.Println("[botguard] cache miss: computing token")
+			c.log().Info("Botguard: cache miss, computing token")
 		}
 	}
 	out, err := c.bg.solver.Attest(req.Context(), in)
 	if err != nil {
 		if c.bg.debug {
-			fmt.Printf("[botguard] attestation error: %v\n", err)
+			c.log().Info("Botguard: attestation failed", logging.Err(err))
 		}
 		return err
 	}
@@ -786,7 +782,7 @@ func (c *Client) maybeApplyBotguard(req *http.Request) error {
 	}
 	if out.Token != "" {
 		if c.bg.debug {
-			fmt.Println("[botguard] token obtained, applying to headers")
+			c.log().Info("Botguard: token obtained, applying to headers")
 		}
 		req.Header.Set("x-goog-ext-123-botguard", out.Token)
 	}
@@ -1,8 +1,11 @@
 package innertube
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -868,3 +871,134 @@ func TestDoWithBotguardRetry(t *testing.T) {
 		})
 	}
 }
+
+func TestGetPlayerResponseTyped(t *testing.T) {
+	body := `{
+		"playabilityStatus":{"status":"OK"},
+		"streamingData":{"expiresInSeconds":"21540","hlsManifestUrl":"https://m/hls","formats":[
+			{"itag":18,"mimeType":"video/mp4; codecs=\"avc1.42001E, mp4a.40.2\"","width":640,"height":360,"contentLength":"1000","url":"https://v/18"}
+		],"adaptiveFormats":[
+			{"itag":140,"mimeType":"audio/mp4; codecs=\"mp4a.40.2\"","audioSampleRate":"44100","audioChannels":2,
+			 "initRange":{"start":"0","end":"631"},"signatureCipher":"s=x&url=y"}
+		]},
+		"videoDetails":{"videoId":"vid","title":"T","lengthSeconds":"212","keywords":["a","b"],"channelId":"UC1",
+			"author":"Ch","viewCount":"42","isLiveContent":false,"thumbnail":{"thumbnails":[{"url":"https://i/1.jpg","width":120,"height":90}]}},
+		"microformat":{"playerMicroformatRenderer":{"title":{"simpleText":"T"},"description":{"runs":[{"text":"he"},{"text":"llo"}]},"publishDate":"2020-01-02","category":"Music"}},
+		"captions":{"playerCaptionsTracklistRenderer":{"captionTracks":[{"baseUrl":"https://c/en","languageCode":"en","name":{"simpleText":"English"}}]}},
+		"storyboards":{"playerStoryboardSpecRenderer":{"spec":"https://s/$N.jpg|48#27"}},
+		"unmodelled":{"answer":42}
+	}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	it := New(&http.Client{Timeout: 5 * time.Second})
+	it.clientVer = "2.0"
+	it.apiKey = "k"
+	it.visitorID.value = "v"
+	it.visitorID.updated = time.Now()
+	oldPlayerURL := playerURL
+	playerURL = srv.URL
+	defer func() { playerURL = oldPlayerURL }()
+
+	pr, err := it.GetPlayerResponse("vid")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sd := pr.StreamingData
+	if sd.ExpiresInSeconds != 21540 || sd.HLSManifestURL != "https://m/hls" {
+		t.Errorf("unexpected streaming data: %+v", sd)
+	}
+	if len(sd.Formats) != 1 || sd.Formats[0].Height != 360 || sd.Formats[0].ContentLength != 1000 {
+		t.Errorf("unexpected formats: %+v", sd.Formats)
+	}
+	if len(sd.AdaptiveFormats) != 1 || sd.AdaptiveFormats[0].AudioSampleRate != 44100 || sd.AdaptiveFormats[0].InitRange.End != 631 {
+		t.Errorf("unexpected adaptive formats: %+v", sd.AdaptiveFormats)
+	}
+	vd := pr.VideoDetails
+	if vd.LengthSeconds != 212 || vd.ViewCount != 42 || vd.ChannelID != "UC1" || len(vd.Keywords) != 2 || len(vd.Thumbnail.Thumbnails) != 1 {
+		t.Errorf("unexpected video details: %+v", vd)
+	}
+	mf := pr.Microformat.PlayerMicroformatRenderer
+	if mf.Description.String() != "hello" || mf.PublishDate != "2020-01-02" {
+		t.Errorf("unexpected microformat: %+v", mf)
+	}
+	if tracks := pr.Captions.PlayerCaptionsTracklistRenderer.CaptionTracks; len(tracks) != 1 || tracks[0].Name.String() != "English" {
+		t.Errorf("unexpected captions: %+v", tracks)
+	}
+	if pr.Storyboards.PlayerStoryboardSpecRenderer == nil {
+		t.Error("expected storyboard spec")
+	}
+	if !strings.Contains(string(pr.Raw), `"unmodelled"`) {
+		t.Error("expected raw body to be kept")
+	}
+}
+
+func TestPlayerResponseLenientIntegers(t *testing.T) {
+	body := `{
+		"streamingData":{"expiresInSeconds":21540,"adaptiveFormats":[
+			{"itag":140,"contentLength":"1000","approxDurationMs":212000,"lastModified":"","audioSampleRate":48000,
+			 "initRange":{"start":0,"end":"631"}}
+		]},
+		"videoDetails":{"lengthSeconds":"212","viewCount":null},
+		"microformat":{"playerMicroformatRenderer":{"lengthSeconds":212,"viewCount":"42"}}
+	}`
+	var pr PlayerResponse
+	if err := json.Unmarshal([]byte(body), &pr); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if pr.StreamingData.ExpiresInSeconds != 21540 {
+		t.Errorf("ExpiresInSeconds = %d", pr.StreamingData.ExpiresInSeconds)
+	}
+	f := pr.StreamingData.AdaptiveFormats[0]
+	if f.ContentLength != 1000 || f.ApproxDurationMs != 212000 || f.LastModified != 0 || f.AudioSampleRate != 48000 {
+		t.Errorf("unexpected format: %+v", f)
+	}
+	if f.InitRange == nil || f.InitRange.Start != 0 || f.InitRange.End != 631 {
+		t.Errorf("unexpected init range: %+v", f.InitRange)
+	}
+	if vd := pr.VideoDetails; vd.LengthSeconds != 212 || vd.ViewCount != 0 {
+		t.Errorf("unexpected video details: %+v", vd)
+	}
+	if mf := pr.Microformat.PlayerMicroformatRenderer; mf.LengthSeconds != 212 || mf.ViewCount != 42 {
+		t.Errorf("unexpected microformat: %+v", mf)
+	}
+
+	if err := json.Unmarshal([]byte(`{"videoDetails":{"lengthSeconds":"3:32"}}`), &pr); err == nil {
+		t.Error("expected an error for a non-numeric integer")
+	}
+}
+
+func TestGetPlayerResponseLogging(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "VISITOR_INFO1_LIVE", Value: "secret-cookie"})
+		_, _ = w.Write([]byte(`{"playabilityStatus":{"status":"OK"},"streamingData":{"formats":[{"itag":18,"url":"https://v/18?sig=SECRET"}]}}`))
+	}))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	it := New(&http.Client{Timeout: 5 * time.Second}).
+		WithLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	it.clientVer = "2.0"
+	it.apiKey = "k"
+	it.visitorID.value = "v"
+	it.visitorID.updated = time.Now()
+	oldPlayerURL := playerURL
+	playerURL = srv.URL
+	defer func() { playerURL = oldPlayerURL }()
+
+	if _, err := it.GetPlayerResponse("vid"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logs := buf.String()
+	if strings.Contains(logs, "SECRET") || strings.Contains(logs, "secret-cookie") {
+		t.Errorf("secrets leaked into logs: %s", logs)
+	}
+	for _, want := range []string{"video_id=vid", "stage=innertube", "status=200"} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("logs do not contain %q: %s", want, logs)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package innertube
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PlayerResponse represents a response from the InnerTube /player endpoint.
+//
+// Only the commonly used parts of the response are modelled. Raw holds the
+// undecoded response body so callers can extract fields that are not.
+type PlayerResponse struct {
+	PlayabilityStatus PlayabilityStatus `json:"playabilityStatus"`
+	StreamingData     StreamingData     `json:"streamingData"`
+	VideoDetails      VideoDetails      `json:"videoDetails"`
+	Microformat       Microformat       `json:"microformat"`
+	Captions          Captions          `json:"captions"`
+	Storyboards       Storyboards       `json:"storyboards"`
+
+	Raw json.RawMessage `json:"-"`
+}
+
+// PlayabilityStatus reports whether the video can be played and why not.
+type PlayabilityStatus struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+// StreamingData lists the media formats and manifests of a video.
+type StreamingData struct {
+	ExpiresInSeconds Int64    `json:"expiresInSeconds"`
+	Formats          []Format `json:"formats"`
+	AdaptiveFormats  []Format `json:"adaptiveFormats"`
+	HLSManifestURL   string   `json:"hlsManifestUrl"`
+	DashManifestURL  string   `json:"dashManifestUrl"`
+}
+
+// Format is a single raw entry of streamingData.formats or
+// streamingData.adaptiveFormats.
+type Format struct {
+	Itag             int         `json:"itag"`
+	URL              string      `json:"url"`
+	SignatureCipher  string      `json:"signatureCipher"`
+	MimeType         string      `json:"mimeType"`
+	Bitrate          int         `json:"bitrate"`
+	AverageBitrate   int         `json:"averageBitrate"`
+	Width            int         `json:"width"`
+	Height           int         `json:"height"`
+	FPS              int         `json:"fps"`
+	Quality          string      `json:"quality"`
+	QualityLabel     string      `json:"qualityLabel"`
+	ContentLength    Int64       `json:"contentLength"`
+	ApproxDurationMs Int64       `json:"approxDurationMs"`
+	LastModified     Int64       `json:"lastModified"`
+	AudioQuality     string      `json:"audioQuality"`
+	AudioSampleRate  Int64       `json:"audioSampleRate"`
+	AudioChannels    int         `json:"audioChannels"`
+	ProjectionType   string      `json:"projectionType"`
+	StereoLayout     string      `json:"stereoLayout"`
+	InitRange        *Range      `json:"initRange"`
+	IndexRange       *Range      `json:"indexRange"`
+	ColorInfo        *ColorInfo  `json:"colorInfo"`
+	AudioTrack       *AudioTrack `json:"audioTrack"`
+}
+
+// Range is an inclusive byte range.
+type Range struct {
+	Start Int64 `json:"start"`
+	End   Int64 `json:"end"`
+}
+
+// AudioTrack identifies one of several audio tracks (dubs, audio description)
+// carried by adaptive audio formats.
+type AudioTrack struct {
+	ID             string `json:"id"`
+	DisplayName    string `json:"displayName"`
+	AudioIsDefault bool   `json:"audioIsDefault"`
+}
+
+// ColorInfo describes the color characteristics of a video format.
+type ColorInfo struct {
+	Primaries               string `json:"primaries"`
+	TransferCharacteristics string `json:"transferCharacteristics"`
+	MatrixCoefficients      string `json:"matrixCoefficients"`
+}
+
+// VideoDetails holds the basic video metadata.
+type VideoDetails struct {
+	VideoID          string     `json:"videoId"`
+	Title            string     `json:"title"`
+	LengthSeconds    Int64      `json:"lengthSeconds"`
+	Keywords         []string   `json:"keywords"`
+	ChannelID        string     `json:"channelId"`
+	ShortDescription string     `json:"shortDescription"`
+	Thumbnail        Thumbnails `json:"thumbnail"`
+	ViewCount        Int64      `json:"viewCount"`
+	Author           string     `json:"author"`
+	IsPrivate        bool       `json:"isPrivate"`
+	IsLive           bool       `json:"isLive"`
+	IsLiveContent    bool       `json:"isLiveContent"`
+}
+
+// Thumbnails is a list of thumbnail images in increasing size.
+type Thumbnails struct {
+	Thumbnails []Thumbnail `json:"thumbnails"`
+}
+
+// Thumbnail is a single thumbnail image.
+type Thumbnail struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// Text is a localized text value that comes either as simpleText or as runs.
+type Text struct {
+	SimpleText string `json:"simpleText"`
+	Runs       []struct {
+		Text string `json:"text"`
+	} `json:"runs"`
+}
+
+// String returns the plain text value.
+func (t Text) String() string {
+	if t.SimpleText != "" {
+		return t.SimpleText
+	}
+	var b strings.Builder
+	for _, r := range t.Runs {
+		b.WriteString(r.Text)
+	}
+	return b.String()
+}
+
+// Microformat holds page-level metadata of a video.
+type Microformat struct {
+	PlayerMicroformatRenderer struct {
+		Title                Text       `json:"title"`
+		Description          Text       `json:"description"`
+		Thumbnail            Thumbnails `json:"thumbnail"`
+		LengthSeconds        Int64      `json:"lengthSeconds"`
+		OwnerProfileURL      string     `json:"ownerProfileUrl"`
+		ExternalChannelID    string     `json:"externalChannelId"`
+		OwnerChannelName     string     `json:"ownerChannelName"`
+		IsFamilySafe         bool       `json:"isFamilySafe"`
+		IsUnlisted           bool       `json:"isUnlisted"`
+		AvailableCountries   []string   `json:"availableCountries"`
+		ViewCount            Int64      `json:"viewCount"`
+		Category             string     `json:"category"`
+		PublishDate          string     `json:"publishDate"`
+		UploadDate           string     `json:"uploadDate"`
+		LiveBroadcastDetails *struct {
+			IsLiveNow      bool   `json:"isLiveNow"`
+			StartTimestamp string `json:"startTimestamp"`
+			EndTimestamp   string `json:"endTimestamp"`
+		} `json:"liveBroadcastDetails"`
+	} `json:"playerMicroformatRenderer"`
+}
+
+// Captions lists the available caption tracks and translation languages.
+type Captions struct {
+	PlayerCaptionsTracklistRenderer struct {
+		CaptionTracks        []CaptionTrack `json:"captionTracks"`
+		TranslationLanguages []struct {
+			LanguageCode string `json:"languageCode"`
+			LanguageName Text   `json:"languageName"`
+		} `json:"translationLanguages"`
+	} `json:"playerCaptionsTracklistRenderer"`
+}
+
+// CaptionTrack is a single subtitle track.
+type CaptionTrack struct {
+	BaseURL        string `json:"baseUrl"`
+	Name           Text   `json:"name"`
+	VssID          string `json:"vssId"`
+	LanguageCode   string `json:"languageCode"`
+	Kind           string `json:"kind"`
+	IsTranslatable bool   `json:"isTranslatable"`
+}
+
+// Storyboards holds the storyboard (seek preview) specifications.
+type Storyboards struct {
+	PlayerStoryboardSpecRenderer *struct {
+		Spec             string `json:"spec"`
+		RecommendedLevel int    `json:"recommendedLevel"`
+	} `json:"playerStoryboardSpecRenderer"`
+	PlayerLiveStoryboardSpecRenderer *struct {
+		Spec string `json:"spec"`
+	} `json:"playerLiveStoryboardSpecRenderer"`
+}
+
+// Int64 is an integer that YouTube encodes as a JSON string, such as
+// "contentLength":"1000". A bare number is accepted too, and an empty
+// string or null decodes as 0.
+type Int64 int64
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (n *Int64) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "" || s == "null" {
+		*n = 0
+		return nil
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("innertube: invalid integer %s", b)
+	}
+	*n = Int64(v)
+	return nil
+}
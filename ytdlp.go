@@ -4,13 +4,13 @@ import (
 	"context"
 	"crypto/sha1"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/http/pprof"
 	"net/url"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"time"
 
@@ -18,8 +18,10 @@ import (
 	"github.com/ytget/ytdlp/v2/downloader"
 	"github.com/ytget/ytdlp/v2/errs"
 	"github.com/ytget/ytdlp/v2/internal/botguard"
+	"github.com/ytget/ytdlp/v2/internal/logging"
 	"github.com/ytget/ytdlp/v2/internal/mimeext"
 	internalSanitize "github.com/ytget/ytdlp/v2/internal/sanitize"
+	"github.com/ytget/ytdlp/v2/postprocess"
 	"github.com/ytget/ytdlp/v2/types"
 	"github.com/ytget/ytdlp/v2/youtube/cipher"
 	"github.com/ytget/ytdlp/v2/youtube/formats"
@@ -34,6 +36,14 @@ type VideoInfo struct {
 	Duration    int
 	Formats     []types.Format
 	Description string
+	// UploadDate is the publication date as "YYYY-MM-DD", when known.
+	UploadDate string
+	// Thumbnail is the URL of the largest thumbnail image.
+	Thumbnail string
+	// Chapters are the chapters of the video, from its watch page data or
+	// else the timestamps listed in its description. They are only looked up
+	// when WithChapters, WithEmbedMetadata or WithSplitChapters is set.
+	Chapters []types.Chapter
 }
 
 // Format describes an available media format.
@@ -44,21 +54,48 @@ type Format = types.Format
 //
 // Use chainable setters on Downloader to populate these options.
 type DownloadOptions struct {
-	FormatSelector  string
-	DesiredExt      string
-	OutputPath      string
-	HTTPClient      *http.Client
-	ProgressFunc    func(Progress)
-	RateLimitBps    int64
-	ITClientName    string
-	ITClientVersion string
+	FormatSelector   string
+	FormatSort       string
+	AudioLanguage    string
+	ExtractAudio     bool
+	AudioFormat      string
+	ProbeSizes       bool
+	DesiredExt       string
+	OutputPath       string
+	HTTPClient       *http.Client
+	ProgressFunc     func(Progress)
+	ProgressInterval time.Duration
+	RateLimitBps     int64
+	Limiter          *downloader.Limiter
+	Connections      int
+	CheckContainer   bool
+	Checksum         bool
+	Chapters         bool
+	EmbedMetadata    bool
+	SplitChapters    bool
+	ITClientName     string
+	ITClientVersion  string
+	Logger           *slog.Logger
+	PostProcessor    *postprocess.PostProcessor
 }
 
-// Progress describes current progress of an ongoing download.
+// Progress describes current progress of an ongoing download. Events of the
+// resolving, deciphering, merging and post-processing phases only carry the
+// Phase, plus the Percent of jobs run by ffmpeg when the duration is known;
+// download phases carry sizes, speed and ETA as reported by
+// downloader.Progress.
 type Progress struct {
 	TotalSize      int64
 	DownloadedSize int64
 	Percent        float64
+
+	Phase         types.Phase
+	Speed         float64 // bytes per second over the last second
+	AverageSpeed  float64 // bytes per second since the download started
+	ETA           time.Duration
+	Elapsed       time.Duration
+	FragmentIndex int
+	FragmentCount int
 }
 
 // Downloader provides a high-level API for retrieving metadata and downloading
@@ -84,9 +121,9 @@ func startPprofServer() {
 		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
 		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
 
-		log.Printf("Starting pprof server on :6060")
+		slog.Info("Starting pprof server", slog.String("addr", ":6060"))
 		if err := http.ListenAndServe(":6060", mux); err != nil {
-			log.Printf("pprof server error: %v", err)
+			slog.Error("pprof server failed", logging.Err(err))
 		}
 	}()
 }
@@ -100,13 +137,52 @@ func New() *Downloader {
 }
 
 // WithFormat sets a format selector and optional desired extension.
-// Examples: "itag=22", "best", "height<=480". Extension is case-insensitive.
+// Examples: "itag=22", "best", "best[height<=480]", "bv+ba/b". See
+// formats.Selector for the grammar. The selector may also name a preset such
+// as "apple" or "smallest" (see formats.Preset). Extension is case-insensitive.
 func (d *Downloader) WithFormat(quality, ext string) *Downloader {
 	d.options.FormatSelector = quality
 	d.options.DesiredExt = strings.TrimPrefix(strings.ToLower(ext), ".")
 	return d
 }
 
+// WithFormatSort sets the sort spec used to rank candidate formats for
+// best/worst selectors, e.g. "res:1080,fps,vcodec:av01,+size". See
+// formats.SortOrder for the supported keys.
+func (d *Downloader) WithFormatSort(spec string) *Downloader {
+	d.options.FormatSort = strings.TrimSpace(spec)
+	return d
+}
+
+// WithAudioLanguage prefers audio tracks in the given language (e.g. "de" or
+// "pt-BR") when several dubbed tracks are available. Formats without a
+// matching track fall back to the video's default audio track.
+func (d *Downloader) WithAudioLanguage(lang string) *Downloader {
+	d.options.AudioLanguage = strings.TrimSpace(lang)
+	return d
+}
+
+// WithExtractAudio downloads only the best audio-only stream instead of a
+// video. format is AudioFormatBest, AudioFormatM4A or AudioFormatOpus; Opus
+// audio is remuxed from WebM into an Ogg Opus ".opus" file. With a
+// post-processor (see WithPostProcessor), format may also be one that
+// ffmpeg encodes, such as "mp3" or "flac" (see postprocess.AudioExt). An
+// explicit selector set via WithFormat takes precedence over format.
+func (d *Downloader) WithExtractAudio(format string) *Downloader {
+	d.options.ExtractAudio = true
+	d.options.AudioFormat = strings.ToLower(strings.TrimSpace(format))
+	return d
+}
+
+// WithSizeProbe enables probing the exact size of every format that does not
+// report its content length, with one ranged request per format, before a
+// format is selected. Without probing such sizes are estimated from the
+// bitrate and duration (see types.Format.SizeEstimated).
+func (d *Downloader) WithSizeProbe(enabled bool) *Downloader {
+	d.options.ProbeSizes = enabled
+	return d
+}
+
 // WithHTTPClient sets a custom HTTP client to be used for all network calls.
 func (d *Downloader) WithHTTPClient(client *http.Client) *Downloader {
 	d.options.HTTPClient = client
@@ -119,6 +195,50 @@ func (d *Downloader) WithProgress(f func(Progress)) *Downloader {
 	return d
 }
 
+// WithProgressInterval delivers at most one download progress event per
+// interval; phase changes and the final event of each file are always
+// delivered. Zero reports every read.
+func (d *Downloader) WithProgressInterval(interval time.Duration) *Downloader {
+	if interval < 0 {
+		interval = 0
+	}
+	d.options.ProgressInterval = interval
+	return d
+}
+
+// WithPostProcessor lets downloads run ffmpeg through p where the pure-Go
+// muxers cannot help: merging a video and an audio format that neither MP4
+// nor Matroska can take, extracting audio in formats such as mp3 or flac,
+// and extracting the audio of a format that has video. ffmpeg's progress is
+// reported through the callback set with WithProgress. Nil (the default)
+// disables ffmpeg.
+func (d *Downloader) WithPostProcessor(p *postprocess.PostProcessor) *Downloader {
+	d.options.PostProcessor = p
+	return d
+}
+
+// runPostProcessor runs job with the configured post-processor, reporting
+// its progress in phase.
+func (d *Downloader) runPostProcessor(ctx context.Context, job postprocess.Job, phase types.Phase) error {
+	pp := *d.options.PostProcessor
+	if d.options.Logger != nil {
+		pp.WithLogger(d.options.Logger)
+	}
+	pp.WithProgress(func(p postprocess.Progress) {
+		if d.options.ProgressFunc != nil {
+			d.options.ProgressFunc(Progress{Phase: phase, Percent: p.Percent})
+		}
+	})
+	return pp.Run(ctx, job)
+}
+
+// reportPhase sends a progress event announcing phase.
+func (d *Downloader) reportPhase(phase types.Phase) {
+	if d.options.ProgressFunc != nil {
+		d.options.ProgressFunc(Progress{Phase: phase})
+	}
+}
+
 // WithOutputPath sets the output file path. If empty, a safe filename is derived
 // from the video title and mime extension. If a directory path is provided, a
 // safe filename is derived and placed inside that directory.
@@ -128,6 +248,8 @@ func (d *Downloader) WithOutputPath(path string) *Downloader {
 }
 
 // WithRateLimit sets a download rate limit in bytes per second. Zero disables limiting.
+// The limit applies to each file separately; use WithLimiter for a limit
+// shared with other downloads.
 func (d *Downloader) WithRateLimit(bytesPerSecond int64) *Downloader {
 	if bytesPerSecond < 0 {
 		bytesPerSecond = 0
@@ -136,6 +258,83 @@ func (d *Downloader) WithRateLimit(bytesPerSecond int64) *Downloader {
 	return d
 }
 
+// WithLimiter shares l between this Downloader and any other user of l, so
+// that all of them together stay under its rate. It takes precedence over
+// WithRateLimit.
+func (d *Downloader) WithLimiter(l *downloader.Limiter) *Downloader {
+	d.options.Limiter = l
+	return d
+}
+
+// WithConnections sets how many byte ranges of a file are downloaded in
+// parallel. Values below 2 use a single connection.
+func (d *Downloader) WithConnections(n int) *Downloader {
+	if n < 1 {
+		n = 1
+	}
+	d.options.Connections = n
+	return d
+}
+
+// WithContainerCheck makes downloads check the structure of each completed
+// MP4 or WebM file (complete top-level boxes with moov and mdat, or an EBML
+// header, tracks and clusters) before it is moved into place. Sizes are
+// always checked against the server and the format's content length.
+func (d *Downloader) WithContainerCheck(enabled bool) *Downloader {
+	d.options.CheckContainer = enabled
+	return d
+}
+
+// WithChapters makes GetInfo, ResolveURL and Download fill
+// VideoInfo.Chapters. When the player response has none, this takes one more
+// request, for the watch page data. WithEmbedMetadata and WithSplitChapters
+// imply it.
+func (d *Downloader) WithChapters(enabled bool) *Downloader {
+	d.options.Chapters = enabled
+	return d
+}
+
+// WithEmbedMetadata makes downloads write the title, channel, upload date,
+// description, video URL and chapters into the output file, with the
+// thumbnail as cover art: iTunes-style tags, a Nero chapter list and a
+// QuickTime chapter track in MP4 and M4A, Matroska tags, chapters and an
+// attachment in MKV. WebM gets the tags and chapters only, as it has no
+// attachments. Other containers, such as Ogg Opus, are left unchanged.
+func (d *Downloader) WithEmbedMetadata(enabled bool) *Downloader {
+	d.options.EmbedMetadata = enabled
+	return d
+}
+
+// WithSplitChapters makes Download also write one file per chapter of the
+// video next to the output file (see SplitChapters), which is kept. Only MP4
+// and M4A output can be split; videos without chapters are left whole.
+func (d *Downloader) WithSplitChapters(enabled bool) *Downloader {
+	d.options.SplitChapters = enabled
+	return d
+}
+
+// WithChecksum makes downloads write the SHA-256 of each output file to
+// "<output>.sha256", in the format read by sha256sum -c.
+func (d *Downloader) WithChecksum(enabled bool) *Downloader {
+	d.options.Checksum = enabled
+	return d
+}
+
+// WithLogger sets the logger used by every stage: metadata requests,
+// deciphering, URL resolution and downloads. Messages carry video_id, itag
+// and stage attributes where known; signature and key query parameters and
+// credentials are redacted. Verbose details are logged at debug level. A nil
+// logger (the default) uses slog.Default().
+func (d *Downloader) WithLogger(l *slog.Logger) *Downloader {
+	d.options.Logger = l
+	return d
+}
+
+// log returns the configured logger or slog.Default().
+func (d *Downloader) log() *slog.Logger {
+	return logging.Or(d.options.Logger)
+}
+
 // WithInnertubeClient sets the Innertube client name and version to use.
 func (d *Downloader) WithInnertubeClient(name, version string) *Downloader {
 	d.options.ITClientName = strings.TrimSpace(name)
@@ -163,33 +362,187 @@ func (d *Downloader) WithBotguardTTL(ttl time.Duration) *Downloader {
 	return d
 }
 
+// GetInfo fetches video metadata and the full list of available formats
+// without selecting a format or resolving media URLs.
+func (d *Downloader) GetInfo(ctx context.Context, videoURL string) (*VideoInfo, error) {
+	info, _, err := d.fetchInfo(ctx, videoURL)
+	return info, err
+}
+
 // ResolveURL performs the metadata fetch and URL resolution, returning the final media URL and basic info.
 func (d *Downloader) ResolveURL(ctx context.Context, videoURL string) (string, *VideoInfo, error) {
-	log.Printf("Starting resolve for URL: %s", videoURL)
+	finalURL, _, info, err := d.resolve(ctx, videoURL)
+	return finalURL, info, err
+}
 
-	// Extract video ID from URL
-	videoID, err := extractVideoID(videoURL)
+// resolve fetches metadata, selects a format and resolves its media URL. It
+// returns the selected format alongside the URL so callers can derive the
+// output extension from it. Selections of several formats are rejected: only
+// Download merges them.
+func (d *Downloader) resolve(ctx context.Context, videoURL string) (string, types.Format, *VideoInfo, error) {
+	urls, selected, info, err := d.resolveAll(ctx, videoURL)
+	if err != nil {
+		return "", types.Format{}, nil, err
+	}
+	if len(selected) > 1 {
+		return "", types.Format{}, nil, fmt.Errorf("selection of %d formats needs merging, which only Download supports", len(selected))
+	}
+	return urls[0], selected[0], info, nil
+}
+
+// resolveAll fetches metadata, selects the formats and resolves their media
+// URLs. A merge selector such as "bv+ba" yields more than one format.
+func (d *Downloader) resolveAll(ctx context.Context, videoURL string) ([]string, []types.Format, *VideoInfo, error) {
+	d.log().Debug("Resolving", logging.URL(videoURL), slog.String(logging.KeyStage, logging.StageResolve))
+	d.reportPhase(types.PhaseResolving)
+
+	info, httpClient, err := d.fetchInfo(ctx, videoURL)
 	if err != nil {
-		return "", nil, fmt.Errorf("extract video id failed: %v", err)
+		return nil, nil, nil, err
 	}
-	log.Printf("Extracted video ID: %s", videoID)
 
-	// Create HTTP client with HTTP/1.1 transport
-	httpClient := client.New()
+	// Select format
+	selector, sortSpec, err := d.selection()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	selected, err := formats.SelectFormatsSorted(info.Formats, selector, sortSpec, d.options.DesiredExt)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("select format failed: %w", err)
+	}
+
+	urls := d.urlResolver(httpClient, videoURL)
+	finalURLs := make([]string, len(selected))
+	for i, f := range selected {
+		if needsDecipher(f) {
+			d.reportPhase(types.PhaseDeciphering)
+		}
+		if finalURLs[i], err = urls.resolve(f); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return finalURLs, selected, info, nil
+}
+
+// selection returns the format selector and sort spec to use. Presets are
+// expanded, extracting audio without an explicit selector uses the audio
+// selector, and the preferred audio language (if any) becomes the most
+// significant sort key.
+func (d *Downloader) selection() (string, string, error) {
+	selector, sortSpec := formats.ExpandPreset(d.options.FormatSelector, d.options.FormatSort)
+	if d.options.ExtractAudio && selector == "" {
+		s, err := audioSelector(d.options.AudioFormat, d.options.PostProcessor != nil)
+		if err != nil {
+			return "", "", err
+		}
+		selector = s
+	}
+	return selector, d.withAudioLanguage(sortSpec), nil
+}
+
+// withAudioLanguage prepends the preferred audio language to sortSpec.
+func (d *Downloader) withAudioLanguage(sortSpec string) string {
+	if d.options.AudioLanguage == "" {
+		return sortSpec
+	}
+	spec := "lang:" + d.options.AudioLanguage
+	if sortSpec == "" {
+		return spec + "," + formats.DefaultSortSpec
+	}
+	return spec + "," + sortSpec
+}
+
+// formatURLResolver resolves playable URLs for the formats of one video,
+// fetching the player.js URL at most once.
+type formatURLResolver struct {
+	httpClient  *http.Client
+	videoURL    string
+	playerJSURL string
+	logger      *slog.Logger
+}
+
+// urlResolver returns a formatURLResolver using d's logger.
+func (d *Downloader) urlResolver(httpClient *http.Client, videoURL string) *formatURLResolver {
+	return &formatURLResolver{httpClient: httpClient, videoURL: videoURL, logger: d.options.Logger}
+}
+
+// needsDecipher reports whether the URL of f must be deciphered via
+// player.js before it can be played.
+func needsDecipher(f types.Format) bool {
+	return strings.TrimSpace(f.URL) == "" || strings.Contains(f.URL, "&n=") || strings.Contains(f.URL, "?n=")
+}
+
+// resolve returns the playable URL of f, deciphering its signature and n
+// parameter via player.js when needed.
+func (r *formatURLResolver) resolve(f types.Format) (string, error) {
+	finalURL := f.URL
+	if needsDecipher(f) {
+		if r.playerJSURL == "" {
+			pjsURL, perr := cipher.FetchPlayerJS(r.httpClient, r.videoURL)
+			if perr != nil {
+				return "", fmt.Errorf("fetch player.js url failed: %v", perr)
+			}
+			r.playerJSURL = pjsURL
+			// Optional debug
+			if body, src, gerr := cipher.DebugGetPlayerJS(r.httpClient, r.playerJSURL); gerr == nil {
+				h := sha1.Sum(body)
+				_ = src
+				_ = h
+			}
+		}
+		u, rerr := formats.NewResolver(r.httpClient).WithLogger(r.logger).ResolveFormatURL(f, r.playerJSURL)
+		if rerr != nil {
+			return "", fmt.Errorf("resolve selected format url failed: %v", rerr)
+		}
+		finalURL = u
+	}
+	return finalURL, nil
+}
+
+// fetchInfo fetches the player response for videoURL, maps playability errors
+// and parses the available formats, probing their sizes when enabled. It also
+// returns the HTTP client used so that URL resolution can reuse it.
+func (d *Downloader) fetchInfo(ctx context.Context, videoURL string) (*VideoInfo, *http.Client, error) {
+	info, httpClient, err := d.fetchPlayerInfo(ctx, videoURL, d.wantChapters())
+	if err != nil {
+		return nil, nil, err
+	}
+	if d.options.ProbeSizes {
+		urls := d.urlResolver(httpClient, videoURL)
+		d.probeSizes(ctx, urls, info.Formats)
+	}
+	return info, httpClient, nil
+}
+
+// newHTTPClient returns the configured HTTP client, forced to HTTP/1.1, or
+// an HTTP/1.1 client with a 30 second timeout when none is set.
+func (d *Downloader) newHTTPClient() *http.Client {
 	if d.options.HTTPClient != nil {
-		httpClient.HTTPClient = d.options.HTTPClient
-		if transport, ok := httpClient.HTTPClient.Transport.(*http.Transport); ok {
+		if transport, ok := d.options.HTTPClient.Transport.(*http.Transport); ok {
 			transport.ForceAttemptHTTP2 = false
 		}
-	} else {
-		httpClient.HTTPClient = &http.Client{
-			Transport: &http.Transport{ForceAttemptHTTP2: false, MaxIdleConns: 100, IdleConnTimeout: 90 * time.Second},
-			Timeout:   30 * time.Second,
-		}
+		return d.options.HTTPClient
+	}
+	return &http.Client{
+		Transport: &http.Transport{ForceAttemptHTTP2: false, MaxIdleConns: 100, IdleConnTimeout: 90 * time.Second},
+		Timeout:   30 * time.Second,
+	}
+}
+
+// fetchPlayerInfo is fetchInfo without size probing. Chapters are only looked
+// up when withChapters is set.
+func (d *Downloader) fetchPlayerInfo(ctx context.Context, videoURL string, withChapters bool) (*VideoInfo, *http.Client, error) {
+	// Extract video ID from URL
+	videoID, err := extractVideoID(videoURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("extract video id failed: %v", err)
 	}
+	log := d.log().With(slog.String(logging.KeyVideoID, videoID))
+
+	httpClient := d.newHTTPClient()
 
 	// Fetch player response via Innertube
-	itClient := innertube.New(httpClient.HTTPClient)
+	itClient := innertube.New(httpClient).WithLogger(d.options.Logger)
 	itClient.WithBotguard(d.bg.solver, d.bg.mode, d.bg.cache).WithBotguardDebug(d.bg.debug).WithBotguardTTL(d.bg.ttl)
 	name := strings.TrimSpace(d.options.ITClientName)
 	ver := strings.TrimSpace(d.options.ITClientVersion)
@@ -202,9 +555,9 @@ func (d *Downloader) ResolveURL(ctx context.Context, videoURL string) (string, *
 	itClient.WithClient(name, ver)
 	playerResponse, err := itClient.GetPlayerResponse(videoID)
 	if err != nil {
-		return "", nil, fmt.Errorf("get player response failed: %v", err)
+		return nil, nil, fmt.Errorf("get player response failed: %v", err)
 	}
-	log.Printf("Video metadata received, title: %s", playerResponse.VideoDetails.Title)
+	log.Info("Video metadata received", slog.String("title", playerResponse.VideoDetails.Title), slog.String(logging.KeyStage, logging.StageInnertube))
 
 	// Map playability
 	s := strings.ToUpper(playerResponse.PlayabilityStatus.Status)
@@ -212,120 +565,482 @@ func (d *Downloader) ResolveURL(ctx context.Context, videoURL string) (string, *
 	switch s {
 	case "ERROR":
 		if strings.Contains(reason, "geograph") || strings.Contains(reason, "available in your country") {
-			return "", nil, errs.ErrGeoBlocked
+			return nil, nil, errs.ErrGeoBlocked
 		}
 		if strings.Contains(reason, "rate limit") || strings.Contains(reason, "quota") {
-			return "", nil, errs.ErrRateLimited
+			return nil, nil, errs.ErrRateLimited
 		}
-		return "", nil, errs.ErrVideoUnavailable
+		return nil, nil, errs.ErrVideoUnavailable
 	case "LOGIN_REQUIRED":
-		return "", nil, errs.ErrAgeRestricted
+		return nil, nil, errs.ErrAgeRestricted
 	case "UNPLAYABLE":
 		if strings.Contains(reason, "private") {
-			return "", nil, errs.ErrPrivate
+			return nil, nil, errs.ErrPrivate
 		}
-		return "", nil, errs.ErrVideoUnavailable
+		return nil, nil, errs.ErrVideoUnavailable
 	}
 
-	// Parse formats and select
+	// Parse formats
 	availableFormats, err := formats.ParseFormats(playerResponse)
 	if err != nil {
-		return "", nil, fmt.Errorf("parse formats failed: %v", err)
+		return nil, nil, fmt.Errorf("parse formats failed: %v", err)
+	}
+
+	vd := playerResponse.VideoDetails
+	mf := playerResponse.Microformat.PlayerMicroformatRenderer
+	info := &VideoInfo{
+		ID:          videoID,
+		Title:       vd.Title,
+		Author:      vd.Author,
+		Duration:    int(vd.LengthSeconds),
+		Formats:     availableFormats,
+		Description: vd.ShortDescription,
+		UploadDate:  uploadDate(mf.PublishDate, mf.UploadDate),
+		Thumbnail:   largestThumbnail(vd.Thumbnail, mf.Thumbnail),
+	}
+	if withChapters {
+		info.Chapters = d.chapters(ctx, itClient, playerResponse, info, log)
 	}
-	selectedFormat := formats.SelectFormat(availableFormats, d.options.FormatSelector, d.options.DesiredExt)
-	if selectedFormat == nil {
-		return "", nil, fmt.Errorf("no suitable format found")
+	return info, httpClient, nil
+}
+
+// uploadDate returns the first of the microformat dates, which may carry a
+// time, as "YYYY-MM-DD".
+func uploadDate(dates ...string) string {
+	for _, d := range dates {
+		if len(d) >= 10 {
+			return d[:10]
+		}
 	}
+	return ""
+}
 
-	// Resolve final URL
-	finalURL := selectedFormat.URL
-	var playerJSURL string
-	if strings.TrimSpace(finalURL) == "" || strings.Contains(finalURL, "&n=") || strings.Contains(finalURL, "?n=") {
-		pjsURL, perr := cipher.FetchPlayerJS(httpClient.HTTPClient, videoURL)
-		if perr != nil {
-			return "", nil, fmt.Errorf("fetch player.js url failed: %v", perr)
+// largestThumbnail returns the URL of the largest thumbnail of the lists.
+func largestThumbnail(lists ...innertube.Thumbnails) string {
+	best, area := "", -1
+	for _, l := range lists {
+		for _, t := range l.Thumbnails {
+			if t.URL != "" && t.Width*t.Height > area {
+				best, area = t.URL, t.Width*t.Height
+			}
 		}
-		playerJSURL = pjsURL
-		// Optional debug
-		if body, src, gerr := cipher.DebugGetPlayerJS(httpClient.HTTPClient, playerJSURL); gerr == nil {
-			h := sha1.Sum(body)
-			_ = src
-			_ = h
+	}
+	return best
+}
+
+// refreshFormatURL returns a URL refresh callback that fetches the player
+// response of videoID again and resolves a fresh URL for the same itag.
+func (d *Downloader) refreshFormatURL(videoID string, itag int) downloader.URLRefreshFunc {
+	return func(ctx context.Context) (string, error) {
+		videoURL := "https://www.youtube.com/watch?v=" + videoID
+		d.log().Info("Refreshing media URL", slog.String(logging.KeyVideoID, videoID), slog.Int(logging.KeyItag, itag), slog.String(logging.KeyStage, logging.StageResolve))
+		info, httpClient, err := d.fetchPlayerInfo(ctx, videoURL, false)
+		if err != nil {
+			return "", err
 		}
-		u, rerr := formats.ResolveFormatURL(httpClient.HTTPClient, *selectedFormat, playerJSURL)
-		if rerr != nil {
-			return "", nil, fmt.Errorf("resolve selected format url failed: %v", rerr)
+		for _, f := range info.Formats {
+			if f.Itag == itag {
+				urls := d.urlResolver(httpClient, videoURL)
+				return urls.resolve(f)
+			}
 		}
-		finalURL = u
+		return "", fmt.Errorf("itag %d is no longer available", itag)
 	}
+}
 
-	info := &VideoInfo{ID: videoID, Title: playerResponse.VideoDetails.Title, Formats: availableFormats, Description: ""}
-	return finalURL, info, nil
+// probeSizes replaces missing or estimated sizes in list with the exact size
+// reported by the server. Formats that cannot be probed keep their estimate.
+func (d *Downloader) probeSizes(ctx context.Context, urls *formatURLResolver, list []types.Format) {
+	dl := downloader.New(urls.httpClient, nil, 0).WithLogger(d.options.Logger)
+	log := d.log().With(slog.String(logging.KeyStage, logging.StageResolve))
+	for i := range list {
+		f := &list[i]
+		if f.Size > 0 && !f.SizeEstimated {
+			continue
+		}
+		u, err := urls.resolve(*f)
+		if err != nil {
+			log.Debug("Size probe skipped", slog.Int(logging.KeyItag, f.Itag), logging.Err(err))
+			continue
+		}
+		size, err := dl.ProbeSize(ctx, u)
+		if err != nil || size <= 0 {
+			log.Debug("Size probe failed", slog.Int(logging.KeyItag, f.Itag), logging.Err(err))
+			continue
+		}
+		f.Size = size
+		f.SizeEstimated = false
+	}
 }
 
 // Download retrieves video metadata, resolves URL, and downloads to disk.
+// A selector that picks a video and an audio format ("bv+ba") downloads both
+// and merges them into one file.
 func (d *Downloader) Download(ctx context.Context, videoURL string) (*VideoInfo, error) {
-	log.Printf("Starting download for URL: %s", videoURL)
-
-	finalURL, info, err := d.ResolveURL(ctx, videoURL)
+	finalURLs, selected, info, err := d.resolveAll(ctx, videoURL)
 	if err != nil {
 		return nil, err
 	}
-
-	// 6. Download video
-	log.Printf("Starting video download...")
-	log.Printf("Final media URL: %s", finalURL)
-	dl := downloader.New(d.options.HTTPClient, func(p downloader.Progress) {
-		if d.options.ProgressFunc != nil {
-			d.options.ProgressFunc(Progress{TotalSize: p.TotalSize, DownloadedSize: p.DownloadedSize, Percent: p.Percent})
+	var outputPath string
+	if len(selected) > 1 {
+		outputPath, err = d.downloadMerged(ctx, finalURLs, selected, info)
+	} else {
+		chosen, finalURL := selected[0], finalURLs[0]
+		d.log().Info("Downloading format",
+			slog.String(logging.KeyVideoID, info.ID), slog.Int(logging.KeyItag, chosen.Itag), logging.URL(finalURL))
+		outputPath, err = d.downloadFormat(ctx, d.newFileDownloader(), finalURL, chosen, info, "")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("download failed: %w", err)
+	}
+	if d.options.SplitChapters {
+		if err := d.splitChapters(outputPath, info); err != nil {
+			return nil, fmt.Errorf("split chapters failed: %w", err)
 		}
-	}, d.options.RateLimitBps)
-	outputPath := d.options.OutputPath
-	if outputPath == "" {
-		// derive extension from mime using helper
-		// try to infer extension from selected format if available
-		var chosen types.Format
-		if len(info.Formats) > 0 {
-			for _, f := range info.Formats {
-				if strings.Contains(finalURL, strconv.Itoa(f.Itag)) {
-					chosen = f
-					break
-				}
-			}
+	}
+	return info, nil
+}
+
+// DownloadTo is like Download but writes the media to w instead of a file,
+// e.g. to pipe it into another process. w does not need to seek: retries
+// never write a byte twice. There is no temporary file, so an interrupted
+// download cannot be resumed, and parallel connections are not used. When
+// extracting audio, WebM Opus is remuxed into Ogg Opus on the fly.
+func (d *Downloader) DownloadTo(ctx context.Context, videoURL string, w io.Writer) (*VideoInfo, error) {
+	finalURL, chosen, info, err := d.resolve(ctx, videoURL)
+	if err != nil {
+		return nil, err
+	}
+	if d.options.ExtractAudio {
+		if chosen.HasVideo() {
+			return nil, fmt.Errorf("extract audio: format %d has a video track", chosen.Itag)
 		}
-		ext := mimeext.ExtFromMime(chosen.MimeType)
-		title := info.Title
-		if strings.TrimSpace(title) == "" {
-			title = "video"
+		if !nativeAudioFormat(d.options.AudioFormat) {
+			return nil, fmt.Errorf("extract audio: audio format %q needs ffmpeg, which only Download supports", d.options.AudioFormat)
 		}
-		outputPath = internalSanitize.ToSafeFilename(title, ext)
+	}
+
+	d.log().Info("Streaming format",
+		slog.String(logging.KeyVideoID, info.ID), slog.Int(logging.KeyItag, chosen.Itag), logging.URL(finalURL))
+	dl := d.newFileDownloader().
+		WithURLRefresh(d.refreshFormatURL(info.ID, chosen.Itag)).
+		WithPhase(downloadPhase(chosen)).
+		WithExpectedSize(expectedSize(chosen))
+	download := func(w io.Writer) error { return dl.DownloadTo(ctx, finalURL, w) }
+	if d.options.ExtractAudio && isWebMOpus(chosen) {
+		err = remuxWebMOpusStream(w, download)
 	} else {
-		// if outputPath is a directory, derive a safe filename and join
-		if fi, statErr := os.Stat(outputPath); statErr == nil && fi.IsDir() {
-			var chosen types.Format
-			if len(info.Formats) > 0 {
-				for _, f := range info.Formats {
-					if strings.Contains(finalURL, strconv.Itoa(f.Itag)) {
-						chosen = f
-						break
-					}
-				}
-			}
-			ext := mimeext.ExtFromMime(chosen.MimeType)
-			title := info.Title
-			if strings.TrimSpace(title) == "" {
-				title = "video"
-			}
-			name := internalSanitize.ToSafeFilename(title, ext)
-			outputPath = filepath.Join(outputPath, name)
+		err = download(w)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("download failed: %w", err)
+	}
+	return info, nil
+}
+
+// Open resolves the format chosen by the configured selector and returns a
+// random-access reader over it (io.ReadSeekCloser and io.ReaderAt), e.g. to
+// serve byte ranges without downloading the whole file. An expired URL is
+// refreshed transparently.
+func (d *Downloader) Open(ctx context.Context, videoURL string) (*downloader.Reader, types.Format, *VideoInfo, error) {
+	finalURL, chosen, info, err := d.resolve(ctx, videoURL)
+	if err != nil {
+		return nil, types.Format{}, nil, err
+	}
+	r, err := d.newFileDownloader().WithURLRefresh(d.refreshFormatURL(info.ID, chosen.Itag)).Open(ctx, finalURL)
+	if err != nil {
+		return nil, types.Format{}, nil, err
+	}
+	return r, chosen, info, nil
+}
+
+// OpenFormat returns a random-access reader over f, a format of videoURL
+// (for example from GetInfo). The format URL is deciphered when needed and
+// refreshed transparently when it expires.
+func (d *Downloader) OpenFormat(ctx context.Context, videoURL string, f types.Format) (*downloader.Reader, error) {
+	videoID, err := extractVideoID(videoURL)
+	if err != nil {
+		return nil, fmt.Errorf("extract video id failed: %v", err)
+	}
+	urls := d.urlResolver(d.newHTTPClient(), videoURL)
+	finalURL, err := urls.resolve(f)
+	if err != nil {
+		return nil, err
+	}
+	return d.newFileDownloader().WithURLRefresh(d.refreshFormatURL(videoID, f.Itag)).Open(ctx, finalURL)
+}
+
+// downloadFormat downloads f from finalURL to the output path derived from
+// the video title and suffix, and returns that path. Partial downloads are
+// keyed by video ID and itag so they are only resumed for the same format,
+// and an expired URL is refreshed by resolving the same itag again.
+// When extracting audio, WebM Opus streams are downloaded to a temporary file
+// and remuxed into Ogg Opus, and audio that needs ffmpeg (see
+// ffmpegAudioFormat) is downloaded to a temporary file and extracted with the
+// post-processor. The downloaded file is verified against the
+// format's size and, when enabled, its container.
+func (d *Downloader) downloadFormat(ctx context.Context, dl *downloader.Downloader, finalURL string, f types.Format, info *VideoInfo, suffix string) (string, error) {
+	title := info.Title
+	d.configureFormat(dl, f, info)
+	if !d.options.ExtractAudio {
+		outputPath := d.outputPath(title, suffix, mimeext.ExtFromMime(f.MimeType))
+		return outputPath, d.downloadFile(ctx, dl, finalURL, outputPath, info)
+	}
+	format, err := d.ffmpegAudioFormat(f)
+	if err != nil {
+		return "", err
+	}
+	if format != "" {
+		outputPath := d.outputPath(title, suffix, postprocess.AudioExt(format))
+		srcPath := outputPath + "." + mimeext.ExtFromMime(f.MimeType)
+		if err := dl.WithChecksum(false).Download(ctx, finalURL, srcPath); err != nil {
+			return "", err
+		}
+		job, err := postprocess.ExtractAudio(outputPath, srcPath, format)
+		if err != nil {
+			return "", err
 		}
+		job.Duration = time.Duration(info.Duration) * time.Second
+		d.reportPhase(types.PhasePostProcessing)
+		if err := d.runPostProcessor(ctx, job, types.PhasePostProcessing); err != nil {
+			return "", fmt.Errorf("extract audio failed: %w", err)
+		}
+		return outputPath, d.finishPostProcessing(ctx, srcPath, outputPath, info)
+	}
+	outputPath := d.outputPath(title, suffix, audioExt(f))
+	if !isWebMOpus(f) {
+		return outputPath, d.downloadFile(ctx, dl, finalURL, outputPath, info)
+	}
+	webmPath := outputPath + "." + mimeext.ExtWebA
+	// The checksum is of the remuxed file, not of the WebM download.
+	if err := dl.WithChecksum(false).Download(ctx, finalURL, webmPath); err != nil {
+		return "", err
+	}
+	d.reportPhase(types.PhasePostProcessing)
+	if err := remuxWebMOpusFile(webmPath, outputPath); err != nil {
+		return "", fmt.Errorf("remux opus failed: %v", err)
 	}
+	return outputPath, d.finishPostProcessing(ctx, webmPath, outputPath, info)
+}
 
+// downloadFile downloads finalURL to outputPath with dl and embeds the
+// metadata when enabled. Otherwise dl writes the checksum itself (see
+// configureFormat).
+func (d *Downloader) downloadFile(ctx context.Context, dl *downloader.Downloader, finalURL, outputPath string, info *VideoInfo) error {
 	if err := dl.Download(ctx, finalURL, outputPath); err != nil {
-		return nil, fmt.Errorf("download failed: %v", err)
+		return err
+	}
+	if !d.options.EmbedMetadata {
+		return nil
 	}
+	return d.finishOutput(ctx, outputPath, info)
+}
 
-	return info, nil
+// finishPostProcessing removes the downloaded file src that outputPath was
+// made from and finishes outputPath.
+func (d *Downloader) finishPostProcessing(ctx context.Context, src, outputPath string, info *VideoInfo) error {
+	if err := os.Remove(src); err != nil {
+		d.log().Warn("Failed to remove temporary file", slog.String("path", src), logging.Err(err))
+	}
+	return d.finishOutput(ctx, outputPath, info)
+}
+
+// finishOutput embeds the metadata of info into the complete file at
+// outputPath and then writes its checksum, each when enabled.
+func (d *Downloader) finishOutput(ctx context.Context, outputPath string, info *VideoInfo) error {
+	if d.options.EmbedMetadata {
+		if err := d.embedMetadata(ctx, outputPath, info); err != nil {
+			return fmt.Errorf("embed metadata failed: %w", err)
+		}
+	}
+	if d.options.Checksum {
+		if _, err := downloader.WriteChecksum(outputPath, outputPath); err != nil {
+			return fmt.Errorf("write checksum failed: %v", err)
+		}
+	}
+	return nil
+}
+
+// configureFormat sets up dl to download f of the video: resume key, URL
+// refresh, progress phase and verification. Embedding metadata changes the
+// file, so its checksum is then left to finishOutput.
+func (d *Downloader) configureFormat(dl *downloader.Downloader, f types.Format, info *VideoInfo) {
+	var container downloader.Container
+	if d.options.CheckContainer {
+		container = downloader.ContainerFromMime(f.MimeType)
+	}
+	dl.WithResumeKey(info.ID, f.Itag).
+		WithURLRefresh(d.refreshFormatURL(info.ID, f.Itag)).
+		WithPhase(downloadPhase(f)).
+		WithExpectedSize(expectedSize(f)).
+		WithContainerCheck(container).
+		WithChecksum(d.options.Checksum && !d.options.EmbedMetadata)
+}
+
+// expectedSize returns the size a download of f must have, or 0 when f's
+// size is only an estimate.
+func expectedSize(f types.Format) int64 {
+	if f.SizeEstimated {
+		return 0
+	}
+	return f.Size
+}
+
+// downloadPhase returns the progress phase of downloading f.
+func downloadPhase(f types.Format) types.Phase {
+	if f.HasVideo() {
+		return types.PhaseDownloadingVideo
+	}
+	return types.PhaseDownloadingAudio
+}
+
+// AudioTrackFile describes one audio track written by DownloadAllAudioTracks.
+type AudioTrackFile struct {
+	Format types.Format
+	Path   string
+}
+
+// DownloadAllAudioTracks downloads the best audio-only format of every audio
+// track (original, dubs, audio description) of a video. Each track is written
+// to its own file named "<title> [<language>].<ext>"; when OutputPath is set
+// it must be an existing directory, else an error is returned before anything
+// is fetched. Videos with a single audio track yield one file.
+// The desired extension set via WithFormat restricts the candidates when
+// possible. With WithExtractAudio the audio format applies to every track.
+func (d *Downloader) DownloadAllAudioTracks(ctx context.Context, videoURL string) (*VideoInfo, []AudioTrackFile, error) {
+	if out := d.options.OutputPath; out != "" {
+		if fi, err := os.Stat(out); err != nil || !fi.IsDir() {
+			return nil, nil, fmt.Errorf("output path %q must be an existing directory to hold one file per audio track", out)
+		}
+	}
+	info, httpClient, err := d.fetchInfo(ctx, videoURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	selector := "ba"
+	if d.options.ExtractAudio {
+		if selector, err = audioSelector(d.options.AudioFormat, d.options.PostProcessor != nil); err != nil {
+			return nil, nil, err
+		}
+	}
+	tracks, err := bestAudioPerTrack(info.Formats, selector, d.options.FormatSort, d.options.DesiredExt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("select audio tracks failed: %w", err)
+	}
+
+	dl := d.newFileDownloader()
+	urls := d.urlResolver(httpClient, videoURL)
+	files := make([]AudioTrackFile, 0, len(tracks))
+	for _, f := range tracks {
+		if needsDecipher(f) {
+			d.reportPhase(types.PhaseDeciphering)
+		}
+		finalURL, err := urls.resolve(f)
+		if err != nil {
+			return nil, files, err
+		}
+		suffix := f.Language
+		if suffix == "" && f.AudioTrack != nil {
+			suffix = f.AudioTrack.ID
+		}
+		d.log().Info("Downloading audio track",
+			slog.String(logging.KeyVideoID, info.ID), slog.Int(logging.KeyItag, f.Itag), slog.String("track", suffix))
+		outputPath, err := d.downloadFormat(ctx, dl, finalURL, f, info, suffix)
+		if err != nil {
+			return nil, files, fmt.Errorf("download audio track %q failed: %w", suffix, err)
+		}
+		files = append(files, AudioTrackFile{Format: f, Path: outputPath})
+	}
+	return info, files, nil
+}
+
+// bestAudioPerTrack returns the format picked by selector for each audio
+// track, in the order the tracks first appear. Formats without track
+// information form a single unnamed track.
+func bestAudioPerTrack(list []types.Format, selector, sortSpec, ext string) ([]types.Format, error) {
+	var order []string
+	byTrack := make(map[string][]types.Format)
+	for _, f := range list {
+		if f.HasVideo() || !f.HasAudio() {
+			continue
+		}
+		id := ""
+		if f.AudioTrack != nil {
+			id = f.AudioTrack.ID
+		}
+		if _, ok := byTrack[id]; !ok {
+			order = append(order, id)
+		}
+		byTrack[id] = append(byTrack[id], f)
+	}
+	if len(order) == 0 {
+		return nil, formats.ErrNoMatchingFormat
+	}
+	out := make([]types.Format, 0, len(order))
+	for _, id := range order {
+		selected, err := formats.SelectFormatsSorted(byTrack[id], selector, sortSpec, ext)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, selected[0])
+	}
+	return out, nil
+}
+
+// newFileDownloader returns a chunked downloader wired to the configured
+// HTTP client, progress callback and interval, rate limit or limiter and
+// connection count.
+func (d *Downloader) newFileDownloader() *downloader.Downloader {
+	var progress func(downloader.Progress)
+	if d.options.ProgressFunc != nil {
+		progress = func(p downloader.Progress) {
+			d.options.ProgressFunc(Progress{
+				TotalSize:      p.TotalSize,
+				DownloadedSize: p.DownloadedSize,
+				Percent:        p.Percent,
+				Phase:          p.Phase,
+				Speed:          p.Speed,
+				AverageSpeed:   p.AverageSpeed,
+				ETA:            p.ETA,
+				Elapsed:        p.Elapsed,
+				FragmentIndex:  p.FragmentIndex,
+				FragmentCount:  p.FragmentCount,
+			})
+		}
+	}
+	dl := downloader.New(d.options.HTTPClient, progress, d.options.RateLimitBps).
+		WithLogger(d.options.Logger).
+		WithConnections(d.options.Connections).
+		WithProgressInterval(d.options.ProgressInterval)
+	if d.options.Limiter != nil {
+		dl.WithLimiter(d.options.Limiter)
+	}
+	return dl
+}
+
+// outputPath returns the configured output path, or a safe filename derived
+// from title, an optional bracketed suffix and ext when the output path is
+// empty or an existing directory.
+func (d *Downloader) outputPath(title, suffix, ext string) string {
+	outputPath := d.options.OutputPath
+	dir := ""
+	if outputPath != "" {
+		fi, statErr := os.Stat(outputPath)
+		if statErr != nil || !fi.IsDir() {
+			return outputPath
+		}
+		dir = outputPath
+	}
+	if strings.TrimSpace(title) == "" {
+		title = "video"
+	}
+	if suffix != "" {
+		// Keep the suffix when long titles are truncated.
+		tag := " [" + suffix + "]"
+		title = internalSanitize.Truncate(title, internalSanitize.MaxFilenameLength-len(tag)) + tag
+	}
+	name := internalSanitize.ToSafeFilename(title, ext)
+	return filepath.Join(dir, name)
 }
 
 // GetPlaylistItems returns minimal playlist items for a playlist ID (MVP: first page only).
@@ -335,7 +1050,7 @@ func (d *Downloader) GetPlaylistItems(ctx context.Context, playlistID string, li
 	if d.options.HTTPClient != nil {
 		httpClient.HTTPClient = d.options.HTTPClient
 	}
-	itClient := innertube.New(httpClient.HTTPClient)
+	itClient := innertube.New(httpClient.HTTPClient).WithLogger(d.options.Logger)
 	itClient.WithBotguard(d.bg.solver, d.bg.mode, d.bg.cache).WithBotguardDebug(d.bg.debug).WithBotguardTTL(d.bg.ttl)
 	items, err := itClient.GetPlaylistItems(playlistID, limit)
 	return items, err
@@ -347,7 +1062,7 @@ func (d *Downloader) GetPlaylistItemsAll(ctx context.Context, playlistID string,
 	if d.options.HTTPClient != nil {
 		httpClient.HTTPClient = d.options.HTTPClient
 	}
-	itClient := innertube.New(httpClient.HTTPClient)
+	itClient := innertube.New(httpClient.HTTPClient).WithLogger(d.options.Logger)
 	itClient.WithBotguard(d.bg.solver, d.bg.mode, d.bg.cache).WithBotguardDebug(d.bg.debug).WithBotguardTTL(d.bg.ttl)
 	return itClient.GetPlaylistItemsAll(playlistID, limit)
 }
@@ -1,12 +1,27 @@
 package ytdlp
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+	"unicode/utf8"
 
+	"github.com/ytget/ytdlp/v2/downloader"
 	"github.com/ytget/ytdlp/v2/internal/botguard"
+	"github.com/ytget/ytdlp/v2/types"
+	"github.com/ytget/ytdlp/v2/youtube/formats"
 )
 
 func TestExtractVideoID(t *testing.T) {
@@ -209,6 +224,151 @@ func TestWithFormat(t *testing.T) {
 	}
 }
 
+func TestWithFormatSort(t *testing.T) {
+	d := New().WithFormatSort(" res:1080,vcodec:av01 ")
+	if d.options.FormatSort != "res:1080,vcodec:av01" {
+		t.Errorf("Expected FormatSort 'res:1080,vcodec:av01', got '%s'", d.options.FormatSort)
+	}
+}
+
+func TestWithAudioLanguage(t *testing.T) {
+	d := New().WithAudioLanguage(" de ")
+	if d.options.AudioLanguage != "de" {
+		t.Errorf("Expected AudioLanguage 'de', got '%s'", d.options.AudioLanguage)
+	}
+	if _, got, _ := d.selection(); got != "lang:de,"+formats.DefaultSortSpec {
+		t.Errorf("Unexpected sort spec %q", got)
+	}
+	d.WithFormatSort("res:720")
+	if _, got, _ := d.selection(); got != "lang:de,res:720" {
+		t.Errorf("Unexpected sort spec %q", got)
+	}
+}
+
+func TestSelectionPreset(t *testing.T) {
+	d := New().WithFormat("apple", "")
+	apple, _ := formats.LookupPreset("apple")
+	selector, sortSpec, err := d.selection()
+	if err != nil || selector != apple.Selector || sortSpec != apple.Sort {
+		t.Errorf("selection() = %q, %q, %v", selector, sortSpec, err)
+	}
+	d.WithFormatSort("res:720").WithAudioLanguage("de")
+	if _, sortSpec, _ := d.selection(); sortSpec != "lang:de,res:720" {
+		t.Errorf("explicit sort should override the preset, got %q", sortSpec)
+	}
+}
+
+func TestBestAudioPerTrack(t *testing.T) {
+	list := []types.Format{
+		{Itag: 18, MimeType: `video/mp4; codecs="avc1.42001E, mp4a.40.2"`, VCodec: "avc1.42001E", ACodec: "mp4a.40.2"},
+		{Itag: 140, MimeType: `audio/mp4; codecs="mp4a.40.2"`, ACodec: "mp4a.40.2", Adaptive: true, Bitrate: 130000, Language: "en", AudioTrack: &types.AudioTrack{ID: "en.4", IsDefault: true}},
+		{Itag: 251, MimeType: `audio/webm; codecs="opus"`, ACodec: "opus", Adaptive: true, Bitrate: 160000, Language: "en", AudioTrack: &types.AudioTrack{ID: "en.4", IsDefault: true}},
+		{Itag: 140, MimeType: `audio/mp4; codecs="mp4a.40.2"`, ACodec: "mp4a.40.2", Adaptive: true, Bitrate: 130000, Language: "de", AudioTrack: &types.AudioTrack{ID: "de.3"}},
+	}
+	got, err := bestAudioPerTrack(list, "ba", "", "")
+	if err != nil || len(got) != 2 {
+		t.Fatalf("expected 2 tracks, got %d (%v)", len(got), err)
+	}
+	if got[0].Itag != 251 || got[0].Language != "en" || got[1].Itag != 140 || got[1].Language != "de" {
+		t.Errorf("unexpected tracks: %+v", got)
+	}
+	got, err = bestAudioPerTrack(list, "ba", "", "mp4")
+	if err != nil || got[0].Itag != 140 {
+		t.Errorf("expected mp4 audio for the default track, got %+v (%v)", got, err)
+	}
+	if _, err := bestAudioPerTrack(list[:1], "ba", "", ""); err == nil {
+		t.Error("expected error without audio-only formats")
+	}
+}
+
+func TestOutputPathSuffix(t *testing.T) {
+	dir := t.TempDir()
+	d := New().WithOutputPath(dir)
+	if got := d.outputPath("Talk", "de", "m4a"); got != filepath.Join(dir, "Talk [de].m4a") {
+		t.Errorf("unexpected path %q", got)
+	}
+	d.WithOutputPath("out.mp4")
+	if got := d.outputPath("Talk", "de", "m4a"); got != "out.mp4" {
+		t.Errorf("unexpected path %q", got)
+	}
+	d.WithOutputPath(dir)
+	got := filepath.Base(d.outputPath(strings.Repeat("é", 100), "de", "m4a"))
+	if !utf8.ValidString(got) || !strings.HasSuffix(got, "é [de].m4a") {
+		t.Errorf("long title gave %q", got)
+	}
+}
+
+func TestDownloadAllAudioTracksFileOutput(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "talk.m4a")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, out := range []string{file, filepath.Join(dir, "missing", "talk.m4a")} {
+		d := New().WithOutputPath(out)
+		if _, _, err := d.DownloadAllAudioTracks(context.Background(), "https://www.youtube.com/watch?v=dQw4w9WgXcQ"); err == nil || !strings.Contains(err.Error(), "directory") {
+			t.Errorf("OutputPath %q: expected a directory error, got %v", out, err)
+		}
+	}
+}
+
+func TestProbeSizes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sizes := map[string]string{"/a": "1000", "/b": "2000"}
+		w.Header().Set("Content-Range", "bytes 0-1/"+sizes[r.URL.Path])
+		w.WriteHeader(http.StatusPartialContent)
+	}))
+	defer srv.Close()
+
+	list := []types.Format{
+		{Itag: 18, URL: srv.URL + "/exact", Size: 10},
+		{Itag: 137, URL: srv.URL + "/a", Size: 900, SizeEstimated: true},
+		{Itag: 251, URL: srv.URL + "/b"},
+	}
+	d := New().WithSizeProbe(true)
+	d.probeSizes(context.Background(), &formatURLResolver{httpClient: srv.Client(), videoURL: "dQw4w9WgXcQ"}, list)
+	if list[0].Size != 10 {
+		t.Errorf("exact size should not be probed, got %d", list[0].Size)
+	}
+	if list[1].Size != 1000 || list[1].SizeEstimated {
+		t.Errorf("expected probed size 1000, got %d (estimated=%v)", list[1].Size, list[1].SizeEstimated)
+	}
+	if list[2].Size != 2000 || list[2].SizeEstimated {
+		t.Errorf("expected probed size 2000, got %d (estimated=%v)", list[2].Size, list[2].SizeEstimated)
+	}
+}
+
+func TestOpenFormat(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 100000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a, b int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &a, &b); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if b >= len(data) {
+			b = len(data) - 1
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", a, b, len(data)))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(data[a : b+1])
+	}))
+	defer srv.Close()
+
+	d := New().WithHTTPClient(srv.Client())
+	r, err := d.OpenFormat(context.Background(), "https://www.youtube.com/watch?v=dQw4w9WgXcQ", types.Format{Itag: 18, URL: srv.URL + "/media"})
+	if err != nil {
+		t.Fatalf("OpenFormat: %v", err)
+	}
+	defer r.Close()
+	var _ io.ReadSeekCloser = r
+	var _ io.ReaderAt = r
+	p := make([]byte, 5)
+	if _, err := r.ReadAt(p, 123457); err != nil || string(p) != "78901" {
+		t.Errorf("ReadAt = %q, %v", p, err)
+	}
+}
+
 func TestWithHTTPClient(t *testing.T) {
 	downloader := New()
 	httpClient := &http.Client{Timeout: 10 * time.Second}
@@ -238,6 +398,126 @@ func TestWithProgress(t *testing.T) {
 	}
 }
 
+func TestWithProgressInterval(t *testing.T) {
+	d := New().WithProgressInterval(time.Second)
+	if d.options.ProgressInterval != time.Second {
+		t.Errorf("Expected ProgressInterval 1s, got %v", d.options.ProgressInterval)
+	}
+	if d := New().WithProgressInterval(-time.Second); d.options.ProgressInterval != 0 {
+		t.Errorf("Expected ProgressInterval 0, got %v", d.options.ProgressInterval)
+	}
+}
+
+func TestFileDownloaderProgress(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "media", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	var events []Progress
+	d := New().WithHTTPClient(srv.Client()).WithProgress(func(p Progress) { events = append(events, p) })
+	d.reportPhase(types.PhaseResolving)
+	dl := d.newFileDownloader().WithPhase(downloadPhase(types.Format{MimeType: "video/mp4", VCodec: "avc1.42001E"}))
+	if err := dl.Download(context.Background(), srv.URL, filepath.Join(t.TempDir(), "out.mp4")); err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	if len(events) < 2 || events[0].Phase != types.PhaseResolving {
+		t.Fatalf("expected a resolving event first, got %+v", events)
+	}
+	last := events[len(events)-1]
+	if last.Phase != types.PhaseDownloadingVideo || last.DownloadedSize != int64(len(data)) ||
+		last.Percent != 100 || last.FragmentIndex != 1 || last.FragmentCount != 1 {
+		t.Errorf("unexpected final event %+v", last)
+	}
+}
+
+func TestWithLogger(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "media", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	d := New().WithHTTPClient(srv.Client()).WithLogger(logger)
+	if d.options.Logger != logger {
+		t.Fatal("Expected Logger to be set")
+	}
+	f := types.Format{Itag: 18, MimeType: "video/mp4", VCodec: "avc1.42001E"}
+	info := &VideoInfo{ID: "dQw4w9WgXcQ", Title: "t"}
+	d.WithOutputPath(filepath.Join(t.TempDir(), "out.mp4"))
+	if _, err := d.downloadFormat(context.Background(), d.newFileDownloader(), srv.URL+"/v?sig=SECRET", f, info, ""); err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	logs := buf.String()
+	if strings.Contains(logs, "SECRET") {
+		t.Errorf("signature leaked into logs:\n%s", logs)
+	}
+	for _, want := range []string{"video_id=dQw4w9WgXcQ", "itag=18", "stage=download"} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("logs do not contain %q:\n%s", want, logs)
+		}
+	}
+}
+
+func TestDownloadFormatVerification(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "media", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+	info := &VideoInfo{ID: "dQw4w9WgXcQ", Title: "t"}
+
+	// A format announcing another size fails the download.
+	d := New().WithHTTPClient(srv.Client()).WithOutputPath(filepath.Join(t.TempDir(), "out.mp4"))
+	f := types.Format{Itag: 18, MimeType: "video/mp4", VCodec: "avc1.42001E", Size: int64(len(data)) + 1}
+	if _, err := d.downloadFormat(context.Background(), d.newFileDownloader(), srv.URL, f, info, ""); !errors.Is(err, downloader.ErrIntegrity) {
+		t.Errorf("size mismatch: error = %v, want ErrIntegrity", err)
+	}
+	// Estimated sizes are not checked.
+	f.SizeEstimated = true
+	if _, err := d.downloadFormat(context.Background(), d.newFileDownloader(), srv.URL, f, info, ""); err != nil {
+		t.Errorf("estimated size: %v", err)
+	}
+
+	// The container check rejects data that is not MP4.
+	d = New().WithHTTPClient(srv.Client()).WithOutputPath(filepath.Join(t.TempDir(), "out.mp4")).WithContainerCheck(true)
+	f.Size, f.SizeEstimated = int64(len(data)), false
+	if _, err := d.downloadFormat(context.Background(), d.newFileDownloader(), srv.URL, f, info, ""); !errors.Is(err, downloader.ErrIntegrity) {
+		t.Errorf("container check: error = %v, want ErrIntegrity", err)
+	}
+
+	// The checksum is written next to the output.
+	out := filepath.Join(t.TempDir(), "out.mp4")
+	d = New().WithHTTPClient(srv.Client()).WithOutputPath(out).WithChecksum(true)
+	if _, err := d.downloadFormat(context.Background(), d.newFileDownloader(), srv.URL, f, info, ""); err != nil {
+		t.Fatalf("checksum download failed: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	if got, err := os.ReadFile(out + ".sha256"); err != nil || !strings.HasPrefix(string(got), hex.EncodeToString(sum[:])+"  out.mp4") {
+		t.Errorf("checksum sidecar = %q, %v", got, err)
+	}
+}
+
+func TestNeedsDecipher(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"", true},
+		{"https://rr1.googlevideo.com/videoplayback?itag=18&n=abc", true},
+		{"https://rr1.googlevideo.com/videoplayback?n=abc", true},
+		{"https://rr1.googlevideo.com/videoplayback?itag=18", false},
+	}
+	for _, tt := range tests {
+		if got := needsDecipher(types.Format{URL: tt.url}); got != tt.want {
+			t.Errorf("needsDecipher(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
+
 func TestWithOutputPath(t *testing.T) {
 	downloader := New()
 	outputPath := "/tmp/downloads"
@@ -258,6 +538,28 @@ func TestWithRateLimit(t *testing.T) {
 	}
 }
 
+func TestWithLimiter(t *testing.T) {
+	l := downloader.NewLimiter(1<<20, 0)
+	d := New().WithLimiter(l)
+	if d.options.Limiter != l {
+		t.Error("Expected Limiter to be set")
+	}
+	if dl := d.newFileDownloader(); dl == nil {
+		t.Error("Expected a file downloader")
+	}
+}
+
+func TestWithConnections(t *testing.T) {
+	downloader := New()
+
+	if result := downloader.WithConnections(4); result.options.Connections != 4 {
+		t.Errorf("Expected Connections 4, got %d", result.options.Connections)
+	}
+	if result := downloader.WithConnections(-2); result.options.Connections != 1 {
+		t.Errorf("Expected Connections 1, got %d", result.options.Connections)
+	}
+}
+
 func TestWithInnertubeClient(t *testing.T) {
 	downloader := New()
 	name := "TEST_CLIENT"